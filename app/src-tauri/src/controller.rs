@@ -6,12 +6,37 @@ use crate::macros::*;
 use crate::model::*;
 use std::collections::HashMap;
 
-use reqwest::blocking::Client;
+use reqwest::blocking::{Client, RequestBuilder};
 use serde_json::json;
 use uuid::Uuid;
 
 use unqlite::UnQLite;
 
+/**
+ * Returns the id this app changes lists as, creating it the first time. It is the same for every list,
+ * unlike the node id a list inherits from the app that created it
+ */
+pub fn get_client_id(db: &UnQLite) -> Result<Uuid, &'static str> {
+    if let Ok(uuid) = db.get_my_uuid() {
+        return Ok(uuid);
+    }
+
+    let new_uuid = Uuid::new_v4();
+    unwrap_or_return!(db.set_my_uuid(new_uuid.to_string()));
+    Ok(new_uuid)
+}
+
+/**
+ * Adds the API token in API_TOKEN to a request to the load balancer, if there is one. The server only lets
+ * the app change lists as the client id the token is bound to
+ */
+fn authenticate(request: RequestBuilder) -> RequestBuilder {
+    match std::env::var("API_TOKEN") {
+        Ok(token) if !token.is_empty() => request.bearer_auth(token),
+        _ => request,
+    }
+}
+
 //TODO: an user maybe can have also a HashMap with list_Uuid -> list_name: A map of the lists the user have
 /**
  * Creates a new user that will use the local app
@@ -246,9 +271,10 @@ pub fn publish_list(
     }
 
     let request_url = format!("http://{}/list", server_address);
+    let client_id = unwrap_or_return!(get_client_id(db)).to_string();
     let response = unwrap_or_return_with!(
-        Client::new()
-            .put(&request_url)
+        authenticate(Client::new().put(&request_url))
+            .header("X-Client-Id", client_id)
             .json(&json!(&List {
                 list_id: list_id.clone(),
                 content: list.crdt,
@@ -280,8 +306,7 @@ pub fn join_list(
 
     let request_url = format!("http://{}/list", server_address);
     let response = unwrap_or_return_with!(
-        Client::new()
-            .post(&request_url)
+        authenticate(Client::new().post(&request_url))
             .json(&json!(&List {
                 list_id: list_id.clone(),
             }))
@@ -380,8 +405,7 @@ pub fn sync_list(
 
     let request_url = format!("http://{}/list", server_address);
     let response = unwrap_or_return_with!(
-        Client::new()
-            .post(&request_url)
+        authenticate(Client::new().post(&request_url))
             .json(&json!(&List {
                 list_id: list_id.clone(),
             }))
@@ -3,7 +3,6 @@
 
 use database::Operation;
 use rusqlite::Result;
-use uuid::Uuid;
 mod controller;
 pub mod crdt;
 mod database;
@@ -62,19 +61,10 @@ fn my_custom_command() {
 //TODO: change to receive node_id, and the title from the input of the frontend and the node_id from created user account using the app
 #[tauri::command]
 fn create_list(app_handle: AppHandle) -> Result<String, String> {
-    let my_uuid;
-
-    let uuid_from_db = app_handle.db(|db| db.get_my_uuid());
-
-    if uuid_from_db.is_err() {
-        let new_uuid = Uuid::new_v4();
-
-        let _ = app_handle.db(|db| db.set_my_uuid(new_uuid.to_string()));
-
-        my_uuid = new_uuid;
-    } else {
-        my_uuid = uuid_from_db.unwrap()
-    }
+    let my_uuid = match app_handle.db(|db| controller::get_client_id(db)) {
+        Ok(uuid) => uuid,
+        Err(e) => return Err(e.to_string()),
+    };
 
     match app_handle.db(|db| controller::create_list("New List", my_uuid, db)) {
         //TODO: create client info to save client name, node_id: Uuid on local database ( possible also in the) persistent information !!!
@@ -0,0 +1,238 @@
+package crdt_go
+
+// Roles a client can have on a list, each one can do everything the ones before it can
+const (
+	ROLE_VIEWER string = "viewer"
+	ROLE_EDITOR string = "editor"
+	ROLE_OWNER  string = "owner"
+)
+
+// roleRank orders the roles, a client without a role has rank zero.
+func roleRank(role string) int {
+	switch role {
+	case ROLE_VIEWER:
+		return 1
+	case ROLE_EDITOR:
+		return 2
+	case ROLE_OWNER:
+		return 3
+	}
+	return 0
+}
+
+// RoleAllows indicates if a client with the given role can do what needs the other role.
+func RoleAllows(role string, need string) bool {
+	return roleRank(role) > 0 && roleRank(role) >= roleRank(need)
+}
+
+// ACL says who can read and change a list, it is replicated with the list.
+// The owner and the role of each member are last-writer-wins registers, so concurrent changes
+// converge, and revoked share tokens are never forgotten.
+type ACL struct {
+	Owner   Register            `json:"owner"`
+	Members map[string]Register `json:"members,omitempty"` // Role of each client, an empty role removes the client
+	Revoked map[string]int64    `json:"revoked,omitempty"` // Ids of the revoked share tokens, with when they expire
+}
+
+// Clone creates a deep copy of the ACL.
+func (a *ACL) Clone() *ACL {
+	clone := &ACL{Owner: a.Owner}
+
+	if a.Members != nil {
+		clone.Members = make(map[string]Register, len(a.Members))
+		for clientId, role := range a.Members {
+			clone.Members[clientId] = role
+		}
+	}
+
+	if a.Revoked != nil {
+		clone.Revoked = make(map[string]int64, len(a.Revoked))
+		for tokenId, expires := range a.Revoked {
+			clone.Revoked[tokenId] = expires
+		}
+	}
+
+	return clone
+}
+
+// Equal indicates if two ACLs give the same roles and revoke the same share tokens. An ACL without
+// members or revoked tokens is the same whether it was created or decoded.
+func (a *ACL) Equal(other *ACL) bool {
+	if a.Owner != other.Owner || len(a.Members) != len(other.Members) || len(a.Revoked) != len(other.Revoked) {
+		return false
+	}
+
+	for clientId, role := range a.Members {
+		if otherRole, ok := other.Members[clientId]; !ok || otherRole != role {
+			return false
+		}
+	}
+	for tokenId, expires := range a.Revoked {
+		if otherExpires, ok := other.Revoked[tokenId]; !ok || otherExpires != expires {
+			return false
+		}
+	}
+	return true
+}
+
+// nextStamp returns a stamp greater than every other in the ACL.
+func (a *ACL) nextStamp() uint64 {
+	stamp := a.Owner.Stamp
+	for _, role := range a.Members {
+		if role.Stamp > stamp {
+			stamp = role.Stamp
+		}
+	}
+	return stamp + 1
+}
+
+// Merge merges another ACL, for the owner and each member the latest change is kept.
+func (a *ACL) Merge(other *ACL) {
+	if other.Owner.newerThan(a.Owner) {
+		a.Owner = other.Owner
+	}
+
+	if len(other.Members) > 0 && a.Members == nil {
+		a.Members = make(map[string]Register)
+	}
+	for clientId, incRole := range other.Members {
+		if role, ok := a.Members[clientId]; !ok || incRole.newerThan(role) {
+			a.Members[clientId] = incRole
+		}
+	}
+
+	if len(other.Revoked) > 0 && a.Revoked == nil {
+		a.Revoked = make(map[string]int64)
+	}
+	for tokenId, expires := range other.Revoked {
+		if expires > a.Revoked[tokenId] {
+			a.Revoked[tokenId] = expires
+		}
+	}
+}
+
+// acl returns the ACL of the list, creating it if the list has none
+func (l *ShoppingList) acl() *ACL {
+	if l.ACL == nil {
+		l.ACL = &ACL{}
+	}
+	return l.ACL
+}
+
+// GetOwner returns the owner of the list, empty if nobody owns it, in which case everybody can use it.
+func (l *ShoppingList) GetOwner() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.ACL == nil {
+		return ""
+	}
+	return l.ACL.Owner.Value
+}
+
+// GetRole returns the role a client has on the list, empty if it has none.
+func (l *ShoppingList) GetRole(clientId string) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.role(clientId)
+}
+
+// canWrite indicates if a client can change the list, everybody can change a list without an owner.
+// The caller must hold the lock
+func (l *ShoppingList) canWrite(clientId string) bool {
+	if l.ACL == nil || l.ACL.Owner.Value == "" {
+		return true
+	}
+	return RoleAllows(l.role(clientId), ROLE_EDITOR)
+}
+
+// role returns the role a client has on the list, the caller must hold the lock
+func (l *ShoppingList) role(clientId string) string {
+	if l.ACL == nil || clientId == "" {
+		return ""
+	}
+	if l.ACL.Owner.Value == clientId {
+		return ROLE_OWNER
+	}
+	return l.ACL.Members[clientId].Value
+}
+
+// GetACL returns a copy of the ACL of the list, an empty one if the list has none.
+func (l *ShoppingList) GetACL() *ACL {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.ACL == nil {
+		return &ACL{}
+	}
+	return l.ACL.Clone()
+}
+
+// SetOwner makes a client the owner of the list, as the given node.
+func (l *ShoppingList) SetOwner(clientId string, NodeID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.own()
+
+	acl := l.acl()
+	acl.Owner = Register{Value: clientId, NodeID: NodeID, Stamp: acl.nextStamp()}
+}
+
+// SetRole gives a client a role on the list as the given node, an empty role removes the client.
+// Returns false if the role is not an editor or viewer one, or the client is the owner.
+func (l *ShoppingList) SetRole(clientId string, role string, NodeID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if clientId == "" || (role != "" && role != ROLE_EDITOR && role != ROLE_VIEWER) {
+		return false
+	}
+	if l.ACL != nil && l.ACL.Owner.Value == clientId {
+		return false
+	}
+
+	l.own()
+	acl := l.acl()
+	if acl.Members == nil {
+		acl.Members = make(map[string]Register)
+	}
+	acl.Members[clientId] = Register{Value: role, NodeID: NodeID, Stamp: acl.nextStamp()}
+
+	return true
+}
+
+// RevokeShareToken revokes a share token of the list, expires is when the token would expire anyway.
+func (l *ShoppingList) RevokeShareToken(tokenId string, expires int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.own()
+
+	acl := l.acl()
+	if acl.Revoked == nil {
+		acl.Revoked = make(map[string]int64)
+	}
+	if expires > acl.Revoked[tokenId] {
+		acl.Revoked[tokenId] = expires
+	}
+}
+
+// IsShareTokenRevoked indicates if a share token of the list was revoked.
+func (l *ShoppingList) IsShareTokenRevoked(tokenId string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.ACL == nil {
+		return false
+	}
+	_, ok := l.ACL.Revoked[tokenId]
+	return ok
+}
+
+// mergeACL merges the ACL of another list.
+func (l *ShoppingList) mergeACL(incList *ShoppingList) {
+	if incList.ACL == nil {
+		return
+	}
+	l.acl().Merge(incList.ACL)
+}
@@ -0,0 +1,114 @@
+package crdt_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACLRoles(t *testing.T) {
+	list := NewShoppingList()
+	assert.Equal(t, "", list.GetOwner())
+	assert.Equal(t, "", list.GetRole("alice"))
+
+	list.SetOwner("alice", "alice")
+	require.True(t, list.SetRole("bob", ROLE_EDITOR, "alice"))
+	require.True(t, list.SetRole("carol", ROLE_VIEWER, "alice"))
+
+	assert.Equal(t, "alice", list.GetOwner())
+	assert.Equal(t, ROLE_OWNER, list.GetRole("alice"))
+	assert.Equal(t, ROLE_EDITOR, list.GetRole("bob"))
+	assert.Equal(t, ROLE_VIEWER, list.GetRole("carol"))
+	assert.Equal(t, "", list.GetRole("dave"))
+
+	// The owner is not a member, and only editors and viewers can be given
+	assert.False(t, list.SetRole("alice", ROLE_VIEWER, "alice"))
+	assert.False(t, list.SetRole("dave", ROLE_OWNER, "alice"))
+	assert.False(t, list.SetRole("dave", "admin", "alice"))
+
+	require.True(t, list.SetRole("bob", "", "alice"))
+	assert.Equal(t, "", list.GetRole("bob"))
+
+	assert.True(t, RoleAllows(ROLE_OWNER, ROLE_EDITOR))
+	assert.True(t, RoleAllows(ROLE_EDITOR, ROLE_VIEWER))
+	assert.False(t, RoleAllows(ROLE_VIEWER, ROLE_EDITOR))
+	assert.False(t, RoleAllows("", ROLE_VIEWER))
+	assert.False(t, RoleAllows("", ""))
+}
+
+func TestACLConcurrentChanges(t *testing.T) {
+	base := NewShoppingList()
+	base.SetOwner("alice", "alice")
+	base.SetRole("bob", ROLE_VIEWER, "alice")
+
+	a := base.Clone()
+	b := base.Clone()
+
+	// Both change bob's role at the same time, every replica keeps the same one
+	a.SetRole("bob", ROLE_EDITOR, "alice")
+	b.SetRole("bob", "", "carol")
+	b.RevokeShareToken("token1", 100)
+
+	ab := a.Clone()
+	ab.Merge(b)
+	ba := b.Clone()
+	ba.Merge(a)
+
+	assert.Equal(t, ab.CanonicalACL(), ba.CanonicalACL())
+	assert.Equal(t, "", ab.GetRole("bob"))
+	assert.True(t, ab.IsShareTokenRevoked("token1"))
+	assert.False(t, ab.IsShareTokenRevoked("token2"))
+
+	// A later change wins over both
+	ab.SetRole("bob", ROLE_EDITOR, "alice")
+	ba.Merge(ab)
+	assert.Equal(t, ROLE_EDITOR, ba.GetRole("bob"))
+}
+
+func TestACLEncoding(t *testing.T) {
+	list := NewShoppingList()
+	assert.Nil(t, list.CanonicalACL())
+
+	list.SetOwner("alice", "alice")
+	list.SetRole("bob", ROLE_EDITOR, "alice")
+	list.RevokeShareToken("token1", 100)
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	assert.Equal(t, list.GetACL(), decoded.GetACL())
+	assert.Equal(t, ROLE_EDITOR, decoded.GetRole("bob"))
+
+	// A snapshot does not see later changes to the ACL
+	snapshot := list.Snapshot()
+	list.SetRole("bob", "", "alice")
+	assert.Equal(t, ROLE_EDITOR, snapshot.GetRole("bob"))
+}
+
+func TestACLEqual(t *testing.T) {
+	list := NewShoppingList()
+	list.SetOwner("alice", "alice")
+	list.SetRole("bob", ROLE_EDITOR, "alice")
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	// Decoding gives the ACL an empty set of revoked tokens, where the list had none
+	assert.True(t, list.GetACL().Equal(decoded.GetACL()))
+	assert.True(t, decoded.GetACL().Equal(list.GetACL()))
+
+	changed := list.Clone()
+	changed.SetRole("bob", ROLE_VIEWER, "alice")
+	assert.False(t, list.GetACL().Equal(changed.GetACL()))
+
+	changed = list.Clone()
+	changed.RevokeShareToken("token1", 100)
+	assert.False(t, list.GetACL().Equal(changed.GetACL()))
+}
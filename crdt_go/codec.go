@@ -0,0 +1,998 @@
+package crdt_go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// The binary encoding of the CRDTs is canonical: the same state always produces the same bytes,
+// no matter the order of the maps or of the AWSet slices. Every integer is a varint and every
+// string is prefixed with its length.
+//
+// Top level values start with a header (magic, version, type) and structs are encoded as a sequence
+// of (field tag, length, value) so fields can be added later without breaking older readers.
+
+const (
+	BinaryMagic   byte = 'Q'
+	BinaryVersion byte = 1
+)
+
+const (
+	binaryTypeCounter        byte = 1
+	binaryTypeAWSet          byte = 2
+	binaryTypeShoppingList   byte = 3
+	binaryTypeShoppingListV2 byte = 4
+)
+
+// ShoppingList fields
+const (
+	shoppingListFieldNodeID    uint64 = 1
+	shoppingListFieldItems     uint64 = 2
+	shoppingListFieldAwSet     uint64 = 3
+	shoppingListFieldRemoved   uint64 = 4
+	shoppingListFieldHistory   uint64 = 5
+	shoppingListFieldReplicas  uint64 = 6
+	shoppingListFieldFolded    uint64 = 7
+	shoppingListFieldOrder     uint64 = 8
+	shoppingListFieldNotes     uint64 = 9
+	shoppingListFieldNames     uint64 = 10
+	shoppingListFieldACL       uint64 = 11
+	shoppingListFieldTombstone uint64 = 12
+	shoppingListFieldPruned    uint64 = 13
+)
+
+// ShoppingListV2 fields
+const (
+	shoppingListV2FieldNodeID           uint64 = 1
+	shoppingListV2FieldNeeded           uint64 = 2
+	shoppingListV2FieldPurchased        uint64 = 3
+	shoppingListV2FieldAwSet            uint64 = 4
+	shoppingListV2FieldRemovedNeeded    uint64 = 5
+	shoppingListV2FieldRemovedPurchased uint64 = 6
+)
+
+var ErrInvalidEncoding = errors.New("invalid binary encoding")
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) header(binaryType byte) {
+	e.buf = append(e.buf, BinaryMagic, BinaryVersion, binaryType)
+}
+
+// field writes a tagged field, its value being whatever encode writes
+func (e *encoder) field(tag uint64, encode func(e *encoder)) {
+	var value encoder
+	encode(&value)
+
+	e.uvarint(tag)
+	e.uvarint(uint64(len(value.buf)))
+	e.buf = append(e.buf, value.buf...)
+}
+
+func (e *encoder) uint32Map(m map[string]uint32) {
+	keys := sortedKeys(m)
+
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.uvarint(uint64(m[key]))
+	}
+}
+
+func (e *encoder) uint64Map(m map[string]uint64) {
+	keys := sortedKeys(m)
+
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.uvarint(m[key])
+	}
+}
+
+func (e *encoder) versionVectors(m map[string]VersionVector) {
+	keys := sortedKeys(m)
+
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.uint64Map(m[key])
+	}
+}
+
+func (e *encoder) counter(c *BoundedPNCounter) {
+	if c == nil {
+		c = NewBoundedPNCounter()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.uint32Map(c.PositiveCount)
+	e.uint32Map(c.NegativeCount)
+
+	senders := sortedKeys(c.Transfers)
+	e.uvarint(uint64(len(senders)))
+	for _, from := range senders {
+		e.string(from)
+		e.uint32Map(c.Transfers[from])
+	}
+}
+
+func (e *encoder) counterMap(m map[string]*BoundedPNCounter) {
+	keys := sortedKeys(m)
+
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.counter(m[key])
+	}
+}
+
+func (e *encoder) context(context []ContextItem) {
+	sorted := make([]ContextItem, len(context))
+	copy(sorted, context)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].NodeID != sorted[j].NodeID {
+			return sorted[i].NodeID < sorted[j].NodeID
+		}
+		return sorted[i].Counter < sorted[j].Counter
+	})
+
+	e.uvarint(uint64(len(sorted)))
+	for _, ctxItem := range sorted {
+		e.string(ctxItem.NodeID)
+		e.uvarint(uint64(ctxItem.Counter))
+	}
+}
+
+func (e *encoder) awset(s *AWSet) {
+	if s == nil {
+		s = NewAWSet()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := make([]item, len(s.State))
+	copy(state, s.State)
+	sort.Slice(state, func(i, j int) bool {
+		if state[i].Name != state[j].Name {
+			return state[i].Name < state[j].Name
+		}
+		if state[i].NodeID != state[j].NodeID {
+			return state[i].NodeID < state[j].NodeID
+		}
+		return state[i].Counter < state[j].Counter
+	})
+
+	e.uvarint(uint64(len(state)))
+	for _, stateItem := range state {
+		e.string(stateItem.Name)
+		e.string(stateItem.NodeID)
+		e.uvarint(uint64(stateItem.Counter))
+	}
+
+	e.context(s.Context)
+}
+
+func (e *encoder) history(h *OperationLog) {
+	clock := sortedKeys(h.Clock)
+
+	e.uvarint(uint64(len(clock)))
+	for _, NodeID := range clock {
+		e.string(NodeID)
+		e.uvarint(h.Clock[NodeID])
+	}
+
+	// Entries are kept sorted by the log
+	e.uvarint(uint64(len(h.Entries)))
+	for _, op := range h.Entries {
+		e.string(op.NodeID)
+		e.uvarint(op.Seq)
+		e.string(op.Op)
+		e.string(op.Item)
+		e.varint(op.Delta)
+		e.string(op.Target)
+		e.varint(op.Timestamp)
+	}
+
+	active := sortedKeys(h.Active)
+
+	e.uvarint(uint64(len(active)))
+	for _, NodeID := range active {
+		e.string(NodeID)
+		e.varint(h.Active[NodeID])
+	}
+}
+
+func (e *encoder) sequence(s *Sequence) {
+	names := sortedKeys(s.Positions)
+
+	e.uvarint(uint64(len(names)))
+	for _, name := range names {
+		position := s.Positions[name]
+
+		e.string(name)
+		e.uvarint(uint64(len(position.Digits)))
+		for _, digit := range position.Digits {
+			e.uvarint(uint64(digit))
+		}
+		e.string(position.NodeID)
+		e.uvarint(position.Stamp)
+	}
+}
+
+func (e *encoder) text(t *Text) {
+	chars := make([]Char, len(t.Chars))
+	copy(chars, t.Chars)
+	sort.Slice(chars, func(i, j int) bool { return chars[i].Id.less(chars[j].Id) })
+
+	e.uvarint(uint64(len(chars)))
+	for _, char := range chars {
+		e.string(char.Id.NodeID)
+		e.uvarint(char.Id.Counter)
+		e.string(char.Parent.NodeID)
+		e.uvarint(char.Parent.Counter)
+		e.uvarint(uint64(char.Value))
+
+		deleted := uint64(0)
+		if char.Deleted {
+			deleted = 1
+		}
+		e.uvarint(deleted)
+	}
+}
+
+func (e *encoder) notes(m map[string]*Text) {
+	names := sortedKeys(m)
+
+	e.uvarint(uint64(len(names)))
+	for _, name := range names {
+		e.string(name)
+		e.text(m[name])
+	}
+}
+
+func (e *encoder) register(r Register) {
+	e.string(r.Value)
+	e.string(r.NodeID)
+	e.uvarint(r.Stamp)
+}
+
+func (e *encoder) registers(m map[string]Register) {
+	keys := sortedKeys(m)
+
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.register(m[key])
+	}
+}
+
+func (e *encoder) acl(a *ACL) {
+	e.register(a.Owner)
+	e.registers(a.Members)
+
+	revoked := sortedKeys(a.Revoked)
+	e.uvarint(uint64(len(revoked)))
+	for _, tokenId := range revoked {
+		e.string(tokenId)
+		e.varint(a.Revoked[tokenId])
+	}
+}
+
+func (e *encoder) tombstone(t *Tombstone) {
+	e.uint64Map(t.Context)
+	e.varint(t.Deleted)
+	e.string(t.NodeID)
+
+	acks := t.sortedAcks()
+	e.uvarint(uint64(len(acks)))
+	for _, replica := range acks {
+		e.string(replica)
+	}
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(reason string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrInvalidEncoding, reason)
+	}
+	d.data = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.data = d.data[n:]
+
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.data = d.data[n:]
+
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	v := d.uvarint()
+	if v > uint64(^uint32(0)) {
+		d.fail("value does not fit in 32 bits")
+		return 0
+	}
+	return uint32(v)
+}
+
+// length reads a length and checks it is not larger than what is left to read
+func (d *decoder) length() int {
+	v := d.uvarint()
+	if v > uint64(len(d.data)) {
+		d.fail("length larger than the input")
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) string() string {
+	n := d.length()
+	if d.err != nil {
+		return ""
+	}
+
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+
+	return s
+}
+
+func (d *decoder) header(binaryType byte) {
+	if len(d.data) < 3 || d.data[0] != BinaryMagic {
+		d.fail("missing header")
+		return
+	}
+	if d.data[1] != BinaryVersion {
+		d.fail(fmt.Sprintf("unsupported version %d", d.data[1]))
+		return
+	}
+	if d.data[2] != binaryType {
+		d.fail(fmt.Sprintf("expected type %d, got %d", binaryType, d.data[2]))
+		return
+	}
+	d.data = d.data[3:]
+}
+
+func (d *decoder) done() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("trailing bytes")
+	}
+	return d.err
+}
+
+// fields reads every tagged field, calling decode with a decoder that only holds the field's value.
+// Unknown fields are skipped.
+func (d *decoder) fields(decode func(tag uint64, field *decoder) bool) {
+	for d.err == nil && len(d.data) > 0 {
+		tag := d.uvarint()
+		n := d.length()
+		if d.err != nil {
+			return
+		}
+
+		field := &decoder{data: d.data[:n]}
+		d.data = d.data[n:]
+
+		if decode(tag, field) {
+			if err := field.done(); err != nil {
+				d.err = err
+				return
+			}
+		}
+	}
+}
+
+func (d *decoder) uint32Map() map[string]uint32 {
+	n := d.length()
+	m := make(map[string]uint32, n)
+
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		m[key] = d.uint32()
+	}
+
+	return m
+}
+
+func (d *decoder) uint64Map() map[string]uint64 {
+	n := d.length()
+	m := make(map[string]uint64, n)
+
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		m[key] = d.uvarint()
+	}
+
+	return m
+}
+
+func (d *decoder) versionVectors() map[string]VersionVector {
+	n := d.length()
+	m := make(map[string]VersionVector, n)
+
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		m[key] = d.uint64Map()
+	}
+
+	return m
+}
+
+func (d *decoder) counter() *BoundedPNCounter {
+	c := NewBoundedPNCounter()
+
+	c.PositiveCount = d.uint32Map()
+	c.NegativeCount = d.uint32Map()
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		from := d.string()
+		c.Transfers[from] = d.uint32Map()
+	}
+
+	return c
+}
+
+func (d *decoder) counterMap() map[string]*BoundedPNCounter {
+	n := d.length()
+	m := make(map[string]*BoundedPNCounter, n)
+
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		m[key] = d.counter()
+	}
+
+	return m
+}
+
+func (d *decoder) context() []ContextItem {
+	n := d.length()
+	context := make([]ContextItem, 0, n)
+
+	for i := 0; i < n && d.err == nil; i++ {
+		NodeID := d.string()
+		context = append(context, ContextItem{NodeID, d.uint32()})
+	}
+
+	return context
+}
+
+func (d *decoder) awset() *AWSet {
+	s := NewAWSet()
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		name := d.string()
+		NodeID := d.string()
+		s.State = append(s.State, item{name, NodeID, d.uint32()})
+	}
+
+	s.Context = d.context()
+
+	return s
+}
+
+func (d *decoder) history() *OperationLog {
+	h := NewOperationLog()
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		NodeID := d.string()
+		h.Clock[NodeID] = d.uvarint()
+	}
+
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		op := Operation{}
+		op.NodeID = d.string()
+		op.Seq = d.uvarint()
+		op.Op = d.string()
+		op.Item = d.string()
+		op.Delta = d.varint()
+		op.Target = d.string()
+		op.Timestamp = d.varint()
+		h.insert(op)
+	}
+
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		NodeID := d.string()
+		h.Active[NodeID] = d.varint()
+	}
+
+	return h
+}
+
+func (d *decoder) sequence() *Sequence {
+	s := NewSequence()
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		name := d.string()
+
+		position := Position{Digits: make([]uint32, d.length())}
+		for j := range position.Digits {
+			position.Digits[j] = d.uint32()
+		}
+		position.NodeID = d.string()
+		position.Stamp = d.uvarint()
+
+		s.Positions[name] = position
+	}
+
+	return s
+}
+
+func (d *decoder) text() *Text {
+	n := d.length()
+	t := &Text{Chars: make([]Char, 0, n)}
+	for i := 0; i < n && d.err == nil; i++ {
+		var char Char
+		char.Id.NodeID = d.string()
+		char.Id.Counter = d.uvarint()
+		char.Parent.NodeID = d.string()
+		char.Parent.Counter = d.uvarint()
+
+		value := d.uvarint()
+		if !validRune(value) {
+			d.fail("invalid character")
+		}
+		char.Value = rune(value)
+
+		switch d.uvarint() {
+		case 0:
+		case 1:
+			char.Deleted = true
+		default:
+			d.fail("invalid deleted flag")
+		}
+
+		t.Chars = append(t.Chars, char)
+	}
+	t.sort()
+	return t
+}
+
+func (d *decoder) notes() map[string]*Text {
+	n := d.length()
+	m := make(map[string]*Text, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		name := d.string()
+		m[name] = d.text()
+	}
+	return m
+}
+
+func (d *decoder) register() Register {
+	return Register{
+		Value:  d.string(),
+		NodeID: d.string(),
+		Stamp:  d.uvarint(),
+	}
+}
+
+func (d *decoder) registers() map[string]Register {
+	n := d.length()
+	m := make(map[string]Register, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		m[key] = d.register()
+	}
+	return m
+}
+
+func (d *decoder) acl() *ACL {
+	a := &ACL{
+		Owner:   d.register(),
+		Members: d.registers(),
+	}
+
+	n := d.length()
+	a.Revoked = make(map[string]int64, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		tokenId := d.string()
+		a.Revoked[tokenId] = d.varint()
+	}
+
+	return a
+}
+
+func (d *decoder) tombstone() *Tombstone {
+	t := &Tombstone{
+		Context: VersionVector(d.uint64Map()),
+		Deleted: d.varint(),
+		NodeID:  d.string(),
+	}
+
+	if n := d.length(); n > 0 {
+		t.Acks = make(map[string]bool, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			t.Acks[d.string()] = true
+		}
+	}
+
+	return t
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalBinary encodes the BoundedPNCounter in its canonical binary form.
+func (c *BoundedPNCounter) MarshalBinary() ([]byte, error) {
+	var e encoder
+	e.header(binaryTypeCounter)
+	e.counter(c)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a BoundedPNCounter from its canonical binary form.
+func (c *BoundedPNCounter) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.header(binaryTypeCounter)
+	decoded := d.counter()
+	if err := d.done(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.PositiveCount = decoded.PositiveCount
+	c.NegativeCount = decoded.NegativeCount
+	c.Transfers = decoded.Transfers
+
+	return nil
+}
+
+// MarshalBinary encodes the AWSet in its canonical binary form.
+func (s *AWSet) MarshalBinary() ([]byte, error) {
+	var e encoder
+	e.header(binaryTypeAWSet)
+	e.awset(s)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes an AWSet from its canonical binary form.
+func (s *AWSet) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.header(binaryTypeAWSet)
+	decoded := d.awset()
+	if err := d.done(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.State = decoded.State
+	s.Context = decoded.Context
+
+	return nil
+}
+
+// CanonicalContext returns the canonical binary form of the AWSet's dot context.
+// Two AWSets that have seen the same dots always return the same bytes.
+func (s *AWSet) CanonicalContext() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var e encoder
+	e.context(s.Context)
+	return e.buf
+}
+
+// CanonicalNotes returns the canonical binary form of the notes of the list, nil if it has none.
+// Two lists with the same notes always return the same bytes.
+func (l *ShoppingList) CanonicalNotes() []byte {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.Notes) == 0 {
+		return nil
+	}
+	var e encoder
+	e.notes(l.Notes)
+	return e.buf
+}
+
+// CanonicalACL returns the canonical binary form of the ACL of the list, nil if it has none.
+// Two lists with the same ACL always return the same bytes.
+func (l *ShoppingList) CanonicalACL() []byte {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.ACL == nil {
+		return nil
+	}
+	var e encoder
+	e.acl(l.ACL)
+	return e.buf
+}
+
+// CanonicalTombstone returns the canonical binary form of the tombstone of the list, nil if it was not deleted.
+// Two lists with the same tombstone always return the same bytes.
+func (l *ShoppingList) CanonicalTombstone() []byte {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.Tombstone == nil {
+		return nil
+	}
+	var e encoder
+	e.tombstone(l.Tombstone)
+	return e.buf
+}
+
+// CanonicalContent returns the canonical binary form of everything the replicas of the list converge on,
+// that is the list without its NodeID and without the versions each replica has seen. Two replicas that
+// hold the same items, order, names, notes, ACL, history and tombstone always return the same bytes.
+func (l *ShoppingList) CanonicalContent() []byte {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var e encoder
+	l.encodeFields(&e, false)
+	return e.buf
+}
+
+// MarshalBinary encodes the ShoppingList in its canonical binary form.
+func (l *ShoppingList) MarshalBinary() ([]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var e encoder
+	e.header(binaryTypeShoppingList)
+	l.encodeFields(&e, true)
+
+	return e.buf, nil
+}
+
+// encodeFields writes the fields of the list, its NodeID and Replicas only when withReplica is set
+func (l *ShoppingList) encodeFields(e *encoder, withReplica bool) {
+	if withReplica {
+		e.field(shoppingListFieldNodeID, func(e *encoder) { e.string(l.NodeID) })
+	}
+	e.field(shoppingListFieldItems, func(e *encoder) { e.counterMap(l.Items) })
+	e.field(shoppingListFieldAwSet, func(e *encoder) { e.awset(l.AwSet) })
+	e.field(shoppingListFieldRemoved, func(e *encoder) { e.counterMap(l.Removed) })
+	if l.History != nil {
+		e.field(shoppingListFieldHistory, func(e *encoder) { e.history(l.History) })
+	}
+	if withReplica && len(l.Replicas) > 0 {
+		e.field(shoppingListFieldReplicas, func(e *encoder) { e.versionVectors(l.Replicas) })
+	}
+	if len(l.Folded) > 0 {
+		e.field(shoppingListFieldFolded, func(e *encoder) { e.uint64Map(l.Folded) })
+	}
+	if l.Order != nil {
+		e.field(shoppingListFieldOrder, func(e *encoder) { e.sequence(l.Order) })
+	}
+	if len(l.Notes) > 0 {
+		e.field(shoppingListFieldNotes, func(e *encoder) { e.notes(l.Notes) })
+	}
+	if len(l.Names) > 0 {
+		e.field(shoppingListFieldNames, func(e *encoder) { e.registers(l.Names) })
+	}
+	if l.ACL != nil {
+		e.field(shoppingListFieldACL, func(e *encoder) { e.acl(l.ACL) })
+	}
+	if l.Tombstone != nil {
+		e.field(shoppingListFieldTombstone, func(e *encoder) { e.tombstone(l.Tombstone) })
+	}
+	if len(l.Pruned) > 0 {
+		e.field(shoppingListFieldPruned, func(e *encoder) { e.uint64Map(l.Pruned) })
+	}
+}
+
+// UnmarshalBinary decodes a ShoppingList from its canonical binary form.
+func (l *ShoppingList) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.header(binaryTypeShoppingList)
+
+	decoded := NewShoppingList()
+	decoded.NodeID = ""
+	decoded.History = nil
+	decoded.Order = nil
+
+	d.fields(func(tag uint64, field *decoder) bool {
+		switch tag {
+		case shoppingListFieldNodeID:
+			decoded.NodeID = field.string()
+		case shoppingListFieldItems:
+			decoded.Items = field.counterMap()
+		case shoppingListFieldAwSet:
+			decoded.AwSet = field.awset()
+		case shoppingListFieldRemoved:
+			decoded.Removed = field.counterMap()
+		case shoppingListFieldHistory:
+			decoded.History = field.history()
+		case shoppingListFieldReplicas:
+			decoded.Replicas = field.versionVectors()
+		case shoppingListFieldFolded:
+			decoded.Folded = field.uint64Map()
+		case shoppingListFieldOrder:
+			decoded.Order = field.sequence()
+		case shoppingListFieldNotes:
+			decoded.Notes = field.notes()
+		case shoppingListFieldNames:
+			decoded.Names = field.registers()
+		case shoppingListFieldACL:
+			decoded.ACL = field.acl()
+		case shoppingListFieldTombstone:
+			decoded.Tombstone = field.tombstone()
+		case shoppingListFieldPruned:
+			decoded.Pruned = field.uint64Map()
+		default:
+			return false
+		}
+		return true
+	})
+
+	if err := d.done(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.NodeID = decoded.NodeID
+	l.Items = decoded.Items
+	l.AwSet = decoded.AwSet
+	l.Removed = decoded.Removed
+	l.History = decoded.History
+	l.Order = decoded.Order
+	l.Notes = decoded.Notes
+	l.Names = decoded.Names
+	l.ACL = decoded.ACL
+	l.Tombstone = decoded.Tombstone
+	l.Replicas = decoded.Replicas
+	l.Folded = decoded.Folded
+	l.Pruned = decoded.Pruned
+	l.shared = false
+
+	return nil
+}
+
+// MarshalBinary encodes the ShoppingListV2 in its canonical binary form.
+func (l *ShoppingListV2) MarshalBinary() ([]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var e encoder
+	e.header(binaryTypeShoppingListV2)
+
+	e.field(shoppingListV2FieldNodeID, func(e *encoder) { e.string(l.NodeID) })
+	e.field(shoppingListV2FieldNeeded, func(e *encoder) { e.counterMap(l.NeededItems) })
+	e.field(shoppingListV2FieldPurchased, func(e *encoder) { e.counterMap(l.PurchasedItems) })
+	e.field(shoppingListV2FieldAwSet, func(e *encoder) { e.awset(l.AwSet) })
+	if len(l.RemovedNeeded) > 0 {
+		e.field(shoppingListV2FieldRemovedNeeded, func(e *encoder) { e.counterMap(l.RemovedNeeded) })
+	}
+	if len(l.RemovedPurchased) > 0 {
+		e.field(shoppingListV2FieldRemovedPurchased, func(e *encoder) { e.counterMap(l.RemovedPurchased) })
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a ShoppingListV2 from its canonical binary form.
+func (l *ShoppingListV2) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.header(binaryTypeShoppingListV2)
+
+	decoded := NewShoppingListV2()
+	decoded.NodeID = ""
+
+	d.fields(func(tag uint64, field *decoder) bool {
+		switch tag {
+		case shoppingListV2FieldNodeID:
+			decoded.NodeID = field.string()
+		case shoppingListV2FieldNeeded:
+			decoded.NeededItems = field.counterMap()
+		case shoppingListV2FieldPurchased:
+			decoded.PurchasedItems = field.counterMap()
+		case shoppingListV2FieldAwSet:
+			decoded.AwSet = field.awset()
+		case shoppingListV2FieldRemovedNeeded:
+			decoded.RemovedNeeded = field.counterMap()
+		case shoppingListV2FieldRemovedPurchased:
+			decoded.RemovedPurchased = field.counterMap()
+		default:
+			return false
+		}
+		return true
+	})
+
+	if err := d.done(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.NodeID = decoded.NodeID
+	l.NeededItems = decoded.NeededItems
+	l.PurchasedItems = decoded.PurchasedItems
+	l.AwSet = decoded.AwSet
+	l.RemovedNeeded = decoded.RemovedNeeded
+	l.RemovedPurchased = decoded.RemovedPurchased
+
+	return nil
+}
+
+// IsBinaryEncoded checks if the data starts with the header of the binary encoding.
+func IsBinaryEncoded(data []byte) bool {
+	return len(data) >= 2 && data[0] == BinaryMagic && data[1] == BinaryVersion
+}
+
+// DecodeShoppingList decodes a ShoppingList that is either in its binary form or in JSON.
+func DecodeShoppingList(data []byte) (*ShoppingList, error) {
+	list := &ShoppingList{}
+
+	if IsBinaryEncoded(data) {
+		if err := list.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
@@ -0,0 +1,215 @@
+package crdt_go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoundedPNCounterBinaryRoundTrip(t *testing.T) {
+	c := NewBoundedPNCounter()
+	c.Increment("node1", 300)
+	c.Increment("node2", 1)
+	c.Decrement("node1", 20)
+	c.Transfer("node1", "node2", 5)
+
+	data, err := c.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := NewBoundedPNCounter()
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	assert.Equal(t, c.PositiveCount, decoded.PositiveCount)
+	assert.Equal(t, c.NegativeCount, decoded.NegativeCount)
+	assert.Equal(t, c.Transfers, decoded.Transfers)
+	assert.Equal(t, c.Value(), decoded.Value())
+}
+
+func TestAWSetBinaryRoundTrip(t *testing.T) {
+	s := generateRandomAWSet()
+
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := NewAWSet()
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	if !equalStateAndContext(s, decoded) {
+		t.Errorf("Decoded AWSet is not equal to the original")
+	}
+}
+
+func TestShoppingListBinaryRoundTrip(t *testing.T) {
+	list := generateRandomShoppingList()
+	list.RemoveItem(list.GetItems()[0], list.NodeID)
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	assert.Equal(t, list.NodeID, decoded.NodeID)
+	if !equalShoppingList(list, decoded) {
+		t.Errorf("Decoded ShoppingList is not equal to the original")
+	}
+
+	// Encoding the decoded list gives back the same bytes
+	again, err := decoded.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+}
+
+func TestShoppingListV2BinaryRoundTrip(t *testing.T) {
+	list := NewShoppingListV2()
+	list.AddOrUpdateItem("milk", 3, list.NodeID)
+	list.PurchaseItem("milk", 1, list.NodeID)
+	list.AddOrUpdateItem("eggs", 12, list.NodeID)
+	list.PurchaseItem("eggs", 6, list.NodeID)
+	list.RemoveItem("eggs")
+	list.AddOrUpdateItem("eggs", 2, list.NodeID)
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingListV2{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	for _, itemName := range list.GetItems() {
+		needed, _ := list.GetItemQuantityNeeded(itemName)
+		decodedNeeded, _ := decoded.GetItemQuantityNeeded(itemName)
+		assert.Equal(t, needed, decodedNeeded)
+
+		purchased, _ := list.GetItemQuantityPurchased(itemName)
+		decodedPurchased, _ := decoded.GetItemQuantityPurchased(itemName)
+		assert.Equal(t, purchased, decodedPurchased)
+	}
+}
+
+func TestShoppingListBinaryIsDeterministic(t *testing.T) {
+	a := NewShoppingList()
+	a.AddOrUpdateItem("milk", 2, a.NodeID)
+	a.AddOrUpdateItem("eggs", 6, a.NodeID)
+
+	b := NewShoppingList()
+	b.NodeID = a.NodeID + "-b"
+	b.AddOrUpdateItem("bread", 1, b.NodeID)
+
+	// The same state reached through different merge orders
+	ab := a.Clone()
+	ab.Merge(b)
+
+	ba := b.Clone()
+	ba.Merge(a)
+	ba.NodeID = ab.NodeID
+
+	abData, err := ab.MarshalBinary()
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		baData, err := ba.MarshalBinary()
+		require.NoError(t, err)
+
+		if !bytes.Equal(abData, baData) {
+			t.Fatalf("Expected the same state to always be encoded in the same bytes")
+		}
+	}
+}
+
+func TestCanonicalContextIgnoresOrder(t *testing.T) {
+	a := NewAWSet()
+	a.Context = []ContextItem{{"node1", 3}, {"node2", 1}, {"node3", 7}}
+
+	b := NewAWSet()
+	b.Context = []ContextItem{{"node3", 7}, {"node1", 3}, {"node2", 1}}
+
+	assert.Equal(t, a.CanonicalContext(), b.CanonicalContext())
+
+	b.Context[0].Counter = 8
+	assert.NotEqual(t, a.CanonicalContext(), b.CanonicalContext())
+}
+
+func TestCanonicalContentIgnoresTheReplica(t *testing.T) {
+	a := NewShoppingList()
+	a.AddOrUpdateItem("milk", 1, "alice")
+	a.AddOrUpdateItem("eggs", 1, "alice")
+
+	// Another replica of the same list, which saw its own version of it
+	b := a.Clone()
+	b.NodeID = "another"
+	b.MarkSeen("replica2")
+
+	assert.Equal(t, a.CanonicalContent(), b.CanonicalContent())
+
+	b.MoveItem("eggs", "", "bob")
+	assert.NotEqual(t, a.CanonicalContent(), b.CanonicalContent())
+}
+
+func TestDecodeShoppingListAcceptsJSON(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+
+	jsonData, err := json.Marshal(list)
+	require.NoError(t, err)
+
+	binaryData, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	for _, data := range [][]byte{jsonData, binaryData} {
+		decoded, err := DecodeShoppingList(data)
+		require.NoError(t, err)
+
+		q, ok := decoded.GetItemQuantity("milk")
+		assert.True(t, ok)
+		assert.Equal(t, int32(2), q)
+	}
+}
+
+func TestUnmarshalBinaryRejectsInvalidData(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	// Every truncation of a valid encoding must be rejected
+	for i := 0; i < len(data); i++ {
+		err := (&ShoppingList{}).UnmarshalBinary(data[:i])
+		if i > 3 && err == nil {
+			// Stopping between two fields is a valid, if incomplete, encoding
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("Expected ErrInvalidEncoding, got %v", err)
+		}
+	}
+
+	// A counter is not a shopping list
+	counterData, err := NewBoundedPNCounter().MarshalBinary()
+	require.NoError(t, err)
+	assert.ErrorIs(t, (&ShoppingList{}).UnmarshalBinary(counterData), ErrInvalidEncoding)
+}
+
+func TestUnmarshalBinarySkipsUnknownFields(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	// A field added by a newer version
+	var e encoder
+	e.field(1000, func(e *encoder) { e.string("from the future") })
+	data = append(data, e.buf...)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	q, ok := decoded.GetItemQuantity("milk")
+	assert.True(t, ok)
+	assert.Equal(t, int32(2), q)
+}
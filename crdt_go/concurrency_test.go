@@ -0,0 +1,261 @@
+package crdt_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	stressReplicas   = 4
+	stressGoroutines = 3 // Per replica
+	stressSteps      = 200
+)
+
+// stress runs step from stressGoroutines goroutines per replica at the same time.
+func stress(step func(rng *rand.Rand, replica int)) {
+	var wg sync.WaitGroup
+
+	for replica := 0; replica < stressReplicas; replica++ {
+		for g := 0; g < stressGoroutines; g++ {
+			wg.Add(1)
+			go func(replica int, seed int64) {
+				defer wg.Done()
+				rng := rand.New(rand.NewSource(seed))
+				for i := 0; i < stressSteps; i++ {
+					step(rng, replica)
+				}
+			}(replica, int64(replica*stressGoroutines+g))
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestBoundedPNCounterConcurrentMerges(t *testing.T) {
+	counters := make([]*BoundedPNCounter, stressReplicas)
+	for i := range counters {
+		counters[i] = NewBoundedPNCounter()
+	}
+	var mu sync.Mutex // Merge returns a new counter, the slot is swapped under a lock
+
+	stress(func(rng *rand.Rand, replica int) {
+		mu.Lock()
+		counter, other := counters[replica], counters[rng.Intn(stressReplicas)]
+		mu.Unlock()
+
+		NodeID := fmt.Sprintf("node%d", replica)
+		switch rng.Intn(5) {
+		case 0:
+			counter.Increment(NodeID, uint32(1+rng.Intn(3)))
+		case 1:
+			counter.Decrement(NodeID, uint32(1+rng.Intn(3)))
+		case 2:
+			counter.Transfer(NodeID, fmt.Sprintf("node%d", rng.Intn(stressReplicas)), 1)
+		case 3:
+			// Counters read each other in both directions at the same time
+			counter.ValueSince(other)
+			counter.RightsSince(NodeID, other)
+			counter.Compare(other)
+		case 4:
+			merged := counter.Merge(other)
+			mu.Lock()
+			counters[replica] = merged.Merge(counters[replica])
+			mu.Unlock()
+		}
+	})
+
+	merged := NewBoundedPNCounter()
+	for _, counter := range counters {
+		merged = merged.Merge(counter)
+	}
+	for _, counter := range counters {
+		assert.Equal(t, merged.Value(), counter.Merge(merged).Value())
+	}
+	assert.GreaterOrEqual(t, merged.Value(), int32(0))
+}
+
+func TestAWSetConcurrentMerges(t *testing.T) {
+	sets := make([]*AWSet, stressReplicas)
+	for i := range sets {
+		sets[i] = NewAWSet()
+	}
+
+	stress(func(rng *rand.Rand, replica int) {
+		set := sets[replica]
+		itemName := fmt.Sprintf("item%d", rng.Intn(5))
+
+		switch rng.Intn(5) {
+		case 0:
+			set.AddI(itemName, fmt.Sprintf("node%d", replica))
+		case 1:
+			set.RmvI(itemName)
+		case 2:
+			set.Merge(sets[rng.Intn(stressReplicas)])
+		case 3:
+			set.Filter(sets[rng.Intn(stressReplicas)])
+			set.Contains(itemName)
+		case 4:
+			_, err := json.Marshal(set)
+			require.NoError(t, err)
+			_, err = set.MarshalBinary()
+			require.NoError(t, err)
+			set.CanonicalContext()
+		}
+	})
+
+	for _, set := range sets {
+		for _, other := range sets {
+			set.Merge(other)
+		}
+	}
+	for _, set := range sets {
+		assert.Equal(t, sets[0].Elements(), set.Elements())
+	}
+}
+
+func TestShoppingListConcurrentMerges(t *testing.T) {
+	lists := make([]*ShoppingList, stressReplicas)
+	for i := range lists {
+		lists[i] = NewShoppingList()
+		lists[i].NodeID = fmt.Sprintf("node%d", i)
+	}
+
+	stress(func(rng *rand.Rand, replica int) {
+		list := lists[replica]
+		itemName := fmt.Sprintf("item%d", rng.Intn(5))
+
+		switch rng.Intn(10) {
+		case 0:
+			list.AddOrUpdateItem(itemName, 1+rng.Intn(3), list.NodeID)
+		case 1:
+			list.AddOrUpdateItem(itemName, -1, list.NodeID)
+		case 2:
+			list.RemoveItem(itemName, list.NodeID)
+		case 3:
+			list.TransferRights(itemName, list.NodeID, fmt.Sprintf("node%d", rng.Intn(stressReplicas)), 1)
+		case 4:
+			list.MoveItem(itemName, "", list.NodeID)
+			list.EditItemNote(itemName, 0, 0, "x", list.NodeID)
+		case 5:
+			list.RenameItem(itemName, fmt.Sprintf("item%d", rng.Intn(5)), list.NodeID)
+			list.MarkSeen(list.NodeID)
+		case 6, 7:
+			// Lists are merged into each other in both directions at the same time
+			list.Merge(lists[rng.Intn(stressReplicas)])
+		case 8:
+			snapshot := list.Snapshot()
+			snapshot.NodeID = fmt.Sprintf("node%d", stressReplicas+replica)
+			snapshot.AddOrUpdateItem(itemName, 1, snapshot.NodeID)
+			list.Merge(snapshot)
+		case 9:
+			list.GetOrderedItems()
+			list.GetItemQuantity(itemName)
+			list.GetHistory()
+			_, err := json.Marshal(list)
+			require.NoError(t, err)
+			_, err = list.MarshalBinary()
+			require.NoError(t, err)
+			list.CanonicalNotes()
+		}
+	})
+
+	for round := 0; round < 2; round++ {
+		for _, list := range lists {
+			for _, other := range lists {
+				list.Merge(other)
+			}
+		}
+	}
+
+	for _, list := range lists {
+		assert.Equal(t, lists[0].GetOrderedItems(), list.GetOrderedItems())
+		for _, itemName := range list.GetItems() {
+			want, _ := lists[0].GetItemQuantity(itemName)
+			got, _ := list.GetItemQuantity(itemName)
+			assert.Equal(t, want, got, itemName)
+		}
+	}
+}
+
+func TestShoppingListV2ConcurrentMerges(t *testing.T) {
+	lists := make([]*ShoppingListV2, stressReplicas)
+	for i := range lists {
+		lists[i] = NewShoppingListV2()
+		lists[i].NodeID = fmt.Sprintf("node%d", i)
+	}
+
+	stress(func(rng *rand.Rand, replica int) {
+		list := lists[replica]
+		itemName := fmt.Sprintf("item%d", rng.Intn(5))
+
+		switch rng.Intn(5) {
+		case 0:
+			list.AddOrUpdateItem(itemName, 1+rng.Intn(3), list.NodeID)
+		case 1:
+			list.PurchaseItem(itemName, 1, list.NodeID)
+		case 2:
+			list.RemoveItem(itemName)
+		case 3:
+			list.Merge(lists[rng.Intn(stressReplicas)])
+		case 4:
+			list.GetItemQuantityNeeded(itemName)
+			_, err := list.MarshalBinary()
+			require.NoError(t, err)
+		}
+	})
+
+	for _, list := range lists {
+		for _, other := range lists {
+			list.Merge(other)
+		}
+	}
+	for _, list := range lists {
+		assert.Equal(t, lists[0].GetItems(), list.GetItems())
+	}
+}
+
+func TestShoppingListSnapshotIsCopyOnWrite(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+	list.SetItemNote("milk", "semi skimmed", list.NodeID)
+
+	snapshot := list.Snapshot()
+
+	// The snapshot shares the state until one of them changes
+	assert.Same(t, list.AwSet, snapshot.AwSet)
+
+	list.AddOrUpdateItem("milk", 1, list.NodeID)
+	list.EditItemNote("milk", 0, 0, "2L ", list.NodeID)
+	list.RenameItem("milk", "Milk", list.NodeID)
+
+	q, _ := snapshot.GetItemQuantity("milk")
+	assert.Equal(t, int32(2), q)
+	note, _ := snapshot.GetItemNote("milk")
+	assert.Equal(t, "semi skimmed", note)
+
+	snapshot.NodeID = "other"
+	snapshot.AddOrUpdateItem("eggs", 6, snapshot.NodeID)
+	assert.Equal(t, []string{"Milk"}, list.GetItems())
+	assert.Equal(t, []string{"eggs", "milk"}, snapshot.GetItems())
+
+	// A snapshot merges like the list it was taken from
+	list.Merge(snapshot)
+	q, _ = list.GetItemQuantity("Milk")
+	assert.Equal(t, int32(3), q)
+	q, _ = list.GetItemQuantity("eggs")
+	assert.Equal(t, int32(6), q)
+
+	// Merging a list into itself changes nothing
+	before, err := list.MarshalBinary()
+	require.NoError(t, err)
+	list.Merge(list)
+	after, err := list.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
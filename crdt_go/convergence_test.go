@@ -0,0 +1,481 @@
+package crdt_go
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Random histories checked for every type, each one with up to convergenceSteps steps
+var convergenceRuns = 500
+
+const (
+	convergenceReplicas = 3
+	convergenceSteps    = 40
+)
+
+// Items and names used by the generated operations, few so that replicas often touch the same ones
+var convergenceItems = []string{"milk", "eggs", "bread", "Milk"}
+var convergenceNotes = []string{"", "semi skimmed", "2L", "free range eggs"}
+
+type stepKind int
+
+const (
+	stepOp      stepKind = iota // A replica makes an operation
+	stepSend                    // A replica sends its state to another one
+	stepDeliver                 // A sent state is merged by the replica it was sent to, it may be delivered again
+)
+
+// step is a step of a generated history.
+type step struct {
+	Kind    stepKind
+	Replica int // The replica making the operation, or sending its state
+	To      int // The replica a state is sent to
+	Message int // The id of the state sent, or of the one delivered
+	Op      int // Which operation, depends on the type
+	Item    int
+	Other   int // Another item or replica, depends on the operation
+	Amount  int
+}
+
+type history []step
+
+// convergenceModel describes how to run histories on a CRDT type.
+type convergenceModel[T any] struct {
+	ops      int // Number of different operations
+	new      func(replica int) T
+	apply    func(state T, replica int, s step)
+	describe func(s step) string
+	merge    func(state T, other T) T // Returns the merged state, which may be state itself
+	clone    func(state T) T
+	observe  func(state T) []byte // Everything replicas must agree on, in a canonical form
+	show     func(state T) string // What is shown of a state when replicas diverge
+}
+
+// generate builds a random history of local operations, sends and deliveries, in any order and
+// possibly delivering the same state more than once.
+func (m convergenceModel[T]) generate(rng *rand.Rand) history {
+	h := make(history, 0, convergenceSteps)
+	sent := 0
+
+	for i := rng.Intn(convergenceSteps) + 1; i > 0; i-- {
+		s := step{
+			Replica: rng.Intn(convergenceReplicas),
+			To:      rng.Intn(convergenceReplicas),
+			Op:      rng.Intn(m.ops),
+			Item:    rng.Intn(len(convergenceItems)),
+			Other:   rng.Intn(len(convergenceItems)),
+			Amount:  rng.Intn(5) + 1,
+		}
+
+		switch r := rng.Intn(4); {
+		case r < 2 || sent == 0 && r == 3:
+			s.Kind = stepOp
+		case r == 2:
+			s.Kind = stepSend
+			s.Message = sent
+			sent++
+		default:
+			s.Kind = stepDeliver
+			s.Message = rng.Intn(sent)
+		}
+
+		h = append(h, s)
+	}
+
+	return h
+}
+
+// run replays a history and then has every replica merge every other one.
+// Returns an error if the replicas do not end up with the same state, or if the type panics.
+func (m convergenceModel[T]) run(h history) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	replicas := make([]T, convergenceReplicas)
+	for i := range replicas {
+		replicas[i] = m.new(i)
+	}
+
+	type message struct {
+		to    int
+		state T
+	}
+	messages := make(map[int]message)
+
+	for _, s := range h {
+		switch s.Kind {
+		case stepOp:
+			m.apply(replicas[s.Replica], s.Replica, s)
+		case stepSend:
+			messages[s.Message] = message{s.To, m.clone(replicas[s.Replica])}
+		case stepDeliver:
+			// The send may have been shrunk away
+			if msg, ok := messages[s.Message]; ok {
+				replicas[msg.to] = m.merge(replicas[msg.to], m.clone(msg.state))
+			}
+		}
+	}
+
+	// Two rounds are enough for every replica to see every operation
+	for round := 0; round < 2; round++ {
+		for i := range replicas {
+			for j := range replicas {
+				if i != j {
+					replicas[i] = m.merge(replicas[i], m.clone(replicas[j]))
+				}
+			}
+		}
+	}
+
+	first := m.observe(replicas[0])
+	for i := 1; i < len(replicas); i++ {
+		if other := m.observe(replicas[i]); !bytes.Equal(first, other) {
+			return fmt.Errorf("replicas r0 and r%d diverged:\n  r0: %s\n  r%d: %s", i, m.show(replicas[0]), i, m.show(replicas[i]))
+		}
+	}
+
+	return nil
+}
+
+// shrink removes steps and simplifies the remaining ones for as long as the history still fails.
+func (m convergenceModel[T]) shrink(h history) history {
+	fails := func(h history) bool { return m.run(h) != nil }
+
+	for changed := true; changed; {
+		changed = false
+
+		// Remove chunks of steps, from half of the history to single steps
+		for size := len(h) / 2; size >= 1; size /= 2 {
+			for i := 0; i+size <= len(h); {
+				candidate := append(append(history{}, h[:i]...), h[i+size:]...)
+				if fails(candidate) {
+					h = candidate
+					changed = true
+				} else {
+					i += size
+				}
+			}
+		}
+
+		// Use the smallest values that still fail
+		for i := range h {
+			for _, simplify := range []func(s *step){
+				func(s *step) { s.Amount = 1 },
+				func(s *step) { s.Item = 0 },
+				func(s *step) { s.Other = 0 },
+				func(s *step) { s.Op = 0 },
+				func(s *step) { s.Replica = 0 },
+				func(s *step) { s.To = 0 },
+			} {
+				candidate := append(history{}, h...)
+				simplify(&candidate[i])
+				if candidate[i] != h[i] && fails(candidate) {
+					h = candidate
+					changed = true
+				}
+			}
+		}
+	}
+
+	return h
+}
+
+// format shows a history one step per line.
+func (m convergenceModel[T]) format(h history) string {
+	var b strings.Builder
+	for _, s := range h {
+		switch s.Kind {
+		case stepOp:
+			fmt.Fprintf(&b, "  r%d: %s\n", s.Replica, m.describe(s))
+		case stepSend:
+			fmt.Fprintf(&b, "  r%d sends its state to r%d (#%d)\n", s.Replica, s.To, s.Message)
+		case stepDeliver:
+			fmt.Fprintf(&b, "  deliver #%d\n", s.Message)
+		}
+	}
+	return b.String()
+}
+
+// check runs random histories and fails the test with the smallest history found that does not converge.
+func (m convergenceModel[T]) check(t *testing.T, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+
+	for i := 0; i < convergenceRuns; i++ {
+		h := m.generate(rng)
+		if err := m.run(h); err != nil {
+			shrunk := m.shrink(h)
+			t.Fatalf("History %d of seed %d did not converge (%d steps, shrunk from %d):\n%s%v",
+				i, seed, len(shrunk), len(h), m.format(shrunk), m.run(shrunk))
+		}
+	}
+}
+
+func replicaNodeID(replica int) string {
+	return fmt.Sprintf("r%d", replica)
+}
+
+var counterModel = convergenceModel[*BoundedPNCounter]{
+	ops: 3,
+	new: func(replica int) *BoundedPNCounter { return NewBoundedPNCounter() },
+	apply: func(c *BoundedPNCounter, replica int, s step) {
+		switch s.Op {
+		case 0:
+			c.Increment(replicaNodeID(replica), uint32(s.Amount))
+		case 1:
+			c.Decrement(replicaNodeID(replica), uint32(s.Amount))
+		case 2:
+			c.Transfer(replicaNodeID(replica), replicaNodeID(s.Other%convergenceReplicas), uint32(s.Amount))
+		}
+	},
+	describe: func(s step) string {
+		switch s.Op {
+		case 0:
+			return fmt.Sprintf("increment %d", s.Amount)
+		case 1:
+			return fmt.Sprintf("decrement %d", s.Amount)
+		default:
+			return fmt.Sprintf("transfer %d to r%d", s.Amount, s.Other%convergenceReplicas)
+		}
+	},
+	merge: func(c *BoundedPNCounter, other *BoundedPNCounter) *BoundedPNCounter { return c.Merge(other) },
+	clone: func(c *BoundedPNCounter) *BoundedPNCounter { return c.Clone() },
+	observe: func(c *BoundedPNCounter) []byte {
+		data, _ := c.MarshalBinary()
+		return append(data, fmt.Sprintf("value=%d", c.Value())...)
+	},
+	show: func(c *BoundedPNCounter) string {
+		return fmt.Sprintf("value %d, positive %v, negative %v, transfers %v", c.Value(), c.PositiveCount, c.NegativeCount, c.Transfers)
+	},
+}
+
+var awsetModel = convergenceModel[*AWSet]{
+	ops: 2,
+	new: func(replica int) *AWSet { return NewAWSet() },
+	apply: func(s *AWSet, replica int, st step) {
+		switch st.Op {
+		case 0:
+			s.AddI(convergenceItems[st.Item], replicaNodeID(replica))
+		case 1:
+			s.RmvI(convergenceItems[st.Item])
+		}
+	},
+	describe: func(s step) string {
+		if s.Op == 0 {
+			return "add " + convergenceItems[s.Item]
+		}
+		return "remove " + convergenceItems[s.Item]
+	},
+	merge: func(s *AWSet, other *AWSet) *AWSet {
+		s.Merge(other)
+		return s
+	},
+	clone: func(s *AWSet) *AWSet { return s.Clone() },
+	observe: func(s *AWSet) []byte {
+		data, _ := s.MarshalBinary()
+		return append(data, fmt.Sprintf("elements=%v", s.Elements())...)
+	},
+	show: func(s *AWSet) string {
+		return fmt.Sprintf("elements %v, state %v, context %v", s.Elements(), s.State, s.Context)
+	},
+}
+
+// Roles given by the share steps, the empty one removes the client
+var convergenceRoles = []string{ROLE_EDITOR, ROLE_VIEWER, ""}
+
+var shoppingListModel = convergenceModel[*ShoppingList]{
+	ops: 8,
+	new: func(replica int) *ShoppingList {
+		l := NewShoppingList()
+		l.NodeID = replicaNodeID(replica)
+		return l
+	},
+	apply: func(l *ShoppingList, replica int, s step) {
+		item := convergenceItems[s.Item]
+		switch s.Op {
+		case 0:
+			l.AddOrUpdateItem(item, s.Amount, l.NodeID)
+		case 1:
+			l.AddOrUpdateItem(item, -s.Amount, l.NodeID)
+		case 2:
+			l.RemoveItem(item, l.NodeID)
+		case 3:
+			l.TransferRights(item, l.NodeID, replicaNodeID(s.Other%convergenceReplicas), uint32(s.Amount))
+		case 4:
+			after := ""
+			if s.Other != s.Item {
+				after = convergenceItems[s.Other]
+			}
+			l.MoveItem(item, after, l.NodeID)
+		case 5:
+			l.SetItemNote(item, convergenceNotes[s.Amount%len(convergenceNotes)], l.NodeID)
+		case 6:
+			l.RenameItem(item, convergenceItems[s.Other], l.NodeID)
+		case 7:
+			l.SetRole(replicaNodeID(s.Other%convergenceReplicas), convergenceRoles[s.Amount%len(convergenceRoles)], l.NodeID)
+		}
+	},
+	describe: func(s step) string {
+		item := convergenceItems[s.Item]
+		switch s.Op {
+		case 0:
+			return fmt.Sprintf("add %d %s", s.Amount, item)
+		case 1:
+			return fmt.Sprintf("take %d %s", s.Amount, item)
+		case 2:
+			return "remove " + item
+		case 3:
+			return fmt.Sprintf("transfer %d %s to r%d", s.Amount, item, s.Other%convergenceReplicas)
+		case 4:
+			return fmt.Sprintf("move %s after %q", item, convergenceItems[s.Other])
+		case 5:
+			return fmt.Sprintf("note %s %q", item, convergenceNotes[s.Amount%len(convergenceNotes)])
+		case 6:
+			return fmt.Sprintf("rename %s to %s", item, convergenceItems[s.Other])
+		default:
+			return fmt.Sprintf("share with r%d as %q", s.Other%convergenceReplicas, convergenceRoles[s.Amount%len(convergenceRoles)])
+		}
+	},
+	merge: func(l *ShoppingList, other *ShoppingList) *ShoppingList {
+		l.Merge(other)
+		return l
+	},
+	clone: func(l *ShoppingList) *ShoppingList { return l.Clone() },
+	observe: func(l *ShoppingList) []byte {
+		state := l.Clone()
+		state.NodeID = ""
+		data, _ := state.MarshalBinary()
+
+		return append(data, showShoppingList(l)...)
+	},
+	show: func(l *ShoppingList) string {
+		return fmt.Sprintf("%s names %v", showShoppingList(l), l.Names)
+	},
+}
+
+var shoppingListV2Model = convergenceModel[*ShoppingListV2]{
+	ops: 4,
+	new: func(replica int) *ShoppingListV2 {
+		l := NewShoppingListV2()
+		l.NodeID = replicaNodeID(replica)
+		return l
+	},
+	apply: func(l *ShoppingListV2, replica int, s step) {
+		item := convergenceItems[s.Item]
+		switch s.Op {
+		case 0:
+			l.AddOrUpdateItem(item, s.Amount, l.NodeID)
+		case 1:
+			l.AddOrUpdateItem(item, -s.Amount, l.NodeID)
+		case 2:
+			l.PurchaseItem(item, s.Amount, l.NodeID)
+		case 3:
+			l.RemoveItem(item)
+		}
+	},
+	describe: func(s step) string {
+		item := convergenceItems[s.Item]
+		switch s.Op {
+		case 0:
+			return fmt.Sprintf("add %d %s", s.Amount, item)
+		case 1:
+			return fmt.Sprintf("take %d %s", s.Amount, item)
+		case 2:
+			return fmt.Sprintf("purchase %d %s", s.Amount, item)
+		default:
+			return "remove " + item
+		}
+	},
+	merge: func(l *ShoppingListV2, other *ShoppingListV2) *ShoppingListV2 {
+		l.Merge(other)
+		return l
+	},
+	clone: func(l *ShoppingListV2) *ShoppingListV2 { return l.Clone() },
+	observe: func(l *ShoppingListV2) []byte {
+		state := l.Clone()
+		state.NodeID = ""
+		data, _ := state.MarshalBinary()
+
+		return append(data, showShoppingListV2(l)...)
+	},
+	show: showShoppingListV2,
+}
+
+// showShoppingList shows the items in order, with their quantities and notes.
+func showShoppingList(l *ShoppingList) string {
+	var b strings.Builder
+	for _, item := range l.GetOrderedItems() {
+		quantity, _ := l.GetItemQuantity(item)
+		note, _ := l.GetItemNote(item)
+		fmt.Fprintf(&b, "%s: %d %q; ", item, quantity, note)
+	}
+	return b.String()
+}
+
+// showShoppingListV2 shows the items with how many are needed and purchased.
+func showShoppingListV2(l *ShoppingListV2) string {
+	var b strings.Builder
+	for _, item := range l.GetItems() {
+		needed, _ := l.GetItemQuantityNeeded(item)
+		purchased, _ := l.GetItemQuantityPurchased(item)
+		fmt.Fprintf(&b, "%s: %d needed %d purchased; ", item, needed, purchased)
+	}
+	return b.String()
+}
+
+func TestBoundedPNCounterConverges(t *testing.T) {
+	counterModel.check(t, 1)
+}
+
+func TestAWSetConverges(t *testing.T) {
+	awsetModel.check(t, 2)
+}
+
+func TestShoppingListConverges(t *testing.T) {
+	shoppingListModel.check(t, 3)
+}
+
+func TestShoppingListV2Converges(t *testing.T) {
+	shoppingListV2Model.check(t, 4)
+}
+
+// registerState is a register that keeps the first value it gets, which does not converge.
+type registerState struct {
+	value int
+}
+
+func TestConvergenceHarnessShrinks(t *testing.T) {
+	model := convergenceModel[*registerState]{
+		ops:      1,
+		new:      func(replica int) *registerState { return &registerState{} },
+		apply:    func(r *registerState, replica int, s step) { r.value = s.Amount },
+		describe: func(s step) string { return fmt.Sprintf("set %d", s.Amount) },
+		merge: func(r *registerState, other *registerState) *registerState {
+			if r.value == 0 {
+				r.value = other.value
+			}
+			return r
+		},
+		clone:   func(r *registerState) *registerState { return &registerState{r.value} },
+		observe: func(r *registerState) []byte { return []byte(fmt.Sprint(r.value)) },
+		show:    func(r *registerState) string { return fmt.Sprint(r.value) },
+	}
+
+	rng := rand.New(rand.NewSource(5))
+
+	var h history
+	for h == nil || model.run(h) == nil {
+		h = model.generate(rng)
+	}
+
+	// Two replicas writing different values is enough to diverge
+	shrunk := model.shrink(h)
+	if len(shrunk) != 2 || model.run(shrunk) == nil {
+		t.Fatalf("Expected two failing steps, got:\n%s", model.format(shrunk))
+	}
+}
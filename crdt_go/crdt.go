@@ -6,14 +6,20 @@ import (
 	_ "fmt"
 	"sort"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
-// BoundedPNCounter represents a positive-negative Counter CRDT.
+// BoundedPNCounter represents a positive-negative Counter CRDT that never goes below zero.
+// Every node may only decrement what it has the rights to: what it incremented itself, minus
+// what it already decremented, plus the rights other nodes transferred to it (escrow).
+// The methods of a BoundedPNCounter are safe for concurrent use, they never hold the lock of
+// another counter, so counters can be merged into each other at the same time.
 type BoundedPNCounter struct {
-	PositiveCount map[string]uint32 `json:"positive_count"`
-	NegativeCount map[string]uint32 `json:"negative_count"`
+	PositiveCount map[string]uint32            `json:"positive_count"`
+	NegativeCount map[string]uint32            `json:"negative_count"`
+	Transfers     map[string]map[string]uint32 `json:"transfers"`
 	mu            sync.Mutex
 }
 
@@ -22,6 +28,7 @@ func NewBoundedPNCounter() *BoundedPNCounter {
 	return &BoundedPNCounter{
 		PositiveCount: make(map[string]uint32),
 		NegativeCount: make(map[string]uint32),
+		Transfers:     make(map[string]map[string]uint32),
 	}
 }
 
@@ -37,42 +44,168 @@ func (c *BoundedPNCounter) Increment(NodeID string, amount uint32) {
 	}
 }
 
-// Decrement decrements the negative count for a given node.
-func (c *BoundedPNCounter) Decrement(NodeID string, amount uint32) {
+// Decrement decrements the negative count for a given node, bounded by the rights the node holds.
+// Returns the amount that was actually decremented.
+func (c *BoundedPNCounter) Decrement(NodeID string, amount uint32) uint32 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if current, ok := c.NegativeCount[NodeID]; ok {
-		if current+amount > c.PositiveCount[NodeID] {
-			c.NegativeCount[NodeID] = c.PositiveCount[NodeID]
-		} else {
-			c.NegativeCount[NodeID] = current + amount
+	amount = boundByRights(amount, c.rightsSince(NodeID, nil))
+	if amount == 0 {
+		return 0
+	}
+
+	c.NegativeCount[NodeID] += amount
+
+	return amount
+}
+
+// Transfer moves up to amount of the rights held by one node to another node.
+// Returns the amount that was actually transferred.
+func (c *BoundedPNCounter) Transfer(from string, to string, amount uint32) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if from == to {
+		return 0
+	}
+
+	amount = boundByRights(amount, c.rightsSince(from, nil))
+	if amount == 0 {
+		return 0
+	}
+
+	if c.Transfers == nil {
+		c.Transfers = make(map[string]map[string]uint32)
+	}
+	if _, ok := c.Transfers[from]; !ok {
+		c.Transfers[from] = make(map[string]uint32)
+	}
+	c.Transfers[from][to] += amount
+
+	return amount
+}
+
+// Rights returns how much a given node is still allowed to decrement.
+func (c *BoundedPNCounter) Rights(NodeID string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rightsSince(NodeID, nil)
+}
+
+// RightsSince returns how much a given node is allowed to decrement, ignoring everything already observed by base.
+func (c *BoundedPNCounter) RightsSince(NodeID string, base *BoundedPNCounter) int64 {
+	if base == nil || base == c {
+		return c.Rights(NodeID)
+	}
+
+	base = base.Snapshot()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rightsSince(NodeID, base)
+}
+
+// rightsSince computes the rights of a node, the caller must hold the locks.
+func (c *BoundedPNCounter) rightsSince(NodeID string, base *BoundedPNCounter) int64 {
+	var rights int64 = int64(c.PositiveCount[NodeID]) - int64(c.NegativeCount[NodeID])
+
+	for to, amount := range c.Transfers[NodeID] {
+		rights -= int64(amount)
+		if base != nil {
+			rights += int64(base.Transfers[NodeID][to])
 		}
-	} else {
-		c.NegativeCount[NodeID] = amount
 	}
+
+	for from, transfers := range c.Transfers {
+		rights += int64(transfers[NodeID])
+		if base != nil {
+			rights -= int64(base.Transfers[from][NodeID])
+		}
+	}
+
+	if base != nil {
+		rights -= int64(base.PositiveCount[NodeID]) - int64(base.NegativeCount[NodeID])
+	}
+
+	return rights
+}
+
+// boundByRights clamps an amount to the available rights.
+func boundByRights(amount uint32, rights int64) uint32 {
+	if rights <= 0 {
+		return 0
+	}
+	if int64(amount) > rights {
+		return uint32(rights)
+	}
+	return amount
+}
+
+// nodes returns every node that holds or has held rights in the Counter, the caller must hold the locks.
+func (c *BoundedPNCounter) nodes() map[string]struct{} {
+	nodes := make(map[string]struct{})
+
+	for NodeID := range c.PositiveCount {
+		nodes[NodeID] = struct{}{}
+	}
+
+	for NodeID := range c.NegativeCount {
+		nodes[NodeID] = struct{}{}
+	}
+
+	for from, transfers := range c.Transfers {
+		nodes[from] = struct{}{}
+		for to := range transfers {
+			nodes[to] = struct{}{}
+		}
+	}
+
+	return nodes
 }
 
 // Value returns the computed value of the Counter.
 func (c *BoundedPNCounter) Value() int32 {
+	return c.ValueSince(nil)
+}
+
+// ValueSince returns the value of the Counter ignoring everything already observed by base.
+// The value is the sum of the rights of every node. A node that consumed rights base already
+// cancelled (e.g. a decrement concurrent with a removal) counts as zero, but a node that spent
+// more than it ever held counts with its negative rights, so an overspend is never hidden.
+func (c *BoundedPNCounter) ValueSince(base *BoundedPNCounter) int32 {
+	if base == c {
+		return 0
+	}
+	if base != nil {
+		base = base.Snapshot()
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	var sumPositive, sumNegative uint32
-
-	for _, v := range c.PositiveCount {
-		sumPositive += v
-	}
+	var sum int64
 
-	for _, v := range c.NegativeCount {
-		sumNegative += v
+	for NodeID := range c.nodes() {
+		rights := c.rightsSince(NodeID, base)
+		if rights < 0 {
+			rights = min64(0, c.rightsSince(NodeID, nil))
+		}
+		sum += rights
 	}
 
-	return int32(sumPositive - sumNegative)
+	return int32(sum)
 }
 
 // Compare compares two BoundedPNCounters.
 func (c *BoundedPNCounter) Compare(other *BoundedPNCounter) bool {
+	if other == c {
+		return true
+	}
+	other = other.Snapshot()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -96,10 +229,28 @@ func (c *BoundedPNCounter) Compare(other *BoundedPNCounter) bool {
 		}
 	}
 
+	for from, transfers := range c.Transfers {
+		for to, val1 := range transfers {
+			val2, ok := other.Transfers[from][to]
+			if !ok {
+				continue
+			}
+			if val1 > val2 {
+				return false
+			}
+		}
+	}
+
 	return true
 }
 
+// Merge returns a new BoundedPNCounter with everything observed by both counters.
 func (c *BoundedPNCounter) Merge(other *BoundedPNCounter) *BoundedPNCounter {
+	if other == c {
+		return c.Clone()
+	}
+	other = other.Snapshot()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -135,6 +286,18 @@ func (c *BoundedPNCounter) Merge(other *BoundedPNCounter) *BoundedPNCounter {
 		}
 	}
 
+	// Merge transfers, each (from, to) pair only ever grows
+	for _, transfers := range []map[string]map[string]uint32{c.Transfers, other.Transfers} {
+		for from, toCounts := range transfers {
+			if _, ok := merged.Transfers[from]; !ok {
+				merged.Transfers[from] = make(map[string]uint32)
+			}
+			for to, count := range toCounts {
+				merged.Transfers[from][to] = max(merged.Transfers[from][to], count)
+			}
+		}
+	}
+
 	return merged
 }
 
@@ -146,16 +309,29 @@ func max(a, b uint32) uint32 {
 	return b
 }
 
+// max64 returns the maximum of two int64 values.
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// min64 returns the minimum of two int64 values.
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // Clone creates a deep copy of the BoundedPNCounter.
 func (c *BoundedPNCounter) Clone() *BoundedPNCounter {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// Create a new BoundedPNCounter with the same positive and negative counts
-	clone := &BoundedPNCounter{
-		PositiveCount: make(map[string]uint32),
-		NegativeCount: make(map[string]uint32),
-	}
+	clone := NewBoundedPNCounter()
 
 	// Copy positive counts
 	for NodeID, count := range c.PositiveCount {
@@ -167,13 +343,39 @@ func (c *BoundedPNCounter) Clone() *BoundedPNCounter {
 		clone.NegativeCount[NodeID] = count
 	}
 
+	// Copy transfers
+	for from, transfers := range c.Transfers {
+		clone.Transfers[from] = make(map[string]uint32)
+		for to, count := range transfers {
+			clone.Transfers[from][to] = count
+		}
+	}
+
 	return clone
 }
 
+// Snapshot returns a copy of the BoundedPNCounter that can be read while the counter changes.
+// Counters are small, so a snapshot is a deep copy.
+func (c *BoundedPNCounter) Snapshot() *BoundedPNCounter {
+	return c.Clone()
+}
+
+// MarshalJSON encodes the BoundedPNCounter while holding its lock.
+func (c *BoundedPNCounter) MarshalJSON() ([]byte, error) {
+	type counter BoundedPNCounter
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return json.Marshal((*counter)(c))
+}
+
 // AWSet represents an Add-Wins Set CRDT.
+// The methods of an AWSet are safe for concurrent use.
 type AWSet struct {
 	State   []item        `json:"state"`
 	Context []ContextItem `json:"context"`
+	mu      sync.RWMutex
 }
 
 type item struct {
@@ -268,7 +470,16 @@ func NewAWSet() *AWSet {
 	}
 }
 
+// Clone creates a deep copy of the AWSet.
 func (s *AWSet) Clone() *AWSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.clone()
+}
+
+// clone creates a deep copy of the AWSet, the caller must hold the lock
+func (s *AWSet) clone() *AWSet {
 
 	// Create a new AWSet and copy the exported fields
 	clone := &AWSet{
@@ -282,8 +493,25 @@ func (s *AWSet) Clone() *AWSet {
 	return clone
 }
 
+// Snapshot returns a copy of the AWSet that can be read while the set changes.
+func (s *AWSet) Snapshot() *AWSet {
+	return s.Clone()
+}
+
+// MarshalJSON encodes the AWSet while holding its lock.
+func (s *AWSet) MarshalJSON() ([]byte, error) {
+	type awset AWSet
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.Marshal((*awset)(s))
+}
+
 // Elements returns the unique elements in the AWSet.
 func (s *AWSet) Elements() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	uniqueItems := make(map[string]struct{})
 
@@ -302,6 +530,8 @@ func (s *AWSet) Elements() []string {
 
 // Contains checks if the given element is in the AWSet.
 func (s *AWSet) Contains(itemName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	for _, item := range s.State {
 		if item.Name == itemName {
@@ -314,6 +544,14 @@ func (s *AWSet) Contains(itemName string) bool {
 
 // MaxI returns the maximum Counter for a given node in the Context.
 func (s *AWSet) MaxI(NodeID string) uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.maxI(NodeID)
+}
+
+// maxI returns the maximum Counter for a given node, the caller must hold the lock
+func (s *AWSet) maxI(NodeID string) uint32 {
 
 	var maxCounter uint32
 	for _, ctxItem := range s.Context {
@@ -334,8 +572,10 @@ func (s *AWSet) NextI(NodeID string) (string, uint32) {
 
 // AddI adds an item to the AWSet.
 func (s *AWSet) AddI(itemName string, NodeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	NodeID, Counter := s.NextI(NodeID)
+	Counter := s.maxI(NodeID) + 1
 
 	// Remove old Context items for the same node
 	var newContext []ContextItem
@@ -362,6 +602,8 @@ func (s *AWSet) AddI(itemName string, NodeID string) {
 
 // RmvI removes an item from the AWSet.
 func (s *AWSet) RmvI(itemName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Remove old State items with the same Name
 	var newState []item
@@ -375,13 +617,25 @@ func (s *AWSet) RmvI(itemName string) {
 
 // Filter returns the filtered State of the AWSet.
 func (s *AWSet) Filter(incAWSet *AWSet) []item {
+	if incAWSet != s {
+		incAWSet = incAWSet.Snapshot()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filter(incAWSet)
+}
+
+// filter returns the items of the State not yet observed by the other AWSet, the caller must hold the locks
+func (s *AWSet) filter(incAWSet *AWSet) []item {
 
 	var result []item
 
 	for _, StateItem := range s.State {
 		include := true
 		for _, ctxItem := range incAWSet.Context {
-			if StateItem.NodeID == ctxItem.NodeID && StateItem.Counter < ctxItem.Counter {
+			if StateItem.NodeID == ctxItem.NodeID && StateItem.Counter <= ctxItem.Counter {
 				include = false
 				break
 			}
@@ -420,33 +674,39 @@ func exclusiveItemUnion(slice1, slice2 []item) []item {
 	return result
 }
 
-// exclusiveContextItemUnion returns the exclusive union of two slices of ContextItems.
+// exclusiveContextItemUnion returns the union of two slices of ContextItems, keeping only the highest Counter of each node.
 func exclusiveContextItemUnion(slice1, slice2 []ContextItem) []ContextItem {
-	set := make(map[ContextItem]struct{})
+	set := make(map[string]uint32)
 
 	// Add items from slice1 to the set
 	for _, item := range slice1 {
-		set[item] = struct{}{}
+		set[item.NodeID] = max(set[item.NodeID], item.Counter)
 	}
 
-	// Add items from slice2 to the set, excluding common elements
+	// Add items from slice2 to the set, keeping the highest counter
 	for _, item := range slice2 {
-		if _, exists := set[item]; !exists {
-			set[item] = struct{}{}
-		}
+		set[item.NodeID] = max(set[item.NodeID], item.Counter)
 	}
 
 	// Create a slice with the elements in the set
 	result := make([]ContextItem, 0, len(set))
-	for k := range set {
-		result = append(result, k)
+	for NodeID, Counter := range set {
+		result = append(result, ContextItem{NodeID, Counter})
 	}
 
 	return result
 }
 
 // Merge merges two AWSets.
+// The incoming AWSet is read from a snapshot, so it can be changed by others while it is merged.
 func (s *AWSet) Merge(incAWSet *AWSet) {
+	if incAWSet == s {
+		return
+	}
+	incAWSet = incAWSet.Snapshot()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Intersection of States
 	var intersection []item
@@ -459,8 +719,8 @@ func (s *AWSet) Merge(incAWSet *AWSet) {
 	}
 
 	// Union of filtered States
-	filteredState1 := s.Filter(incAWSet)
-	filteredState2 := incAWSet.Filter(s)
+	filteredState1 := s.filter(incAWSet)
+	filteredState2 := incAWSet.filter(s)
 	exclusiveUnionState := exclusiveItemUnion(filteredState1, filteredState2)
 	exclusiveUnionState = exclusiveItemUnion(exclusiveUnionState, intersection)
 	union := exclusiveUnionState
@@ -475,18 +735,46 @@ func (s *AWSet) Merge(incAWSet *AWSet) {
 }
 
 // ShoppingList represents a shopping list with CRDT support.
+// Items are identified by a stable id, the key in the AWSet, the counters, the order and the notes.
+// An item gets the name it was first added with as id, so replicas that add the same item
+// concurrently add the same one. The name shown for an item is kept in a Register, so renaming it
+// keeps its quantity, rights, note and history.
+//
+// The methods of a ShoppingList are safe for concurrent use. Its fields must only be changed
+// through them, as they may be shared with a Snapshot.
+//
+// Every change is made by an actor, the NodeID passed to the mutator: a list is edited by many
+// clients, so the actor can not be part of the replicated state.
 type ShoppingList struct {
-	NodeID string                       `json:"node_id"`
-	Items  map[string]*BoundedPNCounter `json:"items"`
-	AwSet  *AWSet                       `json:"awset"`
+	NodeID  string                       `json:"node_id"` // Only kept for the clients that still read it, changes never use it
+	Items   map[string]*BoundedPNCounter `json:"items"`
+	AwSet   *AWSet                       `json:"awset"`
+	Removed map[string]*BoundedPNCounter `json:"removed"`
+	History *OperationLog                `json:"history,omitempty"`
+	Order   *Sequence                    `json:"order,omitempty"`
+	Notes   map[string]*Text             `json:"notes,omitempty"` // Collaborative notes of the items
+	Names   map[string]Register          `json:"names,omitempty"` // Names of the renamed items, the others are shown by their id
+	ACL     *ACL                         `json:"acl,omitempty"`   // Who can read and change the list, everybody if it has no owner
+
+	Tombstone *Tombstone `json:"tombstone,omitempty"` // Set when the list was deleted, its content is then empty
+
+	Replicas map[string]VersionVector `json:"replicas,omitempty"` // What each replica observed, to know what is causally stable
+	Folded   map[string]uint64        `json:"folded,omitempty"`   // Retired nodes, folded into BASE_ACTOR
+	Pruned   map[string]uint64        `json:"pruned,omitempty"`   // Removed items whose counters were forgotten, with when
+
+	mu     sync.RWMutex
+	shared bool // The state is shared with a snapshot, it is copied before the next change
 }
 
 // NewShoppingList creates a new ShoppingList.
 func NewShoppingList() *ShoppingList {
 	return &ShoppingList{
-		NodeID: generateNodeID(),
-		Items:  make(map[string]*BoundedPNCounter),
-		AwSet:  NewAWSet(),
+		NodeID:  generateNodeID(),
+		Items:   make(map[string]*BoundedPNCounter),
+		AwSet:   NewAWSet(),
+		Removed: make(map[string]*BoundedPNCounter),
+		History: NewOperationLog(),
+		Order:   NewSequence(),
 	}
 }
 
@@ -496,71 +784,424 @@ func generateNodeID() string {
 }
 
 // Use boolean and u32 like in the Rust version ?
-// AddOrUpdateItem adds or updates an item in the shopping list.
-func (l *ShoppingList) AddOrUpdateItem(itemName string, quantityChange int) {
+// AddOrUpdateItem adds or updates an item in the shopping list, as the given node.
+func (l *ShoppingList) AddOrUpdateItem(itemName string, quantityChange int, NodeID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A deleted list can not get items back
+	if l.Tombstone != nil {
+		return
+	}
+	l.own()
+
+	itemId := l.itemIdForAdd(itemName, NodeID)
 
-	if _, ok := l.Items[itemName]; !ok {
-		l.Items[itemName] = NewBoundedPNCounter()
+	if _, ok := l.Items[itemId]; !ok {
+		// A re-added item starts from what was removed, so old increments stay cancelled
+		if removed, wasRemoved := l.Removed[itemId]; wasRemoved {
+			l.Items[itemId] = removed.Clone()
+		} else {
+			l.Items[itemId] = NewBoundedPNCounter()
+		}
 	}
 
 	if quantityChange < 0 {
-		l.Items[itemName].Decrement(l.NodeID, uint32(-quantityChange))
-		l.AwSet.AddI(itemName, l.NodeID)
+		// This node can only take back what it has the rights to since the item was last removed
+		amount := boundByRights(uint32(-quantityChange), l.Items[itemId].RightsSince(NodeID, l.Removed[itemId]))
+		l.Items[itemId].Decrement(NodeID, amount)
+		l.AwSet.AddI(itemId, NodeID)
+		if amount > 0 {
+			l.record(Operation{NodeID: NodeID, Op: OP_DECREMENT, Item: itemId, Delta: -int64(amount)})
+		}
 	} else if quantityChange > 0 {
-		l.Items[itemName].Increment(l.NodeID, uint32(quantityChange))
-		l.AwSet.AddI(itemName, l.NodeID)
+		l.Items[itemId].Increment(NodeID, uint32(quantityChange))
+		l.AwSet.AddI(itemId, NodeID)
+		l.record(Operation{NodeID: NodeID, Op: OP_INCREMENT, Item: itemId, Delta: int64(quantityChange)})
+	}
+
+	// New items go to the end of the list
+	if l.AwSet.Contains(itemId) && !l.order().Contains(itemId) {
+		l.order().Append(itemId, NodeID)
 	}
 }
 
-// RemoveItem removes an item from the shopping list.
-func (l *ShoppingList) RemoveItem(itemName string) {
+// order returns the Sequence that keeps the order of the items, lists from before it existed have none
+func (l *ShoppingList) order() *Sequence {
+	if l.Order == nil {
+		l.Order = NewSequence()
+	}
+	return l.Order
+}
 
-	l.AwSet.RmvI(itemName)
-	delete(l.Items, itemName)
+// MoveItem places an item right after another one, or first if after is empty, as the given node.
+// Returns false if the item is not on the list.
+func (l *ShoppingList) MoveItem(itemName string, after string, NodeID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	itemId, ok := l.itemId(itemName)
+	if !ok {
+		return false
+	}
+	if after != "" {
+		after = l.itemIdOrName(after)
+	}
+
+	l.own()
+	l.order().Move(itemId, after, l.orderedItemIds(), NodeID)
+	l.record(Operation{NodeID: NodeID, Op: OP_MOVE, Item: itemId, Target: after})
+
+	return true
+}
+
+// GetItemNote returns the note of an item. If the item does not exist, the second return is false.
+func (l *ShoppingList) GetItemNote(itemName string) (string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	itemId, ok := l.itemId(itemName)
+	if !ok {
+		return "", false
+	}
+	if note, ok := l.Notes[itemId]; ok {
+		return note.String(), true
+	}
+	return "", true
+}
+
+// EditItemNote deletes deleteCount characters of an item's note at index and then inserts text there,
+// as the given node. Indexes count characters, not bytes. Returns false if the item is not on the list.
+func (l *ShoppingList) EditItemNote(itemName string, index int, deleteCount int, text string, NodeID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	itemId, ok := l.itemId(itemName)
+	if !ok {
+		return false
+	}
+
+	l.own()
+	note := l.note(itemId)
+	deleted := note.Delete(index, deleteCount)
+	note.Insert(index, text, NodeID)
+
+	l.recordNote(itemId, deleted, utf8.RuneCountInString(text), NodeID)
+
+	return true
+}
+
+// SetItemNote changes an item's note to text as the given node, only the characters that differ are edited.
+// Returns false if the item is not on the list.
+func (l *ShoppingList) SetItemNote(itemName string, text string, NodeID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	itemId, ok := l.itemId(itemName)
+	if !ok {
+		return false
+	}
+
+	l.own()
+	deleted, inserted := l.note(itemId).Replace(text, NodeID)
+	l.recordNote(itemId, deleted, inserted, NodeID)
+
+	return true
+}
+
+// note returns the note of an item, creating it if the item has none
+func (l *ShoppingList) note(itemId string) *Text {
+	if l.Notes == nil {
+		l.Notes = make(map[string]*Text)
+	}
+	if _, ok := l.Notes[itemId]; !ok {
+		l.Notes[itemId] = NewText()
+	}
+	return l.Notes[itemId]
+}
+
+// recordNote records an edit of a note, the delta is how many characters the note grew
+func (l *ShoppingList) recordNote(itemId string, deleted int, inserted int, NodeID string) {
+	if deleted == 0 && inserted == 0 {
+		return
+	}
+	l.record(Operation{NodeID: NodeID, Op: OP_NOTE, Item: itemId, Delta: int64(inserted - deleted)})
+}
+
+// record appends an operation to the history of the list
+func (l *ShoppingList) record(op Operation) {
+	if l.History == nil {
+		l.History = NewOperationLog()
+	}
+	l.History.Record(op)
+}
+
+// RightsTransfer is a request to move the rights to decrement an item's quantity from one node to another.
+type RightsTransfer struct {
+	ItemName string `json:"item"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Amount   uint32 `json:"amount"`
+}
+
+// TransferRights gives another node the rights to decrement up to amount of an item's quantity.
+// Returns the amount that was actually transferred, which is bounded by the rights the sender holds.
+func (l *ShoppingList) TransferRights(itemName string, from string, to string, amount uint32) uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.applyTransfer(RightsTransfer{ItemName: itemName, From: from, To: to, Amount: amount})
+}
+
+// ApplyTransfer applies a rights transfer to the shopping list.
+// Returns the amount that was actually transferred, which is bounded by the rights of the sender.
+func (l *ShoppingList) ApplyTransfer(transfer RightsTransfer) uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.applyTransfer(transfer)
+}
+
+// applyTransfer applies a rights transfer, the caller must hold the write lock
+func (l *ShoppingList) applyTransfer(transfer RightsTransfer) uint32 {
+	itemId, ok := l.itemId(transfer.ItemName)
+	if !ok {
+		return 0
+	}
+	if _, ok := l.Items[itemId]; !ok {
+		return 0
+	}
+
+	l.own()
+	counter := l.Items[itemId]
+
+	amount := boundByRights(transfer.Amount, counter.RightsSince(transfer.From, l.Removed[itemId]))
+
+	transferred := counter.Transfer(transfer.From, transfer.To, amount)
+	if transferred > 0 {
+		l.record(Operation{NodeID: transfer.From, Op: OP_TRANSFER, Item: itemId, Delta: int64(transferred), Target: transfer.To})
+	}
+
+	return transferred
+}
+
+// GetItemRights returns how much of an item's quantity a node is allowed to decrement.
+func (l *ShoppingList) GetItemRights(itemName string, NodeID string) int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	itemId, ok := l.itemId(itemName)
+	if !ok {
+		return 0
+	}
+	counter, ok := l.Items[itemId]
+	if !ok {
+		return 0
+	}
+	return max64(0, counter.RightsSince(NodeID, l.Removed[itemId]))
+}
+
+// Overspent returns the items on which a node spent more than it holds, that is where its rights are
+// negative and lower than in before (nil for a new list). It happens when two changes of the same node
+// were made from states that did not see each other, e.g. sent to two replicas at once.
+func (l *ShoppingList) Overspent(NodeID string, before *ShoppingList) []string {
+	if before != nil {
+		before = before.Snapshot()
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var overspent []string
+	for itemId, counter := range l.Items {
+		rights := counter.Rights(NodeID)
+		if rights >= 0 {
+			continue
+		}
+		if before != nil {
+			if previous, ok := before.Items[itemId]; ok && previous.Rights(NodeID) <= rights {
+				continue
+			}
+		}
+		overspent = append(overspent, l.itemName(itemId))
+	}
+	sort.Strings(overspent)
+
+	return overspent
+}
+
+// RemoveItem removes an item from the shopping list, as the given node.
+// Only the increments observed by this replica are cancelled, concurrent ones survive the merge.
+func (l *ShoppingList) RemoveItem(itemName string, NodeID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Tombstone != nil {
+		return
+	}
+	l.own()
+
+	itemId := l.itemIdOrName(itemName)
+
+	if quantity, ok := l.itemQuantity(itemName); ok {
+		l.record(Operation{NodeID: NodeID, Op: OP_REMOVE, Item: itemId, Delta: -int64(quantity)})
+	}
+
+	l.AwSet.RmvI(itemId)
+
+	counter, ok := l.Items[itemId]
+	if !ok {
+		return
+	}
+
+	if l.Removed == nil {
+		l.Removed = make(map[string]*BoundedPNCounter)
+	}
+
+	if removed, wasRemoved := l.Removed[itemId]; wasRemoved {
+		l.Removed[itemId] = removed.Merge(counter)
+	} else {
+		l.Removed[itemId] = counter.Clone()
+	}
+
+	delete(l.Items, itemId)
 }
 
 // Merge merges two shopping lists.
+// The incoming list is read from a snapshot, so it can be changed by others while it is merged.
 func (l *ShoppingList) Merge(incList *ShoppingList) {
+	incList = incList.Snapshot()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.own()
+
+	// Once either was deleted, only the tombstone and the ACL are merged
+	if l.Tombstone != nil || incList.Tombstone != nil {
+		l.mergeTombstone(incList)
+		return
+	}
 
 	l.AwSet.Merge(incList.AwSet)
 
-	// Merge items based on the merged AWSet
-	for _, itemName := range l.AwSet.Elements() {
-		selfItem, selfExists := l.Items[itemName]
-		incItem, incExists := incList.Items[itemName]
+	// Merge what each replica has observed as removed
+	if l.Removed == nil {
+		l.Removed = make(map[string]*BoundedPNCounter)
+	}
+	for itemName, incRemoved := range incList.Removed {
+		if selfRemoved, ok := l.Removed[itemName]; ok {
+			l.Removed[itemName] = selfRemoved.Merge(incRemoved)
+		} else {
+			l.Removed[itemName] = incRemoved.Clone()
+		}
+	}
 
-		if selfExists && incExists {
+	// Merge every counter, even of items that are not on the list anymore, since rights
+	// transfers are not tied to the AWSet and must survive until the item is re-added
+	for itemName, incItem := range incList.Items {
+		if selfItem, selfExists := l.Items[itemName]; selfExists {
 			l.Items[itemName] = selfItem.Merge(incItem)
-		} else if incExists {
-			l.Items[itemName] = incItem
 		} else {
-			// If the item only exists in one list, use that item
-			l.Items[itemName] = selfItem
+			l.Items[itemName] = incItem.Clone()
+		}
+	}
+
+	// A counter always covers what was removed, so replicas end up with the same state
+	for itemName, counter := range l.Items {
+		if removed, wasRemoved := l.Removed[itemName]; wasRemoved {
+			l.Items[itemName] = counter.Merge(removed)
+		}
+	}
+
+	if incList.History != nil {
+		if l.History == nil {
+			l.History = NewOperationLog()
 		}
+		l.History.Merge(incList.History)
+	}
+
+	if incList.Order != nil {
+		l.order().Merge(incList.Order)
+	}
+
+	// Notes of removed items are kept, like their counters, so a re-added item gets its note back
+	for itemName, incNote := range incList.Notes {
+		l.note(itemName).Merge(incNote)
 	}
+
+	l.mergeNames(incList)
+
+	l.mergeACL(incList)
+
+	l.mergeFolded(incList)
+
+	l.mergePruned(incList)
 }
 
 // GetItems returns the Names of all items in the shopping list.
 func (l *ShoppingList) GetItems() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-	return l.AwSet.Elements()
+	names := l.itemNames(l.AwSet.Elements())
+	sort.Strings(names)
+	return names
+}
+
+// GetOrderedItems returns the Names of all items in the shopping list, in the order of the list.
+func (l *ShoppingList) GetOrderedItems() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.itemNames(l.orderedItemIds())
+}
+
+// orderedItemIds returns the ids of all items in the shopping list, in the order of the list.
+func (l *ShoppingList) orderedItemIds() []string {
+	if l.Order == nil {
+		// Items without a position are in alphabetical order, like the elements
+		return l.AwSet.Elements()
+	}
+	return l.Order.Sort(l.AwSet.Elements())
 }
 
 // GetItemQuantity returns the quantity of an item in the shopping list. If the item does not exist, the second return is false.
 func (l *ShoppingList) GetItemQuantity(itemName string) (int32, bool) {
-	if !l.AwSet.Contains(itemName) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.itemQuantity(itemName)
+}
+
+// itemQuantity returns the quantity of an item, the caller must hold the lock
+func (l *ShoppingList) itemQuantity(itemName string) (int32, bool) {
+	itemId, ok := l.itemId(itemName)
+	if !ok {
 		return 0, false
 	}
-	return l.Items[itemName].Value(), true
+	counter, ok := l.Items[itemId]
+	if !ok {
+		return 0, false
+	}
+	return counter.ValueSince(l.Removed[itemId]), true
 }
 
 // Clone creates a deep copy of the ShoppingList.
 func (l *ShoppingList) Clone() *ShoppingList {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.clone()
+}
+
+// clone creates a deep copy of the ShoppingList, the caller must hold the lock
+func (l *ShoppingList) clone() *ShoppingList {
 	// Create a new ShoppingList with the same NodeID
 	clone := &ShoppingList{
-		NodeID: l.NodeID,
-		Items:  make(map[string]*BoundedPNCounter),
-		AwSet:  l.AwSet.Clone(),
+		NodeID:  l.NodeID,
+		Items:   make(map[string]*BoundedPNCounter),
+		AwSet:   l.AwSet.Clone(),
+		Removed: make(map[string]*BoundedPNCounter),
 	}
 
 	// Copy items from the original ShoppingList to the clone
@@ -568,15 +1209,74 @@ func (l *ShoppingList) Clone() *ShoppingList {
 		clone.Items[itemName] = counter.Clone()
 	}
 
+	for itemName, counter := range l.Removed {
+		clone.Removed[itemName] = counter.Clone()
+	}
+
+	if l.History != nil {
+		clone.History = l.History.Clone()
+	}
+
+	if l.Order != nil {
+		clone.Order = l.Order.Clone()
+	}
+
+	if l.Notes != nil {
+		clone.Notes = make(map[string]*Text, len(l.Notes))
+		for itemName, note := range l.Notes {
+			clone.Notes[itemName] = note.Clone()
+		}
+	}
+
+	if l.Names != nil {
+		clone.Names = make(map[string]Register, len(l.Names))
+		for itemId, name := range l.Names {
+			clone.Names[itemId] = name
+		}
+	}
+
+	if l.ACL != nil {
+		clone.ACL = l.ACL.Clone()
+	}
+
+	if l.Tombstone != nil {
+		clone.Tombstone = l.Tombstone.Clone()
+	}
+
+	if l.Replicas != nil {
+		clone.Replicas = make(map[string]VersionVector, len(l.Replicas))
+		for replica, seen := range l.Replicas {
+			clone.Replicas[replica] = seen.Clone()
+		}
+	}
+
+	if l.Folded != nil {
+		clone.Folded = make(map[string]uint64, len(l.Folded))
+		for NodeID, seq := range l.Folded {
+			clone.Folded[NodeID] = seq
+		}
+	}
+
+	if l.Pruned != nil {
+		clone.Pruned = make(map[string]uint64, len(l.Pruned))
+		for itemId, at := range l.Pruned {
+			clone.Pruned[itemId] = at
+		}
+	}
+
 	return clone
 }
 
 // ShoppingListV2 represents a shopping list with CRDT support.
+// The methods of a ShoppingListV2 are safe for concurrent use.
 type ShoppingListV2 struct {
-	NodeID         string                       `json:"node_id"`
-	NeededItems    map[string]*BoundedPNCounter `json:"needed"`
-	PurchasedItems map[string]*BoundedPNCounter `json:"purchased"`
-	AwSet          *AWSet                       `json:"awset"`
+	NodeID           string                       `json:"node_id"` // Only kept for the clients that still read it, changes never use it
+	NeededItems      map[string]*BoundedPNCounter `json:"needed"`
+	PurchasedItems   map[string]*BoundedPNCounter `json:"purchased"`
+	AwSet            *AWSet                       `json:"awset"`
+	RemovedNeeded    map[string]*BoundedPNCounter `json:"removed_needed,omitempty"`    // What was needed of each item when it was last removed
+	RemovedPurchased map[string]*BoundedPNCounter `json:"removed_purchased,omitempty"` // What was purchased of each item when it was last removed
+	mu               sync.RWMutex
 }
 
 // NewShoppingListV2 creates a new ShoppingListV2.
@@ -590,108 +1290,185 @@ func NewShoppingListV2() *ShoppingListV2 {
 }
 
 // Use boolean and u32 like in the Rust version ?
-// AddOrUpdateItem adds or updates an item in the shopping list.
-func (l *ShoppingListV2) AddOrUpdateItem(itemName string, quantityChange int) {
+// AddOrUpdateItem adds or updates an item in the shopping list, as the given node.
+func (l *ShoppingListV2) AddOrUpdateItem(itemName string, quantityChange int, NodeID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	if _, ok := l.NeededItems[itemName]; !ok {
-		l.NeededItems[itemName] = NewBoundedPNCounter()
-	}
+	needed := itemCounter(l.NeededItems, l.RemovedNeeded, itemName)
 
 	if quantityChange < 0 {
-		l.NeededItems[itemName].Decrement(l.NodeID, uint32(-quantityChange))
-		l.AwSet.AddI(itemName, l.NodeID)
+		needed.Decrement(NodeID, boundByRights(uint32(-quantityChange), needed.RightsSince(NodeID, l.RemovedNeeded[itemName])))
+		l.AwSet.AddI(itemName, NodeID)
 	} else if quantityChange > 0 {
-		l.NeededItems[itemName].Increment(l.NodeID, uint32(quantityChange))
-		l.AwSet.AddI(itemName, l.NodeID)
+		needed.Increment(NodeID, uint32(quantityChange))
+		l.AwSet.AddI(itemName, NodeID)
 	}
 }
 
-// PurchaseItem adds or updates an item in the shopping list.
-func (l *ShoppingListV2) PurchaseItem(itemName string, quantityChange int) {
+// PurchaseItem adds or updates an item in the shopping list, as the given node.
+func (l *ShoppingListV2) PurchaseItem(itemName string, quantityChange int, NodeID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	if _, ok := l.PurchasedItems[itemName]; !ok {
-		l.PurchasedItems[itemName] = NewBoundedPNCounter()
-	}
+	purchased := itemCounter(l.PurchasedItems, l.RemovedPurchased, itemName)
 
 	if quantityChange < 0 {
-		l.PurchasedItems[itemName].Decrement(l.NodeID, uint32(-quantityChange))
-		l.AwSet.AddI(itemName, l.NodeID)
+		purchased.Decrement(NodeID, boundByRights(uint32(-quantityChange), purchased.RightsSince(NodeID, l.RemovedPurchased[itemName])))
+		l.AwSet.AddI(itemName, NodeID)
 
 	} else if quantityChange > 0 {
-		l.PurchasedItems[itemName].Increment(l.NodeID, uint32(quantityChange))
-		l.AwSet.AddI(itemName, l.NodeID)
+		purchased.Increment(NodeID, uint32(quantityChange))
+		l.AwSet.AddI(itemName, NodeID)
 
-		l.NeededItems[itemName].Decrement(l.NodeID, uint32(quantityChange))
-		l.AwSet.AddI(itemName, l.NodeID)
+		needed := itemCounter(l.NeededItems, l.RemovedNeeded, itemName)
+		needed.Decrement(NodeID, boundByRights(uint32(quantityChange), needed.RightsSince(NodeID, l.RemovedNeeded[itemName])))
+		l.AwSet.AddI(itemName, NodeID)
+	}
+}
+
+// itemCounter returns the counter of an item, a re-added item starts from what was removed so old
+// increments stay cancelled. The caller must hold the write lock.
+func itemCounter(items map[string]*BoundedPNCounter, removed map[string]*BoundedPNCounter, itemName string) *BoundedPNCounter {
+	if _, ok := items[itemName]; !ok {
+		if baseline, wasRemoved := removed[itemName]; wasRemoved {
+			items[itemName] = baseline.Clone()
+		} else {
+			items[itemName] = NewBoundedPNCounter()
+		}
 	}
+	return items[itemName]
 }
 
 // RemoveItem removes an item from the shopping list.
+// Only the quantities observed by this replica are cancelled, concurrent ones survive the merge.
 func (l *ShoppingListV2) RemoveItem(itemName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	l.AwSet.RmvI(itemName)
-	delete(l.NeededItems, itemName)
-	delete(l.PurchasedItems, itemName)
+	l.RemovedNeeded = removeCounter(l.NeededItems, l.RemovedNeeded, itemName)
+	l.RemovedPurchased = removeCounter(l.PurchasedItems, l.RemovedPurchased, itemName)
+}
+
+// removeCounter moves the counter of an item into what was removed of it, returns the removed counters.
+// The caller must hold the write lock.
+func removeCounter(items map[string]*BoundedPNCounter, removed map[string]*BoundedPNCounter, itemName string) map[string]*BoundedPNCounter {
+	counter, ok := items[itemName]
+	if !ok {
+		return removed
+	}
+
+	if removed == nil {
+		removed = make(map[string]*BoundedPNCounter)
+	}
+	if baseline, wasRemoved := removed[itemName]; wasRemoved {
+		removed[itemName] = baseline.Merge(counter)
+	} else {
+		removed[itemName] = counter.Clone()
+	}
+	delete(items, itemName)
+
+	return removed
 }
 
 // Merge merges two shopping lists.
+// The incoming list is read from a snapshot, so it can be changed by others while it is merged.
 func (l *ShoppingListV2) Merge(incList *ShoppingListV2) {
+	incList = incList.Snapshot()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	l.AwSet.Merge(incList.AwSet)
 
-	// Merge items based on the merged AWSet
-	for _, itemName := range l.AwSet.Elements() {
-		selfItem, selfExists := l.NeededItems[itemName]
-		incItem, incExists := incList.NeededItems[itemName]
+	// Merge what each replica has observed as removed
+	if len(incList.RemovedNeeded) > 0 {
+		l.RemovedNeeded = mergeCounterMaps(l.RemovedNeeded, incList.RemovedNeeded)
+	}
+	if len(incList.RemovedPurchased) > 0 {
+		l.RemovedPurchased = mergeCounterMaps(l.RemovedPurchased, incList.RemovedPurchased)
+	}
 
-		if selfExists && incExists {
-			l.NeededItems[itemName] = selfItem.Merge(incItem)
-		} else if incExists {
-			l.NeededItems[itemName] = incItem
-		} else {
-			// If the item only exists in one list, use that item
-			l.NeededItems[itemName] = selfItem
+	// Merge every counter, items removed on one replica only are hidden by the AWSet
+	l.NeededItems = mergeCounterMaps(l.NeededItems, incList.NeededItems)
+	l.PurchasedItems = mergeCounterMaps(l.PurchasedItems, incList.PurchasedItems)
+
+	// A counter always covers what was removed, so replicas end up with the same state
+	coverRemoved(l.NeededItems, l.RemovedNeeded)
+	coverRemoved(l.PurchasedItems, l.RemovedPurchased)
+}
+
+// coverRemoved merges into every counter what was removed of its item
+func coverRemoved(items map[string]*BoundedPNCounter, removed map[string]*BoundedPNCounter) {
+	for itemName, counter := range items {
+		if baseline, wasRemoved := removed[itemName]; wasRemoved {
+			items[itemName] = counter.Merge(baseline)
 		}
 	}
-	for _, itemName := range l.AwSet.Elements() {
-		selfItem, selfExists := l.PurchasedItems[itemName]
-		incItem, incExists := incList.PurchasedItems[itemName]
+}
 
-		if selfExists && incExists {
-			l.PurchasedItems[itemName] = selfItem.Merge(incItem)
-		} else if incExists {
-			l.PurchasedItems[itemName] = incItem
+// mergeCounterMaps merges the counters of incoming into self, the incoming counters are copied.
+func mergeCounterMaps(self map[string]*BoundedPNCounter, incoming map[string]*BoundedPNCounter) map[string]*BoundedPNCounter {
+	if self == nil {
+		self = make(map[string]*BoundedPNCounter)
+	}
+
+	for itemName, incItem := range incoming {
+		if incItem == nil {
+			continue
+		}
+		if selfItem, selfExists := self[itemName]; selfExists && selfItem != nil {
+			self[itemName] = selfItem.Merge(incItem)
 		} else {
-			// If the item only exists in one list, use that item
-			l.PurchasedItems[itemName] = selfItem
+			self[itemName] = incItem.Clone()
 		}
 	}
+
+	return self
 }
 
 // GetItems returns the Names of all items in the shopping list.
 func (l *ShoppingListV2) GetItems() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
 	return l.AwSet.Elements()
 }
 
 // GetItemQuantity returns the quantity of an item in the shopping list. If the item does not exist, the second return is false.
 func (l *ShoppingListV2) GetItemQuantityNeeded(itemName string) (int32, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	if !l.AwSet.Contains(itemName) {
 		return 0, false
 	}
-	return l.NeededItems[itemName].Value(), true
+	if counter, ok := l.NeededItems[itemName]; ok {
+		return counter.ValueSince(l.RemovedNeeded[itemName]), true
+	}
+	return 0, true
 }
 
 // GetItemQuantity returns the quantity of an item in the shopping list. If the item does not exist, the second return is false.
 func (l *ShoppingListV2) GetItemQuantityPurchased(itemName string) (int32, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	if !l.AwSet.Contains(itemName) {
 		return 0, false
 	}
-	return l.PurchasedItems[itemName].Value(), true
+	if counter, ok := l.PurchasedItems[itemName]; ok {
+		return counter.ValueSince(l.RemovedPurchased[itemName]), true
+	}
+	return 0, true
 }
 
 // Clone creates a deep copy of the ShoppingListV2.
 func (l *ShoppingListV2) Clone() *ShoppingListV2 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	// Create a new ShoppingListV2 with the same NodeID
 	clone := &ShoppingListV2{
 		NodeID:         l.NodeID,
@@ -707,6 +1484,27 @@ func (l *ShoppingListV2) Clone() *ShoppingListV2 {
 	for itemName, counter := range l.NeededItems {
 		clone.NeededItems[itemName] = counter.Clone()
 	}
+	if len(l.RemovedNeeded) > 0 {
+		clone.RemovedNeeded = mergeCounterMaps(nil, l.RemovedNeeded)
+	}
+	if len(l.RemovedPurchased) > 0 {
+		clone.RemovedPurchased = mergeCounterMaps(nil, l.RemovedPurchased)
+	}
 
 	return clone
 }
+
+// Snapshot returns a copy of the ShoppingListV2 that can be read while the list changes.
+func (l *ShoppingListV2) Snapshot() *ShoppingListV2 {
+	return l.Clone()
+}
+
+// MarshalJSON encodes the ShoppingListV2 while holding its lock.
+func (l *ShoppingListV2) MarshalJSON() ([]byte, error) {
+	type shoppingList ShoppingListV2
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return json.Marshal((*shoppingList)(l))
+}
@@ -1,6 +1,7 @@
 package crdt_go
 
 import (
+	"bytes"
 	"math/rand"
 	"testing"
 
@@ -138,6 +139,97 @@ func TestMergeOneEmptyCounterBoundedPNCounter(t *testing.T) {
 	}
 }
 
+func TestDecrementBoundedByRightsBoundedPNCounter(t *testing.T) {
+	c := NewBoundedPNCounter()
+	c.Increment("node1", 5)
+
+	// node2 never added anything, so it cannot take anything away
+	if decremented := c.Decrement("node2", 3); decremented != 0 {
+		t.Errorf("Decrement failed. Expected node2 to decrement 0, got %d", decremented)
+	}
+	if value := c.Value(); value != 5 {
+		t.Errorf("Decrement failed. Expected Value() to be 5, got %v", value)
+	}
+}
+
+func TestTransferBoundedPNCounter(t *testing.T) {
+	c := NewBoundedPNCounter()
+	c.Increment("node1", 5)
+
+	if transferred := c.Transfer("node1", "node2", 7); transferred != 5 {
+		t.Errorf("Transfer failed. Expected to transfer the 5 rights node1 holds, got %d", transferred)
+	}
+	if rights := c.Rights("node1"); rights != 0 {
+		t.Errorf("Transfer failed. Expected node1 to have no rights left, got %d", rights)
+	}
+
+	if decremented := c.Decrement("node2", 3); decremented != 3 {
+		t.Errorf("Transfer failed. Expected node2 to decrement 3, got %d", decremented)
+	}
+	if value := c.Value(); value != 2 {
+		t.Errorf("Transfer failed. Expected Value() to be 2, got %v", value)
+	}
+}
+
+func TestConcurrentDecrementsNeverUnderflowBoundedPNCounter(t *testing.T) {
+	c1 := NewBoundedPNCounter()
+	c1.Increment("node1", 4)
+	c1.Transfer("node1", "node2", 2)
+
+	c2 := c1.Clone()
+
+	// Both nodes spend every right they hold at the same time
+	c1.Decrement("node1", 10)
+	c2.Decrement("node2", 10)
+
+	merged := c1.Merge(c2)
+	if value := merged.Value(); value != 0 {
+		t.Errorf("Expected merged value to be 0, got %v", value)
+	}
+
+	// A stale copy of the transfer can not be spent twice
+	c3 := c2.Clone()
+	c3.Decrement("node2", 10)
+	if value := merged.Merge(c3).Value(); value != 0 {
+		t.Errorf("Expected merged value to be 0, got %v", value)
+	}
+}
+
+func TestDoubleSpendIsNotHiddenBoundedPNCounter(t *testing.T) {
+	c1 := NewBoundedPNCounter()
+	c1.Increment("node1", 2)
+
+	// node1 gives its 2 rights away twice, from two copies that did not see each other
+	c2 := c1.Clone()
+	c1.Transfer("node1", "node2", 2)
+	c2.Transfer("node1", "node3", 2)
+
+	merged := c1.Merge(c2)
+	if rights := merged.Rights("node1"); rights != -2 {
+		t.Errorf("Expected node1 to have overspent 2 rights, got %d", rights)
+	}
+	if value := merged.Value(); value != 2 {
+		t.Errorf("Expected the overspend to be taken from the value, leaving 2, got %v", value)
+	}
+}
+
+func TestMergeTransfersBoundedPNCounter(t *testing.T) {
+	c1 := NewBoundedPNCounter()
+	c1.Increment("node1", 5)
+
+	c2 := c1.Clone()
+	c1.Transfer("node1", "node2", 1)
+	c2.Transfer("node1", "node2", 3)
+
+	ab := c1.Merge(c2)
+	ba := c2.Merge(c1)
+
+	assert.Equal(t, ab.Transfers, ba.Transfers)
+	if rights := ab.Rights("node2"); rights != 3 {
+		t.Errorf("Expected node2 to hold 3 rights, got %d", rights)
+	}
+}
+
 func TestAWSet(t *testing.T) {
 	// Create two AWSets
 	set1 := NewAWSet()
@@ -326,8 +418,8 @@ func TestShoppingList(t *testing.T) {
 	list2 := NewShoppingList()
 
 	// Add or update items in the lists
-	list1.AddOrUpdateItem("apple", 3)
-	list2.AddOrUpdateItem("banana", 2)
+	list1.AddOrUpdateItem("apple", 3, list1.NodeID)
+	list2.AddOrUpdateItem("banana", 2, list2.NodeID)
 
 	// Test GetItems function
 	items1 := list1.GetItems()
@@ -340,7 +432,7 @@ func TestShoppingList(t *testing.T) {
 	}
 
 	// Test RemoveItem function
-	list1.RemoveItem("apple")
+	list1.RemoveItem("apple", list1.NodeID)
 	items1AfterRemove := list1.GetItems()
 	if len(items1AfterRemove) != 0 {
 		t.Errorf("Expected items1AfterRemove to be empty, got %v", items1AfterRemove)
@@ -1063,7 +1155,7 @@ func TestShoppingListAddUpdateRemove(t *testing.T) {
 		//add one random element
 		item := generateRandomString(rand.Intn(100) + 1)
 		quantity := rand.Intn(100) + 1
-		a.AddOrUpdateItem(item, quantity)
+		a.AddOrUpdateItem(item, quantity, a.NodeID)
 
 		aOriginal := a.Clone()
 
@@ -1073,7 +1165,7 @@ func TestShoppingListAddUpdateRemove(t *testing.T) {
 		}
 
 		//remove element
-		a.RemoveItem(item)
+		a.RemoveItem(item, a.NodeID)
 
 		//check if element is in set
 		if q, ok := a.GetItemQuantity(item); ok || q != int32(0) {
@@ -1091,6 +1183,315 @@ func TestShoppingListAddUpdateRemove(t *testing.T) {
 	}
 }
 
+func TestShoppingListRemoveThenReAdd(t *testing.T) {
+	list := NewShoppingList()
+
+	list.AddOrUpdateItem("milk", 3, list.NodeID)
+	list.RemoveItem("milk", list.NodeID)
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+
+	if q, ok := list.GetItemQuantity("milk"); !ok || q != 2 {
+		t.Errorf("Expected milk to have quantity 2 after re-adding, got %d (present: %v)", q, ok)
+	}
+}
+
+func TestShoppingListConcurrentRemoveAndStaleReplica(t *testing.T) {
+	a := NewShoppingList()
+	a.AddOrUpdateItem("milk", 3, a.NodeID)
+
+	// b is a replica that saw the milk but nothing else
+	b := a.Clone()
+	b.NodeID = "replica-b"
+
+	a.RemoveItem("milk", a.NodeID)
+	a.AddOrUpdateItem("milk", 1, a.NodeID)
+
+	ab := a.Clone()
+	ab.Merge(b)
+
+	ba := b.Clone()
+	ba.Merge(a)
+
+	for _, list := range []*ShoppingList{ab, ba} {
+		if q, ok := list.GetItemQuantity("milk"); !ok || q != 1 {
+			t.Errorf("Expected the old quantity to stay removed and milk to be 1, got %d (present: %v)", q, ok)
+		}
+	}
+
+	if !equalShoppingList(ab, ba) {
+		t.Errorf("Expected replicas to converge")
+	}
+}
+
+func TestShoppingListV2RemoveKeepsConcurrentIncrements(t *testing.T) {
+	a := NewShoppingListV2()
+	a.AddOrUpdateItem("milk", 3, "replica-a")
+	a.PurchaseItem("milk", 1, "replica-a")
+
+	b := a.Clone()
+
+	// a removes the milk while b concurrently asks for two more and purchases one
+	a.RemoveItem("milk")
+	b.AddOrUpdateItem("milk", 3, "replica-b")
+	b.PurchaseItem("milk", 1, "replica-b")
+
+	ab := a.Clone()
+	ab.Merge(b)
+
+	ba := b.Clone()
+	ba.Merge(a)
+
+	// Only what a had not observed survives the removal
+	for _, list := range []*ShoppingListV2{ab, ba} {
+		if q, ok := list.GetItemQuantityNeeded("milk"); !ok || q != 2 {
+			t.Errorf("Expected only the unobserved need to survive, 2, got %d (present: %v)", q, ok)
+		}
+		if q, ok := list.GetItemQuantityPurchased("milk"); !ok || q != 1 {
+			t.Errorf("Expected only the unobserved purchase to survive, 1, got %d (present: %v)", q, ok)
+		}
+	}
+
+	abData, _ := ab.MarshalBinary()
+	baData, _ := ba.MarshalBinary()
+	if !bytes.Equal(abData, baData) {
+		t.Errorf("Expected replicas to converge")
+	}
+}
+
+func TestShoppingListV2RemovedQuantitiesDoNotComeBack(t *testing.T) {
+	a := NewShoppingListV2()
+	a.AddOrUpdateItem("milk", 3, "replica-a")
+	a.PurchaseItem("milk", 1, "replica-a")
+
+	b := a.Clone()
+	a.RemoveItem("milk")
+	b.Merge(a)
+
+	// Re-adding after the removal was seen starts from zero, on either replica
+	b.AddOrUpdateItem("milk", 1, "replica-b")
+	a.Merge(b)
+
+	for _, list := range []*ShoppingListV2{a, b} {
+		if q, ok := list.GetItemQuantityNeeded("milk"); !ok || q != 1 {
+			t.Errorf("Expected milk to be needed once after re-adding, got %d (present: %v)", q, ok)
+		}
+		if q, _ := list.GetItemQuantityPurchased("milk"); q != 0 {
+			t.Errorf("Expected the removed purchase not to come back, got %d", q)
+		}
+	}
+
+	// The removed rights cannot be taken off again either
+	b.AddOrUpdateItem("milk", -5, "replica-a")
+	if q, _ := b.GetItemQuantityNeeded("milk"); q != 1 {
+		t.Errorf("Expected replica-a to have no rights left on milk, got a quantity of %d", q)
+	}
+}
+
+func TestShoppingListRemoveKeepsConcurrentIncrements(t *testing.T) {
+	a := NewShoppingList()
+	a.AddOrUpdateItem("milk", 3, a.NodeID)
+
+	b := a.Clone()
+	b.NodeID = "replica-b"
+
+	// a removes the milk while b concurrently asks for two more
+	a.RemoveItem("milk", a.NodeID)
+	b.AddOrUpdateItem("milk", 2, b.NodeID)
+
+	ab := a.Clone()
+	ab.Merge(b)
+
+	ba := b.Clone()
+	ba.Merge(a)
+
+	for _, list := range []*ShoppingList{ab, ba} {
+		if q, ok := list.GetItemQuantity("milk"); !ok || q != 2 {
+			t.Errorf("Expected only the unobserved increment to survive, got %d (present: %v)", q, ok)
+		}
+	}
+
+	if !equalShoppingList(ab, ba) {
+		t.Errorf("Expected replicas to converge")
+	}
+}
+
+func TestShoppingListRemoveObservedByEveryReplica(t *testing.T) {
+	a := NewShoppingList()
+	a.AddOrUpdateItem("milk", 3, a.NodeID)
+
+	b := a.Clone()
+	b.NodeID = "replica-b"
+	b.AddOrUpdateItem("milk", -1, b.NodeID)
+
+	a.Merge(b)
+	a.RemoveItem("milk", a.NodeID)
+
+	b.Merge(a)
+
+	if _, ok := b.GetItemQuantity("milk"); ok {
+		t.Errorf("Expected milk to be removed on every replica")
+	}
+
+	// Re-adding after everyone saw the removal starts from zero
+	b.AddOrUpdateItem("milk", 4, b.NodeID)
+	a.Merge(b)
+
+	if q, ok := a.GetItemQuantity("milk"); !ok || q != 4 {
+		t.Errorf("Expected milk to have quantity 4 after re-adding, got %d (present: %v)", q, ok)
+	}
+}
+
+func TestShoppingListDecrementOthersItemsNeedsRights(t *testing.T) {
+	a := NewShoppingList()
+	a.AddOrUpdateItem("milk", 3, a.NodeID)
+
+	b := a.Clone()
+	b.NodeID = "replica-b"
+
+	b.AddOrUpdateItem("milk", -2, b.NodeID)
+	if q, _ := b.GetItemQuantity("milk"); q != 3 {
+		t.Errorf("Expected b to be unable to decrement milk it has no rights to, got %d", q)
+	}
+
+	if transferred := a.TransferRights("milk", a.NodeID, b.NodeID, 2); transferred != 2 {
+		t.Errorf("Expected 2 rights to be transferred, got %d", transferred)
+	}
+	b.Merge(a)
+
+	b.AddOrUpdateItem("milk", -2, b.NodeID)
+	if q, _ := b.GetItemQuantity("milk"); q != 1 {
+		t.Errorf("Expected milk to be 1 after decrementing with transferred rights, got %d", q)
+	}
+	if rights := b.GetItemRights("milk", b.NodeID); rights != 0 {
+		t.Errorf("Expected b to have used all of its rights, got %d", rights)
+	}
+}
+
+func TestShoppingListDoubleSpendIsOverspent(t *testing.T) {
+	base := NewShoppingList()
+	base.AddOrUpdateItem("milk", 2, "alice")
+	base.AddOrUpdateItem("eggs", 6, "alice")
+
+	// alice spends her rights on milk twice, from two replicas at once
+	a := base.Clone()
+	a.TransferRights("milk", "alice", "bob", 2)
+	b := base.Clone()
+	b.AddOrUpdateItem("milk", -2, "alice")
+
+	assert.Empty(t, a.Overspent("alice", base))
+	assert.Empty(t, b.Overspent("alice", base))
+
+	merged := a.Clone()
+	merged.Merge(b)
+	assert.Equal(t, []string{"milk"}, merged.Overspent("alice", base))
+	assert.Equal(t, []string{"milk"}, merged.Overspent("alice", a))
+	assert.Empty(t, merged.Overspent("bob", base))
+
+	// What bob got is not counted on top of what alice took
+	q, _ := merged.GetItemQuantity("milk")
+	assert.Equal(t, int32(0), q)
+
+	// An overspend that was already there is not made by the next change
+	before := merged.Clone()
+	merged.AddOrUpdateItem("eggs", 1, "alice")
+	assert.Empty(t, merged.Overspent("alice", before))
+}
+
+func TestShoppingListActorsAreExplicit(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, "alice")
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	// Two clients decode the same list, each one still changes it as itself
+	bob := &ShoppingList{}
+	require.NoError(t, bob.UnmarshalBinary(data))
+	carol := &ShoppingList{}
+	require.NoError(t, carol.UnmarshalBinary(data))
+
+	bob.AddOrUpdateItem("milk", 1, "bob")
+	carol.AddOrUpdateItem("milk", 1, "carol")
+
+	bob.Merge(carol)
+	q, _ := bob.GetItemQuantity("milk")
+	assert.Equal(t, int32(4), q)
+	assert.Equal(t, int64(1), bob.GetItemRights("milk", "bob"))
+	assert.Equal(t, int64(1), bob.GetItemRights("milk", "carol"))
+
+	// Nobody can take what alice added
+	bob.AddOrUpdateItem("milk", -2, "bob")
+	q, _ = bob.GetItemQuantity("milk")
+	assert.Equal(t, int32(3), q)
+	assert.Equal(t, int64(2), bob.GetItemRights("milk", "alice"))
+}
+
+func TestShoppingListApplyTransferBoundedBySender(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 3, list.NodeID)
+
+	transferred := list.ApplyTransfer(RightsTransfer{ItemName: "milk", From: "someone-else", To: list.NodeID, Amount: 2})
+	if transferred != 0 {
+		t.Errorf("Expected no rights to be transferred from a node without rights, got %d", transferred)
+	}
+
+	transferred = list.ApplyTransfer(RightsTransfer{ItemName: "bread", From: list.NodeID, To: "someone-else", Amount: 2})
+	if transferred != 0 {
+		t.Errorf("Expected no rights to be transferred for an item not on the list, got %d", transferred)
+	}
+}
+
+func TestShoppingListRemoveInterleavings(t *testing.T) {
+	t.Parallel()
+	for i := 0; i < numberOfProperties/100; i++ {
+		replicas := []*ShoppingList{NewShoppingList(), NewShoppingList(), NewShoppingList()}
+		itemNames := []string{"milk", "eggs", "bread"}
+
+		for step := 0; step < 30; step++ {
+			replica := replicas[rand.Intn(len(replicas))]
+			itemName := itemNames[rand.Intn(len(itemNames))]
+
+			switch rand.Intn(5) {
+			case 0:
+				replica.RemoveItem(itemName, replica.NodeID)
+			case 1:
+				replica.AddOrUpdateItem(itemName, -(rand.Intn(5) + 1), replica.NodeID)
+			case 2:
+				replica.TransferRights(itemName, replica.NodeID, replicas[rand.Intn(len(replicas))].NodeID, uint32(rand.Intn(3)+1))
+			case 3:
+				replica.Merge(replicas[rand.Intn(len(replicas))].Clone())
+			default:
+				replica.AddOrUpdateItem(itemName, rand.Intn(5)+1, replica.NodeID)
+			}
+		}
+
+		// Deliver every state to every replica in a different order
+		forward := make([]*ShoppingList, len(replicas))
+		backward := make([]*ShoppingList, len(replicas))
+		for j := range replicas {
+			forward[j] = replicas[j].Clone()
+			backward[j] = replicas[j].Clone()
+		}
+		for j := range replicas {
+			for k := range replicas {
+				forward[j].Merge(replicas[k])
+				backward[j].Merge(replicas[len(replicas)-1-k])
+			}
+		}
+
+		for j := range replicas {
+			if !equalShoppingList(forward[0], forward[j]) || !equalShoppingList(forward[j], backward[j]) {
+				t.Fatalf("Test failed on iteration %d. Replicas did not converge.", i)
+			}
+			for _, itemName := range forward[j].GetItems() {
+				if q, _ := forward[j].GetItemQuantity(itemName); q < 0 {
+					t.Fatalf("Test failed on iteration %d. %s has negative quantity %d.", i, itemName, q)
+				}
+			}
+		}
+	}
+}
+
 // Utility function to compare State and Context
 func equalStateAndContext(a, b *AWSet) bool {
 	return equalItems(a.State, b.State) && equalContextItems(a.Context, b.Context)
@@ -1180,8 +1581,57 @@ func equalItemsMap(a, b map[string]*BoundedPNCounter) bool {
 // equalShoppingList checks if two ShoppingLists are equal in terms of items, state, and context.
 func equalShoppingList(a, b *ShoppingList) bool {
 	return equalItemsMap(a.Items, b.Items) &&
+		equalItemsMap(a.Removed, b.Removed) &&
 		equalItems(a.AwSet.State, b.AwSet.State) &&
-		equalContextItems(a.AwSet.Context, b.AwSet.Context)
+		equalContextItems(a.AwSet.Context, b.AwSet.Context) &&
+		equalHistory(a.History, b.History) &&
+		equalOrder(a.Order, b.Order) &&
+		equalNames(a.Names, b.Names) &&
+		bytes.Equal(a.CanonicalACL(), b.CanonicalACL()) &&
+		bytes.Equal(a.CanonicalTombstone(), b.CanonicalTombstone())
+}
+
+func equalNames(a, b map[string]Register) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for itemId, name := range a {
+		if other, ok := b[itemId]; !ok || other != name {
+			return false
+		}
+	}
+	return true
+}
+
+func equalOrder(a, b *Sequence) bool {
+	if a == nil || b == nil {
+		return (a == nil || len(a.Positions) == 0) && (b == nil || len(b.Positions) == 0)
+	}
+	if len(a.Positions) != len(b.Positions) {
+		return false
+	}
+	for itemName, position := range a.Positions {
+		other, ok := b.Positions[itemName]
+		if !ok || position.newerThan(other) || other.newerThan(position) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalHistory(a, b *OperationLog) bool {
+	if a == nil || b == nil {
+		return (a == nil || len(a.Entries) == 0) && (b == nil || len(b.Entries) == 0)
+	}
+	if len(a.Entries) != len(b.Entries) {
+		return false
+	}
+	for i := range a.Entries {
+		if a.Entries[i] != b.Entries[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // Utility function to generate a random string
@@ -1238,11 +1688,11 @@ func generateRandomShoppingList() *ShoppingList {
 		// Generate a random quantity change
 		quantityChange := rand.Intn(100) + 1
 
-		list.AddOrUpdateItem(itemName, quantityChange)
+		list.AddOrUpdateItem(itemName, quantityChange, list.NodeID)
 
 		quantityChange = rand.Intn(30) * -1
 
-		list.AddOrUpdateItem(itemName, quantityChange)
+		list.AddOrUpdateItem(itemName, quantityChange, list.NodeID)
 	}
 
 	return list
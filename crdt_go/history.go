@@ -0,0 +1,271 @@
+package crdt_go
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of operations recorded in the history of a shopping list
+const (
+	OP_INCREMENT string = "increment"
+	OP_DECREMENT string = "decrement"
+	OP_REMOVE    string = "remove"
+	OP_TRANSFER  string = "transfer"
+	OP_MOVE      string = "move"
+	OP_NOTE      string = "note"
+	OP_RENAME    string = "rename"
+)
+
+// now is the clock used to timestamp operations, replaced in tests
+var now = time.Now
+
+// Operation is an entry of the history of a shopping list.
+// An operation is identified by the node that made it and its sequence number on that node.
+type Operation struct {
+	NodeID    string `json:"node_id"`
+	Seq       uint64 `json:"seq"`
+	Op        string `json:"op"`
+	Item      string `json:"item"`
+	Delta     int64  `json:"delta"`
+	Target    string `json:"target,omitempty"` // The node that received the rights of a transfer, or the new name of a renamed item
+	Timestamp int64  `json:"timestamp"`        // Unix time in milliseconds
+}
+
+// Cursor identifies the position of the operation in the history, used to page through it.
+func (op Operation) Cursor() string {
+	return fmt.Sprintf("%d:%d:%s", op.Timestamp, op.Seq, op.NodeID)
+}
+
+// operationLess orders operations by time, ties are broken by node and sequence number.
+// Every field is compared so two different operations are never considered equal.
+func operationLess(a Operation, b Operation) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	if a.NodeID != b.NodeID {
+		return a.NodeID < b.NodeID
+	}
+	if a.Seq != b.Seq {
+		return a.Seq < b.Seq
+	}
+	if a.Op != b.Op {
+		return a.Op < b.Op
+	}
+	if a.Item != b.Item {
+		return a.Item < b.Item
+	}
+	if a.Delta != b.Delta {
+		return a.Delta < b.Delta
+	}
+	return a.Target < b.Target
+}
+
+// parseCursor returns the operation a cursor points to, only the fields used to order operations are set.
+func parseCursor(cursor string) (Operation, bool) {
+	parts := strings.SplitN(cursor, ":", 3)
+	if len(parts) != 3 {
+		return Operation{}, false
+	}
+
+	timestamp, err1 := strconv.ParseInt(parts[0], 10, 64)
+	seq, err2 := strconv.ParseUint(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return Operation{}, false
+	}
+
+	return Operation{NodeID: parts[2], Seq: seq, Timestamp: timestamp}, true
+}
+
+// OperationLog is an append-only log of the operations made on a shopping list.
+// It is a grow-only set of operations, so it merges like any other CRDT, until old operations
+// are dropped by Retain.
+type OperationLog struct {
+	Clock   map[string]uint64 `json:"clock"`            // Last sequence number used by each node
+	Active  map[string]int64  `json:"active,omitempty"` // Time of the last operation of each node
+	Entries []Operation       `json:"entries"`          // Sorted from the oldest to the newest
+}
+
+// NewOperationLog creates an empty OperationLog.
+func NewOperationLog() *OperationLog {
+	return &OperationLog{
+		Clock:   make(map[string]uint64),
+		Active:  make(map[string]int64),
+		Entries: make([]Operation, 0),
+	}
+}
+
+// Record appends an operation made by op.NodeID, its sequence number and timestamp are set by the log.
+func (h *OperationLog) Record(op Operation) Operation {
+	h.Clock[op.NodeID]++
+	op.Seq = h.Clock[op.NodeID]
+	op.Timestamp = now().UnixMilli()
+
+	if h.Active == nil {
+		h.Active = make(map[string]int64)
+	}
+	h.Active[op.NodeID] = op.Timestamp
+
+	h.insert(op)
+
+	return op
+}
+
+// insert adds an operation keeping the entries sorted.
+// If there is another operation with the same id the greatest one is kept, so every replica picks the same.
+func (h *OperationLog) insert(op Operation) {
+	for i, entry := range h.Entries {
+		if entry.NodeID == op.NodeID && entry.Seq == op.Seq {
+			if !operationLess(entry, op) {
+				return
+			}
+			h.Entries = append(h.Entries[:i], h.Entries[i+1:]...)
+			break
+		}
+	}
+
+	i := sort.Search(len(h.Entries), func(i int) bool { return operationLess(op, h.Entries[i]) })
+	h.Entries = append(h.Entries, Operation{})
+	copy(h.Entries[i+1:], h.Entries[i:])
+	h.Entries[i] = op
+}
+
+// Merge merges another OperationLog into this one.
+func (h *OperationLog) Merge(other *OperationLog) {
+	if other == nil {
+		return
+	}
+
+	for NodeID, seq := range other.Clock {
+		if seq > h.Clock[NodeID] {
+			h.Clock[NodeID] = seq
+		}
+	}
+
+	if h.Active == nil {
+		h.Active = make(map[string]int64)
+	}
+	for NodeID, timestamp := range other.Active {
+		h.Active[NodeID] = max64(h.Active[NodeID], timestamp)
+	}
+
+	type operationId struct {
+		NodeID string
+		Seq    uint64
+	}
+
+	merged := make(map[operationId]Operation, len(h.Entries)+len(other.Entries))
+	for _, entries := range [][]Operation{h.Entries, other.Entries} {
+		for _, op := range entries {
+			id := operationId{op.NodeID, op.Seq}
+			if current, ok := merged[id]; !ok || operationLess(current, op) {
+				merged[id] = op
+			}
+		}
+	}
+
+	h.Entries = make([]Operation, 0, len(merged))
+	for _, op := range merged {
+		h.Entries = append(h.Entries, op)
+	}
+	sort.Slice(h.Entries, func(i, j int) bool { return operationLess(h.Entries[i], h.Entries[j]) })
+}
+
+// Retain drops the operations older than maxAge and then the oldest ones until at most maxEntries are left.
+// A limit of zero or less means no limit.
+func (h *OperationLog) Retain(maxEntries int, maxAge time.Duration, at time.Time) {
+	if maxAge > 0 {
+		oldest := at.Add(-maxAge).UnixMilli()
+		i := sort.Search(len(h.Entries), func(i int) bool { return h.Entries[i].Timestamp >= oldest })
+		h.Entries = h.Entries[i:]
+	}
+
+	if maxEntries > 0 && len(h.Entries) > maxEntries {
+		h.Entries = h.Entries[len(h.Entries)-maxEntries:]
+	}
+}
+
+// Page returns up to limit operations, from the newest to the oldest, that come before the cursor.
+// An empty cursor starts at the newest operation. The returned cursor is empty when there is nothing left.
+func (h *OperationLog) Page(cursor string, limit int) ([]Operation, string, error) {
+	end := len(h.Entries)
+
+	if cursor != "" {
+		position, ok := parseCursor(cursor)
+		if !ok {
+			return nil, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+		end = sort.Search(len(h.Entries), func(i int) bool {
+			entry := h.Entries[i]
+			if entry.Timestamp != position.Timestamp {
+				return entry.Timestamp > position.Timestamp
+			}
+			if entry.NodeID != position.NodeID {
+				return entry.NodeID > position.NodeID
+			}
+			return entry.Seq >= position.Seq
+		})
+	}
+
+	start := 0
+	if limit > 0 && end-limit > 0 {
+		start = end - limit
+	}
+
+	page := make([]Operation, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		page = append(page, h.Entries[i])
+	}
+
+	next := ""
+	if start > 0 {
+		next = h.Entries[start].Cursor()
+	}
+
+	return page, next, nil
+}
+
+// Clone creates a deep copy of the OperationLog.
+func (h *OperationLog) Clone() *OperationLog {
+	clone := &OperationLog{
+		Clock:   make(map[string]uint64, len(h.Clock)),
+		Active:  make(map[string]int64, len(h.Active)),
+		Entries: make([]Operation, len(h.Entries)),
+	}
+
+	for NodeID, seq := range h.Clock {
+		clone.Clock[NodeID] = seq
+	}
+	for NodeID, timestamp := range h.Active {
+		clone.Active[NodeID] = timestamp
+	}
+	copy(clone.Entries, h.Entries)
+
+	return clone
+}
+
+// GetHistory returns a copy of the history of the list, an empty one if the list has none.
+func (l *ShoppingList) GetHistory() *OperationLog {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.History == nil {
+		return NewOperationLog()
+	}
+	return l.History.Clone()
+}
+
+// RetainHistory drops the operations of the list's history that are beyond the limits, see OperationLog.Retain.
+func (l *ShoppingList) RetainHistory(maxEntries int, maxAge time.Duration, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.History == nil {
+		return
+	}
+
+	l.own()
+	l.History.Retain(maxEntries, maxAge, at)
+}
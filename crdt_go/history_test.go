@@ -0,0 +1,182 @@
+package crdt_go
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setClock makes the operations recorded until the end of the test be timestamped by the returned clock
+func setClock(t *testing.T, start time.Time) *time.Time {
+	clock := start
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+	return &clock
+}
+
+func TestRecordOperationLog(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(1000))
+
+	h := NewOperationLog()
+	first := h.Record(Operation{NodeID: "node1", Op: OP_INCREMENT, Item: "milk", Delta: 2})
+	*clock = clock.Add(time.Second)
+	second := h.Record(Operation{NodeID: "node1", Op: OP_DECREMENT, Item: "milk", Delta: -1})
+
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.Equal(t, uint64(2), second.Seq)
+	assert.Equal(t, int64(1000), first.Timestamp)
+	assert.Equal(t, int64(2000), second.Timestamp)
+	assert.Equal(t, []Operation{first, second}, h.Entries)
+}
+
+func TestMergeOperationLog(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(1000))
+
+	a := NewOperationLog()
+	b := NewOperationLog()
+
+	a.Record(Operation{NodeID: "node1", Op: OP_INCREMENT, Item: "milk", Delta: 2})
+	*clock = clock.Add(time.Second)
+	b.Record(Operation{NodeID: "node2", Op: OP_INCREMENT, Item: "eggs", Delta: 6})
+	*clock = clock.Add(time.Second)
+	a.Record(Operation{NodeID: "node1", Op: OP_REMOVE, Item: "milk", Delta: -2})
+
+	ab := a.Clone()
+	ab.Merge(b)
+	ba := b.Clone()
+	ba.Merge(a)
+
+	assert.Equal(t, ab.Entries, ba.Entries)
+	assert.Len(t, ab.Entries, 3)
+	assert.Equal(t, "eggs", ab.Entries[1].Item)
+
+	// Merging is idempotent
+	ab.Merge(ba)
+	assert.Len(t, ab.Entries, 3)
+	assert.Equal(t, uint64(2), ab.Clock["node1"])
+}
+
+func TestMergeOperationLogSameId(t *testing.T) {
+	setClock(t, time.UnixMilli(1000))
+
+	// Two copies of the same list, with the same NodeID, recording different operations
+	a := NewOperationLog()
+	b := NewOperationLog()
+	a.Record(Operation{NodeID: "node1", Op: OP_INCREMENT, Item: "milk", Delta: 2})
+	b.Record(Operation{NodeID: "node1", Op: OP_INCREMENT, Item: "bread", Delta: 1})
+
+	ab := a.Clone()
+	ab.Merge(b)
+	ba := b.Clone()
+	ba.Merge(a)
+
+	assert.Equal(t, ab.Entries, ba.Entries)
+}
+
+func TestRetainOperationLog(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	h := NewOperationLog()
+	for i := 0; i < 10; i++ {
+		h.Record(Operation{NodeID: "node1", Op: OP_INCREMENT, Item: "milk", Delta: 1})
+		*clock = clock.Add(time.Minute)
+	}
+
+	h.Retain(0, 5*time.Minute, *clock)
+	require.Len(t, h.Entries, 5)
+	assert.Equal(t, uint64(6), h.Entries[0].Seq)
+
+	h.Retain(2, 0, *clock)
+	require.Len(t, h.Entries, 2)
+	assert.Equal(t, uint64(9), h.Entries[0].Seq)
+
+	// Dropped operations do not reuse their sequence numbers
+	op := h.Record(Operation{NodeID: "node1", Op: OP_INCREMENT, Item: "milk", Delta: 1})
+	assert.Equal(t, uint64(11), op.Seq)
+}
+
+func TestPageOperationLog(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	h := NewOperationLog()
+	for i := 0; i < 5; i++ {
+		h.Record(Operation{NodeID: "node1", Op: OP_INCREMENT, Item: "milk", Delta: 1})
+		*clock = clock.Add(time.Second)
+	}
+
+	page, cursor, err := h.Page("", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{5, 4}, seqs(page))
+	require.NotEmpty(t, cursor)
+
+	page, cursor, err = h.Page(cursor, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 2}, seqs(page))
+
+	page, cursor, err = h.Page(cursor, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1}, seqs(page))
+	assert.Empty(t, cursor)
+
+	_, _, err = h.Page("not a cursor", 2)
+	assert.Error(t, err)
+}
+
+func seqs(ops []Operation) []uint64 {
+	result := make([]uint64, 0, len(ops))
+	for _, op := range ops {
+		result = append(result, op.Seq)
+	}
+	return result
+}
+
+func TestShoppingListHistory(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(1000))
+
+	alice := NewShoppingList()
+	alice.AddOrUpdateItem("milk", 3, alice.NodeID)
+
+	*clock = clock.Add(time.Second)
+
+	bob := alice.Clone()
+	bob.NodeID = "bob"
+	bob.AddOrUpdateItem("milk", 1, bob.NodeID)
+	bob.RemoveItem("milk", bob.NodeID)
+
+	alice.Merge(bob)
+
+	ops, _, err := alice.History.Page("", 0)
+	require.NoError(t, err)
+	require.Len(t, ops, 3)
+
+	removed := ops[0]
+	assert.Equal(t, OP_REMOVE, removed.Op)
+	assert.Equal(t, "bob", removed.NodeID)
+	assert.Equal(t, "milk", alice.GetItemName(removed.Item))
+	assert.Equal(t, int64(-4), removed.Delta)
+
+	// The history survives the binary encoding
+	data, err := alice.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, alice.History, decoded.History)
+}
+
+func TestShoppingListHistoryRecordsTransfers(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 3, list.NodeID)
+	list.TransferRights("milk", list.NodeID, "bob", 2)
+	milkId, _ := list.ItemId("milk")
+
+	// Decrements without the rights to do them are not recorded
+	list.AddOrUpdateItem("milk", -5, list.NodeID)
+
+	entries := list.History.Entries
+	require.Len(t, entries, 3)
+	assert.Equal(t, Operation{NodeID: list.NodeID, Seq: 2, Op: OP_TRANSFER, Item: milkId, Delta: 2, Target: "bob", Timestamp: entries[1].Timestamp}, entries[1])
+	assert.Equal(t, int64(-1), entries[2].Delta)
+}
@@ -0,0 +1,172 @@
+package crdt_go
+
+// Register is a last-writer-wins register.
+// Stamp is a Lamport clock, when the register is written concurrently the latest write wins.
+type Register struct {
+	Value  string `json:"value"`
+	NodeID string `json:"node_id"`
+	Stamp  uint64 `json:"stamp"`
+}
+
+// newerThan indicates if the register replaces the other one when merging.
+func (r Register) newerThan(other Register) bool {
+	if r.Stamp != other.Stamp {
+		return r.Stamp > other.Stamp
+	}
+	if r.NodeID != other.NodeID {
+		return r.NodeID > other.NodeID
+	}
+	return r.Value > other.Value
+}
+
+// GetItemName returns the name of the item with the given id.
+func (l *ShoppingList) GetItemName(itemId string) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.itemName(itemId)
+}
+
+// itemName returns the name of the item with the given id, the caller must hold the lock
+func (l *ShoppingList) itemName(itemId string) string {
+	if name, ok := l.Names[itemId]; ok {
+		return name.Value
+	}
+	return itemId
+}
+
+// GetItemIds returns the ids of all items in the shopping list.
+func (l *ShoppingList) GetItemIds() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.AwSet.Elements()
+}
+
+// ItemId returns the id of the item shown with the given name. If the item does not exist, the
+// second return is false. If concurrent adds or renames left two items with the same name, the one
+// with the smallest id is returned, so every replica picks the same.
+func (l *ShoppingList) ItemId(itemName string) (string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.itemId(itemName)
+}
+
+// itemId returns the id of the item shown with the given name, the caller must hold the lock
+func (l *ShoppingList) itemId(itemName string) (string, bool) {
+	// Without renames every item is shown by its id
+	if len(l.Names) == 0 {
+		return itemName, l.AwSet.Contains(itemName)
+	}
+
+	// Ids are sorted
+	for _, itemId := range l.AwSet.Elements() {
+		if l.itemName(itemId) == itemName {
+			return itemId, true
+		}
+	}
+	return "", false
+}
+
+// itemIdForAdd returns the id of the item to add with the given name: the item on the list with
+// that name, or the removed one, so a re-added item keeps what it had, or a new one.
+func (l *ShoppingList) itemIdForAdd(itemName string, NodeID string) string {
+	if itemId, ok := l.itemId(itemName); ok {
+		return itemId
+	}
+
+	for _, itemIds := range [][]string{sortedKeys(l.Items), sortedKeys(l.Removed)} {
+		for _, itemId := range itemIds {
+			if l.itemName(itemId) == itemName {
+				return itemId
+			}
+		}
+	}
+
+	// A new item gets an id of its own, never the name, so it is not mixed up with an item another
+	// replica added, renamed to the same name or removed and pruned
+	if l.Names == nil {
+		l.Names = make(map[string]Register)
+	}
+	itemId := itemName + "#" + generateNodeID()
+	l.Names[itemId] = Register{
+		Value:  itemName,
+		NodeID: NodeID,
+		Stamp:  l.nextNameStamp(),
+	}
+	return itemId
+}
+
+// itemIdOrName returns the id of the item shown with the given name, or the name itself if there
+// is no such item.
+func (l *ShoppingList) itemIdOrName(itemName string) string {
+	if itemId, ok := l.itemId(itemName); ok {
+		return itemId
+	}
+	return itemName
+}
+
+// RenameItem changes the name shown for an item as the given node, its id and everything kept for
+// it stay the same. Returns false if the item is not on the list or another item already has the new name.
+func (l *ShoppingList) RenameItem(itemName string, newName string, NodeID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	itemId, ok := l.itemId(itemName)
+	if !ok || newName == "" {
+		return false
+	}
+	if itemName == newName {
+		return true
+	}
+	if _, taken := l.itemId(newName); taken {
+		return false
+	}
+
+	l.own()
+
+	if l.Names == nil {
+		l.Names = make(map[string]Register)
+	}
+	l.Names[itemId] = Register{
+		Value:  newName,
+		NodeID: NodeID,
+		Stamp:  l.nextNameStamp(),
+	}
+	l.record(Operation{NodeID: NodeID, Op: OP_RENAME, Item: itemId, Target: newName})
+
+	return true
+}
+
+// nextNameStamp returns a stamp greater than every other in the names of the list.
+func (l *ShoppingList) nextNameStamp() uint64 {
+	var stamp uint64
+	for _, name := range l.Names {
+		if name.Stamp > stamp {
+			stamp = name.Stamp
+		}
+	}
+	return stamp + 1
+}
+
+// mergeNames merges the names of another list, for each item the latest rename is kept.
+func (l *ShoppingList) mergeNames(incList *ShoppingList) {
+	if len(incList.Names) > 0 && l.Names == nil {
+		l.Names = make(map[string]Register)
+	}
+	for itemId, incName := range incList.Names {
+		if name, ok := l.Names[itemId]; !ok || incName.newerThan(name) {
+			l.Names[itemId] = incName
+		}
+	}
+}
+
+// itemNames returns the names of the items with the given ids.
+func (l *ShoppingList) itemNames(itemIds []string) []string {
+	names := make([]string, len(itemIds))
+	for i, itemId := range itemIds {
+		names[i] = l.itemName(itemId)
+	}
+	return names
+}
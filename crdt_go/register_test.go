@@ -0,0 +1,213 @@
+package crdt_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameKeepsItem(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("Mlik", 3, list.NodeID)
+	list.AddOrUpdateItem("eggs", 6, list.NodeID)
+	list.SetItemNote("Mlik", "semi skimmed", list.NodeID)
+	mlikId, _ := list.ItemId("Mlik")
+
+	require.True(t, list.RenameItem("Mlik", "Milk", list.NodeID))
+
+	_, ok := list.GetItemQuantity("Mlik")
+	assert.False(t, ok)
+
+	q, ok := list.GetItemQuantity("Milk")
+	assert.True(t, ok)
+	assert.Equal(t, int32(3), q)
+	assert.Equal(t, int64(3), list.GetItemRights("Milk", list.NodeID))
+
+	note, _ := list.GetItemNote("Milk")
+	assert.Equal(t, "semi skimmed", note)
+
+	assert.Equal(t, []string{"Milk", "eggs"}, list.GetItems())
+	assert.Equal(t, []string{"Milk", "eggs"}, list.GetOrderedItems())
+
+	// The id stays the same, so the history of the item is kept
+	itemId, ok := list.ItemId("Milk")
+	assert.True(t, ok)
+	assert.Equal(t, mlikId, itemId)
+	assert.Equal(t, "Milk", list.GetItemName(itemId))
+
+	last := list.History.Entries[len(list.History.Entries)-1]
+	assert.Equal(t, OP_RENAME, last.Op)
+	assert.Equal(t, mlikId, last.Item)
+	assert.Equal(t, "Milk", last.Target)
+
+	// Every operation by name reaches the renamed item
+	list.AddOrUpdateItem("Milk", -1, list.NodeID)
+	q, _ = list.GetItemQuantity("Milk")
+	assert.Equal(t, int32(2), q)
+
+	assert.True(t, list.MoveItem("eggs", "", list.NodeID))
+	assert.Equal(t, []string{"eggs", "Milk"}, list.GetOrderedItems())
+	assert.True(t, list.MoveItem("eggs", "Milk", list.NodeID))
+	assert.Equal(t, []string{"Milk", "eggs"}, list.GetOrderedItems())
+}
+
+func TestRenameRejectsTakenNames(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 1, list.NodeID)
+	list.AddOrUpdateItem("eggs", 1, list.NodeID)
+
+	assert.False(t, list.RenameItem("milk", "eggs", list.NodeID))
+	assert.False(t, list.RenameItem("bread", "rye bread", list.NodeID))
+	assert.False(t, list.RenameItem("milk", "", list.NodeID))
+	assert.True(t, list.RenameItem("milk", "milk", list.NodeID))
+
+	// The old name is free once the item is renamed, and is a new item
+	require.True(t, list.RenameItem("milk", "oat milk", list.NodeID))
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+
+	q, _ := list.GetItemQuantity("milk")
+	assert.Equal(t, int32(2), q)
+	q, _ = list.GetItemQuantity("oat milk")
+	assert.Equal(t, int32(1), q)
+
+	milkId, _ := list.ItemId("milk")
+	assert.NotEqual(t, "milk", milkId)
+}
+
+func TestRenameThenRemoveAndAddBack(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("Mlik", 3, list.NodeID)
+	mlikId, _ := list.ItemId("Mlik")
+	list.RenameItem("Mlik", "Milk", list.NodeID)
+	list.RemoveItem("Milk", list.NodeID)
+
+	assert.Empty(t, list.GetItems())
+
+	// The item added back is the removed one, it starts from zero
+	list.AddOrUpdateItem("Milk", 1, list.NodeID)
+	itemId, _ := list.ItemId("Milk")
+	assert.Equal(t, mlikId, itemId)
+
+	q, _ := list.GetItemQuantity("Milk")
+	assert.Equal(t, int32(1), q)
+}
+
+func TestConcurrentRenames(t *testing.T) {
+	base := NewShoppingList()
+	base.AddOrUpdateItem("Mlik", 3, base.NodeID)
+
+	a := base.Clone()
+	a.NodeID = "a"
+	b := base.Clone()
+	b.NodeID = "b"
+
+	// Both fix the typo differently, while quantities change concurrently
+	require.True(t, a.RenameItem("Mlik", "Milk", a.NodeID))
+	a.AddOrUpdateItem("Milk", 1, a.NodeID)
+	require.True(t, b.RenameItem("Mlik", "milk", b.NodeID))
+	b.AddOrUpdateItem("milk", 2, b.NodeID)
+
+	ab := a.Clone()
+	ab.Merge(b)
+	ba := b.Clone()
+	ba.Merge(a)
+
+	assert.Equal(t, ab.GetItems(), ba.GetItems())
+	require.Len(t, ab.GetItems(), 1)
+
+	// Same stamp, the greater node wins
+	assert.Equal(t, []string{"milk"}, ab.GetItems())
+
+	q, _ := ab.GetItemQuantity("milk")
+	assert.Equal(t, int32(6), q)
+
+	// A later rename wins over both
+	require.True(t, ab.RenameItem("milk", "Whole milk", ab.NodeID))
+	ba.Merge(ab)
+	assert.Equal(t, []string{"Whole milk"}, ba.GetItems())
+}
+
+func TestConcurrentRenameAndAddOfTheSameName(t *testing.T) {
+	base := NewShoppingList()
+	base.AddOrUpdateItem("Mlik", 3, base.NodeID)
+
+	a := base.Clone()
+	a.NodeID = "a"
+	b := base.Clone()
+	b.NodeID = "b"
+
+	mlikId, _ := base.ItemId("Mlik")
+	a.RenameItem("Mlik", "Milk", a.NodeID)
+	b.AddOrUpdateItem("Milk", 2, b.NodeID)
+	addedId, _ := b.ItemId("Milk")
+	assert.NotEqual(t, "Milk", addedId)
+
+	a.Merge(b)
+	b.Merge(a)
+
+	// Two items end up with the same name, every replica resolves it to the same one
+	assert.Equal(t, []string{"Milk", "Milk"}, a.GetItems())
+	assert.Equal(t, a.GetItems(), b.GetItems())
+
+	// Each keeps its own id and quantity
+	for _, list := range []*ShoppingList{a, b} {
+		assert.ElementsMatch(t, []string{mlikId, addedId}, list.GetItemIds())
+		assert.Equal(t, int32(3), list.Items[mlikId].Value())
+		assert.Equal(t, int32(2), list.Items[addedId].Value())
+	}
+
+	aId, _ := a.ItemId("Milk")
+	bId, _ := b.ItemId("Milk")
+	assert.Equal(t, aId, bId)
+
+	// The conflict is solved by renaming the item the name resolves to
+	assert.Len(t, a.GetItemIds(), 2)
+	require.True(t, a.RenameItem("Milk", "Oat milk", a.NodeID))
+	assert.Equal(t, []string{"Milk", "Oat milk"}, a.GetItems())
+
+	other, _ := a.ItemId("Milk")
+	assert.NotEqual(t, aId, other)
+}
+
+func TestAddDoesNotTakeTheIdOfAnItemRenamedConcurrently(t *testing.T) {
+	base := NewShoppingList()
+
+	// b got the list before a added its item, then renamed it
+	b := base.Clone()
+	b.NodeID = "b"
+	a := base.Clone()
+	a.NodeID = "a"
+	a.AddOrUpdateItem("Oat milk", 1, a.NodeID)
+	require.True(t, a.RenameItem("Oat milk", "Milk", a.NodeID))
+
+	// b adds an item with the name a's item was first added with
+	b.AddOrUpdateItem("Oat milk", 4, b.NodeID)
+
+	a.Merge(b)
+	b.Merge(a)
+
+	for _, list := range []*ShoppingList{a, b} {
+		assert.Equal(t, []string{"Milk", "Oat milk"}, list.GetItems())
+
+		milk, _ := list.GetItemQuantity("Milk")
+		oatMilk, _ := list.GetItemQuantity("Oat milk")
+		assert.Equal(t, int32(1), milk)
+		assert.Equal(t, int32(4), oatMilk)
+	}
+}
+
+func TestNamesEncoding(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("Mlik", 3, list.NodeID)
+	list.RenameItem("Mlik", "Milk", list.NodeID)
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+
+	assert.Equal(t, list.Names, decoded.Names)
+	assert.Equal(t, []string{"Milk"}, decoded.GetItems())
+}
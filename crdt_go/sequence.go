@@ -0,0 +1,217 @@
+package crdt_go
+
+import (
+	"math"
+	"sort"
+)
+
+// ORDER_STEP is how far apart the positions of items appended at the end are, leaving room to insert between them
+const ORDER_STEP uint64 = 1 << 16
+
+// Position is the place of an item in a Sequence, Logoot style: a path of digits in a dense order,
+// so there is always room for a new position between two others.
+// Stamp is a Lamport clock, when an item is moved concurrently the latest move wins.
+type Position struct {
+	Digits []uint32 `json:"digits"`
+	NodeID string   `json:"node_id"` // The node that made the position, breaks ties between equal digits
+	Stamp  uint64   `json:"stamp"`
+}
+
+// before indicates if the position comes before the other one in the Sequence.
+func (p Position) before(other Position) bool {
+	for i := 0; i < len(p.Digits) && i < len(other.Digits); i++ {
+		if p.Digits[i] != other.Digits[i] {
+			return p.Digits[i] < other.Digits[i]
+		}
+	}
+	if len(p.Digits) != len(other.Digits) {
+		return len(p.Digits) < len(other.Digits)
+	}
+	return p.NodeID < other.NodeID
+}
+
+// newerThan indicates if the position replaces the other one when merging.
+func (p Position) newerThan(other Position) bool {
+	if p.Stamp != other.Stamp {
+		return p.Stamp > other.Stamp
+	}
+	if p.NodeID != other.NodeID {
+		return p.NodeID > other.NodeID
+	}
+	return other.before(p)
+}
+
+// digitsBetween returns digits strictly between lo and hi, a nil bound being the start or the end of the Sequence.
+func digitsBetween(lo []uint32, hi []uint32) []uint32 {
+	digit := func(digits []uint32, i int) uint64 {
+		if i < len(digits) {
+			return uint64(digits[i])
+		}
+		return 0
+	}
+
+	result := make([]uint32, 0, len(lo)+1)
+	bounded := hi != nil
+
+	for i := 0; ; i++ {
+		l := digit(lo, i)
+
+		// Past both bounds the result is already greater than lo and smaller than hi
+		if i >= len(lo) && i >= len(hi) {
+			bounded = false
+		}
+
+		h := uint64(math.MaxUint32) + 1
+		if bounded {
+			h = digit(hi, i)
+		}
+
+		if h > l+1 {
+			// Room at this level, between two items take the middle, at the end leave room for more
+			gap := h - l - 1
+			if bounded {
+				return append(result, uint32(l+1+gap/2))
+			}
+			return append(result, uint32(l+min(gap, ORDER_STEP)))
+		}
+
+		result = append(result, uint32(l))
+		if h > l {
+			bounded = false
+		}
+	}
+}
+
+// Sequence is a CRDT that keeps the order of the items of a list.
+// Each item has a position, positions are only compared, so the order is the same on every replica.
+type Sequence struct {
+	Positions map[string]Position `json:"positions"`
+}
+
+// NewSequence creates an empty Sequence.
+func NewSequence() *Sequence {
+	return &Sequence{
+		Positions: make(map[string]Position),
+	}
+}
+
+// nextStamp returns a stamp greater than every other in the Sequence.
+func (s *Sequence) nextStamp() uint64 {
+	var stamp uint64
+	for _, position := range s.Positions {
+		if position.Stamp > stamp {
+			stamp = position.Stamp
+		}
+	}
+	return stamp + 1
+}
+
+// Contains indicates if the item has a position.
+func (s *Sequence) Contains(itemName string) bool {
+	_, ok := s.Positions[itemName]
+	return ok
+}
+
+// Append places the item after every other item.
+func (s *Sequence) Append(itemName string, NodeID string) {
+	var last []uint32
+	for _, position := range s.Positions {
+		if last == nil || (Position{Digits: last}).before(Position{Digits: position.Digits}) {
+			last = position.Digits
+		}
+	}
+
+	s.Positions[itemName] = Position{
+		Digits: digitsBetween(last, nil),
+		NodeID: NodeID,
+		Stamp:  s.nextStamp(),
+	}
+}
+
+// Move places the item right after another one of the given items, or first if after is empty.
+// items are the items currently shown, in order, only their positions are taken into account.
+func (s *Sequence) Move(itemName string, after string, items []string, NodeID string) {
+	var lo, hi []uint32
+
+	found := after == ""
+	for _, name := range items {
+		if name == itemName {
+			continue
+		}
+		if found {
+			hi = s.Positions[name].Digits
+			break
+		}
+		if name == after {
+			lo = s.Positions[name].Digits
+			found = true
+		}
+	}
+
+	if !found {
+		s.Append(itemName, NodeID)
+		return
+	}
+
+	s.Positions[itemName] = Position{
+		Digits: digitsBetween(lo, hi),
+		NodeID: NodeID,
+		Stamp:  s.nextStamp(),
+	}
+}
+
+// Sort orders the items by their positions. Items without one come last, alphabetically.
+func (s *Sequence) Sort(items []string) []string {
+	sorted := make([]string, len(items))
+	copy(sorted, items)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, aOk := s.Positions[sorted[i]]
+		b, bOk := s.Positions[sorted[j]]
+
+		if aOk && bOk {
+			if a.before(b) {
+				return true
+			}
+			if b.before(a) {
+				return false
+			}
+			return sorted[i] < sorted[j]
+		}
+		if aOk != bOk {
+			return aOk
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	return sorted
+}
+
+// Merge merges another Sequence into this one, for each item the latest position is kept.
+func (s *Sequence) Merge(other *Sequence) {
+	if other == nil {
+		return
+	}
+
+	for itemName, incPosition := range other.Positions {
+		if position, ok := s.Positions[itemName]; !ok || incPosition.newerThan(position) {
+			s.Positions[itemName] = incPosition.clone()
+		}
+	}
+}
+
+// Clone creates a deep copy of the Sequence.
+func (s *Sequence) Clone() *Sequence {
+	clone := NewSequence()
+	for itemName, position := range s.Positions {
+		clone.Positions[itemName] = position.clone()
+	}
+	return clone
+}
+
+func (p Position) clone() Position {
+	digits := make([]uint32, len(p.Digits))
+	copy(digits, p.Digits)
+	p.Digits = digits
+	return p
+}
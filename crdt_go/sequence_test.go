@@ -0,0 +1,135 @@
+package crdt_go
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigitsBetween(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		// Keep inserting at random places of a growing sequence
+		positions := []Position{{Digits: digitsBetween(nil, nil)}}
+
+		for j := 0; j < 50; j++ {
+			k := rand.Intn(len(positions) + 1)
+
+			var lo, hi []uint32
+			if k > 0 {
+				lo = positions[k-1].Digits
+			}
+			if k < len(positions) {
+				hi = positions[k].Digits
+			}
+
+			position := Position{Digits: digitsBetween(lo, hi)}
+
+			if k > 0 {
+				require.True(t, positions[k-1].before(position), "%v should be before %v", lo, position.Digits)
+			}
+			if k < len(positions) {
+				require.True(t, position.before(positions[k]), "%v should be before %v", position.Digits, hi)
+			}
+
+			positions = append(positions[:k], append([]Position{position}, positions[k:]...)...)
+		}
+	}
+}
+
+func TestSequenceAppendAndMove(t *testing.T) {
+	s := NewSequence()
+	s.Append("milk", "node1")
+	s.Append("eggs", "node1")
+	s.Append("bread", "node1")
+
+	items := []string{"bread", "eggs", "milk"}
+	assert.Equal(t, []string{"milk", "eggs", "bread"}, s.Sort(items))
+
+	s.Move("bread", "", s.Sort(items), "node1")
+	assert.Equal(t, []string{"bread", "milk", "eggs"}, s.Sort(items))
+
+	s.Move("bread", "milk", s.Sort(items), "node1")
+	assert.Equal(t, []string{"milk", "bread", "eggs"}, s.Sort(items))
+
+	s.Move("milk", "eggs", s.Sort(items), "node1")
+	assert.Equal(t, []string{"bread", "eggs", "milk"}, s.Sort(items))
+
+	// Items without a position come last
+	assert.Equal(t, []string{"bread", "eggs", "milk", "apples"}, s.Sort(append(items, "apples")))
+}
+
+func TestSequenceConcurrentMoves(t *testing.T) {
+	a := NewSequence()
+	a.Append("milk", "node1")
+	a.Append("eggs", "node1")
+	a.Append("bread", "node1")
+
+	b := a.Clone()
+	items := []string{"bread", "eggs", "milk"}
+
+	a.Move("bread", "", a.Sort(items), "node1")
+	b.Move("bread", "milk", b.Sort(items), "node2")
+	b.Move("milk", "eggs", b.Sort(items), "node2")
+
+	ab := a.Clone()
+	ab.Merge(b)
+	ba := b.Clone()
+	ba.Merge(a)
+
+	assert.Equal(t, ab.Sort(items), ba.Sort(items))
+	assert.Equal(t, ab.Positions, ba.Positions)
+}
+
+func TestShoppingListOrder(t *testing.T) {
+	alice := NewShoppingList()
+	alice.AddOrUpdateItem("milk", 1, alice.NodeID)
+	alice.AddOrUpdateItem("eggs", 6, alice.NodeID)
+	alice.AddOrUpdateItem("bread", 1, alice.NodeID)
+
+	assert.Equal(t, []string{"milk", "eggs", "bread"}, alice.GetOrderedItems())
+	assert.Equal(t, []string{"bread", "eggs", "milk"}, alice.GetItems())
+
+	bob := alice.Clone()
+	bob.NodeID = "bob"
+
+	assert.True(t, alice.MoveItem("bread", "", alice.NodeID))
+	bob.AddOrUpdateItem("apples", 3, bob.NodeID)
+	assert.False(t, bob.MoveItem("bananas", "", bob.NodeID))
+
+	alice.Merge(bob)
+	bob.Merge(alice)
+
+	assert.Equal(t, []string{"bread", "milk", "eggs", "apples"}, alice.GetOrderedItems())
+	assert.Equal(t, alice.GetOrderedItems(), bob.GetOrderedItems())
+
+	// Removed items keep their place if they come back
+	alice.RemoveItem("milk", alice.NodeID)
+	assert.Equal(t, []string{"bread", "eggs", "apples"}, alice.GetOrderedItems())
+	alice.AddOrUpdateItem("milk", 1, alice.NodeID)
+	assert.Equal(t, []string{"bread", "milk", "eggs", "apples"}, alice.GetOrderedItems())
+
+	data, err := alice.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, alice.GetOrderedItems(), decoded.GetOrderedItems())
+}
+
+func TestShoppingListOrderWithoutSequence(t *testing.T) {
+	// A list stored before items had an order
+	old := NewShoppingList()
+	old.AddOrUpdateItem("milk", 1, old.NodeID)
+	old.AddOrUpdateItem("eggs", 1, old.NodeID)
+	old.Order = nil
+
+	assert.Equal(t, []string{"eggs", "milk"}, old.GetOrderedItems())
+
+	list := NewShoppingList()
+	list.AddOrUpdateItem("bread", 1, list.NodeID)
+	list.Merge(old)
+
+	assert.Equal(t, []string{"bread", "eggs", "milk"}, list.GetOrderedItems())
+}
@@ -0,0 +1,63 @@
+package crdt_go
+
+import "encoding/json"
+
+// Snapshot returns a copy of the ShoppingList that can be read, encoded or merged while the list
+// keeps changing. The copy is cheap: both share their state until one of them is changed, which
+// then copies it first (copy-on-write).
+func (l *ShoppingList) Snapshot() *ShoppingList {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.shared = true
+
+	return &ShoppingList{
+		NodeID:    l.NodeID,
+		Items:     l.Items,
+		AwSet:     l.AwSet,
+		Removed:   l.Removed,
+		History:   l.History,
+		Order:     l.Order,
+		Notes:     l.Notes,
+		Names:     l.Names,
+		ACL:       l.ACL,
+		Tombstone: l.Tombstone,
+		Replicas:  l.Replicas,
+		Folded:    l.Folded,
+		Pruned:    l.Pruned,
+		shared:    true,
+	}
+}
+
+// own copies the state of the list if it is shared with a snapshot, so it can be changed.
+// Every change of the list must call it first, the caller must hold the write lock.
+func (l *ShoppingList) own() {
+	if !l.shared {
+		return
+	}
+
+	clone := l.clone()
+	l.Items = clone.Items
+	l.AwSet = clone.AwSet
+	l.Removed = clone.Removed
+	l.History = clone.History
+	l.Order = clone.Order
+	l.Notes = clone.Notes
+	l.Names = clone.Names
+	l.ACL = clone.ACL
+	l.Tombstone = clone.Tombstone
+	l.Replicas = clone.Replicas
+	l.Folded = clone.Folded
+	l.Pruned = clone.Pruned
+	l.shared = false
+}
+
+// MarshalJSON encodes the ShoppingList while holding its lock.
+func (l *ShoppingList) MarshalJSON() ([]byte, error) {
+	type shoppingList ShoppingList
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return json.Marshal((*shoppingList)(l))
+}
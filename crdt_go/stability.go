@@ -0,0 +1,461 @@
+package crdt_go
+
+import (
+	"sort"
+	"time"
+)
+
+// BASE_ACTOR is the node, in every counter, that holds what was folded from retired nodes
+const BASE_ACTOR string = "base"
+
+// VersionVector maps each node to the number of its operations that were observed.
+type VersionVector map[string]uint64
+
+// Clone creates a copy of the VersionVector.
+func (v VersionVector) Clone() VersionVector {
+	clone := make(VersionVector, len(v))
+	for NodeID, seq := range v {
+		clone[NodeID] = seq
+	}
+	return clone
+}
+
+// Merge keeps, for every node, the most operations observed by either vector.
+func (v VersionVector) Merge(other VersionVector) {
+	for NodeID, seq := range other {
+		if seq > v[NodeID] {
+			v[NodeID] = seq
+		}
+	}
+}
+
+// LessOrEqual indicates if every operation observed by v was also observed by other.
+func (v VersionVector) LessOrEqual(other VersionVector) bool {
+	for NodeID, seq := range v {
+		if seq > other[NodeID] {
+			return false
+		}
+	}
+	return true
+}
+
+// VersionVector returns the operations this list has observed.
+// Operations are counted by the history, so a list without one has observed nothing.
+func (l *ShoppingList) VersionVector() VersionVector {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.versionVector()
+}
+
+// versionVector returns the operations this list has observed, the caller must hold the lock
+func (l *ShoppingList) versionVector() VersionVector {
+	if l.History == nil {
+		return VersionVector{}
+	}
+	return VersionVector(l.History.Clock).Clone()
+}
+
+// MarkSeen records that a replica has observed the current state of the list.
+func (l *ShoppingList) MarkSeen(replica string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.own()
+
+	if l.Replicas == nil {
+		l.Replicas = make(map[string]VersionVector)
+	}
+	l.Replicas[replica] = l.versionVector()
+}
+
+// StableVersion returns the operations that every replica has observed, these are causally stable.
+// The second return is false if one of the replicas has not yet recorded what it observed.
+func (l *ShoppingList) StableVersion(replicas []string) (VersionVector, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.stableVersion(replicas)
+}
+
+// stableVersion returns the operations every replica has observed, the caller must hold the lock
+func (l *ShoppingList) stableVersion(replicas []string) (VersionVector, bool) {
+	if len(replicas) == 0 {
+		return nil, false
+	}
+
+	var stable VersionVector
+
+	for _, replica := range replicas {
+		seen, ok := l.Replicas[replica]
+		if !ok {
+			return nil, false
+		}
+
+		if stable == nil {
+			stable = seen.Clone()
+			continue
+		}
+
+		for NodeID, seq := range stable {
+			if seen[NodeID] < seq {
+				stable[NodeID] = seen[NodeID]
+			}
+		}
+	}
+
+	return stable, true
+}
+
+// IsRetired indicates if a node was folded into the base, the operations it makes from now on are ignored.
+// Only the clients that could no longer write the list are retired.
+func (l *ShoppingList) IsRetired(NodeID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.Folded[NodeID]
+	return ok
+}
+
+// Compact folds the counters of retired nodes into BASE_ACTOR and forgets them, so the size of the
+// list only depends on the nodes still using it.
+// A node is retired when it made no operation for inactiveFor, every replica has observed
+// exactly the same operations of it and it can no longer write the list. Compaction only happens
+// once the whole state of this list is causally stable, so no replica can still hold a dot this
+// list has already removed.
+//
+// A client keeps its id across lists, so one that can still write is never retired: its later
+// operations would be ignored with no id to continue with. Nobody is retired from a list without
+// an owner, which everybody can write.
+//
+// Every replica must compact with the same retired nodes, so only one replica of the list (e.g.
+// the first one in the ring) should call Compact, the others get the result when merging.
+// Returns the nodes that were retired.
+func (l *ShoppingList) Compact(replicas []string, inactiveFor time.Duration, at time.Time) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.History == nil {
+		return nil
+	}
+
+	if !l.causallyStable(replicas) {
+		return nil
+	}
+
+	cutoff := at.Add(-inactiveFor).UnixMilli()
+
+	retired := make(map[string]struct{})
+	for NodeID, seq := range l.History.Clock {
+		if NodeID == BASE_ACTOR || l.History.Active[NodeID] > cutoff || l.canWrite(NodeID) {
+			continue
+		}
+
+		observedByAll := true
+		for _, replica := range replicas {
+			if l.Replicas[replica][NodeID] != seq {
+				observedByAll = false
+				break
+			}
+		}
+
+		if observedByAll {
+			retired[NodeID] = struct{}{}
+		}
+	}
+
+	// A node that got rights from a node still in use keeps its counters, as they are
+	// part of the counters of the other node
+	counters := l.counters()
+	for changed := true; changed; {
+		changed = false
+		for _, counter := range counters {
+			for from, transfers := range counter.Transfers {
+				if _, ok := retired[from]; ok {
+					continue
+				}
+				for to, amount := range transfers {
+					if _, ok := retired[to]; ok && amount > 0 {
+						delete(retired, to)
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	if len(retired) == 0 {
+		return nil
+	}
+
+	// The counters were only read so far, they are changed from here on
+	l.own()
+	for _, counter := range l.counters() {
+		counter.fold(retired)
+	}
+
+	if l.Folded == nil {
+		l.Folded = make(map[string]uint64)
+	}
+
+	result := make([]string, 0, len(retired))
+	for NodeID := range retired {
+		l.Folded[NodeID] = l.History.Clock[NodeID]
+		result = append(result, NodeID)
+	}
+	sort.Strings(result)
+
+	l.dropFolded()
+
+	return result
+}
+
+// causallyStable indicates if every replica has observed every operation of this list, the caller
+// must hold the lock
+func (l *ShoppingList) causallyStable(replicas []string) bool {
+	stable, ok := l.stableVersion(replicas)
+	return ok && l.versionVector().LessOrEqual(stable)
+}
+
+// PruneRemoved forgets the counters of the removed items that nobody added back, so the size of
+// the list does not grow with every item it ever had. They are only kept to cancel the increments a
+// replica had not observed as removed, so they are pruned once the whole state of this list is
+// causally stable.
+// The pruned items are remembered, like the retired nodes, so copies of the list that did not see
+// the removal do not bring their counters back. An item added back afterwards gets a new id.
+//
+// Like Compact, only one replica of the list should call it. Returns the items that were pruned.
+func (l *ShoppingList) PruneRemoved(replicas []string, at time.Time) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.History == nil || len(l.Removed) == 0 || !l.causallyStable(replicas) {
+		return nil
+	}
+
+	live := make(map[string]bool)
+	for _, itemId := range l.AwSet.Elements() {
+		live[itemId] = true
+	}
+
+	pruned := make([]string, 0)
+	for _, itemId := range sortedKeys(l.Removed) {
+		// The counter of a removed item can still hold rights transferred since it was removed
+		if counter, ok := l.Items[itemId]; live[itemId] || (ok && !counter.coveredBy(l.Removed[itemId])) {
+			continue
+		}
+		pruned = append(pruned, itemId)
+	}
+
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	l.own()
+	if l.Pruned == nil {
+		l.Pruned = make(map[string]uint64)
+	}
+	for _, itemId := range pruned {
+		l.Pruned[itemId] = uint64(at.UnixMilli())
+	}
+
+	l.dropPruned()
+
+	return pruned
+}
+
+// dropPruned forgets the counters and notes of the pruned items, which may have been brought back
+// by a merge with a copy that had not seen them removed. An item that is on the list again was
+// added back by a client that did not know it was pruned, it keeps what it has. The caller must
+// hold the write lock.
+func (l *ShoppingList) dropPruned() {
+	if len(l.Pruned) == 0 {
+		return
+	}
+
+	for itemId := range l.Pruned {
+		if l.AwSet.Contains(itemId) {
+			continue
+		}
+		delete(l.Items, itemId)
+		delete(l.Removed, itemId)
+		delete(l.Notes, itemId)
+	}
+}
+
+// mergePruned merges the items pruned by another replica.
+func (l *ShoppingList) mergePruned(incList *ShoppingList) {
+	if len(incList.Pruned) > 0 && l.Pruned == nil {
+		l.Pruned = make(map[string]uint64)
+	}
+	for itemId, at := range incList.Pruned {
+		if at >= l.Pruned[itemId] {
+			l.Pruned[itemId] = at
+		}
+	}
+
+	l.dropPruned()
+}
+
+// counters returns every counter of the list, of the items and of what was removed.
+func (l *ShoppingList) counters() []*BoundedPNCounter {
+	counters := make([]*BoundedPNCounter, 0, len(l.Items)+len(l.Removed))
+	for _, counter := range l.Items {
+		counters = append(counters, counter)
+	}
+	for _, counter := range l.Removed {
+		counters = append(counters, counter)
+	}
+	return counters
+}
+
+// dropFolded forgets every retired node, which may have been brought back by a merge with a
+// replica that had not yet seen the compaction. The caller must hold the write lock.
+func (l *ShoppingList) dropFolded() {
+	if len(l.Folded) == 0 {
+		return
+	}
+
+	for _, counter := range l.counters() {
+		counter.forget(l.Folded)
+	}
+
+	if l.History != nil {
+		for NodeID := range l.Folded {
+			delete(l.History.Clock, NodeID)
+			delete(l.History.Active, NodeID)
+		}
+	}
+
+	for _, seen := range l.Replicas {
+		for NodeID := range l.Folded {
+			delete(seen, NodeID)
+		}
+	}
+
+	// The context of a retired node is only needed while one of its dots is still on the list
+	l.AwSet.mu.Lock()
+	defer l.AwSet.mu.Unlock()
+
+	hasDots := make(map[string]bool)
+	for _, stateItem := range l.AwSet.State {
+		hasDots[stateItem.NodeID] = true
+	}
+
+	context := make([]ContextItem, 0, len(l.AwSet.Context))
+	for _, ctxItem := range l.AwSet.Context {
+		if _, retired := l.Folded[ctxItem.NodeID]; retired && !hasDots[ctxItem.NodeID] {
+			continue
+		}
+		context = append(context, ctxItem)
+	}
+	l.AwSet.Context = context
+}
+
+// mergeFolded merges the nodes retired by another replica.
+func (l *ShoppingList) mergeFolded(incList *ShoppingList) {
+	if len(incList.Folded) > 0 && l.Folded == nil {
+		l.Folded = make(map[string]uint64)
+	}
+	for NodeID, seq := range incList.Folded {
+		if seq >= l.Folded[NodeID] {
+			l.Folded[NodeID] = seq
+		}
+	}
+
+	if len(incList.Replicas) > 0 && l.Replicas == nil {
+		l.Replicas = make(map[string]VersionVector)
+	}
+	for replica, incSeen := range incList.Replicas {
+		if seen, ok := l.Replicas[replica]; ok {
+			seen.Merge(incSeen)
+		} else {
+			l.Replicas[replica] = incSeen.Clone()
+		}
+	}
+
+	l.dropFolded()
+}
+
+// fold moves the counts of the retired nodes into BASE_ACTOR.
+// Transfers between retired nodes cancel out, transfers to other nodes become transfers from the base.
+func (c *BoundedPNCounter) fold(retired map[string]struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for NodeID := range retired {
+		if count, ok := c.PositiveCount[NodeID]; ok {
+			c.PositiveCount[BASE_ACTOR] += count
+			delete(c.PositiveCount, NodeID)
+		}
+
+		if count, ok := c.NegativeCount[NodeID]; ok {
+			c.NegativeCount[BASE_ACTOR] += count
+			delete(c.NegativeCount, NodeID)
+		}
+
+		for to, amount := range c.Transfers[NodeID] {
+			if _, ok := retired[to]; ok {
+				continue
+			}
+			if c.Transfers == nil {
+				c.Transfers = make(map[string]map[string]uint32)
+			}
+			if _, ok := c.Transfers[BASE_ACTOR]; !ok {
+				c.Transfers[BASE_ACTOR] = make(map[string]uint32)
+			}
+			c.Transfers[BASE_ACTOR][to] += amount
+		}
+		delete(c.Transfers, NodeID)
+	}
+}
+
+// forget removes every count of the retired nodes, they are already part of BASE_ACTOR.
+func (c *BoundedPNCounter) forget(retired map[string]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for NodeID := range retired {
+		delete(c.PositiveCount, NodeID)
+		delete(c.NegativeCount, NodeID)
+		delete(c.Transfers, NodeID)
+	}
+
+	for from, transfers := range c.Transfers {
+		for NodeID := range retired {
+			delete(transfers, NodeID)
+		}
+		if len(transfers) == 0 {
+			delete(c.Transfers, from)
+		}
+	}
+}
+
+// coveredBy indicates if every count of the counter was also observed by the other one.
+func (c *BoundedPNCounter) coveredBy(other *BoundedPNCounter) bool {
+	if other == c {
+		return true
+	}
+	other = other.Snapshot()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for NodeID, count := range c.PositiveCount {
+		if count > other.PositiveCount[NodeID] {
+			return false
+		}
+	}
+	for NodeID, count := range c.NegativeCount {
+		if count > other.NegativeCount[NodeID] {
+			return false
+		}
+	}
+	for from, transfers := range c.Transfers {
+		for to, amount := range transfers {
+			if amount > other.Transfers[from][to] {
+				return false
+			}
+		}
+	}
+	return true
+}
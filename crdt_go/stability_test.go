@@ -0,0 +1,344 @@
+package crdt_go
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var replicaIds = []string{"replica1", "replica2", "replica3"}
+
+// syncReplicas merges every replica into every other, each one recording what it observed
+func syncReplicas(replicas []*ShoppingList) {
+	for round := 0; round < 2; round++ {
+		for i, replica := range replicas {
+			for _, other := range replicas {
+				replica.Merge(other)
+			}
+			replica.MarkSeen(replicaIds[i])
+		}
+	}
+}
+
+func TestVersionVector(t *testing.T) {
+	a := VersionVector{"node1": 2, "node2": 1}
+	b := VersionVector{"node1": 3}
+
+	assert.False(t, a.LessOrEqual(b))
+	assert.True(t, b.LessOrEqual(VersionVector{"node1": 3, "node2": 5}))
+
+	a.Merge(b)
+	assert.Equal(t, VersionVector{"node1": 3, "node2": 1}, a)
+}
+
+func TestStableVersion(t *testing.T) {
+	list := NewShoppingList()
+	list.Replicas = map[string]VersionVector{
+		"replica1": {"node1": 3, "node2": 1},
+		"replica2": {"node1": 2, "node2": 4},
+	}
+
+	_, ok := list.StableVersion([]string{"replica1", "replica2", "replica3"})
+	assert.False(t, ok)
+
+	stable, ok := list.StableVersion([]string{"replica1", "replica2"})
+	require.True(t, ok)
+	assert.Equal(t, VersionVector{"node1": 2, "node2": 1}, stable)
+}
+
+// ownedByBob gives the list to bob, so the other clients can no longer write it and can be retired
+func ownedByBob(list *ShoppingList) {
+	list.SetOwner("bob", "bob")
+}
+
+func TestCompactFoldsRetiredNodes(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	alice := NewShoppingList()
+	ownedByBob(alice)
+	alice.AddOrUpdateItem("milk", 5, alice.NodeID)
+	alice.AddOrUpdateItem("milk", -1, alice.NodeID)
+	alice.AddOrUpdateItem("eggs", 6, alice.NodeID)
+	alice.TransferRights("eggs", alice.NodeID, "someone", 2)
+
+	*clock = clock.Add(10 * 24 * time.Hour)
+
+	bob := alice.Clone()
+	bob.NodeID = "bob"
+	bob.AddOrUpdateItem("milk", 2, bob.NodeID)
+	milkId, _ := bob.ItemId("milk")
+
+	replicas := []*ShoppingList{bob.Clone(), bob.Clone(), bob.Clone()}
+	syncReplicas(replicas)
+
+	retired := replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock)
+	assert.Equal(t, []string{alice.NodeID}, retired)
+
+	leader := replicas[0]
+	assert.True(t, leader.IsRetired(alice.NodeID))
+	assert.NotContains(t, leader.Items[milkId].PositiveCount, alice.NodeID)
+	assert.NotContains(t, leader.History.Clock, alice.NodeID)
+
+	// Quantities are kept by the base
+	milk, _ := leader.GetItemQuantity("milk")
+	eggs, _ := leader.GetItemQuantity("eggs")
+	assert.Equal(t, int32(6), milk)
+	assert.Equal(t, int32(6), eggs)
+	assert.Equal(t, int64(2), leader.GetItemRights("eggs", "someone"))
+	assert.Equal(t, int64(4), leader.GetItemRights("eggs", BASE_ACTOR))
+
+	// Replicas that did not see the compaction yet do not bring the retired node back
+	syncReplicas(replicas)
+	for _, replica := range replicas {
+		milk, _ := replica.GetItemQuantity("milk")
+		assert.Equal(t, int32(6), milk)
+		assert.NotContains(t, replica.Items[milkId].PositiveCount, alice.NodeID)
+		assert.True(t, equalShoppingList(leader, replica))
+	}
+
+	// Neither does alice's own copy
+	leader.Merge(alice)
+	milk, _ = leader.GetItemQuantity("milk")
+	assert.Equal(t, int32(6), milk)
+}
+
+func TestCompactWaitsForStability(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	alice := NewShoppingList()
+	ownedByBob(alice)
+	alice.AddOrUpdateItem("milk", 5, alice.NodeID)
+
+	*clock = clock.Add(10 * 24 * time.Hour)
+
+	replicas := []*ShoppingList{alice.Clone(), alice.Clone(), alice.Clone()}
+	replicas[0].NodeID = "replica1"
+	syncReplicas(replicas)
+
+	// An operation the other replicas have not observed yet
+	replicas[0].AddOrUpdateItem("eggs", 1, replicas[0].NodeID)
+	replicas[0].MarkSeen(replicaIds[0])
+
+	assert.Empty(t, replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock))
+
+	// A replica that never recorded what it observed
+	assert.Empty(t, replicas[1].Compact([]string{"replica1", "replica4"}, 7*24*time.Hour, *clock))
+}
+
+func TestCompactKeepsActiveNodes(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	alice := NewShoppingList()
+	ownedByBob(alice)
+	alice.AddOrUpdateItem("milk", 5, alice.NodeID)
+
+	*clock = clock.Add(time.Hour)
+
+	replicas := []*ShoppingList{alice.Clone(), alice.Clone(), alice.Clone()}
+	replicas[0].NodeID = "replica1"
+	syncReplicas(replicas)
+
+	assert.Empty(t, replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock))
+}
+
+func TestCompactKeepsNodesWithRightsFromActiveNodes(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	alice := NewShoppingList()
+	ownedByBob(alice)
+	alice.AddOrUpdateItem("milk", 5, alice.NodeID)
+	alice.AddOrUpdateItem("eggs", 1, alice.NodeID)
+
+	*clock = clock.Add(10 * 24 * time.Hour)
+
+	// bob, who is still active, gave rights to alice
+	bob := alice.Clone()
+	bob.NodeID = "bob"
+	bob.AddOrUpdateItem("milk", 2, bob.NodeID)
+	bob.TransferRights("milk", bob.NodeID, alice.NodeID, 1)
+
+	replicas := []*ShoppingList{bob.Clone(), bob.Clone(), bob.Clone()}
+	syncReplicas(replicas)
+
+	assert.Empty(t, replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock))
+}
+
+func TestCompactDropsContextOfRetiredNodes(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	alice := NewShoppingList()
+	ownedByBob(alice)
+	alice.AddOrUpdateItem("milk", 5, alice.NodeID)
+
+	*clock = clock.Add(10 * 24 * time.Hour)
+
+	bob := alice.Clone()
+	bob.NodeID = "bob"
+	bob.RemoveItem("milk", bob.NodeID)
+	bob.AddOrUpdateItem("eggs", 2, bob.NodeID)
+
+	replicas := []*ShoppingList{bob.Clone(), bob.Clone(), bob.Clone()}
+	syncReplicas(replicas)
+
+	require.Equal(t, []string{alice.NodeID}, replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock))
+
+	for _, ctxItem := range replicas[0].AwSet.Context {
+		assert.NotEqual(t, alice.NodeID, ctxItem.NodeID)
+	}
+
+	// The compacted list still encodes and decodes to the same state
+	data, err := replicas[0].MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.True(t, equalShoppingList(replicas[0], decoded))
+	assert.Equal(t, replicas[0].Folded, decoded.Folded)
+	assert.Equal(t, replicas[0].Replicas, decoded.Replicas)
+}
+
+func TestCompactKeepsClientsThatCanWrite(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	alice := NewShoppingList()
+	alice.AddOrUpdateItem("milk", 5, alice.NodeID)
+
+	*clock = clock.Add(10 * 24 * time.Hour)
+
+	bob := alice.Clone()
+	bob.NodeID = "bob"
+	bob.AddOrUpdateItem("milk", 2, bob.NodeID)
+
+	// Everybody can write a list without an owner
+	replicas := []*ShoppingList{bob.Clone(), bob.Clone(), bob.Clone()}
+	syncReplicas(replicas)
+	assert.Empty(t, replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock))
+
+	// alice is an editor of bob's list, so she would lose the changes she makes when she is back
+	replicas[0].SetOwner("bob", "bob")
+	replicas[0].SetRole(alice.NodeID, ROLE_EDITOR, "bob")
+	syncReplicas(replicas)
+	assert.Empty(t, replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock))
+
+	// Once she is only a viewer, she can not change it anymore
+	replicas[0].SetRole(alice.NodeID, ROLE_VIEWER, "bob")
+	syncReplicas(replicas)
+	assert.Equal(t, []string{alice.NodeID}, replicas[0].Compact(replicaIds, 7*24*time.Hour, *clock))
+}
+
+func TestPruneRemovedForgetsStableRemovals(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	alice := NewShoppingList()
+	alice.AddOrUpdateItem("milk", 5, "alice")
+	alice.AddOrUpdateItem("eggs", 2, "alice")
+	milkId, _ := alice.ItemId("milk")
+
+	bob := alice.Clone()
+	bob.RemoveItem("milk", "bob")
+
+	replicas := []*ShoppingList{bob.Clone(), bob.Clone(), bob.Clone()}
+	syncReplicas(replicas)
+
+	leader := replicas[0]
+	assert.Equal(t, []string{milkId}, leader.PruneRemoved(replicaIds, *clock))
+	assert.NotContains(t, leader.Removed, milkId)
+	assert.NotContains(t, leader.Items, milkId)
+	assert.Contains(t, leader.Pruned, milkId)
+
+	// Nothing is left to prune
+	assert.Empty(t, leader.PruneRemoved(replicaIds, *clock))
+
+	// Replicas that did not see the pruning forget the counters too
+	syncReplicas(replicas)
+	for _, replica := range replicas {
+		assert.NotContains(t, replica.Removed, milkId)
+		assert.NotContains(t, replica.Items, milkId)
+		assert.True(t, equalShoppingList(leader, replica))
+	}
+
+	// Neither does alice's copy, from before the removal, bring them back
+	leader.Merge(alice)
+	assert.NotContains(t, leader.Items, milkId)
+	assert.Equal(t, []string{"eggs"}, leader.GetItems())
+
+	// Added back, the item starts over with a new id, so the old increments stay cancelled
+	leader.AddOrUpdateItem("milk", 1, "bob")
+	leader.Merge(alice)
+	milk, ok := leader.GetItemQuantity("milk")
+	assert.True(t, ok)
+	assert.Equal(t, int32(1), milk)
+
+	itemId, _ := leader.ItemId("milk")
+	assert.NotEqual(t, milkId, itemId)
+
+	// The pruned items are encoded with the list
+	data, err := leader.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, leader.Pruned, decoded.Pruned)
+}
+
+func TestPruneRemovedWaitsForStability(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 5, "alice")
+	milkId, _ := list.ItemId("milk")
+	list.RemoveItem("milk", "alice")
+
+	replicas := []*ShoppingList{list.Clone(), list.Clone(), list.Clone()}
+	syncReplicas(replicas)
+
+	// An operation the other replicas have not observed yet
+	replicas[0].AddOrUpdateItem("eggs", 1, "bob")
+	replicas[0].MarkSeen(replicaIds[0])
+	assert.Empty(t, replicas[0].PruneRemoved(replicaIds, *clock))
+	assert.Contains(t, replicas[0].Removed, milkId)
+}
+
+func TestPruneRemovedKeepsRightsTransferredConcurrently(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 5, "alice")
+	milkId, _ := list.ItemId("milk")
+
+	// alice gives bob rights on the item while carol removes it
+	alice := list.Clone()
+	alice.TransferRights("milk", "alice", "bob", 2)
+	carol := list.Clone()
+	carol.RemoveItem("milk", "carol")
+
+	replicas := []*ShoppingList{alice, carol, list.Clone()}
+	syncReplicas(replicas)
+
+	assert.Empty(t, replicas[0].PruneRemoved(replicaIds, *clock))
+	assert.Contains(t, replicas[0].Removed, milkId)
+}
+
+func TestPrunedItemAddedBackByAnOlderClient(t *testing.T) {
+	clock := setClock(t, time.UnixMilli(0))
+
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 5, "alice")
+	milkId, _ := list.ItemId("milk")
+	list.RemoveItem("milk", "alice")
+	older := list.Clone()
+
+	replicas := []*ShoppingList{list.Clone(), list.Clone(), list.Clone()}
+	syncReplicas(replicas)
+	require.Equal(t, []string{milkId}, replicas[0].PruneRemoved(replicaIds, *clock))
+
+	// A client that does not know the item was pruned adds it back with its old id
+	older.AddOrUpdateItem("milk", 2, "dave")
+
+	replicas[0].Merge(older)
+	milk, ok := replicas[0].GetItemQuantity("milk")
+	assert.True(t, ok)
+	assert.Equal(t, int32(2), milk)
+}
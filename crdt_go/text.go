@@ -0,0 +1,232 @@
+package crdt_go
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// CharId identifies a character of a Text.
+// Counter is a Lamport clock, a character inserted after seeing another one always has a greater id.
+type CharId struct {
+	NodeID  string `json:"node_id"`
+	Counter uint64 `json:"counter"`
+}
+
+// less orders ids by counter, ties are broken by node.
+func (c CharId) less(other CharId) bool {
+	if c.Counter != other.Counter {
+		return c.Counter < other.Counter
+	}
+	return c.NodeID < other.NodeID
+}
+
+// Char is a character of a Text. Deleted characters are kept as tombstones, so characters
+// inserted after them concurrently still have a place.
+type Char struct {
+	Id      CharId `json:"id"`
+	Parent  CharId `json:"parent"` // The character it was inserted after, the zero id is the start of the text
+	Value   rune   `json:"value"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Text is a CRDT for collaborative text, RGA style: each character is inserted after another one,
+// and characters inserted after the same one are placed from the newest to the oldest.
+// Concurrent edits are merged character by character instead of one overwriting the other.
+type Text struct {
+	Chars []Char `json:"chars"` // Sorted by id
+}
+
+// NewText creates an empty Text.
+func NewText() *Text {
+	return &Text{
+		Chars: make([]Char, 0),
+	}
+}
+
+// nextCounter returns a counter greater than every other in the Text.
+func (t *Text) nextCounter() uint64 {
+	var counter uint64
+	for _, char := range t.Chars {
+		if char.Id.Counter > counter {
+			counter = char.Id.Counter
+		}
+	}
+	return counter + 1
+}
+
+// ordered returns the indexes of every character, tombstones included, in the order of the text.
+func (t *Text) ordered() []int {
+	children := make(map[CharId][]int)
+	for i, char := range t.Chars {
+		children[char.Parent] = append(children[char.Parent], i)
+	}
+
+	// Newest first, so a character typed right after another one comes before older ones
+	for _, indexes := range children {
+		sort.Slice(indexes, func(i, j int) bool {
+			return t.Chars[indexes[j]].Id.less(t.Chars[indexes[i]].Id)
+		})
+	}
+
+	// Depth first, without recursion as text typed in one go is a single long branch
+	result := make([]int, 0, len(t.Chars))
+	stack := make([]int, 0)
+	push := func(parent CharId) {
+		indexes := children[parent]
+		for i := len(indexes) - 1; i >= 0; i-- {
+			stack = append(stack, indexes[i])
+		}
+	}
+
+	push(CharId{})
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		result = append(result, i)
+		push(t.Chars[i].Id)
+	}
+
+	return result
+}
+
+// visible returns the indexes of the characters that were not deleted, in the order of the text.
+func (t *Text) visible() []int {
+	ordered := t.ordered()
+
+	result := ordered[:0]
+	for _, i := range ordered {
+		if !t.Chars[i].Deleted {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// String returns the current text.
+func (t *Text) String() string {
+	visible := t.visible()
+
+	runes := make([]rune, len(visible))
+	for i, index := range visible {
+		runes[i] = t.Chars[index].Value
+	}
+	return string(runes)
+}
+
+// Len returns the number of characters of the current text.
+func (t *Text) Len() int {
+	return len(t.visible())
+}
+
+// Insert inserts value before the character at index, an index past the end appends to the text.
+// Indexes count characters, not bytes.
+func (t *Text) Insert(index int, value string, NodeID string) {
+	if value == "" {
+		return
+	}
+
+	visible := t.visible()
+	if index > len(visible) {
+		index = len(visible)
+	}
+
+	var parent CharId
+	if index > 0 {
+		parent = t.Chars[visible[index-1]].Id
+	}
+
+	// New ids are greater than every other one, so appending keeps the characters sorted
+	counter := t.nextCounter()
+	for _, value := range value {
+		id := CharId{NodeID: NodeID, Counter: counter}
+		t.Chars = append(t.Chars, Char{Id: id, Parent: parent, Value: value})
+
+		parent = id
+		counter++
+	}
+}
+
+// Delete deletes up to length characters starting at index.
+// Returns the number of characters that were deleted.
+func (t *Text) Delete(index int, length int) int {
+	visible := t.visible()
+	if index < 0 || index >= len(visible) || length <= 0 {
+		return 0
+	}
+
+	end := min(index+length, len(visible))
+	for _, i := range visible[index:end] {
+		t.Chars[i].Deleted = true
+	}
+
+	return end - index
+}
+
+// Replace changes the text to value, only the part between the common prefix and suffix is edited
+// so concurrent edits elsewhere in the text are kept.
+// Returns the number of characters that were deleted and inserted.
+func (t *Text) Replace(value string, NodeID string) (int, int) {
+	current := []rune(t.String())
+	target := []rune(value)
+
+	prefix := 0
+	for prefix < len(current) && prefix < len(target) && current[prefix] == target[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(current)-prefix && suffix < len(target)-prefix &&
+		current[len(current)-1-suffix] == target[len(target)-1-suffix] {
+		suffix++
+	}
+
+	deleted := t.Delete(prefix, len(current)-prefix-suffix)
+
+	inserted := target[prefix : len(target)-suffix]
+	t.Insert(prefix, string(inserted), NodeID)
+
+	return deleted, len(inserted)
+}
+
+// Merge merges another Text into this one, a character deleted by either one stays deleted.
+func (t *Text) Merge(other *Text) {
+	if other == nil {
+		return
+	}
+
+	index := make(map[CharId]int, len(t.Chars))
+	for i, char := range t.Chars {
+		index[char.Id] = i
+	}
+
+	for _, incChar := range other.Chars {
+		if i, ok := index[incChar.Id]; ok {
+			t.Chars[i].Deleted = t.Chars[i].Deleted || incChar.Deleted
+			continue
+		}
+		index[incChar.Id] = len(t.Chars)
+		t.Chars = append(t.Chars, incChar)
+	}
+
+	t.sort()
+}
+
+// sort orders the characters by id, so the same text is always stored the same way.
+func (t *Text) sort() {
+	sort.Slice(t.Chars, func(i, j int) bool { return t.Chars[i].Id.less(t.Chars[j].Id) })
+}
+
+// Clone creates a deep copy of the Text.
+func (t *Text) Clone() *Text {
+	clone := &Text{
+		Chars: make([]Char, len(t.Chars)),
+	}
+	copy(clone.Chars, t.Chars)
+	return clone
+}
+
+// validRune indicates if a decoded value can be a character of a Text.
+func validRune(value uint64) bool {
+	return value <= utf8.MaxRune && utf8.ValidRune(rune(value))
+}
@@ -0,0 +1,204 @@
+package crdt_go
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextInsertAndDelete(t *testing.T) {
+	text := NewText()
+	text.Insert(0, "semi skimmed", "node1")
+	assert.Equal(t, "semi skimmed", text.String())
+
+	text.Insert(4, "-", "node1")
+	assert.Equal(t, "semi- skimmed", text.String())
+
+	assert.Equal(t, 1, text.Delete(5, 1))
+	assert.Equal(t, "semi-skimmed", text.String())
+
+	// Inserting past the end appends, deleting past the end deletes what is there
+	text.Insert(100, " milk", "node1")
+	assert.Equal(t, "semi-skimmed milk", text.String())
+	assert.Equal(t, 5, text.Delete(12, 100))
+	assert.Equal(t, "semi-skimmed", text.String())
+	assert.Equal(t, 0, text.Delete(12, 1))
+
+	// Indexes count characters, not bytes
+	text.Replace("pão de forma", "node1")
+	text.Insert(3, "!", "node1")
+	assert.Equal(t, "pão! de forma", text.String())
+	assert.Equal(t, 13, text.Len())
+}
+
+func TestTextConcurrentEditsInterleave(t *testing.T) {
+	base := NewText()
+	base.Insert(0, "milk", "node1")
+
+	a := base.Clone()
+	a.Insert(0, "2L ", "node1")
+	a.Insert(100, " whole", "node1")
+
+	b := base.Clone()
+	b.Insert(4, " from the farm", "node2")
+	b.Delete(0, 1)
+
+	ab := a.Clone()
+	ab.Merge(b)
+
+	ba := b.Clone()
+	ba.Merge(a)
+
+	// Both edits are kept, each one where it was typed, and every replica agrees on the order
+	assert.Equal(t, ab.String(), ba.String())
+	assert.Equal(t, "2L ilk whole from the farm", ab.String())
+
+	// Merging again changes nothing
+	ab.Merge(b)
+	assert.Equal(t, ba.String(), ab.String())
+}
+
+func TestTextConcurrentInsertsAtTheSamePlace(t *testing.T) {
+	base := NewText()
+	base.Insert(0, "ab", "node1")
+
+	a := base.Clone()
+	a.Insert(1, "xyz", "node1")
+
+	b := base.Clone()
+	b.Insert(1, "123", "node2")
+
+	a.Merge(b)
+	b.Merge(a)
+
+	// Text typed in one go is never split by a concurrent insert
+	assert.Equal(t, a.String(), b.String())
+	assert.Contains(t, []string{"axyz123b", "a123xyzb"}, a.String())
+}
+
+func TestTextConverges(t *testing.T) {
+	letters := []rune("abcdefghij")
+
+	for i := 0; i < 200; i++ {
+		replicas := []*Text{NewText(), NewText(), NewText()}
+		nodes := []string{"node1", "node2", "node3"}
+
+		for j := 0; j < 30; j++ {
+			k := rand.Intn(len(replicas))
+			replica := replicas[k]
+
+			switch rand.Intn(3) {
+			case 0:
+				replica.Insert(rand.Intn(replica.Len()+1), string(letters[rand.Intn(len(letters))]), nodes[k])
+			case 1:
+				replica.Delete(rand.Intn(replica.Len()+1), 1+rand.Intn(2))
+			case 2:
+				replica.Merge(replicas[rand.Intn(len(replicas))])
+			}
+		}
+
+		for _, replica := range replicas {
+			for _, other := range replicas {
+				replica.Merge(other)
+			}
+		}
+
+		require.Equal(t, replicas[0].String(), replicas[1].String())
+		require.Equal(t, replicas[0].String(), replicas[2].String())
+		require.Equal(t, replicas[0].Chars, replicas[1].Chars)
+	}
+}
+
+func TestTextReplaceKeepsConcurrentEdits(t *testing.T) {
+	base := NewText()
+	base.Insert(0, "buy milk", "node1")
+
+	a := base.Clone()
+	deleted, inserted := a.Replace("buy oat milk", "node1")
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, 4, inserted)
+
+	b := base.Clone()
+	b.Replace("buy milk today", "node2")
+
+	a.Merge(b)
+	assert.Equal(t, "buy oat milk today", a.String())
+}
+
+func TestShoppingListNotes(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+
+	assert.False(t, list.SetItemNote("eggs", "free range", list.NodeID))
+	_, ok := list.GetItemNote("eggs")
+	assert.False(t, ok)
+
+	note, ok := list.GetItemNote("milk")
+	assert.True(t, ok)
+	assert.Equal(t, "", note)
+
+	require.True(t, list.SetItemNote("milk", "semi skimmed", list.NodeID))
+
+	other := list.Clone()
+	other.NodeID = "other"
+
+	require.True(t, list.EditItemNote("milk", 0, 0, "2L ", list.NodeID))
+	require.True(t, other.EditItemNote("milk", 4, 1, "-", other.NodeID))
+
+	list.Merge(other)
+	other.Merge(list)
+
+	note, _ = list.GetItemNote("milk")
+	assert.Equal(t, "2L semi-skimmed", note)
+	otherNote, _ := other.GetItemNote("milk")
+	assert.Equal(t, note, otherNote)
+
+	// Every edit is in the history
+	notes := 0
+	for _, op := range list.History.Entries {
+		if op.Op == OP_NOTE {
+			notes++
+		}
+	}
+	assert.Equal(t, 3, notes)
+
+	// The note comes back with the item
+	list.RemoveItem("milk", list.NodeID)
+	_, ok = list.GetItemNote("milk")
+	assert.False(t, ok)
+
+	list.AddOrUpdateItem("milk", 1, list.NodeID)
+	note, _ = list.GetItemNote("milk")
+	assert.Equal(t, "2L semi-skimmed", note)
+}
+
+func TestShoppingListNotesEncoding(t *testing.T) {
+	list := NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, list.NodeID)
+	list.SetItemNote("milk", "pão de forma", list.NodeID)
+	list.EditItemNote("milk", 0, 4, "", list.NodeID)
+	milkId, _ := list.ItemId("milk")
+
+	data, err := list.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, list.Notes[milkId].Chars, decoded.Notes[milkId].Chars)
+
+	jsonData, err := json.Marshal(list)
+	require.NoError(t, err)
+
+	fromJSON := &ShoppingList{}
+	require.NoError(t, json.Unmarshal(jsonData, fromJSON))
+
+	note, _ := fromJSON.GetItemNote("milk")
+	assert.Equal(t, "de forma", note)
+
+	// Lists without notes have no notes field, and no notes hash
+	assert.Nil(t, NewShoppingList().CanonicalNotes())
+	assert.NotNil(t, list.CanonicalNotes())
+}
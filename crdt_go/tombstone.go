@@ -0,0 +1,163 @@
+package crdt_go
+
+import (
+	"sort"
+	"time"
+)
+
+// Tombstone marks a list as deleted, it is kept in place of the list so no replica brings it back.
+// A deleted list stays deleted: merging anything into it keeps only the tombstone and the ACL.
+type Tombstone struct {
+	Context VersionVector   `json:"context"`        // The operations of the list the deletion observed
+	Deleted int64           `json:"deleted"`        // When the list was deleted, in Unix milliseconds
+	NodeID  string          `json:"node_id"`        // Who deleted the list
+	Acks    map[string]bool `json:"acks,omitempty"` // The replicas that stored the tombstone
+}
+
+// Clone creates a deep copy of the Tombstone.
+func (t *Tombstone) Clone() *Tombstone {
+	clone := &Tombstone{
+		Context: t.Context.Clone(),
+		Deleted: t.Deleted,
+		NodeID:  t.NodeID,
+	}
+
+	if t.Acks != nil {
+		clone.Acks = make(map[string]bool, len(t.Acks))
+		for replica := range t.Acks {
+			clone.Acks[replica] = true
+		}
+	}
+
+	return clone
+}
+
+// Merge merges another tombstone of the same list, the latest deletion is the one kept.
+func (t *Tombstone) Merge(other *Tombstone) {
+	if t.Context == nil {
+		t.Context = VersionVector{}
+	}
+	t.Context.Merge(other.Context)
+
+	if other.Deleted > t.Deleted || (other.Deleted == t.Deleted && other.NodeID > t.NodeID) {
+		t.Deleted = other.Deleted
+		t.NodeID = other.NodeID
+	}
+
+	if len(other.Acks) > 0 && t.Acks == nil {
+		t.Acks = make(map[string]bool)
+	}
+	for replica := range other.Acks {
+		t.Acks[replica] = true
+	}
+}
+
+// Delete deletes the list as the given node, dropping everything but its ACL.
+// Returns false if the list was already deleted.
+func (l *ShoppingList) Delete(NodeID string, at time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Tombstone != nil {
+		return false
+	}
+
+	l.own()
+	l.Tombstone = &Tombstone{
+		Context: l.versionVector(),
+		Deleted: at.UnixMilli(),
+		NodeID:  NodeID,
+	}
+	l.clearContent()
+
+	return true
+}
+
+// IsDeleted indicates if the list was deleted.
+func (l *ShoppingList) IsDeleted() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.Tombstone != nil
+}
+
+// GetTombstone returns a copy of the tombstone of the list, nil if it was not deleted.
+func (l *ShoppingList) GetTombstone() *Tombstone {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.Tombstone == nil {
+		return nil
+	}
+	return l.Tombstone.Clone()
+}
+
+// AckTombstone records that a replica stored the tombstone of the list, if it was deleted.
+func (l *ShoppingList) AckTombstone(replica string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Tombstone == nil || l.Tombstone.Acks[replica] {
+		return
+	}
+
+	l.own()
+	if l.Tombstone.Acks == nil {
+		l.Tombstone.Acks = make(map[string]bool)
+	}
+	l.Tombstone.Acks[replica] = true
+}
+
+// CanPurge indicates if the list was deleted at least gracePeriod ago and every replica stored its
+// tombstone, so it can be removed without any replica bringing the list back.
+func (l *ShoppingList) CanPurge(replicas []string, gracePeriod time.Duration, at time.Time) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.Tombstone == nil || at.Sub(time.UnixMilli(l.Tombstone.Deleted)) < gracePeriod {
+		return false
+	}
+
+	for _, replica := range replicas {
+		if !l.Tombstone.Acks[replica] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeTombstone merges a list when either one was deleted, the caller must hold the lock.
+func (l *ShoppingList) mergeTombstone(incList *ShoppingList) {
+	if l.Tombstone == nil {
+		l.Tombstone = incList.Tombstone.Clone()
+		l.clearContent()
+	} else if incList.Tombstone != nil {
+		l.Tombstone.Merge(incList.Tombstone)
+	}
+
+	l.mergeACL(incList)
+}
+
+// clearContent drops the content of a deleted list, the caller must hold the lock.
+func (l *ShoppingList) clearContent() {
+	l.Items = make(map[string]*BoundedPNCounter)
+	l.AwSet = NewAWSet()
+	l.Removed = make(map[string]*BoundedPNCounter)
+	l.History = nil
+	l.Order = nil
+	l.Notes = nil
+	l.Names = nil
+	l.Replicas = nil
+	l.Folded = nil
+	l.Pruned = nil
+}
+
+// sortedAcks returns the replicas that stored the tombstone, sorted.
+func (t *Tombstone) sortedAcks() []string {
+	acks := make([]string, 0, len(t.Acks))
+	for replica := range t.Acks {
+		acks = append(acks, replica)
+	}
+	sort.Strings(acks)
+	return acks
+}
@@ -0,0 +1,103 @@
+package crdt_go
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletedListStaysDeleted(t *testing.T) {
+	at := time.UnixMilli(1000)
+
+	base := NewShoppingList()
+	base.SetOwner("alice", "alice")
+	base.AddOrUpdateItem("milk", 2, "alice")
+
+	deleted := base.Clone()
+	live := base.Clone()
+
+	require.True(t, deleted.Delete("alice", at))
+	assert.False(t, deleted.Delete("alice", at))
+	assert.Empty(t, deleted.GetItems())
+	assert.Equal(t, VersionVector{"alice": 1}, deleted.GetTombstone().Context)
+
+	// A concurrent change does not bring the list back, in either direction
+	live.AddOrUpdateItem("eggs", 6, "bob")
+	live.SetRole("bob", ROLE_EDITOR, "alice")
+
+	a := deleted.Clone()
+	a.Merge(live)
+	b := live.Clone()
+	b.Merge(deleted)
+
+	for _, list := range []*ShoppingList{a, b} {
+		assert.True(t, list.IsDeleted())
+		assert.Empty(t, list.GetItems())
+		assert.Equal(t, ROLE_EDITOR, list.GetRole("bob"))
+	}
+	assert.Equal(t, a.CanonicalTombstone(), b.CanonicalTombstone())
+
+	dataA, err := a.MarshalBinary()
+	require.NoError(t, err)
+	dataB, err := b.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, dataA, dataB)
+
+	// A deleted list can not be changed
+	a.AddOrUpdateItem("bread", 1, "alice")
+	assert.Empty(t, a.GetItems())
+}
+
+func TestTombstoneAcks(t *testing.T) {
+	at := time.UnixMilli(1000)
+	grace := time.Hour
+
+	a := NewShoppingList()
+	a.AddOrUpdateItem("milk", 1, "alice")
+	b := a.Clone()
+
+	a.Delete("alice", at)
+	a.AckTombstone("node1")
+	assert.False(t, a.CanPurge([]string{"node1"}, grace, at), "too soon")
+	assert.True(t, a.CanPurge([]string{"node1"}, grace, at.Add(grace)))
+
+	// Every replica must store the tombstone first
+	b.Merge(a)
+	b.AckTombstone("node2")
+	assert.False(t, a.CanPurge([]string{"node1", "node2"}, grace, at.Add(grace)))
+	a.Merge(b)
+	assert.True(t, a.CanPurge([]string{"node1", "node2"}, grace, at.Add(grace)))
+
+	// Lists that were not deleted are never purged
+	assert.False(t, NewShoppingList().CanPurge(nil, 0, at))
+
+	// The tombstone and its acks survive the encoding
+	data, err := a.MarshalBinary()
+	require.NoError(t, err)
+	decoded := &ShoppingList{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, a.GetTombstone(), decoded.GetTombstone())
+	assert.Equal(t, a.CanonicalTombstone(), decoded.CanonicalTombstone())
+}
+
+func TestConcurrentDeletesConverge(t *testing.T) {
+	base := NewShoppingList()
+	base.AddOrUpdateItem("milk", 1, "alice")
+
+	a := base.Clone()
+	b := base.Clone()
+	a.AddOrUpdateItem("eggs", 1, "alice")
+	a.Delete("alice", time.UnixMilli(2000))
+	b.Delete("bob", time.UnixMilli(1000))
+
+	ab := a.Clone()
+	ab.Merge(b)
+	ba := b.Clone()
+	ba.Merge(a)
+
+	assert.Equal(t, ab.CanonicalTombstone(), ba.CanonicalTombstone())
+	assert.Equal(t, "alice", ab.GetTombstone().NodeID)
+	assert.Equal(t, VersionVector{"alice": 2}, ab.GetTombstone().Context)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+	"sdle.com/mod/utils"
+)
+
+// The secret share tokens are signed with, from SHARE_TOKEN_SECRET. It must be the same on every node,
+// without it no share token can be created or used
+var shareTokenSecret = []byte(os.Getenv("SHARE_TOKEN_SECRET"))
+
+// How long a share token lasts when the request does not say, from SHARE_TOKEN_TTL (in seconds)
+var shareTokenTTL = utils.SecondsFromEnv("SHARE_TOKEN_TTL", DEFAULT_SHARE_TOKEN_TTL)
+
+const DEFAULT_SHARE_TOKEN_TTL = 7 * 24 * time.Hour
+
+/**
+ * Returns the role the request has on a list, the best of the role of the client it was authenticated as and
+ * of its share token. The client id it claims gives no role by itself
+ */
+func requestRole(r *http.Request, listId string, list *crdt_go.ShoppingList) string {
+	role := ""
+	if clientId := protocol.AuthenticatedClient(r); clientId != "" {
+		role = list.GetRole(clientId)
+	}
+
+	signed := r.Header.Get(protocol.SHARE_TOKEN_HEADER)
+	if signed == "" {
+		return role
+	}
+
+	token, err := protocol.ParseShareToken(shareTokenSecret, signed, time.Now())
+	if err != nil || token.ListId != listId || list.IsShareTokenRevoked(token.Id) {
+		return role
+	}
+
+	if !crdt_go.RoleAllows(role, token.Role) {
+		role = token.Role
+	}
+	return role
+}
+
+/**
+ * Checks if the request can do what needs the given role on a list, answering with the error if it can not.
+ * A list without an owner can be read and written by everybody, but nobody is its owner
+ */
+func authorize(w http.ResponseWriter, r *http.Request, listId string, list *crdt_go.ShoppingList, need string) bool {
+	if protocol.IsAdmin(r) {
+		return true
+	}
+
+	// A client that claims to be another one is not let through, even where anybody would be
+	if !protocol.ClaimsOwnClientId(w, r) {
+		return false
+	}
+
+	if list.GetOwner() == "" && need != crdt_go.ROLE_OWNER {
+		return true
+	}
+
+	if crdt_go.RoleAllows(requestRole(r, listId, list), need) {
+		return true
+	}
+
+	if protocol.AuthenticatedClient(r) == "" && r.Header.Get(protocol.SHARE_TOKEN_HEADER) == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+	w.Write([]byte("Not allowed to " + need + " this list."))
+	return false
+}
+
+/**
+ * Checks if the request can write a list over the current one, which is nil if the list does not exist.
+ * Editors can change the list, but only its owner can change who has access to it. Whoever writes a list
+ * without an owner can claim it, but only for itself
+ */
+func authorizeWrite(w http.ResponseWriter, r *http.Request, listId string, current *crdt_go.ShoppingList, incoming *crdt_go.ShoppingList) bool {
+	if protocol.IsAdmin(r) {
+		return true
+	}
+
+	if !protocol.ClaimsOwnClientId(w, r) {
+		return false
+	}
+
+	// Writing a tombstone deletes the list
+	if incoming.IsDeleted() && current != nil && !current.IsDeleted() && !authorize(w, r, listId, current, deleteRole(current)) {
+		return false
+	}
+
+	acl := incoming.GetACL()
+	if current == nil || current.GetOwner() == "" {
+		if current != nil {
+			acl.Merge(current.GetACL())
+		}
+
+		owner := acl.Owner.Value
+		if owner != "" && owner != protocol.AuthenticatedClient(r) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Cannot give the list to another client."))
+			return false
+		}
+		return true
+	}
+
+	if !authorize(w, r, listId, current, crdt_go.ROLE_EDITOR) {
+		return false
+	}
+
+	// The incoming list changes the access to the list if its ACL is newer than the current one
+	currentACL := current.GetACL()
+	acl.Merge(currentACL)
+	if !acl.Equal(currentACL) {
+		return authorize(w, r, listId, current, crdt_go.ROLE_OWNER)
+	}
+
+	return true
+}
+
+/**
+ * Returns the role needed to delete a list, its owner deletes it unless nobody owns it
+ */
+func deleteRole(list *crdt_go.ShoppingList) string {
+	if list.GetOwner() == "" {
+		return crdt_go.ROLE_EDITOR
+	}
+	return crdt_go.ROLE_OWNER
+}
+
+/**
+ * Indicates if a replica checks the access of an operation. The coordinators already checked the operations
+ * other nodes of the ring send, every other one is checked. The peer is the one that signed the request, a node
+ * only takes the one a request claims when INSECURE_CLUSTER says the network is trusted
+ */
+func enforceOnReplica(r *http.Request) bool {
+	return !protocol.IsAdmin(r) && !ring.HasNode(protocol.ClusterPeer(r))
+}
+
+/**
+ * Reads a list for a request that needs the given role on it, answering with the error if it can not.
+ * Returns the list merged from a read quorum
+ */
+func readAuthorized(w http.ResponseWriter, r *http.Request, listId string, need string) (*crdt_go.ShoppingList, bool) {
+	readsContent, nodesRead := readQuorum(quorumContext(r), listId)
+
+	if len(readsContent) == 0 {
+		if len(nodesRead) != 0 {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return nil, false
+	}
+
+	list := mergeReads(readsContent)
+	if !authorize(w, r, listId, list, need) {
+		return nil, false
+	}
+
+	if list.IsDeleted() {
+		listDeleted(w)
+		return nil, false
+	}
+
+	return list, true
+}
+
+/**
+ * Writes a list changed by a coordinator to a write quorum, answering with the result
+ */
+func writeChanged(w http.ResponseWriter, r *http.Request, listId string, list *crdt_go.ShoppingList) {
+	if writeQuorum(quorumContext(r), protocol.ShoppingListOperation{ListId: listId, Content: list}) > 0 {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/**
+ * Creates share tokens for a list and revokes them, only the owner of the list can do it
+ */
+func handleShare(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "Received /list/share request", "method", r.Method)
+
+	query := r.URL.Query()
+
+	listId := query.Get("list_id")
+	if listId == "" {
+		protocol.RequestWithWrongFormat(w)
+		return
+	}
+
+	if len(shareTokenSecret) == 0 {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte("Share tokens are disabled, SHARE_TOKEN_SECRET is not set."))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		{
+			role := query.Get("role")
+			if role != crdt_go.ROLE_EDITOR && role != crdt_go.ROLE_VIEWER {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
+
+			ttl := shareTokenTTL
+			if value := query.Get("ttl"); value != "" {
+				seconds, err := strconv.Atoi(value)
+				if err != nil || seconds < 1 {
+					protocol.RequestWithWrongFormat(w)
+					return
+				}
+				ttl = time.Duration(seconds) * time.Second
+			}
+
+			if _, ok := readAuthorized(w, r, listId, crdt_go.ROLE_OWNER); !ok {
+				return
+			}
+
+			token := protocol.NewShareToken(listId, role, ttl, time.Now())
+			signed, err := protocol.SignShareToken(shareTokenSecret, token)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to sign the share token", "list_id", listId, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			jsonData, err := json.Marshal(protocol.ShareTokenResponse{Token: signed, ShareToken: token})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to marshal the share token", "list_id", listId, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonData)
+		}
+	case http.MethodDelete:
+		{
+			token, err := protocol.ParseShareToken(shareTokenSecret, query.Get("token"), time.Now())
+			if errors.Is(err, protocol.ErrExpiredShareToken) {
+				// An expired token can not be used anyway
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if err != nil || token.ListId != listId {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
+
+			list, ok := readAuthorized(w, r, listId, crdt_go.ROLE_OWNER)
+			if !ok {
+				return
+			}
+
+			list.RevokeShareToken(token.Id, token.Expires)
+			writeChanged(w, r, listId, list)
+		}
+	default:
+		{
+			protocol.WrongRequestType(w)
+		}
+	}
+}
+
+/**
+ * Returns who has access to a list, and lets its owner give and take the roles of other clients
+ */
+func handleACL(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "Received /list/acl request", "method", r.Method)
+
+	query := r.URL.Query()
+
+	listId := query.Get("list_id")
+	if listId == "" {
+		protocol.RequestWithWrongFormat(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		{
+			list, ok := readAuthorized(w, r, listId, crdt_go.ROLE_VIEWER)
+			if !ok {
+				return
+			}
+
+			jsonData, err := json.Marshal(list.GetACL())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to marshal the ACL", "list_id", listId, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonData)
+		}
+	case http.MethodPut:
+		{
+			memberId := query.Get("client_id")
+			if !protocol.ValidClientId(memberId) {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
+
+			list, ok := readAuthorized(w, r, listId, crdt_go.ROLE_OWNER)
+			if !ok {
+				return
+			}
+
+			// The change is made as the owner, unless an admin without a client id makes it
+			actor := protocol.AuthenticatedClient(r)
+			if actor == "" {
+				actor = list.GetOwner()
+			}
+			if actor == "" {
+				actor = crdt_go.BASE_ACTOR
+			}
+
+			// A retired client's changes to the list would be dropped, so it can not write it again
+			role := query.Get("role")
+			if crdt_go.RoleAllows(role, crdt_go.ROLE_EDITOR) && list.IsRetired(memberId) {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("This client id was retired from the list, it can only be a viewer."))
+				return
+			}
+
+			if !list.SetRole(memberId, role, actor) {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
+
+			writeChanged(w, r, listId, list)
+		}
+	default:
+		{
+			protocol.WrongRequestType(w)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+)
+
+/**
+ * Stores a list owned by alice, with bob as an editor
+ */
+func storeAliceList(t *testing.T, listId string) {
+	list := crdt_go.NewShoppingList()
+	list.SetOwner("alice", "alice")
+	list.SetRole("bob", crdt_go.ROLE_EDITOR, "alice")
+	list.AddOrUpdateItem("milk", 2, "alice")
+	storeTestList(t, listId, list)
+}
+
+func TestForgedClientIdIsForbidden(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+
+	change := crdt_go.NewShoppingList()
+	change.AddOrUpdateItem("eggs", 6, "alice")
+
+	cases := []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"the owner reads", clientRequest(http.MethodPost, "/list", readBody("list1"), "alice", "alice"), http.StatusOK},
+		{"the owner writes", clientRequest(http.MethodPut, "/list", writeBody(t, "list1", change), "alice", "alice"), http.StatusOK},
+		{"an editor writes", clientRequest(http.MethodPut, "/list", writeBody(t, "list1", change), "bob", "bob"), http.StatusOK},
+		// mallory's API token is bound to mallory, claiming to be alice gets nothing
+		{"a forged read", clientRequest(http.MethodPost, "/list", readBody("list1"), "alice", "mallory"), http.StatusForbidden},
+		{"a forged write", clientRequest(http.MethodPut, "/list", writeBody(t, "list1", change), "alice", "mallory"), http.StatusForbidden},
+		{"a forged delete", clientRequest(http.MethodDelete, "/list?list_id=list1", nil, "alice", "mallory"), http.StatusForbidden},
+		{"a forged share", clientRequest(http.MethodGet, "/list/acl?list_id=list1", nil, "alice", "mallory"), http.StatusForbidden},
+		// A client id nobody vouched for gives no role either
+		{"an unauthenticated read", clientRequest(http.MethodPost, "/list", readBody("list1"), "alice", ""), http.StatusForbidden},
+		{"an unauthenticated write", clientRequest(http.MethodPut, "/list", writeBody(t, "list1", change), "alice", ""), http.StatusUnauthorized},
+		{"an editor changes the ACL", clientRequest(http.MethodPut, "/list/acl?list_id=list1&client_id=bob&role=viewer", nil, "bob", "bob"), http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		handler := handleCoordenator
+		if c.req.URL.Path == "/list/acl" {
+			handler = handleACL
+		}
+
+		if recorder := serve(handler, c.req); recorder.Code != c.code {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.code, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	list, _ := database.getShoppingList("list1")
+	if list.GetOwner() != "alice" || list.GetRole("bob") != crdt_go.ROLE_EDITOR {
+		t.Error("the ACL should not have changed", list.GetACL())
+	}
+}
+
+func TestClientCannotGiveAListAway(t *testing.T) {
+	setupTestNode(t)
+
+	// mallory creates a list claiming alice owns it, to make her look responsible for it
+	list := crdt_go.NewShoppingList()
+	list.SetOwner("alice", "alice")
+	list.AddOrUpdateItem("milk", 1, "mallory")
+
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodPut, "/list", writeBody(t, "list2", list), "", "mallory")); recorder.Code != http.StatusForbidden {
+		t.Fatal("expected a list given to another client to be refused, got", recorder.Code)
+	}
+
+	// A new list is owned by the client that was authenticated, not the one it claims
+	list = crdt_go.NewShoppingList()
+	list.AddOrUpdateItem("milk", 1, "mallory")
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodPut, "/list", writeBody(t, "list2", list), "", "mallory")); recorder.Code != http.StatusOK {
+		t.Fatal("expected the list to be created, got", recorder.Code, recorder.Body.String())
+	}
+
+	stored, _ := database.getShoppingList("list2")
+	if stored.GetOwner() != "mallory" {
+		t.Error("expected mallory to own the list, got", stored.GetOwner())
+	}
+}
+
+func TestReplicaChecksOperationsNotFromTheRing(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list3")
+
+	// Without an admin token or a cluster secret, the replica still tells the ring from everybody else
+	defer func(token string) { protocol.AdminToken = token }(protocol.AdminToken)
+	protocol.AdminToken = ""
+
+	change := crdt_go.NewShoppingList()
+	change.AddOrUpdateItem("eggs", 6, "mallory")
+
+	fromPeer := func(req *http.Request, peer string) *http.Request {
+		req.Header.Set(protocol.PEER_HEADER, peer)
+		return req
+	}
+
+	cases := []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"a coordinator reads", fromPeer(clientRequest(http.MethodPost, "/operation", readBody("list3"), "", ""), protocol.LocalPeer), http.StatusOK},
+		{"a client reads", fromPeer(clientRequest(http.MethodPost, "/operation", readBody("list3"), "", "mallory"), protocol.LOAD_BALANCER_PEER), http.StatusForbidden},
+		{"a client writes", fromPeer(clientRequest(http.MethodPut, "/operation", writeBody(t, "list3", change), "", "mallory"), protocol.LOAD_BALANCER_PEER), http.StatusForbidden},
+		{"a client writes as a node", fromPeer(clientRequest(http.MethodPut, "/operation", writeBody(t, "list3", change), "", "mallory"), "127.0.0.1:9999"), http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		if recorder := serve(protocol.RequireCluster(ringPeer, handleOperation), c.req); recorder.Code != c.code {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.code, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	list, _ := database.getShoppingList("list3")
+	if hasItem(list, "eggs") {
+		t.Error("the replica should not have written the change")
+	}
+
+	// With a cluster secret only the nodes of the ring can send operations, even signed
+	defer func(secret []byte) { protocol.ClusterSecret = secret }(protocol.ClusterSecret)
+	protocol.ClusterSecret = []byte("secret")
+
+	body := writeBody(t, "list3", change)
+	req := clientRequest(http.MethodPut, "/operation", body, "", "alice")
+	protocol.SignRequest(protocol.ClusterSecret, protocol.LOAD_BALANCER_PEER, req, body, time.Now())
+	if recorder := serve(protocol.RequireCluster(ringPeer, handleOperation), req); recorder.Code != http.StatusForbidden {
+		t.Error("expected an operation from the load balancer to be refused, got", recorder.Code)
+	}
+
+	req = clientRequest(http.MethodPut, "/operation", body, "", "")
+	protocol.SignRequest(protocol.ClusterSecret, protocol.LocalPeer, req, body, time.Now())
+	if recorder := serve(protocol.RequireCluster(ringPeer, handleOperation), req); recorder.Code != http.StatusOK {
+		t.Error("expected an operation from a node of the ring to be written, got", recorder.Code, recorder.Body.String())
+	}
+	if list, _ := database.getShoppingList("list3"); !hasItem(list, "eggs") {
+		t.Error("the replica should have written the operation of the coordinator")
+	}
+}
+
+func TestRetiredClientIsRefused(t *testing.T) {
+	setupTestNode(t)
+
+	// carol was retired from alice's list, and got her role back on a replica that had not seen it yet
+	list := crdt_go.NewShoppingList()
+	list.SetOwner("alice", "alice")
+	list.SetRole("carol", crdt_go.ROLE_EDITOR, "alice")
+	list.AddOrUpdateItem("milk", 2, "alice")
+	list.Folded = map[string]uint64{"carol": 1}
+	storeTestList(t, "list4", list)
+
+	// Her own copy never saw the retirement
+	change := crdt_go.NewShoppingList()
+	change.AddOrUpdateItem("eggs", 6, "carol")
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodPut, "/list", writeBody(t, "list4", change), "carol", "carol")); recorder.Code != http.StatusConflict {
+		t.Error("expected the write of a retired client to conflict, got", recorder.Code, recorder.Body.String())
+	}
+
+	// A retired client can only be given back the role of a viewer
+	if recorder := serve(handleACL, clientRequest(http.MethodPut, "/list/acl?list_id=list4&client_id=carol&role=editor", nil, "alice", "alice")); recorder.Code != http.StatusConflict {
+		t.Error("expected a retired client not to be made an editor, got", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(handleACL, clientRequest(http.MethodPut, "/list/acl?list_id=list4&client_id=carol&role=viewer", nil, "alice", "alice")); recorder.Code != http.StatusOK {
+		t.Error("expected a retired client to be made a viewer, got", recorder.Code, recorder.Body.String())
+	}
+
+	// The other editors are not affected
+	change = crdt_go.NewShoppingList()
+	change.AddOrUpdateItem("eggs", 6, "alice")
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodPut, "/list", writeBody(t, "list4", change), "alice", "alice")); recorder.Code != http.StatusOK {
+		t.Error("expected the owner's write to go through, got", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"sdle.com/mod/protocol"
+)
+
+/**
+ * Returns the ids of every list this node stores, for the load balancer to export the cluster
+ */
+func handleListIds(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "Received /admin/lists request", "method", r.Method)
+
+	if r.Method != http.MethodGet {
+		protocol.WrongRequestType(w)
+		return
+	}
+
+	listIds := database.getListIds()
+	sort.Strings(listIds)
+
+	jsonData, err := json.Marshal(listIds)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to marshal the list ids", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
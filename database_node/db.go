@@ -1,22 +1,29 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/nobonobo/unqlitego"
 	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/tracing"
 	"sdle.com/mod/utils"
 )
 
 type DatabaseInstance struct {
 	conn *unqlitego.Database
 	lock sync.Mutex
+	// Held by every read-merge-write of a list, so concurrent writes to a list never merge against stale state
+	listLocks utils.KeyMutex
+	// Every write goes through it before it is applied
+	wal *writeAheadLog
 }
 
 func (db *DatabaseInstance) initialize(address string, port string) {
@@ -34,82 +41,104 @@ func (db *DatabaseInstance) initialize(address string, port string) {
 
 	// It means the database wasn't started
 	if startErr.Error() == "IO error" {
-		log.Panicln("Failed to create the database")
+		slog.Error("Failed to create the database", "path", dbPath)
+		panic("failed to create the database")
 	}
 
 	err2 := db.conn.Commit()
 
 	utils.CheckErr(err2)
 
-	log.Println("Database initialized at", dbPath)
+	db.wal, err = openWriteAheadLog(fmt.Sprintf("./db/wal-%s:%s.log", address, port))
+	utils.CheckErr(err)
+
+	// The writes a crash left in the log are applied before anything else is read
+	utils.CheckErr(db.wal.replay(db.applyBatches))
+	go db.wal.run(db.applyBatches)
+
+	db.migrateLegacyKeys()
+	db.checkConsistency()
+
+	slog.Info("Database initialized", "path", dbPath)
 
 }
 
-func (db *DatabaseInstance) updateOrSetShoppingList(key string, list *crdt_go.ShoppingList) bool {
-	readList, listExists := db.getShoppingList(key)
-	log.Println("List/dotContext exist?", listExists," for key ", key)
-	if listExists {
-		//print read
-		log.Println("Existed id ", readList)
-		log.Println("Incoming id : ", list)
-		// merge and store
-		//Check first if the incoming list key is lists_id_dot_contents
-		if key != "lists_id_dot_contents" {
+func (db *DatabaseInstance) updateOrSetShoppingList(ctx context.Context, key string, list *crdt_go.ShoppingList) bool {
+	ctx, span := tracing.Start(ctx, "updateOrSetShoppingList", tracing.SPAN_KIND_INTERNAL, "list_id", key)
+	defer span.End()
 
+	return db.updateShoppingList(ctx, key, func(readList *crdt_go.ShoppingList, listExists bool) (*crdt_go.ShoppingList, bool) {
+		slog.DebugContext(ctx, "Merging a list", "list_id", key, "exists", listExists)
+		if listExists {
+			// merge and store
 			readList.Merge(list)
-
+			getHistoryRetention().Apply(readList)
+			observeAndCompact(key, readList)
+		} else if list.AwSet != nil {
+			// The incoming list may still be in use by the coordinator, so it is changed on a copy
+			readList = list.Clone()
+			getHistoryRetention().Apply(readList)
+			observeAndCompact(key, readList)
+		} else {
+			readList = list
 		}
 
-		crdtBytes, err := json.Marshal(readList)
+		return readList, true
+	})
+}
 
-		if err != nil {
-			//print error
-			log.Println("Error marshalling list", err)
-			return false
-		}
+/**
+* Reads a list, changes it with update and stores the result, holding the lock of the list in between so no
+* other write to it is lost. update gets nil if the list does not exist, and returns false to leave it as it is
+ */
+func (db *DatabaseInstance) updateShoppingList(ctx context.Context, key string, update func(current *crdt_go.ShoppingList, exists bool) (*crdt_go.ShoppingList, bool)) bool {
+	unlock := db.listLocks.Lock(key)
+	defer unlock()
 
-		list_store_res := db.storeValue([]byte(key), crdtBytes)
-		dot_context_hash, err := hashOfDotContext(readList.AwSet)
-		if dot_context_hash == "" {
-			fmt.Printf("Error computing hash of AWSet context: %v", err)
-			return false
-		}
+	current, exists := db.getShoppingList(key)
 
-		if err != nil {
-			log.Printf("Error computing hash of AWSet context: %v", err)
-			return false
-		}
-		// TODO: Check error here
-		// Update the lists_id_dot_contents record with the context hash
-		list_store_context_res := db.updateOrSetListsIdDotContents(key, dot_context_hash)
-		if !list_store_context_res {
-			log.Printf("Error updating lists_id_dot_contents for key %s", key)
-		}
-		return (list_store_res && list_store_context_res) //TODO: error can be here, if list_store_context_res is not true: check this
+	updated, changed := update(current, exists)
+	if !changed {
+		return true
+	}
 
-	} else {
-		// simply store
+	crdtBytes, err := updated.MarshalBinary()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to marshal the list", "list_id", key, "err", err)
+		return false
+	}
 
-		crdtBytes, err := json.Marshal(list)
+	return db.storeList(ctx, key, crdtBytes, newListMetadata(key, updated, crdtBytes))
+}
 
-		if err != nil {
-			return false
-		}
-		list_store_res := db.storeValue([]byte(key), crdtBytes)
+/**
+* Records that this node observed the list and, if this node is the first replica of the list,
+* folds the nodes that retired from it and prunes the items removed from it. Only one replica compacts so
+* every replica folds the same nodes.
+ */
+func observeAndCompact(key string, list *crdt_go.ShoppingList) {
+	ownId := fmt.Sprintf("%s:%s", serverHostname, serverPort)
+	list.MarkSeen(ownId)
+	list.AckTombstone(ownId)
+
+	replicas := ring.GetReplicasForID(key)
+	if len(replicas) == 0 || replicas[0].Id != ownId {
+		return
+	}
 
-		dot_context_hash, err := hashOfDotContext(list.AwSet)
-		if err != nil {
-			log.Printf("Error computing hash of AWSet context: %s", err)
-			return false
-		}
-		if list.AwSet == nil {
-			return true // TODO: CHeck this
-		}
-		list_store_context_res := db.updateOrSetListsIdDotContents(key, dot_context_hash)
-		if !list_store_context_res {
-			log.Printf("Error updating lists_id_dot_contents for key %s", key)
-		}
-		return (list_store_res && list_store_context_res) //TODO: error can be here, if list_store_context_res is not true: check this
+	replicaIds := make([]string, 0, len(replicas))
+	for _, replica := range replicas {
+		replicaIds = append(replicaIds, replica.Id)
+	}
+
+	retired := list.Compact(replicaIds, actorRetirement, time.Now())
+	if len(retired) > 0 {
+		slog.Info("Folded the retired nodes of a list", "list_id", key, "retired", retired)
+	}
+
+	pruned := list.PruneRemoved(replicaIds, time.Now())
+	if len(pruned) > 0 {
+		slog.Info("Pruned the removed items of a list", "list_id", key, "items", pruned)
 	}
 }
 
@@ -118,15 +147,14 @@ func (db *DatabaseInstance) updateOrSetShoppingList(key string, list *crdt_go.Sh
  */
 func (db *DatabaseInstance) getShoppingList(key string) (*crdt_go.ShoppingList, bool) {
 
-	crdtBytes, readSuccess := db.getValue([]byte(key))
+	crdtBytes, readSuccess := db.getValue(listKey(key))
 
 	if !readSuccess {
 		return nil, false
 	}
 
-	var crdt *crdt_go.ShoppingList
-
-	err := json.Unmarshal(crdtBytes, &crdt)
+	// Lists are stored in the binary encoding, older ones may still be in JSON
+	crdt, err := crdt_go.DecodeShoppingList(crdtBytes)
 
 	if err != nil {
 		return nil, false
@@ -136,35 +164,74 @@ func (db *DatabaseInstance) getShoppingList(key string) (*crdt_go.ShoppingList,
 }
 
 /**
-* Stores a value into the database
+* Stores a list and its metadata in the same transaction, so one is never written without the other
  */
-func (db *DatabaseInstance) storeValue(key []byte, value []byte) bool {
-	log.Println("wrote list or list_ids_dot_contents", string(key))
-
-	db.lock.Lock()
-
-	err := db.conn.Store(key, value)
-
+func (db *DatabaseInstance) storeList(ctx context.Context, key string, crdtBytes []byte, metadata ListMetadata) bool {
+	metadataBytes, err := json.Marshal(metadata)
 	if err != nil {
-		db.lock.Unlock()
+		slog.ErrorContext(ctx, "Failed to marshal the metadata of the list", "list_id", key, "err", err)
 		return false
 	}
 
-	if db.conn.Commit() != nil {
-		db.lock.Unlock()
-		return false
+	slog.DebugContext(ctx, "Writing a list", "list_id", key)
+
+	return db.commit(ctx, walBatch{Puts: map[string][]byte{
+		string(listKey(key)):     crdtBytes,
+		string(metadataKey(key)): metadataBytes,
+	}})
+}
+
+/**
+* Writes a batch through the write-ahead log, returns once it is durable and applied. Its span covers the wait
+* for the other writes of its group, the sync of the log and the unqlite commit
+ */
+func (db *DatabaseInstance) commit(ctx context.Context, batch walBatch) bool {
+	_, span := tracing.Start(ctx, "unqlite commit", tracing.SPAN_KIND_INTERNAL, "puts", len(batch.Puts), "deletes", len(batch.Deletes))
+	defer span.End()
+
+	err := db.wal.commit(batch)
+	span.SetError(err)
+	return err == nil
+}
+
+/**
+* Applies batches from the write-ahead log to the storage engine, all of them in the same transaction
+ */
+func (db *DatabaseInstance) applyBatches(batches []walBatch) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, batch := range batches {
+		for key, value := range batch.Puts {
+			if err := db.conn.Store([]byte(key), value); err != nil {
+				db.conn.Rollback()
+				return err
+			}
+		}
+
+		// Deleting a key that is not there is not an error, so replaying a deletion is harmless
+		for _, key := range batch.Deletes {
+			db.conn.Delete([]byte(key))
+		}
 	}
 
-	db.lock.Unlock()
+	if err := db.conn.Commit(); err != nil {
+		db.conn.Rollback()
+		return err
+	}
 
-	return true
+	return nil
 }
 
 /**
 * Gets a value from the database
  */
 func (db *DatabaseInstance) getValue(key []byte) ([]byte, bool) {
-	log.Println("read somtething on getValue function in db.go", string(key))
+	slog.Debug("Reading a key", "key", string(key))
+
+	// A commit here would otherwise commit half of a batch being applied
+	db.lock.Lock()
+	defer db.lock.Unlock()
 
 	data, err := db.conn.Fetch(key)
 	
@@ -175,100 +242,65 @@ func (db *DatabaseInstance) getValue(key []byte) ([]byte, bool) {
 	if db.conn.Commit() != nil {
 		return []byte{}, false
 	}
-	log.Println("This is the result of get Value", string(data))
-	
 	return data, true
 }
 
 /**
-* Deletes a shopping list from the database
+* Deletes a shopping list and its metadata from the database, in the same transaction
  */
 func (db *DatabaseInstance) deleteList(key string) bool {
-	
-	success := db.deleteValue([]byte(key))
-	
-
-	return success
+	return db.deleteListIf(key, func(list *crdt_go.ShoppingList) bool { return true })
 }
 
 /**
-* Deletes a key from the database
+* Deletes a shopping list if it still satisfies shouldDelete, holding the lock of the list so no write
+* happens between the check and the deletion. Returns true if it was deleted
  */
-func (db *DatabaseInstance) deleteValue(key []byte) bool {
-	log.Println("delete list", string(key))
-	
-	if db.conn.Delete([]byte(key)) != nil {
-		return false
-	}
+func (db *DatabaseInstance) deleteListIf(key string, shouldDelete func(list *crdt_go.ShoppingList) bool) bool {
+	unlock := db.listLocks.Lock(key)
+	defer unlock()
 
-	if db.conn.Commit() != nil {
+	list, exists := db.getShoppingList(key)
+	if !exists || !shouldDelete(list) {
 		return false
 	}
-	
-	return true
-}
-
-// TODO: check if updateOrSetListsIdDotContents is used properly and works as expected
-// Logic for anti-entropy
-func (db *DatabaseInstance) updateOrSetListsIdDotContents(key string, contextHash string) bool {
-	specialKey := []byte("lists_id_dot_contents")
-
-	currentContentsBytes, readSuccess := db.getValue(specialKey)
-	var currentContents map[string]string  = make(map[string]string)
-	if !readSuccess {
-		// Print This: If the lists_id_dot_contents record doesn't exist, create it to store list_id -> context_hash mappings
-		currentContents[key] = contextHash
-		fmt.Printf("If the lists_id_dot_contents record doesn't exist, create it to store list_id -> context_hash mappings, the_list_id_dot_context is: %s", currentContents)
-
-
-
-	}else{
-		err := json.Unmarshal(currentContentsBytes, &currentContents)
-		if err != nil {
-			log.Printf("Error unmarshaling lists_id_dot_contents: %s", err)
-			return false
-		} 
-		//print the currentContents
-		currentContents[key] = contextHash
-		fmt.Printf("updateOrSetListsIdDotContents: the_list_id_dot_context is: %s", currentContents)
-	
-		
-	
-		
-	}
-
 
+	slog.Debug("Deleting a list", "list_id", key)
 
-	// Update or set the entry for the current shopping list with the context hash
-	//Print the currentContents
-	fmt.Printf("updateOrSetListsIdDotContents: the_list_id_dot_context is: %s", currentContents)
-	updatedContentsBytes, err := json.Marshal(currentContents) //TODO: check if this is correct
-	if err != nil {
-		log.Printf("Error marshaling updated lists_id_dot_contents: %s", err)
-		return false
-	}
+	return db.commit(context.Background(), walBatch{Deletes: []string{string(listKey(key)), string(metadataKey(key))}})
+}
 
-	return db.storeValue(specialKey, updatedContentsBytes)
+/**
+* Deletes a shopping list if it was not written since its metadata was read, a compare-and-delete for the
+* callers that sent the list somewhere else and must not lose the writes that came meanwhile
+ */
+func (db *DatabaseInstance) deleteListIfUnchanged(key string, read ListMetadata) bool {
+	return db.deleteListIf(key, func(list *crdt_go.ShoppingList) bool {
+		metadata, found := db.getListMetadata(key)
+		return found && metadata == read
+	})
 }
 
-func (db *DatabaseInstance) GetAllListsIdDotContents() (map[string]string, error) {
-	specialKey := []byte("lists_id_dot_contents")
+/**
+* Gets the ids of every list in the database
+ */
+func (db *DatabaseInstance) getListIds() []string {
+	listIds := make([]string, 0)
 
-	contentsBytes, readSuccess := db.getValue(specialKey)
-	if !readSuccess {
+	db.scanKeys([]byte(LIST_KEY_PREFIX), func(listId string) {
+		listIds = append(listIds, listId)
+	})
 
-		return make(map[string]string), nil
-	}
+	return listIds
+}
 
-	// Unmarshal the JSON data into a map
-	var listsIdDotContents map[string]string
-	err := json.Unmarshal(contentsBytes, &listsIdDotContents)
-	if err != nil {
-		log.Printf("Error unmarshaling lists_id_dot_contents: %s", err)
-		return nil, err
-	}
+/**
+* Deletes a key from the database
+ */
+func (db *DatabaseInstance) deleteValue(key []byte) bool {
+	slog.Debug("Deleting a key", "key", string(key))
 
-	return listsIdDotContents, nil
+	return db.commit(context.Background(), walBatch{Deletes: []string{string(key)}})
 }
 
 //Usefull functions for future work
@@ -284,17 +316,24 @@ func hashOfDotContext(awset *crdt_go.AWSet) (string, error) {
         return "", errors.New("awset.Context is nil")
     }
 
-    // Serialize the dot_context item to JSON
-    jsonData, err := json.Marshal(awset.Context)
-    if err != nil {
-        return "", err
-    }
-
-    // Compute the SHA-256 hash of the JSON string
-    hash := sha256.Sum256(jsonData)
+    // The canonical form does not depend on the order of the context items
+    hash := sha256.Sum256(awset.CanonicalContext())
 
     // Convert the hash to a hexadecimal string
     hexHash := fmt.Sprintf("%x", hash)
 
     return hexHash, nil
 }
+
+// hashOfListContext hashes everything the replicas of the list converge on (its items and their rights,
+// order, names, notes, ACL, history and tombstone), so anti-entropy finds every list that differs between
+// two replicas and not only those whose dot context does.
+func hashOfListContext(list *crdt_go.ShoppingList) (string, error) {
+	if list.AwSet == nil || list.AwSet.Context == nil {
+		return "", errors.New("awset.Context is nil")
+	}
+
+	hash := sha256.Sum256(list.CanonicalContent())
+
+	return fmt.Sprintf("%x", hash), nil
+}
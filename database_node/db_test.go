@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+)
+
+func TestConcurrentWritesAreNotLost(t *testing.T) {
+	setupTestNode(t)
+
+	const WRITE_PATHS, WRITES_PER_PATH = 4, 10
+	item := func(path int, i int) string { return fmt.Sprintf("item-%d-%d", path, i) }
+
+	// Each write is made by its own client, from the list as it was before any of them
+	base := crdt_go.NewShoppingList()
+	base.SetOwner("alice", "alice")
+	base.AddOrUpdateItem("milk", 2, "alice")
+	for i := 0; i < WRITES_PER_PATH; i++ {
+		base.SetRole(item(0, i), crdt_go.ROLE_EDITOR, "alice")
+	}
+	storeTestList(t, "list1", base)
+
+	change := func(client string) *crdt_go.ShoppingList {
+		list := base.Clone()
+		list.AddOrUpdateItem(client, 1, client)
+		return list
+	}
+
+	// Every write path changes the same list at once
+	writes := [WRITE_PATHS]func(client string) bool{
+		func(client string) bool {
+			return serve(handleCoordenator, clientRequest(http.MethodPut, "/list", writeBody(t, "list1", change(client)), client, client)).Code == http.StatusOK
+		},
+		func(client string) bool {
+			req := clientRequest(http.MethodPut, "/operation", writeBody(t, "list1", change(client)), "", "")
+			req.Header.Set(protocol.PEER_HEADER, protocol.LocalPeer)
+			return serve(protocol.RequireCluster(ringPeer, handleOperation), req).Code == http.StatusOK
+		},
+		func(client string) bool {
+			return processMergedList(context.Background(), "list1", change(client))
+		},
+		func(client string) bool {
+			return database.updateOrSetShoppingList(context.Background(), "list1", change(client))
+		},
+	}
+
+	var wait sync.WaitGroup
+	failed := make(chan string, WRITE_PATHS*WRITES_PER_PATH)
+	for path, write := range writes {
+		for i := 0; i < WRITES_PER_PATH; i++ {
+			wait.Add(1)
+			go func(client string, write func(client string) bool) {
+				defer wait.Done()
+				if !write(client) {
+					failed <- client
+				}
+			}(item(path, i), write)
+		}
+	}
+	wait.Wait()
+	close(failed)
+
+	for client := range failed {
+		t.Error("the write of", client, "failed")
+	}
+
+	list, _ := database.getShoppingList("list1")
+	for path := 0; path < WRITE_PATHS; path++ {
+		for i := 0; i < WRITES_PER_PATH; i++ {
+			if !hasItem(list, item(path, i)) {
+				t.Error("the write of", item(path, i), "was lost")
+			}
+		}
+	}
+	if !hasItem(list, "milk") {
+		t.Error("the item the list had before was lost")
+	}
+}
@@ -1,137 +1,101 @@
 package main
 
 import (
-	
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"time"
 
 	"sdle.com/mod/crdt_go"
 	hash_ring "sdle.com/mod/hash_ring"
+	"sdle.com/mod/logging"
 	"sdle.com/mod/protocol"
+	"sdle.com/mod/scheduler"
 )
 
-func gossip() {
-	for {
-		for _, value := range ring.GetNodes() {
-			go gossipWith(value)
-		}
+// How often a node gossips, with how many nodes, and how many at once
+var gossipConfig = scheduler.Config{Interval: 1 * time.Second, Jitter: 200 * time.Millisecond, Fanout: 3, Workers: 4}
+
+// How often a node runs anti-entropy. With no fanout set it picks half as many nodes as each list has replicas
+var antiEntropyConfig = scheduler.Config{Interval: 60 * time.Second, Jitter: 10 * time.Second, Fanout: 0, Workers: 2}
 
-		time.Sleep(1 * time.Second) // FIXME: This should not be like this :p
+/**
+* Reads the configuration of the gossip and anti-entropy schedulers from the environment
+ */
+func setupSchedulers() error {
+	var err error
+	if gossipConfig, err = scheduler.ConfigFromEnv("GOSSIP", gossipConfig); err != nil {
+		return err
+	}
+	antiEntropyConfig, err = scheduler.ConfigFromEnv("ANTI_ENTROPY", antiEntropyConfig)
+	return err
+}
 
+/**
+* Gossips with a few of the other nodes of the ring every round, until stop is closed, and hands off the lists
+* that belong to other nodes when the ring changed
+ */
+func gossip(stop <-chan struct{}) {
+	scheduler.New("gossip", gossipConfig, func() []*hash_ring.NodeInfo {
+		return ring.GetNodeList(protocol.LocalPeer)
+	}, gossipWith).AfterRound(func() {
 		if ring.WasUpdated() {
 			checkForHintedHandoff()
 		}
-	}
+	}).Run(stop)
 }
 
-
-//TODO: Implement a more sophisticated rule here based on your requirements.
-// antiEntropyInterval determines the sleep duration between anti-entropy gossip rounds.
-func antiEntropyInterval(n float32) time.Duration {
-	// Example: Return a fixed interval of 5 seconds
-	return time.Duration(n * float32(time.Second)) // TODO: The value for n should have a concrete rule
+/**
+* Runs anti-entropy with a few of the nodes that replicate the lists of this node every round, until stop is closed
+ */
+func gossipAntiEntropy(stop <-chan struct{}) {
+	slog.Info("Anti-entropy started")
+	scheduler.New("anti_entropy", antiEntropyConfig, antiEntropyTargets, func(node *hash_ring.NodeInfo) {
+		gossipAntiEntropyWith(node, 2)
+	}).Run(stop)
 }
 
-// Propagate antiEntropy mechanism from sender node to a numb_choosen_nodes that a node knows
-func gossipAntiEntropy(numb_choosen_nodes int32) {
-	//TODO: test the code bellow
-	fmt.Println("Anti-entropy mechanism started!")
-	for {
-		var server_host_node_id string = fmt.Sprintf("%s:%s", serverHostname, serverPort)
-		var server_host_vnodes_hash_keys = make([]string, 0)
-		
-
-		
-		// Get all vnodes hash keys from the ring of the current node
-		for index := 0; index < len(ring.GetNodes()[server_host_node_id].Vnodes); index++ { //We directly use ring on getNodes because we dont want to clone ring that have a Mutex parameter
-			var vnode_id = ring.GetNodes()[server_host_node_id].Vnodes[index]
-			var vnode_hash string = hash_ring.HashId(vnode_id)
-			server_host_vnodes_hash_keys = append(server_host_vnodes_hash_keys, vnode_hash)
-		}
+/**
+* The nodes that replicate the lists of the vnodes of this node, the ones anti-entropy is run with
+ */
+func antiEntropyTargets() []*hash_ring.NodeInfo {
+	ownNode := ring.GetNodes()[protocol.LocalPeer]
+	if ownNode == nil {
+		return nil
+	}
 
-		var allReplicationNodes [][]*hash_ring.NodeInfo
-		// print server_host_vnodes_hash_keys
-		fmt.Println("Server host vnodes hash keys on the ring:", server_host_vnodes_hash_keys)
-		
-		for _, vnodeHashKey := range server_host_vnodes_hash_keys {
-			replicationNodes := ring.GetHealthyNodesForID(vnodeHashKey)
-			if len(replicationNodes) > ring.ReplicationFactor {
-				replicationNodes = replicationNodes[:ring.ReplicationFactor]
-			}
-			allReplicationNodes = append(allReplicationNodes, replicationNodes)
+	// Deduplicated, as the same nodes replicate many vnodes
+	nodeMap := make(map[string]*hash_ring.NodeInfo)
+	for _, vnode_id := range ownNode.GetVirtualNodes() {
+		replicationNodes := ring.GetHealthyNodesForID(hash_ring.HashId(vnode_id))
+		if len(replicationNodes) > ring.ReplicationFactor {
+			replicationNodes = replicationNodes[:ring.ReplicationFactor]
 		}
-		
-		
-		fmt.Println("All replication nodes possible to do anti-entropy:", allReplicationNodes)
-
-        
-		src := rand.NewSource(time.Now().UnixNano())
-		r := rand.New(src)
-		
-		// Shuffle allReplicationNodes to randomize the selection and select a subset of nodes for anti-entropy gossip
-		r.Shuffle(min(len(allReplicationNodes),ring.ReplicationFactor), func(i, j int) {
-			allReplicationNodes[i], allReplicationNodes[j] = allReplicationNodes[j], allReplicationNodes[i]
-		})
-
-		// Flatten and deduplicate allReplicationNodes
-		nodeMap := make(map[string]*hash_ring.NodeInfo)
-		for _, nodes := range allReplicationNodes {
-			for _, node := range nodes {
+		for _, node := range replicationNodes {
+			//Dont do anti entropy with yourself crazy node!
+			if node.Id != protocol.LocalPeer {
 				nodeMap[node.Id] = node
 			}
 		}
+	}
 
-		flattenedNodes := make([]*hash_ring.NodeInfo, 0, len(nodeMap))
-		for _, node := range nodeMap {
-			flattenedNodes = append(flattenedNodes, node)
-		}
-		r.Shuffle(len(flattenedNodes), func(i, j int) {
-			flattenedNodes[i], flattenedNodes[j] = flattenedNodes[j], flattenedNodes[i]
-		})
-		//print flattenedNodes
-		fmt.Println("Flattened nodes to do anti-entropy:", flattenedNodes)
-		
-		fmt.Println("Number of nodes to do anti-entropy:", numb_choosen_nodes)
-		//print length of flattenedNodes
-		fmt.Println("Length of flattened nodes to do anti-entropy:", len(flattenedNodes))
-		// decalare selectedNodes
-		
-		selectedNodes := flattenedNodes
-		if numb_choosen_nodes < int32(len(flattenedNodes)) {
-			selectedNodes = flattenedNodes[:numb_choosen_nodes]
-		}
-		
-	
-		
-		//print value of numb_choosen_nodes
-		fmt.Println("Selected nodes to do anti-entropy:", selectedNodes)
-		
-		for _, node := range selectedNodes {
-			
-				//print going to do anti-entropy with node
-				fmt.Println("I am node port: ", serverPort," and address: ", serverHostname)
-				fmt.Println("Going to try send dot context anti-entropy with node to port:", node.Port, " and address:", node.Address)
-				//Dont do anti entropy with yourself crazy node!
-				if node.Address == serverHostname && node.Port == serverPort{
-					fmt.Println("You are not going to do dot context anti-entropy with yourself!")
-					
-					continue
-				}
-				go gossipAntiEntropyWith(node, 2) 
-			
-		}
+	targets := make([]*hash_ring.NodeInfo, 0, len(nodeMap))
+	for _, node := range nodeMap {
+		targets = append(targets, node)
+	}
 
-		// Sleep for a defined interval before the next gossipAntiEntropy round
-		time.Sleep(antiEntropyInterval(60.0)) // TODO: Check this
-		//Print one gossip round done
-		fmt.Println("One dotContext antiEntropy push-pull gossip round done!!!!!")
+	// The replication factor grows with the ring, so the default fanout follows it
+	if antiEntropyConfig.Fanout == 0 {
+		rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+		targets = targets[:min(len(targets), max(ring.ReplicationFactor/2, 1))]
+	}
 
-    }
+	slog.Debug("Picked the anti-entropy candidates", "candidates", len(nodeMap), "selected", len(targets))
+	return targets
 }
 
 func HashId(vnode_id string) {
@@ -149,20 +113,22 @@ func gossipWith(node *hash_ring.NodeInfo) {
 
 	jsonData, err := json.Marshal(gossipMaterial)
 	if err != nil {
-		log.Printf("Error happened in JSON marshal. Err: %s", err)
+		slog.Error("Failed to marshal the gossip", "err", err)
 		node.GossipLock.Unlock()
 		return
 	}
 
+	start := time.Now()
 	response, err2 := protocol.SendRequestWithData(http.MethodPost, node.Address, node.Port, "/gossip", jsonData)
 	if err2 != nil {
+		observeGossip(node, start, "unreachable")
+
 		// If cannot gossip four consecutive times then assume the node is dead
 		if node.DeadCounter < 3 {
 			node.DeadCounter++
 		} else if node.DeadCounter == 3 {
-			node.Status = hash_ring.NODE_UNRESPONSIVE
-			log.Printf("%s set to UNRESPONSIVE\n", node.Id)
-			ring.NodeStatusChanged()
+			setNodeStatus(node, hash_ring.NODE_UNRESPONSIVE)
+			slog.Warn("Node set to unresponsive", "peer", node.Id, "err", err2)
 
 			node.DeadCounter++
 		}
@@ -173,12 +139,16 @@ func gossipWith(node *hash_ring.NodeInfo) {
 
 	// Await for response to get the node's status
 	if response.StatusCode == 200 {
+		observeGossip(node, start, "ok")
+		node.SetLastGossip(time.Now())
+
 		node.DeadCounter = 0
 		if node.Status != hash_ring.NODE_OK {
-			node.Status = hash_ring.NODE_OK
-			log.Printf("%s set to OK\n", node.Id)
-			ring.NodeStatusChanged()
+			setNodeStatus(node, hash_ring.NODE_OK)
+			slog.Info("Node set to OK", "peer", node.Id)
 		}
+	} else {
+		observeGossip(node, start, "refused")
 	}
 
 	node.GossipLock.Unlock()
@@ -189,14 +159,14 @@ func gossipWith(node *hash_ring.NodeInfo) {
 //Push-pull gossip dot context ( awset ) anti-entropy mechanism: Pull side
 func gossipAntiEntropyWith(node *hash_ring.NodeInfo,  numb_tries int64) {
 	if !node.GossipLock.TryLock() { //TODO: check if this is important to check ?
-		//print this("Node is locked and ready for dot context anti-entropy ")
-		fmt.Println("Node is locked and ready for dot context anti-entropy")
+		slog.Debug("Skipping anti-entropy, the node is busy", "peer", node.Id)
 
 		return
 	}
 	
-	
-	fmt.Println("I am the sender node and i will send dot context to receiver node: for dot context pull anti-entropy")
+	// Both nodes log the round with the same id
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+	slog.DebugContext(ctx, "Sending the context hashes for anti-entropy", "peer", node.Id)
 	// Here we need to read all list_ids and dot_context for every list and save on a Map
 	read_chan_with_dot_context_chan := make(chan readChanStructForDotContext)// to receive ShoppingLists from database
 
@@ -206,55 +176,51 @@ func gossipAntiEntropyWith(node *hash_ring.NodeInfo,  numb_tries int64) {
 	
 	if read_chan_with_dot_context.Code != 1 {
 		// dot context was not found
-		fmt.Println("Ids Dot context doesnt exist on sender node, no anti-entropy action needed.")
+		slog.DebugContext(ctx, "No context hashes on this node, no anti-entropy needed")
 		node.GossipLock.Unlock()
 		return
 	}
 
 
-	fmt.Println("I have the dot context to send to receiver node on anti entropy:", read_chan_with_dot_context.Content)
 	jsonDotContext, err := json.Marshal(read_chan_with_dot_context)
 	
 	if len(read_chan_with_dot_context.Content) == 0 {
-        fmt.Println("The dot context map is empty, no data to send for anti-entropy!.")
+        slog.DebugContext(ctx, "No context hashes on this node, no anti-entropy needed")
         node.GossipLock.Unlock()
         return
     }
-	// fmt.Println("I have the json Marshall dot context to send to receiver node:", jsonDotContext)
 	if err != nil {
-		log.Printf("Error happened in JSON marshal. Err: %s", err)
+		slog.ErrorContext(ctx, "Failed to marshal the context hashes", "err", err)
 
 		node.GossipLock.Unlock()
 		return
 	}
 	// send all the list_id_dot_contents to node and wait for response
 	//TODO: 
-	response_from_pull, err := protocol.SendRequestWithData(http.MethodPost, node.Address, node.Port, "/gossip/antiEntropy/request", jsonDotContext)
+	response_from_pull, err := protocol.SendRequestWithContext(ctx, http.MethodPost, node.Address, node.Port, "/gossip/antiEntropy/request", jsonDotContext, protocol.JSON_CONTENT_TYPE, protocol.JSON_CONTENT_TYPE)
 	
 	if err != nil {
 		
-		log.Printf("Error sending request: %s", err)
+		slog.WarnContext(ctx, "Failed to send the context hashes", "peer", node.Id, "err", err)
 		return
 	}
 
 	bodyBytes, err := ioutil.ReadAll(response_from_pull.Body)
 	responseBody := string(bodyBytes)
 	if responseBody == "No differing lists" {
-		fmt.Println("No differing lists found on receiver node, no anti entropy action needed.")
+		slog.DebugContext(ctx, "No differing lists, no anti-entropy needed", "peer", node.Id)
 		node.GossipLock.Unlock()
 		return
 	}
-	fmt.Println("Response from receiver node to pull anti-entropy:", responseBody)
 	if err != nil {
 		
-		log.Printf("Error reading response body: %s", err)
+		slog.WarnContext(ctx, "Failed to read the differing lists", "peer", node.Id, "err", err)
 		return
 	}
 
 
-	fmt.Println("I have the response_from_pull.Body from receiver node on anti entropy:", responseBody)
 	if err != nil {
-        handleCommunicationError(node)
+        handleCommunicationError(ctx, node)
 		node.GossipLock.Unlock()
         return
     }
@@ -263,83 +229,80 @@ func gossipAntiEntropyWith(node *hash_ring.NodeInfo,  numb_tries int64) {
 	if response_from_pull.StatusCode == http.StatusOK  {
 		
 
-		handleSuccessfulPullPushResponse(node, response_from_pull)
-		//print sender node that anti-entropy pull-push was successful
-		fmt.Println("Function: handleSuccessfulPullPushResponse -> Anti-entropy pull-push was successful!")
+		handleSuccessfulPullPushResponse(ctx, node, response_from_pull)
     } else {
-		handleCommunicationError(node)
-		fmt.Println("Non-OK status code received:", response_from_pull.StatusCode)
+		handleCommunicationError(ctx, node)
+		slog.WarnContext(ctx, "The node refused the context hashes", "peer", node.Id, "status", response_from_pull.StatusCode)
 		node.GossipLock.Unlock()
 	}
     
-	//Print successful anti-entropy pull-push
-	fmt.Println("End of one anti-entropy pull-push mechanism go routine !!!!")
+	slog.DebugContext(ctx, "Anti-entropy with a node done", "peer", node.Id)
 	node.GossipLock.Unlock()
 	
 }
 
 
 
-func handleCommunicationError(node *hash_ring.NodeInfo) {
+func handleCommunicationError(ctx context.Context, node *hash_ring.NodeInfo) {
 
-	fmt.Printf("SendRequestData with dotContext failed to node %s\n", node.Port)
+	slog.WarnContext(ctx, "Anti-entropy with a node failed", "peer", node.Id)
 }
 
-func handleSuccessfulPullPushResponse(node *hash_ring.NodeInfo, response *http.Response) {
+func handleSuccessfulPullPushResponse(ctx context.Context, node *hash_ring.NodeInfo, response *http.Response) {
 	
 	bodyBytes, err := ioutil.ReadAll(response.Body)
 	responseBody := string(bodyBytes)
 	differing_lists := make(map[string]*crdt_go.ShoppingList)
 	success, decoded_differingLists := protocol.DecodeHTTPResponse(nil, response, differing_lists)//TODO: check this if decoded properly
 	if responseBody == "No differing lists" {
-		fmt.Println("No differing lists found on receiver node, no anti entropy action needed.")
+		slog.DebugContext(ctx, "No differing lists, no anti-entropy needed", "peer", node.Id)
 		node.GossipLock.Unlock()
 		return
 	}
 	
-	fmt.Printf("Decoded_differingLists on receiver side for anti-entropy push-pull mechanism: %s", fmt.Sprintf("%v", decoded_differingLists))
 	if !success {
-		fmt.Println("Error decoding response from anti-entropy pull request")
+		slog.WarnContext(ctx, "Failed to decode the differing lists", "peer", node.Id)
 		return
 	}
 
 	differing_lists = decoded_differingLists
-	merged_lists,err := processDifferingLists(differing_lists)
+	slog.DebugContext(ctx, "Merging the differing lists", "peer", node.Id, "lists", len(differing_lists))
+	merged_lists,err := processDifferingLists(ctx, differing_lists)
 	if err != nil {
 		// Handle the error
-		fmt.Printf("Error processing differing lists: %s\n", err)
+		slog.WarnContext(ctx, "Failed to merge the differing lists", "peer", node.Id, "err", err)
 		return
 	}
 
 	// Send the merged new shoppingLists to the receiver node that have responded
 	marshaled_merged_lists, err := json.Marshal(merged_lists)
 	if err != nil {
-		fmt.Println("Error marshaling merged lists:", err)
+		slog.ErrorContext(ctx, "Failed to marshal the merged lists", "err", err)
 		return
 	}
 	//TODO: check if i need to retun on err !=nill
 	// Finally we send the merged shoppingLists, requesting a push in the anti-entropy mechanism
-	response_from_push, err := protocol.SendRequestWithData(http.MethodPut, node.Address, node.Port, "/gossip/antiEntropy/request", marshaled_merged_lists)
+	response_from_push, err := protocol.SendRequestWithContext(ctx, http.MethodPut, node.Address, node.Port, "/gossip/antiEntropy/request", marshaled_merged_lists, protocol.JSON_CONTENT_TYPE, protocol.JSON_CONTENT_TYPE)
 	if err != nil {
-		fmt.Println("Error sending merged lists to receiver node:", err)
+		slog.WarnContext(ctx, "Failed to send the merged lists", "peer", node.Id, "err", err)
 	} else if response_from_push.StatusCode != http.StatusOK {
-		fmt.Println("Non-OK status code received from receiver node during push:", response_from_push.StatusCode)
+		slog.WarnContext(ctx, "The node refused the merged lists", "peer", node.Id, "status", response_from_push.StatusCode)
 	}
 
 	if response_from_push.StatusCode == http.StatusOK{
-		fmt.Println("AntiEntropy pushpull dot context mechanism totally successful!")
+		slog.InfoContext(ctx, "Anti-entropy reconciled lists with a node", "peer", node.Id, "lists", len(merged_lists))
 	} 
 	
 }
 
-func processDifferingLists(differing_lists map[string]*crdt_go.ShoppingList) (map[string]*crdt_go.ShoppingList, error) {
+func processDifferingLists(ctx context.Context, differing_lists map[string]*crdt_go.ShoppingList) (map[string]*crdt_go.ShoppingList, error) {
     merged_lists := make(map[string]*crdt_go.ShoppingList)
 	var err error
 
     for list_id, common_list := range differing_lists {
         readChan := make(chan readChanStruct)
         payload := map[string]string{"list_id": list_id}
-        go sendReadAndWait(serverHostname, serverPort, payload, readChan)
+        go sendReadAndWait(ctx, serverHostname, serverPort, payload, readChan)
         result_read := <-readChan
         
         switch result_read.code {
@@ -349,16 +312,14 @@ func processDifferingLists(differing_lists map[string]*crdt_go.ShoppingList) (ma
 				merged_lists[list_id] = local_list
 	
 				// Store the merged list back into the local node's database
-				if !storeMergedList(list_id, local_list) {
-					fmt.Printf("Error storing merged list with ID: %s\n", list_id)
+				if !storeMergedList(ctx, list_id, local_list) {
+					slog.ErrorContext(ctx, "Failed to store a merged list", "list_id", list_id)
 				}
 	
 			case 2: // No list was found but is supposed to exist
-				fmt.Printf("List with ID %s not found locally\n", list_id)
 				err = fmt.Errorf("list with ID %s not found locally", list_id)
 	
 			case 3: // No response or the response is invalid
-				fmt.Printf("Invalid response or error occurred when fetching list with ID %s\n", list_id)
 				err = fmt.Errorf("invalid response or error occurred when fetching list with ID %s", list_id)
 			}
 			
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"slices"
 	"strings"
 	"time"
 
+	"sdle.com/mod/logging"
 	"sdle.com/mod/protocol"
 	"sdle.com/mod/utils"
 )
@@ -21,9 +23,9 @@ func hintedHandoff() {
 	for {
 		if listsToRealocate.Size() > 0 {
 
-			// utils.Stack
-			//Print the message I am doing hinted handoff
-			log.Println("I am inside method  HINTED-OFF, listToRealocate:",  listsToRealocate,", Size of list: ",listsToRealocate.Size())
+			// The nodes the lists are handed off to log them with the same id
+			ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+			slog.InfoContext(ctx, "Handing off lists", "lists", listsToRealocate.Size())
 
 
 			//Print the lists that are going to be reallocated
@@ -35,21 +37,28 @@ func hintedHandoff() {
 
 			var waitingFor int = 0
 			waitingForMap := make(map[string]int) // How many times do we need to wait for a successful write for each list
+			sentMetadata := make(map[string]ListMetadata) // The metadata of each list when it was sent
 
 			for j := 0; j < listsToRealocate.Size(); j++ {
 				listInfo := listsToRealocate.Pop()
 
+				// Read before the list, so a write that comes in between makes them differ
+				metadata, found := database.getListMetadata(listInfo.listID)
+				if !found {
+					continue
+				}
 				shoppingList, found := database.getShoppingList(listInfo.listID)
 				if !found {
 					continue
 				}
+				sentMetadata[listInfo.listID] = metadata
 
 				for k := 0; k < len(listInfo.correctNodes); k++ {
 					parsedServerID := strings.FieldsFunc(listInfo.correctNodes[k], func(r rune) bool {
 						return r == ':'
 					})
 
-					go sendHintedHandoffWriteAndWait(parsedServerID[0], parsedServerID[1], protocol.ShoppingListOperation{ListId: listInfo.listID, Content: shoppingList}, writeChan)
+					go sendHintedHandoffWriteAndWait(ctx, parsedServerID[0], parsedServerID[1], protocol.ShoppingListOperation{ListId: listInfo.listID, Content: shoppingList}, writeChan)
 					waitingFor += 1
 				}
 				waitingForMap[listInfo.listID] = min(ring.ReplicationFactor/2+1, len(listInfo.correctNodes)) // same logic as the quorums
@@ -68,34 +77,36 @@ func hintedHandoff() {
 			}
 
 			for key, value := range waitingForMap {
-				// If the list was reallocated successfully delete from this database
+				// If the list was reallocated successfully delete from this database, unless it was written
+				// meanwhile, then it is handed off again later
 				if value <= 0 {
-					database.deleteList(key)
+					database.deleteListIfUnchanged(key, sentMetadata[key])
 				}
 			}
 		}
-		log.Println("One ITERATION OF HINTED HANDOFF FINISHED")
+		slog.Debug("Hinted handoff iteration done")
 
 		time.Sleep(10 * time.Second)
 	}
 }
 
 // Returns true if successful, false if not
-func sendHintedHandoffWriteAndWait(address string, port string, payload protocol.ShoppingListOperation, writeChan chan struct {
+func sendHintedHandoffWriteAndWait(ctx context.Context, address string, port string, payload protocol.ShoppingListOperation, writeChan chan struct {
 	string
 	bool
 }) {
 	if address == serverHostname && port == serverPort {
-		database.updateOrSetShoppingList(payload.ListId, payload.Content)
+		// The hint is only handed off if the list was stored
+		stored := database.updateOrSetShoppingList(ctx, payload.ListId, payload.Content)
 
 		writeChan <- struct {
 			string
 			bool
-		}{payload.ListId, true}
+		}{payload.ListId, stored}
 		return
 	}
 
-	response, err := sendWrite(address, port, payload)
+	response, err := sendWrite(ctx, address, port, payload)
 	if err != nil {
 		writeChan <- struct {
 			string
@@ -122,36 +133,7 @@ func checkForHintedHandoff() {
 	// check for lists in the wrong partitions
 
 	// Get lists
-	listIds := make([]string, 0)
-
-	database.lock.Lock()
-
-	cursor, err := database.conn.NewCursor()
-
-	if err != nil {
-		cursor.Close()
-		database.lock.Unlock()
-		return
-	}
-
-	cursor.First()
-
-	// iterate through every list key
-
-	for {
-		key, err := cursor.Key()
-
-		if err != nil {
-			break
-		}
-		listIds = append(listIds, string(key))
-
-		cursor.Next()
-
-	}
-
-	cursor.Close()
-	database.lock.Unlock()
+	listIds := database.getListIds()
 
 	// For every list on the database check if they are on the right place
 
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"sdle.com/mod/crdt_go"
 	"sdle.com/mod/hash_ring"
 	"sdle.com/mod/protocol"
+	"sdle.com/mod/tracing"
 	"sdle.com/mod/utils"
 )
 
+// How many operations of the history are returned when the request does not say
+const DEFAULT_HISTORY_PAGE_SIZE int = 50
+
 type readChanStruct struct {
 	code    int
 	content *crdt_go.ShoppingList
@@ -29,7 +37,8 @@ type readChanStructForDotContext struct {
 }
 
 func handleCoordenator(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received /list", r.Method, "request")
+	slog.InfoContext(r.Context(), "Received /list request", "method", r.Method)
+	ctx := quorumContext(r)
 
 	switch r.Method {
 
@@ -45,106 +54,33 @@ func handleCoordenator(w http.ResponseWriter, r *http.Request) {
 
 			var listId string = target["list_id"]
 
-			// The coordenator, upon receiving a read, reads locally and performs a read quorum
-			// however, this coordenator may not be a holder of this information, in this case
-			// it only performs the read quorum
-			healthyNodes := ring.GetHealthyNodesForID(listId)
-
-			var healthyNodesStack utils.Stack[*hash_ring.NodeInfo]
-
-			// Scrambles N first healthy replicas so a quorum can be performed for this key
-			rand.Shuffle(min(len(healthyNodes), ring.ReplicationFactor), func(i, j int) { healthyNodes[i], healthyNodes[j] = healthyNodes[j], healthyNodes[i] })
-
-			for i := 0; i < len(healthyNodes); i++ {
-				healthyNodesStack.Push(healthyNodes[i])
-			}
-
-			// Information about the success of the writes
-			readChan := make(chan readChanStruct)
-
-			var waitForRead int = 0
-
-			// Send write to nodes
-			quorumNodesNumber := min(ring.ReplicationFactor/2+1, len(healthyNodes))
-
-			for i := 0; i < quorumNodesNumber; i++ {
-				// If there aren't enough healthy nodes
-				if healthyNodesStack.Size() == 0 {
-					break
-				}
-
-				physicalNode := healthyNodesStack.Pop()
-
-				payload := map[string]string{
-					"list_id": listId,
-				}
-				log.Println("I am inside method", r.Method, "request","on Coordenator handler " ,"and I am sending a read request to ", physicalNode.Address, physicalNode.Port)
-				go sendReadAndWait(physicalNode.Address, physicalNode.Port, payload, readChan)
-				waitForRead += 1
-			}
-
-			readsContent := make([]*crdt_go.ShoppingList, 0)
-			nodesRead := make([]struct {
-				address string
-				port    string
-			}, 0)
-
-			// TODO: TIMEOUT
-			for {
-				log.Println("I am inside method", r.Method, "request","on Coordenator handler " ,"and I am waiting for a read response")
-				if waitForRead < 1 {
-					break
-				}
-				result := <-readChan
-
-				if result.code < 3 {
-					if result.code == 1 {
-						readsContent = append(readsContent, result.content)
-					}
-					nodesRead = append(nodesRead, struct {
-						address string
-						port    string
-					}{result.address, result.port})
-					waitForRead--
-				} else {
-					// if still has replicas
-					if healthyNodesStack.Size() > 0 {
-						physicalNode := healthyNodesStack.Pop()
-
-						payload := map[string]string{
-							"list_id": listId,
-						}
-
-						go sendReadAndWait(physicalNode.Address, physicalNode.Port, payload, readChan)
-					} else {
-						// Cannot write anymore so we do not wait
-						waitForRead--
-					}
-				}
-			}
-			
+			readsContent, nodesRead := readQuorum(ctx, listId)
 
 			if len(readsContent) > 0 {
 				// Merge every read
-				var finalCRDT *crdt_go.ShoppingList = readsContent[0]
+				var finalCRDT *crdt_go.ShoppingList = mergeReads(readsContent)
 
-				for i := 1; i < len(readsContent); i++ {
-					finalCRDT.Merge(readsContent[i])
+				if !authorize(w, r, listId, finalCRDT, crdt_go.ROLE_VIEWER) {
+					return
 				}
 
 				// After merging
-				w.WriteHeader(http.StatusOK)
-				w.Header().Set("Content-Type", "application/json")
-				jsonResp, err := json.Marshal(protocol.ShoppingListOperation{ListId: listId, Content: finalCRDT})
-				if err != nil {
-					log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+				if finalCRDT.IsDeleted() {
+					listDeleted(w)
+				} else {
+					err := protocol.WriteOperation(w, r, http.StatusOK, protocol.ShoppingListOperation{
+						ListId:  listId,
+						Content: finalCRDT,
+						Order:   finalCRDT.GetOrderedItems(),
+					})
+					if err != nil {
+						slog.ErrorContext(ctx, "Failed to encode the list", "list_id", listId, "err", err)
+					}
 				}
 
-				w.Write(jsonResp)
-
 				// After writing response to the user, write the final CRDT in the database
 				for i := 0; i < len(nodesRead); i++ {
-					go sendWrite(nodesRead[i].address, nodesRead[i].port, protocol.ShoppingListOperation{
+					go sendWrite(ctx, nodesRead[i].address, nodesRead[i].port, protocol.ShoppingListOperation{
 						ListId:  listId,
 						Content: finalCRDT,
 					})
@@ -168,71 +104,90 @@ func handleCoordenator(w http.ResponseWriter, r *http.Request) {
 	 */
 	case http.MethodPut:
 		{
-			var target protocol.ShoppingListOperation
-
-			decoded, target := protocol.DecodeRequestBody(w, r.Body, target)
+			decoded, target := protocol.DecodeOperationRequest(w, r)
 
 			if !decoded {
 				return
 			}
 
-			// The coordenator, upon receiving a write, writes locally and performs a quorum
-			// however, this coordenator may not be a holder of this information, in this case
-			// it only performs the quorum
-			healthyNodes := ring.GetHealthyNodesForID(target.ListId)
-
-			var healthyNodesStack utils.Stack[*hash_ring.NodeInfo]
+			if target.Content == nil {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
 
-			// Scrambles N first healthy replicas so a quorum can be performed for this key
-			rand.Shuffle(min(len(healthyNodes), ring.ReplicationFactor), func(i, j int) { healthyNodes[i], healthyNodes[j] = healthyNodes[j], healthyNodes[i] })
+			if target.Content.IsDeleted() {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Lists are deleted with DELETE /list."))
+				return
+			}
 
-			for i := 0; i < len(healthyNodes); i++ {
-				healthyNodesStack.Push(healthyNodes[i])
+			// The client changes the list as the actor it identifies itself with, not as the node id in the list
+			clientId, ok := protocol.ClientId(w, r)
+			if !ok {
+				return
 			}
 
-			// Information about the success of the writes
-			writeChan := make(chan bool)
+			// The list is read first, to know who can write it
+			readsContent, nodesRead := readQuorum(ctx, target.ListId)
+			if len(readsContent) == 0 && len(nodesRead) == 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			current := mergeReads(readsContent)
 
-			var waitForWrite int = 0
-			var wroteSuccessfully int = 0
+			if current != nil && current.IsDeleted() {
+				listDeleted(w)
+				return
+			}
 
-			// Send write to nodes
-			quorumNodesNumber := min(ring.ReplicationFactor/2+1, len(healthyNodes))
+			// A new list is owned by the client that creates it, admins (like imports) write lists as they are
+			if current == nil && target.Content.GetOwner() == "" && !protocol.IsAdmin(r) {
+				target.Content.SetOwner(clientId, clientId)
+			}
 
-			for i := 0; i < quorumNodesNumber; i++ {
-				// If there aren't enough healthy nodes
-				if healthyNodesStack.Size() == 0 {
-					break
-				}
+			if !authorizeWrite(w, r, target.ListId, current, target.Content) {
+				return
+			}
 
-				physicalNode := healthyNodesStack.Pop()
+			// The operations of a retired client would be dropped by the merge. Only clients that could no
+			// longer write the list are retired, this one got a role back concurrently with its retirement
+			if current != nil && current.IsRetired(clientId) {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("This client id was retired from the list."))
+				return
+			}
 
-				go sendWriteAndWait(physicalNode.Address, physicalNode.Port, target, writeChan)
-				waitForWrite += 1
+			// The rights of the client are those of the list as it is, not as the client last saw it
+			if current != nil {
+				target.Content.Merge(current)
 			}
 
-			// TODO: TIMEOUT
-			for {
-				if waitForWrite < 1 {
-					break
+			// A client can only hand over its own rights, and only those it still holds
+			for _, transfer := range target.Transfers {
+				if transfer.From != clientId {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("Cannot transfer the rights of another node."))
+					return
 				}
-				result := <-writeChan
-
-				if result {
-					wroteSuccessfully++
-					waitForWrite--
-				} else {
-					// if still has replicas
-					if healthyNodesStack.Size() > 0 {
-						physicalNode := healthyNodesStack.Pop()
-
-						go sendWriteAndWait(physicalNode.Address, physicalNode.Port, target, writeChan)
-					} else {
-						// Cannot write anymore so we do not wait
-						waitForWrite--
-					}
+				transferred := target.Content.ApplyTransfer(transfer)
+				if transferred < transfer.Amount {
+					w.WriteHeader(http.StatusConflict)
+					w.Write([]byte("Not enough rights to transfer."))
+					return
 				}
+				slog.InfoContext(ctx, "Transferred rights", "list_id", target.ListId, "amount", transferred, "item", transfer.ItemName, "from", transfer.From, "to", transfer.To)
 			}
+			target.Transfers = nil
+
+			// Changes the client made from states that did not see each other would spend its rights twice
+			if overspent := target.Content.Overspent(clientId, current); len(overspent) > 0 {
+				slog.WarnContext(ctx, "Refused a write that spends rights twice", "list_id", target.ListId, "client", clientId, "items", overspent)
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("Spends rights the client no longer holds: " + strings.Join(overspent, ", ")))
+				return
+			}
+
+			wroteSuccessfully := writeQuorum(ctx, target)
 
 			if wroteSuccessfully > 0 {
 				w.WriteHeader(http.StatusOK)
@@ -242,11 +197,303 @@ func handleCoordenator(w http.ResponseWriter, r *http.Request) {
 
 			return
 		}
+
+	/**
+	 * This deletes a list, leaving a tombstone in its place on every replica
+	 */
+	case http.MethodDelete:
+		{
+			listId := r.URL.Query().Get("list_id")
+			if listId == "" {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
+
+			clientId, ok := protocol.ClientId(w, r)
+			if !ok {
+				return
+			}
+
+			readsContent, nodesRead := readQuorum(ctx, listId)
+			if len(readsContent) == 0 {
+				if len(nodesRead) != 0 {
+					w.WriteHeader(http.StatusNotFound)
+				} else {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				return
+			}
+			current := mergeReads(readsContent)
+
+			if !authorize(w, r, listId, current, deleteRole(current)) {
+				return
+			}
+
+			if !current.Delete(clientId, time.Now()) {
+				listDeleted(w)
+				return
+			}
+
+			writeChanged(w, r, listId, current)
+		}
+	}
+}
+
+/**
+ * Answers that the list was deleted
+ */
+func listDeleted(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusGone)
+	w.Write([]byte("This list was deleted."))
+}
+
+// The address of a node that answered a read
+type nodeAddress struct {
+	address string
+	port    string
+}
+
+/**
+ * The context of the calls a coordinator makes to the replicas: it carries the id of the request, but is not
+ * cancelled when the client goes away, so the replicas get every write that was sent to them
+ */
+func quorumContext(r *http.Request) context.Context {
+	return context.WithoutCancel(r.Context())
+}
+
+/**
+ * Reads a list from a read quorum of its replicas, returns every list read and the nodes that answered
+ */
+func readQuorum(ctx context.Context, listId string) ([]*crdt_go.ShoppingList, []nodeAddress) {
+	ctx, span := tracing.Start(ctx, "read quorum", tracing.SPAN_KIND_INTERNAL, "list_id", listId)
+	defer span.End()
+
+	// The coordenator, upon receiving a read, reads locally and performs a read quorum
+	// however, this coordenator may not be a holder of this information, in this case
+	// it only performs the read quorum
+	healthyNodes := ring.GetHealthyNodesForID(listId)
+
+	var healthyNodesStack utils.Stack[*hash_ring.NodeInfo]
+
+	// Scrambles N first healthy replicas so a quorum can be performed for this key
+	rand.Shuffle(min(len(healthyNodes), ring.ReplicationFactor), func(i, j int) { healthyNodes[i], healthyNodes[j] = healthyNodes[j], healthyNodes[i] })
+
+	for i := 0; i < len(healthyNodes); i++ {
+		healthyNodesStack.Push(healthyNodes[i])
+	}
+
+	// Information about the success of the writes
+	readChan := make(chan readChanStruct)
+
+	var waitForRead int = 0
+
+	// Send write to nodes
+	quorumNodesNumber := min(ring.ReplicationFactor/2+1, len(healthyNodes))
+
+	for i := 0; i < quorumNodesNumber; i++ {
+		// If there aren't enough healthy nodes
+		if healthyNodesStack.Size() == 0 {
+			break
+		}
+
+		physicalNode := healthyNodesStack.Pop()
+
+		payload := map[string]string{
+			"list_id": listId,
+		}
+		slog.DebugContext(ctx, "Sending a read to a replica", "list_id", listId, "replica", physicalNode.Id)
+		go sendReadAndWait(ctx, physicalNode.Address, physicalNode.Port, payload, readChan)
+		waitForRead += 1
+	}
+
+	readsContent := make([]*crdt_go.ShoppingList, 0)
+	nodesRead := make([]nodeAddress, 0)
+
+	// TODO: TIMEOUT
+	for {
+		if waitForRead < 1 {
+			break
+		}
+		result := <-readChan
+
+		if result.code < 3 {
+			if result.code == 1 {
+				readsContent = append(readsContent, result.content)
+			}
+			nodesRead = append(nodesRead, nodeAddress{result.address, result.port})
+			waitForRead--
+		} else {
+			// if still has replicas
+			if healthyNodesStack.Size() > 0 {
+				physicalNode := healthyNodesStack.Pop()
+
+				payload := map[string]string{
+					"list_id": listId,
+				}
+
+				go sendReadAndWait(ctx, physicalNode.Address, physicalNode.Port, payload, readChan)
+			} else {
+				// Cannot write anymore so we do not wait
+				waitForRead--
+			}
+		}
+	}
+
+	observeQuorum("read", len(nodesRead))
+	span.SetAttributes("read", len(readsContent), "answered", len(nodesRead))
+	slog.DebugContext(ctx, "Read quorum done", "list_id", listId, "read", len(readsContent), "answered", len(nodesRead))
+
+	return readsContent, nodesRead
+}
+
+/**
+ * Merges every list read into the first one, returns nil if nothing was read
+ */
+func mergeReads(readsContent []*crdt_go.ShoppingList) *crdt_go.ShoppingList {
+	if len(readsContent) == 0 {
+		return nil
+	}
+
+	for i := 1; i < len(readsContent); i++ {
+		readsContent[0].Merge(readsContent[i])
+	}
+
+	return readsContent[0]
+}
+
+/**
+ * Writes a list to a write quorum of its replicas, returns how many replicas wrote it
+ */
+func writeQuorum(ctx context.Context, target protocol.ShoppingListOperation) int {
+	ctx, span := tracing.Start(ctx, "write quorum", tracing.SPAN_KIND_INTERNAL, "list_id", target.ListId)
+	defer span.End()
+
+	// The coordenator, upon receiving a write, writes locally and performs a quorum
+	// however, this coordenator may not be a holder of this information, in this case
+	// it only performs the quorum
+	healthyNodes := ring.GetHealthyNodesForID(target.ListId)
+
+	var healthyNodesStack utils.Stack[*hash_ring.NodeInfo]
+
+	// Scrambles N first healthy replicas so a quorum can be performed for this key
+	rand.Shuffle(min(len(healthyNodes), ring.ReplicationFactor), func(i, j int) { healthyNodes[i], healthyNodes[j] = healthyNodes[j], healthyNodes[i] })
+
+	for i := 0; i < len(healthyNodes); i++ {
+		healthyNodesStack.Push(healthyNodes[i])
+	}
+
+	// Information about the success of the writes
+	writeChan := make(chan bool)
+
+	var waitForWrite int = 0
+	var wroteSuccessfully int = 0
+
+	// Send write to nodes
+	quorumNodesNumber := min(ring.ReplicationFactor/2+1, len(healthyNodes))
+
+	for i := 0; i < quorumNodesNumber; i++ {
+		// If there aren't enough healthy nodes
+		if healthyNodesStack.Size() == 0 {
+			break
+		}
+
+		physicalNode := healthyNodesStack.Pop()
+
+		slog.DebugContext(ctx, "Sending a write to a replica", "list_id", target.ListId, "replica", physicalNode.Id)
+		go sendWriteAndWait(ctx, physicalNode.Address, physicalNode.Port, target, writeChan)
+		waitForWrite += 1
+	}
+
+	// TODO: TIMEOUT
+	for {
+		if waitForWrite < 1 {
+			break
+		}
+		result := <-writeChan
+
+		if result {
+			wroteSuccessfully++
+			waitForWrite--
+		} else {
+			// if still has replicas
+			if healthyNodesStack.Size() > 0 {
+				physicalNode := healthyNodesStack.Pop()
+
+				go sendWriteAndWait(ctx, physicalNode.Address, physicalNode.Port, target, writeChan)
+			} else {
+				// Cannot write anymore so we do not wait
+				waitForWrite--
+			}
+		}
+	}
+
+	observeQuorum("write", wroteSuccessfully)
+	span.SetAttributes("wrote", wroteSuccessfully)
+	slog.DebugContext(ctx, "Write quorum done", "list_id", target.ListId, "wrote", wroteSuccessfully)
+
+	return wroteSuccessfully
+}
+
+/**
+ * Pages through the history of a list, read from a read quorum like the list itself
+ */
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "Received /list/history request", "method", r.Method)
+
+	switch r.Method {
+	case http.MethodGet:
+		{
+			query := r.URL.Query()
+
+			listId := query.Get("list_id")
+			if listId == "" {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
+
+			limit := DEFAULT_HISTORY_PAGE_SIZE
+			if value := query.Get("limit"); value != "" {
+				parsed, err := strconv.Atoi(value)
+				if err != nil || parsed < 1 {
+					protocol.RequestWithWrongFormat(w)
+					return
+				}
+				limit = parsed
+			}
+
+			list, ok := readAuthorized(w, r, listId, crdt_go.ROLE_VIEWER)
+			if !ok {
+				return
+			}
+
+			history := list.GetHistory()
+
+			entries, nextCursor, err := history.Page(query.Get("cursor"), limit)
+			if err != nil {
+				protocol.RequestWithWrongFormat(w)
+				return
+			}
+
+			jsonData, err := json.Marshal(protocol.HistoryPage{ListId: listId, Entries: entries, NextCursor: nextCursor})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to marshal the history", "list_id", listId, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonData)
+		}
+	default:
+		{
+			protocol.WrongRequestType(w)
+		}
 	}
 }
 
 func handleOperation(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received /operation", r.Method, "request")
+	slog.InfoContext(r.Context(), "Received /operation request", "method", r.Method)
 	switch r.Method {
 	// The read operation
 	case http.MethodPost:
@@ -266,46 +513,48 @@ func handleOperation(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json")
+		if enforceOnReplica(r) && !authorize(w, r, target["list_id"], valueRead, crdt_go.ROLE_VIEWER) {
+			return
+		}
 
-		jsonResp, err := json.Marshal(protocol.ShoppingListOperation{ListId: target["list_id"], Content: valueRead})
+		err := protocol.WriteOperation(w, r, http.StatusOK, protocol.ShoppingListOperation{ListId: target["list_id"], Content: valueRead})
 		if err != nil {
-			log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+			slog.ErrorContext(r.Context(), "Failed to encode the list", "list_id", target["list_id"], "err", err)
 		}
-
-		w.Write(jsonResp)
 		return
 	// The write operation
 	case http.MethodPut:
-		var target protocol.ShoppingListOperation
-
-		crdtErr, target := protocol.DecodeRequestBody(w, r.Body, target)
-		
-		log.Println("I am inside method", r.Method, "request","on Operation handler " ,"and I am on write operation")
+		crdtErr, target := protocol.DecodeOperationRequest(w, r)
 
 		if !crdtErr {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Failed to unmarshall CRDT"))
 			
-			fmt.Println("Error when Decoding Request Body from write operation")
+			slog.WarnContext(r.Context(), "Failed to decode the write operation")
 			return
 		}
-		// Write the information received in this machine
-		fmt.Println("I am going to update or set a shopping list" , "id: ", target.ListId, "content: ", target.Content)
 
+		// An operation without a list has nothing to merge
+		if target.Content == nil {
+			protocol.RequestWithWrongFormat(w)
+			return
+		}
 
+		if enforceOnReplica(r) {
+			// nil if this replica does not have the list yet
+			current, _ := database.getShoppingList(target.ListId)
+			if !authorizeWrite(w, r, target.ListId, current, target.Content) {
+				return
+			}
+		}
+		// Write the information received in this machine
+		slog.DebugContext(r.Context(), "Writing a list on this replica", "list_id", target.ListId)
 
-		if(target.ListId == "lists_id_dot_contents"){
-			//Print message to the user
-			fmt.Println("We are going to update or set the lists_id_dot_contents on the database")
-			
-		}else{
-			//Print message to the user
-			fmt.Println("We are going to update or set a shopping list on the database")
+		// The coordinator only counts this replica in the write quorum if the list was stored
+		if !database.updateOrSetShoppingList(r.Context(), target.ListId, target.Content) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to store the list."))
 		}
-		
-		database.updateOrSetShoppingList(target.ListId, target.Content)
 	}
 }
 
@@ -315,7 +564,10 @@ func handleOperation(w http.ResponseWriter, r *http.Request) {
  * 2 - No list was found
  * 3 - No response or the response is invalid
  */
-func sendReadAndWait(address string, port string, payload map[string]string, readChan chan readChanStruct) {
+func sendReadAndWait(ctx context.Context, address string, port string, payload map[string]string, readChan chan readChanStruct) {
+	ctx, span := tracing.Start(ctx, "replica read", tracing.SPAN_KIND_CLIENT, "replica", protocol.PeerId(address, port))
+	defer span.End()
+
 	if address == serverHostname && port == serverPort {
 		value, got_list := database.getShoppingList(payload["list_id"])
 
@@ -330,31 +582,32 @@ func sendReadAndWait(address string, port string, payload map[string]string, rea
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error happened in JSON marshal. Err: %s \n", err)
+		slog.ErrorContext(ctx, "Failed to marshal the read", "err", err)
 		readChan <- readChanStruct{3, nil, address, port}
 		return
 	}
 
-	response, err := protocol.SendRequestWithData(http.MethodPost, address, port, "/operation", jsonData)
+	response, err := protocol.SendRequestWithContext(ctx, http.MethodPost, address, port, "/operation", jsonData, protocol.JSON_CONTENT_TYPE, protocol.BINARY_CONTENT_TYPE)
 	if err != nil {
+		slog.WarnContext(ctx, "Failed to read from a replica", "replica", protocol.PeerId(address, port), "err", err)
+		span.SetError(err)
 		readChan <- readChanStruct{3, nil, address, port}
 		return
 	}
 
+	span.SetAttributes("http.status_code", response.StatusCode)
+
 	// Successful if read succeeds
 	if response.StatusCode == http.StatusOK {
-		var target protocol.ShoppingListOperation
-		err = json.NewDecoder(response.Body).Decode(&target)
+		target, err := protocol.DecodeOperationResponse(response)
 		if err != nil {
-			fmt.Println("Error when receiving response from read request", err)
+			slog.WarnContext(ctx, "Failed to decode the read of a replica", "replica", protocol.PeerId(address, port), "err", err)
 			readChan <- readChanStruct{3, nil, address, port}
 			return
 		}
 
 		readChan <- readChanStruct{1, target.Content, address, port}
 	} else if response.StatusCode == http.StatusNotFound {
-		//Print message to the user
-		fmt.Println("List not found!!!!!")
 		readChan <- readChanStruct{2, nil, address, port}
 	} else {
 		readChan <- readChanStruct{3, nil, address, port}
@@ -363,29 +616,20 @@ func sendReadAndWait(address string, port string, payload map[string]string, rea
 
 /**
  * Returns 1, 2 or 3
- * 1 - list_id_dot_contents was found and retrieved
- * 2 - No list_id_dot_contents was found
+ * 1 - The context hashes of the lists were found and retrieved
+ * 2 - No list has a context hash
  * 3 - No response or the response is invalid
  */
  func sendReadAndWaitDotContext(address string, port string, readChan chan readChanStructForDotContext) {
 	if address == serverHostname && port == serverPort {
-        listsIdDotContents, err := database.GetAllListsIdDotContents()
+        listsIdDotContents := database.getContextHashes()
 
-        if err != nil {
-            
-            readChan <- readChanStructForDotContext{3, nil, address, port}
-			//print error
-			fmt.Println("Error when receiving response from sendReadAndWaitDotContext: ", err)
-            return
-        }
-		// No response or the response is invalid
-        if len(listsIdDotContents) == 0 || listsIdDotContents == nil {
-            // No list_id_dot_contents found
+        if len(listsIdDotContents) == 0 {
             readChan <- readChanStructForDotContext{2, nil, address, port}
             return
         }
 
-        // Successfully found and retrieved lists_id_dot_contents
+        // Successfully found and retrieved the context hashes
         readChan <- readChanStructForDotContext{1, listsIdDotContents, address, port}
         return
     }
@@ -399,19 +643,24 @@ func sendReadAndWait(address string, port string, payload map[string]string, rea
 
 
 // Returns true if successful, false if not
-func sendWriteAndWait(address string, port string, payload protocol.ShoppingListOperation, writeChan chan bool) {
-	if address == serverHostname && port == serverPort {
-		database.updateOrSetShoppingList(payload.ListId, payload.Content)
+func sendWriteAndWait(ctx context.Context, address string, port string, payload protocol.ShoppingListOperation, writeChan chan bool) {
+	ctx, span := tracing.Start(ctx, "replica write", tracing.SPAN_KIND_CLIENT, "replica", protocol.PeerId(address, port))
+	defer span.End()
 
-		writeChan <- true
+	if address == serverHostname && port == serverPort {
+		// This replica only counts in the write quorum if the list was stored
+		writeChan <- database.updateOrSetShoppingList(ctx, payload.ListId, payload.Content)
 		return
 	}
 
-	response, err := sendWrite(address, port, payload)
+	response, err := sendWrite(ctx, address, port, payload)
 	if err != nil {
+		slog.WarnContext(ctx, "Failed to write to a replica", "list_id", payload.ListId, "replica", protocol.PeerId(address, port), "err", err)
+		span.SetError(err)
 		writeChan <- false
 		return
 	}
+	span.SetAttributes("http.status_code", response.StatusCode)
 
 	// Successful if write suceeds
 	if response.StatusCode == http.StatusOK {
@@ -421,12 +670,12 @@ func sendWriteAndWait(address string, port string, payload protocol.ShoppingList
 	}
 }
 
-func sendWrite(address string, port string, payload protocol.ShoppingListOperation) (*http.Response, error) {
-	jsonData, err := json.Marshal(payload)
+func sendWrite(ctx context.Context, address string, port string, payload protocol.ShoppingListOperation) (*http.Response, error) {
+	// Replicas exchange lists in the binary encoding
+	data, err := payload.MarshalBinary()
 	if err != nil {
-		fmt.Printf("error happened in JSON marshal: %s \n", err)
-		return nil, fmt.Errorf("error happened in JSON marshal: %s", err)
+		return nil, fmt.Errorf("error happened in binary marshal: %s", err)
 	}
 
-	return protocol.SendRequestWithData(http.MethodPut, address, port, "/operation", jsonData)
+	return protocol.SendRequestWithContext(ctx, http.MethodPut, address, port, "/operation", data, protocol.BINARY_CONTENT_TYPE, protocol.BINARY_CONTENT_TYPE)
 }
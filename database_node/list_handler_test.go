@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+)
+
+func TestOperationWithoutAListIsRejected(t *testing.T) {
+	setupTestNode(t)
+
+	for _, body := range []string{`{"list_id":"list1"}`, `{"list_id":"list1","content":null}`} {
+		if recorder := serve(handleOperation, clientRequest(http.MethodPut, "/operation", []byte(body), "", "")); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", body, http.StatusBadRequest, recorder.Code)
+		}
+	}
+
+	if _, ok := database.getShoppingList("list1"); ok {
+		t.Error("nothing should have been written")
+	}
+}
+
+func TestReplicaAnswersAFailedWrite(t *testing.T) {
+	setupTestNode(t)
+	failStorage(t)
+
+	list := crdt_go.NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, "alice")
+
+	req := clientRequest(http.MethodPut, "/operation", writeBody(t, "list1", list), "", "")
+	req.Header.Set(protocol.PEER_HEADER, protocol.LocalPeer)
+	if recorder := serve(protocol.RequireCluster(ringPeer, handleOperation), req); recorder.Code != http.StatusInternalServerError {
+		t.Error("expected the coordinator to be told the list was not stored, got", recorder.Code)
+	}
+	if _, ok := database.getShoppingList("list1"); ok {
+		t.Error("the list should not have been stored")
+	}
+}
+
+func TestFailedLocalWriteIsNotAcknowledged(t *testing.T) {
+	setupTestNode(t)
+	failStorage(t)
+
+	list := crdt_go.NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, "alice")
+
+	// This node is the only replica of the list, so nothing acknowledges the write
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodPut, "/list", writeBody(t, "list1", list), "", "alice")); recorder.Code != http.StatusServiceUnavailable {
+		t.Error("expected the write to fail without a write quorum, got", recorder.Code)
+	}
+
+	written := make(chan bool, 1)
+	sendWriteAndWait(context.Background(), serverHostname, serverPort, protocol.ShoppingListOperation{ListId: "list1", Content: list}, written)
+	if <-written {
+		t.Error("expected the local replica not to acknowledge a write it did not store")
+	}
+}
+
+func TestTransferFromAnotherClientIsRefused(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+
+	transfer := func(from string, claimed string, authenticated string) int {
+		stored, _ := database.getShoppingList("list1")
+		body, err := json.Marshal(protocol.ShoppingListOperation{
+			ListId:    "list1",
+			Content:   stored.Clone(),
+			Transfers: []crdt_go.RightsTransfer{{ItemName: "milk", From: from, To: "bob", Amount: 1}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serve(handleCoordenator, clientRequest(http.MethodPut, "/list", body, claimed, authenticated)).Code
+	}
+
+	// bob can write the list, but only hand over his own rights, whatever id he claims
+	if code := transfer("alice", "", "bob"); code != http.StatusForbidden {
+		t.Error("expected a transfer of another client's rights to be refused, got", code)
+	}
+	if code := transfer("alice", "alice", "bob"); code != http.StatusForbidden {
+		t.Error("expected a transfer from a forged client id to be refused, got", code)
+	}
+	if list, _ := database.getShoppingList("list1"); list.GetItemRights("milk", "bob") != 0 {
+		t.Error("bob should not have got any rights")
+	}
+
+	if code := transfer("alice", "", "alice"); code != http.StatusOK {
+		t.Error("expected alice to hand over her rights, got", code)
+	}
+	if list, _ := database.getShoppingList("list1"); list.GetItemRights("milk", "bob") != 1 {
+		t.Error("bob should have got the rights alice handed over")
+	}
+}
+
+func TestDoubleSpendFromOneClientIsRefused(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+	base, _ := database.getShoppingList("list1")
+
+	write := func(operation protocol.ShoppingListOperation) int {
+		body, err := json.Marshal(operation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serve(handleCoordenator, clientRequest(http.MethodPut, "/list", body, "", "alice")).Code
+	}
+
+	// alice spends her 2 milk twice, in two writes made from the list before either of them
+	handOver := protocol.ShoppingListOperation{
+		ListId:    "list1",
+		Content:   base.Clone(),
+		Transfers: []crdt_go.RightsTransfer{{ItemName: "milk", From: "alice", To: "bob", Amount: 2}},
+	}
+	taken := base.Clone()
+	taken.AddOrUpdateItem("milk", -2, "alice")
+
+	if code := write(handOver); code != http.StatusOK {
+		t.Fatal("expected alice to hand over her rights, got", code)
+	}
+	if code := write(protocol.ShoppingListOperation{ListId: "list1", Content: taken}); code != http.StatusConflict {
+		t.Error("expected the second spending of the same rights to be refused, got", code)
+	}
+	if code := write(handOver); code != http.StatusConflict {
+		t.Error("expected a transfer of rights alice no longer holds to be refused, got", code)
+	}
+
+	list, _ := database.getShoppingList("list1")
+	if quantity, _ := list.GetItemQuantity("milk"); quantity != 2 || list.GetItemRights("milk", "bob") != 2 || list.GetItemRights("milk", "alice") != 0 {
+		t.Errorf("expected bob to hold the 2 milk, got %d milk and the rights of bob %d, alice %d",
+			quantity, list.GetItemRights("milk", "bob"), list.GetItemRights("milk", "alice"))
+	}
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"sync"
+	"time"
+
 	hash_ring "sdle.com/mod/hash_ring"
+	"sdle.com/mod/logging"
+	"sdle.com/mod/protocol"
+	"sdle.com/mod/tracing"
 	"sdle.com/mod/utils"
 )
 
@@ -15,6 +21,28 @@ var ring hash_ring.HashRing
 
 var database DatabaseInstance
 
+// How much of the history of each list is kept, replaced by the cluster's when joining it. It is set while
+// lists are written, so it is only read through getHistoryRetention
+var historyRetention = protocol.HistoryRetentionFromEnv()
+var historyRetentionLock sync.RWMutex
+
+func getHistoryRetention() protocol.HistoryRetention {
+	historyRetentionLock.RLock()
+	defer historyRetentionLock.RUnlock()
+	return historyRetention
+}
+
+func setHistoryRetention(retention protocol.HistoryRetention) {
+	historyRetentionLock.Lock()
+	defer historyRetentionLock.Unlock()
+	historyRetention = retention
+}
+
+// How long a client must be inactive on a list before its counters are folded, from ACTOR_RETIREMENT (in seconds)
+var actorRetirement = utils.SecondsFromEnv("ACTOR_RETIREMENT", DEFAULT_ACTOR_RETIREMENT)
+
+const DEFAULT_ACTOR_RETIREMENT = 7 * 24 * time.Hour
+
 
 
 func main() {
@@ -39,8 +67,34 @@ func main() {
 		os.Exit(1)
 	}
 
+	protocol.LocalPeer = protocol.PeerId(serverHostname, serverPort)
+	if err := logging.Setup("node", "node", protocol.LocalPeer); err != nil {
+		fmt.Println("Failed to set up logging:", err)
+		os.Exit(1)
+	}
+	if err := tracing.Setup("node", protocol.LocalPeer); err != nil {
+		fmt.Println("Failed to set up tracing:", err)
+		os.Exit(1)
+	}
+	if err := setupSchedulers(); err != nil {
+		fmt.Println("Failed to set up gossip:", err)
+		os.Exit(1)
+	}
+	if err := protocol.SetupTLS(); err != nil {
+		fmt.Println("Failed to set up TLS:", err)
+		os.Exit(1)
+	}
+	if err := protocol.CheckClusterSecret(); err != nil {
+		fmt.Println("Refusing to start:", err)
+		os.Exit(1)
+	}
+
 	registerRoutes()
-	log.Printf("Node starting... %s:%s", serverHostname, serverPort)
+	slog.Info("Node starting", "address", serverHostname, "port", serverPort)
+
+	if len(protocol.ClusterSecret) == 0 {
+		slog.Warn("CLUSTER_SECRET is not set and INSECURE_CLUSTER is, requests between the machines of the cluster are not signed and the peer and client they claim are trusted")
+	}
 
 	ring.Initialize()
 	database.initialize(serverHostname, serverPort)
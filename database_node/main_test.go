@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"sdle.com/mod/crdt_go"
+	hash_ring "sdle.com/mod/hash_ring"
+	"sdle.com/mod/protocol"
+)
+
+/**
+ * Runs the node alone in its ring, on a database in a temporary directory, so every quorum is this node
+ */
+func setupTestNode(t *testing.T) {
+	// The database is opened in ./db
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+
+	serverHostname, serverPort = "127.0.0.1", "9001"
+	protocol.LocalPeer = protocol.PeerId(serverHostname, serverPort)
+
+	ring = hash_ring.HashRing{}
+	ring.Initialize()
+	ring.AddNode(serverHostname, serverPort, true)
+
+	database = DatabaseInstance{}
+	database.initialize(serverHostname, serverPort)
+
+	t.Cleanup(func() {
+		close(database.wal.requests)
+		database.wal.file.Close()
+		database.conn.Close()
+	})
+}
+
+/**
+ * Makes every write to the database of the node fail from now on, as a full or broken disk would
+ */
+func failStorage(t *testing.T) {
+	t.Helper()
+
+	if err := database.wal.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/**
+ * A request to the node for the given client, as the load balancer forwards it: claimed is the client id the
+ * client sent, authenticated the one its API token is bound to
+ */
+func clientRequest(method string, target string, body []byte, claimed string, authenticated string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+	if claimed != "" {
+		req.Header.Set(protocol.CLIENT_ID_HEADER, claimed)
+	}
+	if authenticated != "" {
+		req.Header.Set(protocol.AUTHENTICATED_CLIENT_HEADER, authenticated)
+	}
+	return req
+}
+
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
+
+/**
+ * The body of a write of a list through the coordinator
+ */
+func writeBody(t *testing.T, listId string, list *crdt_go.ShoppingList) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(protocol.ShoppingListOperation{ListId: listId, Content: list})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+/**
+ * The body of a read of a list through the coordinator
+ */
+func readBody(listId string) []byte {
+	body, _ := json.Marshal(map[string]string{"list_id": listId})
+	return body
+}
+
+/**
+ * Stores a list on the node as it is, without going through the coordinator
+ */
+func storeTestList(t *testing.T, listId string, list *crdt_go.ShoppingList) {
+	t.Helper()
+
+	if !database.updateOrSetShoppingList(context.Background(), listId, list) {
+		t.Fatal("failed to store the list", listId)
+	}
+}
+
+func hasItem(list *crdt_go.ShoppingList, itemName string) bool {
+	_, ok := list.GetItemQuantity(itemName)
+	return ok
+}
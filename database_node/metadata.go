@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+)
+
+// The database is split in namespaces by the prefix of its keys, so the id of a list never collides with
+// the keys the node keeps for itself
+const (
+	// A list is stored under LIST_KEY_PREFIX + its id
+	LIST_KEY_PREFIX string = "list/"
+	// The metadata of a list is stored under METADATA_KEY_PREFIX + its id
+	METADATA_KEY_PREFIX string = "meta/"
+
+	// The key older nodes kept every context hash in, and stored lists under their bare id
+	LEGACY_INDEX_KEY string = "lists_id_dot_contents"
+)
+
+// ListMetadata is what the node knows about a list without reading it, written together with the list
+type ListMetadata struct {
+	ContextHash  string `json:"context_hash"`  // The hash anti-entropy compares, empty if it could not be computed
+	Size         int    `json:"size"`          // The size of the stored list, in bytes
+	LastModified int64  `json:"last_modified"` // When the list was last written, in Unix milliseconds
+}
+
+func listKey(listId string) []byte {
+	return []byte(LIST_KEY_PREFIX + listId)
+}
+
+func metadataKey(listId string) []byte {
+	return []byte(METADATA_KEY_PREFIX + listId)
+}
+
+/**
+* Builds the metadata of a list about to be stored with the given encoding
+ */
+func newListMetadata(key string, list *crdt_go.ShoppingList, crdtBytes []byte) ListMetadata {
+	contextHash, err := hashOfListContext(list)
+	if err != nil {
+		slog.Error("Failed to hash the context of the list", "list_id", key, "err", err)
+	}
+
+	return ListMetadata{
+		ContextHash:  contextHash,
+		Size:         len(crdtBytes),
+		LastModified: time.Now().UnixMilli(),
+	}
+}
+
+/**
+* Gets the metadata of a list from the database
+ */
+func (db *DatabaseInstance) getListMetadata(key string) (ListMetadata, bool) {
+	var metadata ListMetadata
+
+	data, readSuccess := db.getValue(metadataKey(key))
+	if !readSuccess {
+		return metadata, false
+	}
+
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		slog.Error("Failed to unmarshal the metadata of the list", "list_id", key, "err", err)
+		return metadata, false
+	}
+
+	return metadata, true
+}
+
+/**
+* Gets the metadata of every list in the database, by list id
+ */
+func (db *DatabaseInstance) getAllListMetadata() map[string]ListMetadata {
+	allMetadata := make(map[string]ListMetadata)
+
+	db.scanPrefix([]byte(METADATA_KEY_PREFIX), func(listId string, value []byte) {
+		var metadata ListMetadata
+		if err := json.Unmarshal(value, &metadata); err != nil {
+			slog.Error("Failed to unmarshal the metadata of the list", "list_id", listId, "err", err)
+			return
+		}
+		allMetadata[listId] = metadata
+	})
+
+	return allMetadata
+}
+
+/**
+* Gets the context hash of every list in the database, by list id, for anti-entropy
+ */
+func (db *DatabaseInstance) getContextHashes() map[string]string {
+	contextHashes := make(map[string]string)
+
+	for listId, metadata := range db.getAllListMetadata() {
+		if metadata.ContextHash != "" {
+			contextHashes[listId] = metadata.ContextHash
+		}
+	}
+
+	return contextHashes
+}
+
+/**
+* Calls visit with the rest of the key and the value of every key with the given prefix
+ */
+func (db *DatabaseInstance) scanPrefix(prefix []byte, visit func(rest string, value []byte)) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	cursor, err := db.conn.NewCursor()
+	if err != nil {
+		return
+	}
+	defer cursor.Close()
+
+	// The storage engine keeps its keys unordered, so every key is visited
+	for cursor.First(); cursor.IsValid(); cursor.Next() {
+		key, err := cursor.Key()
+		if err != nil {
+			break
+		}
+
+		if !bytes.HasPrefix(key, prefix) {
+			continue
+		}
+
+		value, err := cursor.Value()
+		if err != nil {
+			continue
+		}
+
+		visit(string(key[len(prefix):]), value)
+	}
+}
+
+/**
+* Calls visit with the rest of every key with the given prefix, without reading the values
+ */
+func (db *DatabaseInstance) scanKeys(prefix []byte, visit func(rest string)) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	cursor, err := db.conn.NewCursor()
+	if err != nil {
+		return
+	}
+	defer cursor.Close()
+
+	for cursor.First(); cursor.IsValid(); cursor.Next() {
+		key, err := cursor.Key()
+		if err != nil {
+			break
+		}
+
+		if rest, ok := bytes.CutPrefix(key, prefix); ok {
+			visit(string(rest))
+		}
+	}
+}
+
+/**
+* Moves the lists older nodes stored under their bare id into the list namespace, building their metadata,
+* and drops the index those nodes kept
+ */
+func (db *DatabaseInstance) migrateLegacyKeys() {
+	legacy := make(map[string][]byte)
+
+	db.lock.Lock()
+	cursor, err := db.conn.NewCursor()
+	if err == nil {
+		for cursor.First(); cursor.IsValid(); cursor.Next() {
+			key, err := cursor.Key()
+			if err != nil {
+				break
+			}
+
+			if bytes.HasPrefix(key, []byte(LIST_KEY_PREFIX)) || bytes.HasPrefix(key, []byte(METADATA_KEY_PREFIX)) {
+				continue
+			}
+
+			value, err := cursor.Value()
+			if err != nil {
+				continue
+			}
+			legacy[string(key)] = value
+		}
+		cursor.Close()
+	}
+	db.lock.Unlock()
+
+	for key, value := range legacy {
+		if key != LEGACY_INDEX_KEY {
+			list, err := crdt_go.DecodeShoppingList(value)
+			if err != nil {
+				slog.Warn("Leaving a legacy key, it is not a list", "key", key)
+				continue
+			}
+
+			if !db.storeList(context.Background(), key, value, newListMetadata(key, list, value)) {
+				slog.Error("Failed to move a legacy list", "list_id", key)
+				continue
+			}
+		}
+
+		if !db.deleteValue([]byte(key)) {
+			slog.Error("Failed to delete a legacy key", "key", key)
+			continue
+		}
+		slog.Info("Migrated a legacy key", "key", key)
+	}
+}
+
+/**
+* Reconciles the lists with their metadata: the lists without metadata, or whose metadata does not match
+* them, get it rebuilt, and the metadata of lists that are not stored anymore is removed
+ */
+func (db *DatabaseInstance) checkConsistency() {
+	allMetadata := db.getAllListMetadata()
+	rebuilt, removed := 0, 0
+
+	for _, listId := range db.getListIds() {
+		metadata, hasMetadata := allMetadata[listId]
+		delete(allMetadata, listId)
+
+		if db.rebuildListMetadata(listId, metadata, hasMetadata) {
+			rebuilt++
+		}
+	}
+
+	// What is left is the metadata of lists that are gone
+	for listId := range allMetadata {
+		if db.deleteValue(metadataKey(listId)) {
+			removed++
+		}
+	}
+
+	slog.Info("Checked the lists against their metadata", "rebuilt", rebuilt, "removed", removed)
+}
+
+/**
+* Rebuilds the metadata of a list if it does not match the list, returns true if it was rebuilt
+ */
+func (db *DatabaseInstance) rebuildListMetadata(listId string, metadata ListMetadata, hasMetadata bool) bool {
+	unlock := db.listLocks.Lock(listId)
+	defer unlock()
+
+	crdtBytes, found := db.getValue(listKey(listId))
+	if !found {
+		return false
+	}
+	list, err := crdt_go.DecodeShoppingList(crdtBytes)
+	if err != nil {
+		slog.Warn("Could not check a list, it can not be decoded", "list_id", listId, "err", err)
+		return false
+	}
+
+	expected := newListMetadata(listId, list, crdtBytes)
+	if hasMetadata && metadata.ContextHash == expected.ContextHash && metadata.Size == expected.Size {
+		return false
+	}
+
+	if hasMetadata {
+		// When the list was last written is still known, only the rest is stale
+		expected.LastModified = metadata.LastModified
+	}
+	return db.storeList(context.Background(), listId, crdtBytes, expected)
+}
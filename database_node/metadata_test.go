@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+)
+
+/**
+ * Writes a key of the database as it is, outside of the namespaces
+ */
+func storeRawValue(t *testing.T, key string, value []byte) {
+	t.Helper()
+
+	if !database.commit(context.Background(), walBatch{Puts: map[string][]byte{key: value}}) {
+		t.Fatal("failed to store the key", key)
+	}
+}
+
+func TestListIsStoredWithItsMetadata(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+
+	crdtBytes, ok := database.getValue(listKey("list1"))
+	if !ok {
+		t.Fatal("the list should be stored under its key in the list namespace")
+	}
+	list, _ := database.getShoppingList("list1")
+	contextHash, err := hashOfListContext(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, ok := database.getListMetadata("list1")
+	if !ok {
+		t.Fatal("the metadata should be stored with the list")
+	}
+	if metadata.Size != len(crdtBytes) || metadata.ContextHash != contextHash || metadata.LastModified == 0 {
+		t.Errorf("the metadata %+v does not match the list", metadata)
+	}
+
+	// Deleting the list deletes its metadata with it
+	if !database.deleteList("list1") {
+		t.Fatal("failed to delete the list")
+	}
+	if _, ok := database.getListMetadata("list1"); ok {
+		t.Error("the metadata should have been deleted with the list")
+	}
+}
+
+func TestListIdsDoNotCollideWithInternalKeys(t *testing.T) {
+	setupTestNode(t)
+
+	// Ids that are, or look like, the keys the node keeps for itself
+	listIds := []string{LEGACY_INDEX_KEY, METADATA_KEY_PREFIX + "list1", "list1"}
+	for i, listId := range listIds {
+		list := crdt_go.NewShoppingList()
+		list.AddOrUpdateItem("milk", i+1, "alice")
+
+		if recorder := serve(handleCoordenator, clientRequest(http.MethodPut, "/list", writeBody(t, listId, list), "", "alice")); recorder.Code != http.StatusOK {
+			t.Fatalf("%s: expected the list to be written, got %d: %s", listId, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	for i, listId := range listIds {
+		list, ok := database.getShoppingList(listId)
+		if !ok {
+			t.Errorf("%s: the list should be stored", listId)
+			continue
+		}
+		if quantity, _ := list.GetItemQuantity("milk"); int(quantity) != i+1 {
+			t.Errorf("%s: expected %d milk, got %d", listId, i+1, quantity)
+		}
+		if _, ok := database.getListMetadata(listId); !ok {
+			t.Errorf("%s: the list should have its metadata", listId)
+		}
+	}
+
+	stored := database.getListIds()
+	sort.Strings(stored)
+	expected := append([]string{}, listIds...)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("expected the lists %v, got %v", expected, stored)
+	}
+
+	// A replica reads them through the operations like any other list
+	req := clientRequest(http.MethodPost, "/operation", readBody(LEGACY_INDEX_KEY), "", "")
+	req.Header.Set(protocol.PEER_HEADER, protocol.LocalPeer)
+	if recorder := serve(protocol.RequireCluster(ringPeer, handleOperation), req); recorder.Code != http.StatusOK {
+		t.Error("expected the list to be read by a replica, got", recorder.Code)
+	}
+}
+
+func TestLegacyKeysAreMigrated(t *testing.T) {
+	setupTestNode(t)
+
+	list := crdt_go.NewShoppingList()
+	list.AddOrUpdateItem("milk", 2, "alice")
+	crdtBytes, err := list.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _ := json.Marshal(map[string]string{"old": "hash"})
+
+	// As an older node left them: the list under its bare id, next to the index of every context hash
+	storeRawValue(t, "old", crdtBytes)
+	storeRawValue(t, LEGACY_INDEX_KEY, index)
+
+	database.migrateLegacyKeys()
+
+	if _, ok := database.getValue([]byte("old")); ok {
+		t.Error("the list should not be under its bare id anymore")
+	}
+	if _, ok := database.getValue([]byte(LEGACY_INDEX_KEY)); ok {
+		t.Error("the legacy index should have been dropped")
+	}
+	if migrated, ok := database.getShoppingList("old"); !ok || !hasItem(migrated, "milk") {
+		t.Error("the list should have been moved to the list namespace")
+	}
+	if metadata, ok := database.getListMetadata("old"); !ok || metadata.Size != len(crdtBytes) {
+		t.Error("the moved list should have its metadata, got", metadata)
+	}
+}
+
+func TestMetadataIsReconciledWithTheLists(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+	storeAliceList(t, "list2")
+
+	// list1 lost its metadata, list2 has a stale one and list3 is gone but left its metadata behind
+	if !database.deleteValue(metadataKey("list1")) {
+		t.Fatal("failed to delete the metadata")
+	}
+	stale, _ := json.Marshal(ListMetadata{ContextHash: "stale", Size: 1, LastModified: 42})
+	storeRawValue(t, string(metadataKey("list2")), stale)
+	storeRawValue(t, string(metadataKey("list3")), stale)
+
+	database.checkConsistency()
+
+	for _, listId := range []string{"list1", "list2"} {
+		crdtBytes, _ := database.getValue(listKey(listId))
+		metadata, ok := database.getListMetadata(listId)
+		if !ok || metadata.Size != len(crdtBytes) || metadata.ContextHash == "stale" {
+			t.Errorf("%s: expected the metadata to be rebuilt, got %+v", listId, metadata)
+		}
+	}
+	if metadata, _ := database.getListMetadata("list2"); metadata.LastModified != 42 {
+		t.Error("a rebuilt metadata should keep when the list was last written, got", metadata.LastModified)
+	}
+	if _, ok := database.getListMetadata("list3"); ok {
+		t.Error("the metadata of a list that is gone should have been removed")
+	}
+}
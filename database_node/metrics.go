@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/metrics"
+)
+
+// The outcomes of a quorum: every replica of the quorum answered, only some did, or none did
+const (
+	QUORUM_REACHED string = "reached"
+	QUORUM_PARTIAL string = "partial"
+	QUORUM_FAILED  string = "failed"
+)
+
+// The quorums this node coordinated, by operation (read or write) and outcome
+var quorumOutcomes = metrics.NewCounterVec("quorum_total", "Quorums coordinated by this node, by operation and outcome.", "operation", "outcome")
+
+// How long a gossip round with a node took, and how it ended
+var gossipRoundTrip = metrics.NewHistogramVec("gossip_round_trip_seconds", "How long a gossip round with a node took, in seconds.", metrics.DefaultBuckets, "node")
+var gossipRounds = metrics.NewCounterVec("gossip_rounds_total", "Gossip rounds with a node, by outcome.", "node", "outcome")
+
+// The nodes this node saw change status
+var nodeStatusTransitions = metrics.NewCounterVec("node_status_transitions_total", "Status changes of the nodes of the ring, as seen by this node.", "node", "from", "to")
+
+// The anti-entropy rounds other nodes started with this node, and the lists that differed in them
+var antiEntropyRounds = metrics.NewCounterVec("anti_entropy_rounds_total", "Anti-entropy rounds answered by this node, by outcome.", "outcome")
+var antiEntropyDiffs = metrics.NewCounterVec("anti_entropy_differing_lists_total", "Lists that differed from the other node in the anti-entropy rounds.")
+
+/**
+* Registers the metrics read when they are served
+ */
+func registerMetrics() {
+	metrics.NewGaugeFunc("hinted_handoff_backlog", "Lists waiting to be handed off to the nodes they belong to.", func() float64 {
+		return float64(listsToRealocate.Size())
+	})
+	metrics.NewGaugeFunc("stored_lists", "Lists stored on this node.", func() float64 {
+		return float64(len(database.getAllListMetadata()))
+	})
+	metrics.NewGaugeFunc("stored_lists_bytes", "Size of the lists stored on this node, in bytes.", func() float64 {
+		size := 0
+		for _, metadata := range database.getAllListMetadata() {
+			size += metadata.Size
+		}
+		return float64(size)
+	})
+}
+
+/**
+* Counts a quorum of the given operation in which answered replicas answered
+ */
+func observeQuorum(operation string, answered int) {
+	switch {
+	case answered >= ring.ReplicationFactor/2+1:
+		quorumOutcomes.Inc(operation, QUORUM_REACHED)
+	case answered > 0:
+		quorumOutcomes.Inc(operation, QUORUM_PARTIAL)
+	default:
+		quorumOutcomes.Inc(operation, QUORUM_FAILED)
+	}
+}
+
+/**
+* Counts a gossip round with a node that started at start
+ */
+func observeGossip(node *hash_ring.NodeInfo, start time.Time, outcome string) {
+	gossipRoundTrip.Observe(time.Since(start).Seconds(), node.Id)
+	gossipRounds.Inc(node.Id, outcome)
+}
+
+/**
+* Changes the status of a node of the ring, counting the change
+ */
+func setNodeStatus(node *hash_ring.NodeInfo, status hash_ring.NodeStatus) {
+	nodeStatusTransitions.Inc(node.Id, node.Status.String(), status.String())
+	node.Status = status
+	ring.NodeStatusChanged()
+}
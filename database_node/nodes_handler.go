@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
-	"fmt"
+	"sync/atomic"
+	"time"
 
 	"sdle.com/mod/crdt_go"
 	"sdle.com/mod/protocol"
@@ -17,12 +19,49 @@ func getPing(w http.ResponseWriter, r *http.Request) {
 	resp["message"] = "pong"
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
-		log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+		slog.ErrorContext(r.Context(), "Failed to marshal the pong", "err", err)
+		return
 	}
 
 	w.Write(jsonResp)
 }
 
+// When this node was last gossiped to, in Unix nanoseconds
+var lastGossipReceived atomic.Int64
+
+/**
+ * Answers with the state of this node the health checker shows: its hinted-handoff backlog, the lists it stores
+ * and when it was last gossiped to. It tells nothing about the lists themselves, but counting them goes through
+ * the metadata of every list, so only the health checker and the cluster can ask for it
+ */
+func getStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		protocol.WrongRequestType(w)
+		return
+	}
+
+	status := protocol.NodeHealth{Id: protocol.LocalPeer, HintedHandoffBacklog: listsToRealocate.Size()}
+	for _, metadata := range database.getAllListMetadata() {
+		status.StoredLists++
+		status.StoredListsBytes += metadata.Size
+	}
+	if at := lastGossipReceived.Load(); at != 0 {
+		lastGossip := time.Unix(0, at)
+		status.LastGossipReceived = &lastGossip
+	}
+
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to marshal the status", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
 func nodeAdd(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	/**
@@ -55,7 +94,9 @@ func nodeAdd(w http.ResponseWriter, r *http.Request) {
 			jsonData, err := json.Marshal(nodesData)
 
 			if err != nil {
-				log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+				slog.ErrorContext(r.Context(), "Failed to marshal the nodes of the ring", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
 			}
 
 			w.Header().Set("Content-Type", "application/json")
@@ -83,7 +124,13 @@ func handleGossip(w http.ResponseWriter, r *http.Request) {
 		if !decoded {
 			return
 		}
-		
+		lastGossipReceived.Store(time.Now().UnixNano())
+
+		// The load balancer gossips the whole ring, so nodes learn about the ones that joined through another node.
+		// The peer is only trusted when the request was signed
+		if len(protocol.ClusterSecret) > 0 && r.Header.Get(protocol.PEER_HEADER) == protocol.LOAD_BALANCER_PEER {
+			ring.CheckForNewNodes(target["nodes"], serverHostname, serverPort)
+		}
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("This node is operating normally"))
@@ -101,9 +148,6 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 	 */
 	case http.MethodPost:
 	{	
-		//Print pull/Post method request received from sender node with dot context for anti-entropy push-pull mechanism
-		fmt.Println("pull/Post method request received from sender node with dot context for anti-entropy push-pull mechanism")
-
 		var incomingListIdDotContents readChanStructForDotContext
 		
 		decoded, incomingListIdDotContents := protocol.DecodeRequestBody(w, r.Body, incomingListIdDotContents)
@@ -111,8 +155,7 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 			
 			return
 		}
-		//Print the incomingListIdDotContents that comes in json format
-		fmt.Println("incomingListIdDotContents received for anti-entropy push-pull mechanism: ", incomingListIdDotContents.Content)
+		slog.DebugContext(r.Context(), "Received context hashes for anti-entropy", "lists", len(incomingListIdDotContents.Content))
 
 		
 		//TODO: check if here we can use/have access serverPort and serverHostname
@@ -120,18 +163,12 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 		localListIdDotContentsChan := make(chan readChanStructForDotContext)
 
 		// Call the function with the channel
-		// Print receiver node have serverPort and serverHostname
-		fmt.Println("serverPort:  of receiver node: ",serverPort)
-
 		go sendReadAndWaitDotContext(serverHostname, serverPort, localListIdDotContentsChan)
 		
 		localListIdDotContents := <-localListIdDotContentsChan
-		//print localListIdDotContents
-		fmt.Println("localListIdDotContents received for anti-entropy push-pull mechanism: ", localListIdDotContents.Content)
 		if localListIdDotContents.Code > 1 {
 			//TODO: check if this is the best approach !
-			//write message to log
-			log.Printf("Dont sender node dont have localListIdDotContents: %s",fmt.Sprintf("%v",localListIdDotContents))
+			slog.DebugContext(r.Context(), "No context hashes on this node to compare", "code", localListIdDotContents.Code)
 			
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -142,8 +179,7 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 		for listId, incomingHash := range incomingListIdDotContents.Content {
 			localHash, exists := localListIdDotContents.Content[listId]
 			if exists && localHash != incomingHash {
-				//Print the listId -> hash(dot context) exists and is different from hash of the sender node
-				fmt.Printf("listId %s exists and have different hash(dot context) comparing to the sender node",listId)
+				slog.DebugContext(r.Context(), "A list differs from the sender", "list_id", listId)
 				//TODO: check if here we can use/have access to serverPort and serverHostname
 				payload := map[string]string{
 					"list_id": listId,
@@ -151,7 +187,7 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 
 				shopping_list_chan := make(chan readChanStruct)
 				// Here we get the local Shopping_list with listId
-				go sendReadAndWait(serverHostname, serverPort, payload, shopping_list_chan)
+				go sendReadAndWait(r.Context(), serverHostname, serverPort, payload, shopping_list_chan)
 				shopping_list := <-shopping_list_chan
 				if shopping_list.code < 2 {
 					differingLists[listId] = shopping_list.content
@@ -161,21 +197,16 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 					return
 				}
 			}else if !exists {
-				//Print the shopping_list that comes in json format
-				fmt.Printf("shopping_id %s received for anti-entropy push-pull mechanism, that node doenst have and will check if this list fits on any of his virtual nodes : ",listId)
 				//TODO: check if this is the best approach !
 				// Hinted off solves this problem!!!
 				
 				continue
 			}
 		}
-		//Print the differingLists
-		fmt.Printf("differingLists from receiver for response for anti entropy: %s", fmt.Sprintf("%v", differingLists))
 		// if differingLists is empty, return
 		if len(differingLists) == 0 {
+			antiEntropyRounds.Inc("in_sync")
 			w.WriteHeader(http.StatusOK)
-			// print after sending dot context to receiver node, no lists exists with different hash(dot context)
-			fmt.Println("after sending dot context to receiver node, no common lists exists with different hash(dot context)!!!")
 			w.Write([]byte("No differing lists"))
 			return
 		}
@@ -186,6 +217,10 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 			w.Write([]byte("Error marshaling differing lists"))
 			return
 		}
+		antiEntropyRounds.Inc("diverged")
+		antiEntropyDiffs.Add(float64(len(differingLists)))
+		slog.InfoContext(r.Context(), "Lists differ from the sender", "lists", len(differingLists))
+
 		//Push moment to the sender node in the antiEntropy mechanism
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(differingListsMarshaled)
@@ -208,7 +243,7 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 			all_success := true
 
 			for list_id, inc_merged_list := range incoming_merged_lists {
-				if !processMergedList(list_id, inc_merged_list) {
+				if !processMergedList(r.Context(), list_id, inc_merged_list) {
 					all_success = false
 				}
 			}
@@ -229,34 +264,34 @@ func handleGossipPushPullAntiEntropyRequest(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-func processMergedList(list_id string, merged_list *crdt_go.ShoppingList) bool {
-	readChan := make(chan readChanStruct)
+func processMergedList(ctx context.Context, list_id string, merged_list *crdt_go.ShoppingList) bool {
+	// The local read answers before returning, so the channel has room for its answer
+	readChan := make(chan readChanStruct, 1)
 	payload := map[string]string{"list_id": list_id}
-	sendReadAndWait(serverHostname, serverPort, payload, readChan)
+	sendReadAndWait(ctx, serverHostname, serverPort, payload, readChan)
 	result := <-readChan
 
 	if result.code == 1 {
 		local_list := result.content
 		local_list.Merge(merged_list)
-		return storeMergedList(list_id, local_list)
+		return storeMergedList(ctx, list_id, local_list)
 	} else if result.code == 2 {
 		// If the list doesn't exist locally, just add the new list
-		return storeMergedList(list_id, merged_list)
+		return storeMergedList(ctx, list_id, merged_list)
 	}
 
 	return false
 }
 
-func storeMergedList(list_id string, merged_list *crdt_go.ShoppingList) bool {
+func storeMergedList(ctx context.Context, list_id string, merged_list *crdt_go.ShoppingList) bool {
 	mergedListPayload := protocol.ShoppingListOperation{
 		ListId:  list_id,
 		Content: merged_list,
 	}
 	
 	writeChan := make(chan bool)
-	//Print
-	fmt.Printf("mergedListPayload on sender node side received for anti-entropy push-pull mechanism: %s", fmt.Sprintf("%v", mergedListPayload))
-	go sendWriteAndWait(serverHostname, serverPort, mergedListPayload, writeChan)
+	slog.DebugContext(ctx, "Storing a merged list", "list_id", list_id)
+	go sendWriteAndWait(ctx, serverHostname, serverPort, mergedListPayload, writeChan)
 
 	writeChanResult := <-writeChan
 	return writeChanResult
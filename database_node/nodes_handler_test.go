@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+)
+
+/**
+ * Plays a node that starts an anti-entropy round with this one while holding the given lists:
+ * sends their hashes, merges the lists this node answers as differing and pushes the merged lists back.
+ * Returns the ids of the lists that differed.
+ */
+func antiEntropyFrom(t *testing.T, lists map[string]*crdt_go.ShoppingList) []string {
+	t.Helper()
+
+	hashes := readChanStructForDotContext{Code: 1, Content: make(map[string]string)}
+	for listId, list := range lists {
+		hash, err := hashOfListContext(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes.Content[listId] = hash
+	}
+	body, err := json.Marshal(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(handleGossipPushPullAntiEntropyRequest, httptest.NewRequest(http.MethodPost, "/gossip/antiEntropy/request", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatal("expected the pull to be answered, got", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Body.String() == "No differing lists" {
+		return nil
+	}
+
+	var differing map[string]*crdt_go.ShoppingList
+	if err := json.Unmarshal(recorder.Body.Bytes(), &differing); err != nil {
+		t.Fatal(err)
+	}
+	listIds := make([]string, 0, len(differing))
+	for listId, list := range differing {
+		lists[listId].Merge(list)
+		listIds = append(listIds, listId)
+	}
+
+	merged, err := json.Marshal(lists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(handleGossipPushPullAntiEntropyRequest, httptest.NewRequest(http.MethodPut, "/gossip/antiEntropy/request", bytes.NewReader(merged))); recorder.Code != http.StatusOK {
+		t.Fatal("expected the merged lists to be stored, got", recorder.Code, recorder.Body.String())
+	}
+	return listIds
+}
+
+func TestStatusIsOnlyToldToTheCluster(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+	storeAliceList(t, "list2")
+
+	defer func(secret []byte) { protocol.ClusterSecret = secret }(protocol.ClusterSecret)
+	protocol.ClusterSecret = []byte("secret")
+
+	status := func(peer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if peer != "" {
+			protocol.SignRequest(protocol.ClusterSecret, peer, req, nil, time.Now())
+		}
+		return serve(protocol.RequireCluster(statusPeer, getStatus), req)
+	}
+
+	if recorder := status(""); recorder.Code != http.StatusUnauthorized {
+		t.Error("expected an unsigned request to be refused, got", recorder.Code)
+	}
+	if recorder := status("127.0.0.1:9999"); recorder.Code != http.StatusForbidden {
+		t.Error("expected a request from outside the cluster to be refused, got", recorder.Code)
+	}
+
+	for _, peer := range []string{protocol.HEALTH_CHECKER_PEER, protocol.LOAD_BALANCER_PEER, protocol.LocalPeer} {
+		recorder := status(peer)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: expected the status, got %d: %s", peer, recorder.Code, recorder.Body.String())
+			continue
+		}
+
+		var health protocol.NodeHealth
+		if err := json.Unmarshal(recorder.Body.Bytes(), &health); err != nil {
+			t.Fatal(err)
+		}
+
+		size := 0
+		for _, listId := range []string{"list1", "list2"} {
+			metadata, _ := database.getListMetadata(listId)
+			size += metadata.Size
+		}
+		if health.Id != protocol.LocalPeer || health.StoredLists != 2 || health.StoredListsBytes != size {
+			t.Errorf("%s: expected the status of the node with 2 lists of %d bytes, got %+v", peer, size, health)
+		}
+	}
+}
+
+func TestAntiEntropyReconcilesEveryChange(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(list *crdt_go.ShoppingList)
+		same   func(local *crdt_go.ShoppingList, remote *crdt_go.ShoppingList) bool
+	}{
+		{
+			"a move",
+			func(list *crdt_go.ShoppingList) { list.MoveItem("bread", "", "bob") },
+			func(local *crdt_go.ShoppingList, remote *crdt_go.ShoppingList) bool {
+				return reflect.DeepEqual(local.GetOrderedItems(), remote.GetOrderedItems())
+			},
+		},
+		{
+			"a rename",
+			func(list *crdt_go.ShoppingList) { list.RenameItem("milk", "oat milk", "bob") },
+			func(local *crdt_go.ShoppingList, remote *crdt_go.ShoppingList) bool {
+				return hasItem(local, "oat milk") && !hasItem(local, "milk")
+			},
+		},
+		{
+			"a transfer of rights",
+			func(list *crdt_go.ShoppingList) { list.TransferRights("milk", "alice", "bob", 1) },
+			func(local *crdt_go.ShoppingList, remote *crdt_go.ShoppingList) bool {
+				return local.GetItemRights("milk", "bob") == 1 && local.GetItemRights("milk", "alice") == 0
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setupTestNode(t)
+
+			local := crdt_go.NewShoppingList()
+			for _, item := range []string{"milk", "eggs", "bread"} {
+				local.AddOrUpdateItem(item, 1, "alice")
+			}
+			storeTestList(t, "list1", local)
+
+			// The other replica has the same list, but for the one change
+			remote := local.Clone()
+			c.change(remote)
+
+			if differing := antiEntropyFrom(t, map[string]*crdt_go.ShoppingList{"list1": remote}); len(differing) != 1 {
+				t.Fatal("expected the list to differ between the replicas, got", differing)
+			}
+			if stored, _ := database.getShoppingList("list1"); !c.same(stored, remote) {
+				t.Error("expected the change to be reconciled")
+			}
+			if differing := antiEntropyFrom(t, map[string]*crdt_go.ShoppingList{"list1": remote}); len(differing) != 0 {
+				t.Error("expected the replicas to agree once reconciled, got", differing)
+			}
+		})
+	}
+}
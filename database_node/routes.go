@@ -2,15 +2,71 @@ package main
 
 import (
 	"net/http"
+
+	"sdle.com/mod/logging"
+	"sdle.com/mod/metrics"
+	"sdle.com/mod/protocol"
+	"sdle.com/mod/tracing"
 )
 
 func registerRoutes() {
 	// http.HandleFunc("/", getRoot)
 	
-	http.HandleFunc("/operation", handleOperation)
-	http.HandleFunc("/list", handleCoordenator)
-	http.HandleFunc("/gossip", handleGossip)
-	http.HandleFunc("/gossip/antiEntropy/request", handleGossipPushPullAntiEntropyRequest)
-	http.HandleFunc("/node/add", nodeAdd)
-	http.HandleFunc("/ping", getPing)
+	// Clients reach the nodes through the load balancer, so every route but the ping is internal
+	// Only the coordinators send the operations to the replicas
+	handle("/operation", protocol.RequireCluster(ringPeer, handleOperation))
+	handle("/list", protocol.RequireCluster(knownPeer, handleCoordenator))
+	handle("/list/history", protocol.RequireCluster(knownPeer, handleHistory))
+	handle("/list/share", protocol.RequireCluster(knownPeer, handleShare))
+	handle("/list/acl", protocol.RequireCluster(knownPeer, handleACL))
+	handleUntraced("/gossip", protocol.RequireCluster(knownPeer, handleGossip))
+	handleUntraced("/gossip/antiEntropy/request", protocol.RequireCluster(knownPeer, handleGossipPushPullAntiEntropyRequest))
+	handle("/node/add", protocol.RequireCluster(knownPeer, nodeAdd))
+	handleUntraced("/ping", getPing)
+	handleUntraced("/status", protocol.RequireCluster(statusPeer, getStatus))
+
+	// Operators reach the admin routes of a node directly, with the admin token
+	handle("/admin/snapshot", protocol.RequireAdmin(handleSnapshot))
+	handle("/admin/lists", protocol.RequireAdmin(handleListIds))
+
+	// Scraped by Prometheus, like the ping it tells nothing about the lists
+	registerMetrics()
+	http.HandleFunc("/metrics", metrics.Handler)
+}
+
+/**
+ * Registers the handler of a route, counting its requests and giving each an id and a span. The id and the
+ * trace the load balancer or another node sent are kept, so a request can be followed across the nodes
+ */
+func handle(route string, handler http.HandlerFunc) {
+	http.HandleFunc(route, metrics.InstrumentHandler(route, logging.HandleRequestID(true, tracing.HandleTrace(true, route, handler))))
+}
+
+/**
+ * Registers the handler of a route the nodes and the health checker call every few seconds, which would
+ * otherwise fill the traces with one span per call
+ */
+func handleUntraced(route string, handler http.HandlerFunc) {
+	http.HandleFunc(route, metrics.InstrumentHandler(route, logging.HandleRequestID(true, handler)))
+}
+
+/**
+ * The peers a node accepts internal requests from: the load balancer and the nodes in its ring
+ */
+func knownPeer(peer string) bool {
+	return peer == protocol.LOAD_BALANCER_PEER || ring.HasNode(peer)
+}
+
+/**
+ * The peers a node accepts replica operations from: the nodes in its ring
+ */
+func ringPeer(peer string) bool {
+	return ring.HasNode(peer)
+}
+
+/**
+ * The peers a node tells its status to: the health checker and the peers it accepts internal requests from
+ */
+func statusPeer(peer string) bool {
+	return peer == protocol.HEALTH_CHECKER_PEER || knownPeer(peer)
 }
@@ -3,9 +3,8 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 
@@ -29,19 +28,26 @@ func startServer(serverPort string, serverRunning chan bool) {
 	// The server should be added to its own node ring
 	ring.AddNode(serverHostname, serverPort, true)
 
-	go gossip()
+	// The gossip and anti-entropy rounds stop with the server
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go gossip(stop)
 
 	go hintedHandoff()
-	//TODO: launch gossipAntiEntropy here or bellow? 
-	go gossipAntiEntropy(int32(ring.ReplicationFactor/2)) 
-	err := http.ListenAndServe(fmt.Sprintf(":%s", serverPort), nil)
+
+	go purgeTombstones()
+
+	go gossipAntiEntropy(stop)
+
+	// Only the cluster talks to the nodes, so with mutual TLS every connection needs a client certificate
+	err := protocol.ListenAndServe(serverPort, true)
 
 	if errors.Is(err, http.ErrServerClosed) {
-		log.Printf("server closed")
+		slog.Info("Server closed")
 	} else if err != nil {
-		log.Printf("error starting server: %s\n", err)
+		slog.Error("Failed to start the server", "err", err)
 	}
-	//TODO: launch gossipAntiEntropy here or above ?
 	serverRunning <- true
 }
 
@@ -49,33 +55,39 @@ func joinCluster(loadBalancerAddress string, loadBalancerPort string, ownData ma
 	if loadBalancerAddress != "" && loadBalancerPort != "" {
 		jsonData, err := json.Marshal(ownData)
 		if err != nil {
-			log.Fatalf("Error happened in JSON marshal. Err: %s", err)
-			return
+			slog.Error("Failed to marshal the node data", "err", err)
+			os.Exit(1)
 		}
 		// This will register the node current node, as a node on the Ring of the load balancer
 		r, err := protocol.SendRequestWithData(http.MethodPut, loadBalancerAddress, loadBalancerPort, "/node/add", jsonData)
 		utils.CheckErr(err)
 
 		if r.StatusCode == 202 {
-			log.Println("Joined the cluster")
+			slog.Info("Joined the cluster", "load_balancer", protocol.PeerId(loadBalancerAddress, loadBalancerPort))
 		} else {
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
-				log.Println("Failed to join the cluster")
+				slog.Error("Failed to join the cluster", "status", r.StatusCode)
 			} else {
-				log.Println("Failed to join the cluster:", string(body))
+				slog.Error("Failed to join the cluster", "status", r.StatusCode, "reason", string(body))
 			}
 			os.Exit(1)
 			return
 		}
 
-		target := make(map[string][]map[string]string)
+		var target protocol.JoinResponse
 
 		err = json.NewDecoder(r.Body).Decode(&target)
 		utils.CheckErr(err)
 
-		for i := 0; i < len(target["nodes"]); i++ {
-			newNode := target["nodes"][i]
+		// The cluster decides how much history is kept
+		if target.History != nil {
+			setHistoryRetention(*target.History)
+			slog.Info("History retention set by the cluster", "max_entries", target.History.MaxEntries, "max_age_seconds", target.History.MaxAgeSeconds)
+		}
+
+		for i := 0; i < len(target.Nodes); i++ {
+			newNode := target.Nodes[i]
 
 			if newNode["address"] == "" || newNode["port"] == "" {
 				continue
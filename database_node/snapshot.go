@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+)
+
+// The version of the snapshot archives this node writes, it reads the ones with the same version
+const SNAPSHOT_FORMAT_VERSION = 1
+
+// The content type of a snapshot archive: gzipped newline-delimited JSON, a snapshotHeader and then
+// one snapshotEntry per list
+const SNAPSHOT_CONTENT_TYPE string = "application/gzip"
+
+// snapshotHeader is the first line of a snapshot archive
+type snapshotHeader struct {
+	Version int    `json:"version"`
+	Node    string `json:"node"`    // The node the snapshot was taken on
+	Created int64  `json:"created"` // When the snapshot was taken, in Unix milliseconds
+	Lists   int    `json:"lists"`   // How many lists follow
+}
+
+// snapshotEntry is a list in a snapshot archive, in its binary encoding
+type snapshotEntry struct {
+	ListId   string       `json:"list_id"`
+	List     []byte       `json:"list"`
+	Metadata ListMetadata `json:"metadata"`
+}
+
+// snapshotRestoreResponse is what a node answers once it restored a snapshot
+type snapshotRestoreResponse struct {
+	Restored int `json:"restored"`
+	Failed   int `json:"failed"`
+}
+
+/**
+* Reads every list and its metadata at the same point in time, returned with the entries. The database lock is
+* held while the keys are walked, so no batch of the write-ahead log is applied in the middle of the walk; the
+* values are only copied under it and the metadata is decoded once the writes go on
+ */
+func (db *DatabaseInstance) snapshotLists() ([]snapshotEntry, time.Time) {
+	lists := make(map[string][]byte)
+	rawMetadata := make(map[string][]byte)
+
+	db.lock.Lock()
+	at := time.Now()
+	cursor, err := db.conn.NewCursor()
+	if err == nil {
+		for cursor.First(); cursor.IsValid(); cursor.Next() {
+			key, err := cursor.Key()
+			if err != nil {
+				break
+			}
+
+			if listId, ok := bytes.CutPrefix(key, []byte(LIST_KEY_PREFIX)); ok {
+				if value, err := cursor.Value(); err == nil {
+					lists[string(listId)] = value
+				}
+			} else if listId, ok := bytes.CutPrefix(key, []byte(METADATA_KEY_PREFIX)); ok {
+				if value, err := cursor.Value(); err == nil {
+					rawMetadata[string(listId)] = value
+				}
+			}
+		}
+		cursor.Close()
+	}
+	db.lock.Unlock()
+
+	entries := make([]snapshotEntry, 0, len(lists))
+	for listId, list := range lists {
+		var metadata ListMetadata
+		if err := json.Unmarshal(rawMetadata[listId], &metadata); err != nil {
+			slog.Warn("Taking a list without its metadata into the snapshot", "list_id", listId, "err", err)
+		}
+		entries = append(entries, snapshotEntry{ListId: listId, List: list, Metadata: metadata})
+	}
+
+	return entries, at
+}
+
+/**
+* Writes a snapshot archive of the lists of this node
+ */
+func writeSnapshot(w io.Writer, entries []snapshotEntry, at time.Time) error {
+	archive := gzip.NewWriter(w)
+	encoder := json.NewEncoder(archive)
+
+	header := snapshotHeader{
+		Version: SNAPSHOT_FORMAT_VERSION,
+		Node:    protocol.PeerId(serverHostname, serverPort),
+		Created: at.UnixMilli(),
+		Lists:   len(entries),
+	}
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+/**
+* Reads a snapshot archive, calling restore with every list in it
+ */
+func readSnapshot(r io.Reader, restore func(entry snapshotEntry)) (snapshotHeader, error) {
+	var header snapshotHeader
+
+	archive, err := gzip.NewReader(r)
+	if err != nil {
+		return header, err
+	}
+	defer archive.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(archive))
+	if err := decoder.Decode(&header); err != nil {
+		return header, err
+	}
+	if header.Version != SNAPSHOT_FORMAT_VERSION {
+		return header, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	for {
+		var entry snapshotEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return header, err
+		}
+
+		restore(entry)
+	}
+}
+
+/**
+* Takes a snapshot of the lists of this node (GET) or restores one into it (PUT). A restored list is merged
+* into the one the node has, if any, so restoring never loses writes, and anti-entropy and hinted handoff
+* then reconcile the restored lists with the other replicas
+ */
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "Received /admin/snapshot request", "method", r.Method)
+
+	switch r.Method {
+	case http.MethodGet:
+		{
+			entries, at := database.snapshotLists()
+
+			w.Header().Set("Content-Type", SNAPSHOT_CONTENT_TYPE)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"snapshot-%s-%s.ndjson.gz\"", serverHostname, serverPort))
+			w.WriteHeader(http.StatusOK)
+
+			if err := writeSnapshot(w, entries, at); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to write the snapshot", "err", err)
+				return
+			}
+			slog.InfoContext(r.Context(), "Took a snapshot", "lists", len(entries))
+		}
+	case http.MethodPut:
+		{
+			var response snapshotRestoreResponse
+
+			header, err := readSnapshot(r.Body, func(entry snapshotEntry) {
+				list, err := crdt_go.DecodeShoppingList(entry.List)
+				if err != nil || !database.updateOrSetShoppingList(r.Context(), entry.ListId, list) {
+					slog.WarnContext(r.Context(), "Failed to restore a list", "list_id", entry.ListId)
+					response.Failed++
+					return
+				}
+				response.Restored++
+			})
+			if err != nil {
+				slog.WarnContext(r.Context(), "Failed to read the snapshot", "err", err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Invalid snapshot: " + err.Error()))
+				return
+			}
+			slog.InfoContext(r.Context(), "Restored a snapshot", "lists", response.Restored, "failed", response.Failed, "snapshot_node", header.Node)
+
+			jsonData, err := json.Marshal(response)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to marshal the restore response", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonData)
+		}
+	default:
+		{
+			protocol.WrongRequestType(w)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/protocol"
+)
+
+/**
+ * Takes a snapshot of the lists of the node, as an archive
+ */
+func takeSnapshot(t *testing.T) []byte {
+	t.Helper()
+
+	entries, _ := database.snapshotLists()
+
+	var archive bytes.Buffer
+	if err := writeSnapshot(&archive, entries, time.UnixMilli(1700000000000)); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+/**
+ * Reads every entry of an archive, sorted by list id
+ */
+func readEntries(archive []byte) (snapshotHeader, []snapshotEntry, error) {
+	entries := make([]snapshotEntry, 0)
+	header, err := readSnapshot(bytes.NewReader(archive), func(entry snapshotEntry) {
+		entries = append(entries, entry)
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ListId < entries[j].ListId })
+	return header, entries, err
+}
+
+/**
+ * Restores an archive into the node through the admin route, returns the response
+ */
+func restoreSnapshot(archive []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/admin/snapshot", bytes.NewReader(archive))
+	req.Header.Set("Content-Type", SNAPSHOT_CONTENT_TYPE)
+	return serve(handleSnapshot, req)
+}
+
+/**
+ * Gzips lines of newline-delimited JSON, as a snapshot archive is written
+ */
+func gzipLines(t *testing.T, lines ...any) []byte {
+	t.Helper()
+
+	var archive bytes.Buffer
+	writer := gzip.NewWriter(&archive)
+	encoder := json.NewEncoder(writer)
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+	storeAliceList(t, "list2")
+
+	header, entries, err := readEntries(takeSnapshot(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedHeader := snapshotHeader{Version: SNAPSHOT_FORMAT_VERSION, Node: protocol.LocalPeer, Created: 1700000000000, Lists: 2}
+	if header != expectedHeader {
+		t.Errorf("expected the header %+v, got %+v", expectedHeader, header)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 lists, got %d", len(entries))
+	}
+	for i, listId := range []string{"list1", "list2"} {
+		list, _ := database.getValue(listKey(listId))
+		metadata, _ := database.getListMetadata(listId)
+		if !reflect.DeepEqual(entries[i], snapshotEntry{ListId: listId, List: list, Metadata: metadata}) {
+			t.Errorf("%s: the list or its metadata changed in the archive", listId)
+		}
+	}
+}
+
+func TestSnapshotIsRestored(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+	archive := takeSnapshot(t)
+
+	// The node lost one of its lists, and got another one since the snapshot
+	if !database.deleteList("list1") {
+		t.Fatal("failed to delete the list")
+	}
+	storeAliceList(t, "list2")
+
+	recorder := restoreSnapshot(archive)
+	if recorder.Code != http.StatusOK {
+		t.Fatal("expected the snapshot to be restored, got", recorder.Code, recorder.Body.String())
+	}
+	var response snapshotRestoreResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response != (snapshotRestoreResponse{Restored: 1}) {
+		t.Errorf("expected one list restored, got %+v", response)
+	}
+
+	for _, listId := range []string{"list1", "list2"} {
+		if list, ok := database.getShoppingList(listId); !ok || !hasItem(list, "milk") || list.GetOwner() != "alice" {
+			t.Errorf("%s: expected the list to be there after the restore", listId)
+		}
+	}
+}
+
+func TestBrokenSnapshotIsRejected(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+	storeAliceList(t, "list2")
+	archive := takeSnapshot(t)
+
+	header := snapshotHeader{Version: SNAPSHOT_FORMAT_VERSION, Lists: 1}
+	cases := []struct {
+		name    string
+		archive []byte
+	}{
+		{"empty", nil},
+		{"not gzipped", []byte(`{"version":1}`)},
+		{"truncated", archive[:len(archive)/2]},
+		{"without its gzip trailer", archive[:len(archive)-4]},
+		{"corrupted", append(append([]byte{}, archive[:len(archive)-10]...), bytes.Repeat([]byte{0xff}, 10)...)},
+		{"of another version", gzipLines(t, snapshotHeader{Version: SNAPSHOT_FORMAT_VERSION + 1})},
+		{"with an unreadable entry", gzipLines(t, header, "not an entry")},
+	}
+
+	for _, c := range cases {
+		if _, _, err := readEntries(c.archive); err == nil {
+			t.Errorf("%s: expected the archive to be rejected", c.name)
+		}
+		if recorder := restoreSnapshot(c.archive); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", c.name, http.StatusBadRequest, recorder.Code)
+		}
+	}
+
+	// The lists read before the archive broke off are merged, and merging them again changes nothing
+	for _, listId := range []string{"list1", "list2"} {
+		if list, ok := database.getShoppingList(listId); !ok || !hasItem(list, "milk") {
+			t.Errorf("%s: the list should not have been changed by a broken archive", listId)
+		}
+	}
+}
+
+func TestSnapshotIsTakenAtOnePointInTime(t *testing.T) {
+	setupTestNode(t)
+
+	const WRITES = 200
+	listIds := []string{"first", "second"}
+
+	// Both lists get one more milk in turn, so at any point in time first has as many as second or one more
+	written := make(chan bool, 1)
+	go func() {
+		lists := []*crdt_go.ShoppingList{crdt_go.NewShoppingList(), crdt_go.NewShoppingList()}
+		for i := 0; i < WRITES; i++ {
+			for j, list := range lists {
+				list.AddOrUpdateItem("milk", 1, "alice")
+				if !database.updateOrSetShoppingList(context.Background(), listIds[j], list.Clone()) {
+					written <- false
+					return
+				}
+			}
+		}
+		written <- true
+	}()
+
+	// Snapshots are taken for as long as the lists are written
+	for {
+		select {
+		case ok := <-written:
+			if !ok {
+				t.Fatal("failed to write the lists")
+			}
+			return
+		default:
+		}
+
+		entries, _ := database.snapshotLists()
+		quantities := make(map[string]int32)
+		for _, entry := range entries {
+			list, err := crdt_go.DecodeShoppingList(entry.List)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Metadata.Size != len(entry.List) {
+				t.Fatalf("%s: the metadata was not taken with the list", entry.ListId)
+			}
+			quantities[entry.ListId], _ = list.GetItemQuantity("milk")
+		}
+
+		if first, second := quantities["first"], quantities["second"]; first != second && first != second+1 {
+			t.Fatalf("the snapshot took first with %d milk and second with %d, they never were at the same time", first, second)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/utils"
+)
+
+// How long the tombstone of a deleted list is kept at least, from TOMBSTONE_GRACE (in seconds)
+var tombstoneGrace = utils.SecondsFromEnv("TOMBSTONE_GRACE", DEFAULT_TOMBSTONE_GRACE)
+
+const DEFAULT_TOMBSTONE_GRACE = 7 * 24 * time.Hour
+
+// How often the tombstones are checked
+const TOMBSTONE_PURGE_INTERVAL = time.Minute
+
+/**
+ * Removes the tombstones of the deleted lists once their grace period is over and every replica of the
+ * list stored them. Each replica purges its own copy, so none of them brings the list back
+ */
+func purgeTombstones() {
+	for {
+		time.Sleep(TOMBSTONE_PURGE_INTERVAL)
+
+		purgeExpiredTombstones()
+	}
+}
+
+/**
+ * Goes once through the lists this node stores, removing the tombstones that can be purged
+ */
+func purgeExpiredTombstones() {
+	for _, listId := range database.getListIds() {
+		replicas := ring.GetReplicasForID(listId)
+		if len(replicas) == 0 {
+			continue
+		}
+		replicaIds := make([]string, 0, len(replicas))
+		for _, replica := range replicas {
+			replicaIds = append(replicaIds, replica.Id)
+		}
+
+		// Checked under the lock of the list, a write can not come between the check and the purge
+		canPurge := func(list *crdt_go.ShoppingList) bool {
+			return list.CanPurge(replicaIds, tombstoneGrace, time.Now())
+		}
+		if database.deleteListIf(listId, canPurge) {
+			slog.Info("Purged the tombstone of a list", "list_id", listId)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"sdle.com/mod/protocol"
+)
+
+func TestDeletedListIsNotBroughtBack(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list1")
+
+	// A copy from before the deletion, as another replica or a hint may still have it
+	stale, _ := database.getShoppingList("list1")
+
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodDelete, "/list?list_id=list1", nil, "bob", "bob")); recorder.Code != http.StatusForbidden {
+		t.Error("expected an editor not to delete the list, got", recorder.Code)
+	}
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodDelete, "/list?list_id=list1", nil, "alice", "alice")); recorder.Code != http.StatusOK {
+		t.Fatal("expected the owner to delete the list, got", recorder.Code, recorder.Body.String())
+	}
+
+	expectGone := func(when string) {
+		t.Helper()
+
+		cases := []struct {
+			name string
+			req  *http.Request
+		}{
+			{"read", clientRequest(http.MethodPost, "/list", readBody("list1"), "alice", "alice")},
+			{"write", clientRequest(http.MethodPut, "/list", writeBody(t, "list1", stale.Clone()), "alice", "alice")},
+			{"delete", clientRequest(http.MethodDelete, "/list?list_id=list1", nil, "alice", "alice")},
+		}
+		for _, c := range cases {
+			if recorder := serve(handleCoordenator, c.req); recorder.Code != http.StatusGone {
+				t.Errorf("%s, %s: expected %d, got %d", when, c.name, http.StatusGone, recorder.Code)
+			}
+		}
+	}
+	expectGone("after the deletion")
+
+	// A replica that missed the deletion writes its copy back, like read repair or a hint would
+	req := clientRequest(http.MethodPut, "/operation", writeBody(t, "list1", stale.Clone()), "", "")
+	req.Header.Set(protocol.PEER_HEADER, protocol.LocalPeer)
+	if recorder := serve(protocol.RequireCluster(ringPeer, handleOperation), req); recorder.Code != http.StatusOK {
+		t.Fatal("expected the replica write to be merged, got", recorder.Code, recorder.Body.String())
+	}
+	expectGone("after a replica wrote its copy")
+
+	// And anti-entropy pushes it
+	if !processMergedList(context.Background(), "list1", stale.Clone()) {
+		t.Fatal("expected anti-entropy to merge the copy")
+	}
+	expectGone("after anti-entropy")
+
+	list, _ := database.getShoppingList("list1")
+	if hasItem(list, "milk") {
+		t.Error("the tombstone should not keep the items of the list")
+	}
+}
+
+func TestTombstoneIsPurgedAfterTheGracePeriod(t *testing.T) {
+	setupTestNode(t)
+	storeAliceList(t, "list2")
+
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodDelete, "/list?list_id=list2", nil, "alice", "alice")); recorder.Code != http.StatusOK {
+		t.Fatal("expected the owner to delete the list, got", recorder.Code)
+	}
+
+	// Within its grace period the tombstone is kept, even though every replica stored it
+	purgeExpiredTombstones()
+	if _, ok := database.getShoppingList("list2"); !ok {
+		t.Fatal("the tombstone should be kept during its grace period")
+	}
+
+	defer func(grace time.Duration) { tombstoneGrace = grace }(tombstoneGrace)
+	tombstoneGrace = 0
+
+	purgeExpiredTombstones()
+	if _, ok := database.getShoppingList("list2"); ok {
+		t.Error("the tombstone should have been purged")
+	}
+	if _, ok := database.getListMetadata("list2"); ok {
+		t.Error("the metadata of the list should have been purged with it")
+	}
+	if recorder := serve(handleCoordenator, clientRequest(http.MethodPost, "/list", readBody("list2"), "alice", "alice")); recorder.Code != http.StatusNotFound {
+		t.Error("expected the purged list not to be found, got", recorder.Code)
+	}
+}
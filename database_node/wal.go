@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// How many writes are made durable with the same fsync at most
+const WAL_MAX_GROUP_SIZE = 256
+
+// The size of the header of a record of the log: the length of its content and its CRC-32
+const WAL_HEADER_SIZE = 8
+
+// walBatch is a set of changes to the database applied in the same transaction, one record of the log
+type walBatch struct {
+	Puts    map[string][]byte `json:"puts,omitempty"`
+	Deletes []string          `json:"deletes,omitempty"`
+}
+
+type walRequest struct {
+	batch walBatch
+	done  chan error
+}
+
+// writeAheadLog makes the writes to the database durable before they are applied, so a node that crashes
+// in the middle of a write applies it again when it restarts. The writes that arrive while one is being
+// synced are synced together (group commit), and the log is emptied once they are applied.
+type writeAheadLog struct {
+	file     *os.File
+	requests chan walRequest
+}
+
+/**
+* Opens the write-ahead log at the given path, creating it if it does not exist
+ */
+func openWriteAheadLog(path string) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeAheadLog{file: file, requests: make(chan walRequest, WAL_MAX_GROUP_SIZE)}, nil
+}
+
+/**
+* Reads the batches in the log, in the order they were written. A record that was only partly written
+* when the node crashed, and everything after it, is ignored: its write was never acknowledged
+ */
+func (wal *writeAheadLog) read() ([]walBatch, error) {
+	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	batches := make([]walBatch, 0)
+	reader := bufio.NewReader(wal.file)
+	header := make([]byte, WAL_HEADER_SIZE)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+
+		content := make([]byte, binary.BigEndian.Uint32(header[:4]))
+		if _, err := io.ReadFull(reader, content); err != nil {
+			slog.Warn("Ignoring a torn record at the end of the write-ahead log")
+			break
+		}
+		if crc32.ChecksumIEEE(content) != binary.BigEndian.Uint32(header[4:]) {
+			slog.Warn("Ignoring a corrupted record at the end of the write-ahead log")
+			break
+		}
+
+		var batch walBatch
+		if err := json.Unmarshal(content, &batch); err != nil {
+			slog.Warn("Ignoring an unreadable record at the end of the write-ahead log", "err", err)
+			break
+		}
+		batches = append(batches, batch)
+	}
+
+	return batches, nil
+}
+
+/**
+* Appends batches to the log and syncs it once for all of them. If it fails, the log is cut back to where
+* it was, so no torn record hides the ones written after it
+ */
+func (wal *writeAheadLog) write(batches []walBatch) (err error) {
+	start, err := wal.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			wal.file.Truncate(start)
+		}
+	}()
+
+	writer := bufio.NewWriter(wal.file)
+	for _, batch := range batches {
+		content, marshalErr := json.Marshal(batch)
+		if marshalErr != nil {
+			return marshalErr
+		}
+
+		header := make([]byte, WAL_HEADER_SIZE)
+		binary.BigEndian.PutUint32(header[:4], uint32(len(content)))
+		binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(content))
+
+		writer.Write(header)
+		writer.Write(content)
+	}
+
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+
+	return wal.file.Sync()
+}
+
+/**
+* Empties the log, once every batch in it was applied to the database
+ */
+func (wal *writeAheadLog) truncate() error {
+	if err := wal.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := wal.file.Seek(0, io.SeekStart)
+	return err
+}
+
+/**
+* Applies the batches left in the log by a node that stopped before applying them, and empties it
+ */
+func (wal *writeAheadLog) replay(apply func(batches []walBatch) error) error {
+	batches, err := wal.read()
+	if err != nil {
+		return err
+	}
+
+	if len(batches) > 0 {
+		slog.Info("Replaying the write-ahead log", "writes", len(batches))
+		if err := apply(batches); err != nil {
+			return err
+		}
+	}
+
+	return wal.truncate()
+}
+
+/**
+* Makes a batch durable and waits until it is applied to the database
+ */
+func (wal *writeAheadLog) commit(batch walBatch) error {
+	done := make(chan error, 1)
+	wal.requests <- walRequest{batch, done}
+	return <-done
+}
+
+/**
+* Writes the batches committed to the log and applies them
+ */
+func (wal *writeAheadLog) run(apply func(batches []walBatch) error) {
+	for first := range wal.requests {
+		pending := []walRequest{first}
+
+		// Every write that came while the previous group was synced joins this one
+	collect:
+		for len(pending) < WAL_MAX_GROUP_SIZE {
+			select {
+			case request := <-wal.requests:
+				pending = append(pending, request)
+			default:
+				break collect
+			}
+		}
+
+		batches := make([]walBatch, len(pending))
+		for i, request := range pending {
+			batches[i] = request.batch
+		}
+
+		err := wal.write(batches)
+		if err == nil {
+			err = apply(batches)
+
+			// Once applied the batches are not needed anymore, and if they failed they were rolled back and
+			// are not acknowledged, so they must not be applied when the node restarts either
+			if truncateErr := wal.truncate(); truncateErr != nil {
+				slog.Error("Failed to empty the write-ahead log", "err", truncateErr)
+			}
+		}
+		if err != nil {
+			slog.Error("Failed to commit writes", "writes", len(batches), "err", err)
+		}
+
+		for _, request := range pending {
+			request.done <- err
+		}
+	}
+}
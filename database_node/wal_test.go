@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/**
+ * Opens a write-ahead log in a temporary directory, closing it when the test ends
+ */
+func openTestLog(t *testing.T) (*writeAheadLog, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wal.log")
+	wal, err := openWriteAheadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wal.file.Close() })
+	return wal, path
+}
+
+func testBatches() []walBatch {
+	return []walBatch{
+		{Puts: map[string][]byte{"list/a": []byte("first")}},
+		{Puts: map[string][]byte{"list/b": []byte("second")}, Deletes: []string{"list/a"}},
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestWriteAheadLogReplaysAfterACrash(t *testing.T) {
+	wal, path := openTestLog(t)
+	if err := wal.write(testBatches()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The node stops before applying the batches, and opens the log again when it restarts
+	wal.file.Close()
+	reopened, err := openWriteAheadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.file.Close()
+
+	var applied []walBatch
+	err = reopened.replay(func(batches []walBatch) error {
+		applied = append(applied, batches...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(applied, testBatches()) {
+		t.Errorf("replayed %v, want %v", applied, testBatches())
+	}
+	if size := fileSize(t, path); size != 0 {
+		t.Errorf("the log has %d bytes after the replay, want it empty", size)
+	}
+}
+
+func TestWriteAheadLogKeepsBatchesThatFailedToReplay(t *testing.T) {
+	wal, path := openTestLog(t)
+	if err := wal.write(testBatches()); err != nil {
+		t.Fatal(err)
+	}
+	size := fileSize(t, path)
+
+	err := wal.replay(func([]walBatch) error { return errors.New("the database is not writable") })
+	if err == nil {
+		t.Fatal("the replay should fail")
+	}
+
+	// They are replayed again the next time the node starts
+	if got := fileSize(t, path); got != size {
+		t.Errorf("the log has %d bytes, want the %d it had", got, size)
+	}
+}
+
+func TestWriteAheadLogIgnoresATornRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		// How many bytes of the last record were written before the crash
+		written int64
+	}{
+		{"part of the header", WAL_HEADER_SIZE / 2},
+		{"the header only", WAL_HEADER_SIZE},
+		{"part of the content", WAL_HEADER_SIZE + 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wal, path := openTestLog(t)
+			batches := testBatches()
+			if err := wal.write(batches[:1]); err != nil {
+				t.Fatal(err)
+			}
+			end := fileSize(t, path)
+			if err := wal.write(batches[1:]); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := wal.file.Truncate(end + tt.written); err != nil {
+				t.Fatal(err)
+			}
+
+			read, err := wal.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read, batches[:1]) {
+				t.Errorf("read %v, want only the records before the torn one", read)
+			}
+		})
+	}
+}
+
+func TestWriteAheadLogStopsAtACorruptRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		// The offset of the byte flipped, from the start of the second record
+		offset int64
+	}{
+		{"content", WAL_HEADER_SIZE + 1},
+		{"checksum", WAL_HEADER_SIZE - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wal, path := openTestLog(t)
+			batches := append(testBatches(), walBatch{Deletes: []string{"list/b"}})
+			if err := wal.write(batches[:1]); err != nil {
+				t.Fatal(err)
+			}
+			start := fileSize(t, path)
+			if err := wal.write(batches[1:]); err != nil {
+				t.Fatal(err)
+			}
+
+			corrupt := make([]byte, 1)
+			if _, err := wal.file.ReadAt(corrupt, start+tt.offset); err != nil {
+				t.Fatal(err)
+			}
+			corrupt[0] ^= 0xff
+			if _, err := wal.file.WriteAt(corrupt, start+tt.offset); err != nil {
+				t.Fatal(err)
+			}
+
+			// The record after the corrupt one is intact, but nothing past a corrupt record can be trusted
+			read, err := wal.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read, batches[:1]) {
+				t.Errorf("read %v, want only the records before the corrupt one", read)
+			}
+		})
+	}
+}
+
+func TestWriteAheadLogIsTruncatedAfterApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		applyErr error
+	}{
+		{"applied", nil},
+		// A batch that was rolled back was not acknowledged, so it must not be applied on a restart either
+		{"rolled back", errors.New("the database is not writable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wal, path := openTestLog(t)
+
+			var applied []walBatch
+			sizeWhenApplied := int64(-1)
+			go wal.run(func(batches []walBatch) error {
+				applied = append(applied, batches...)
+				if info, err := os.Stat(path); err == nil {
+					sizeWhenApplied = info.Size()
+				}
+				return tt.applyErr
+			})
+			defer close(wal.requests)
+
+			batch := testBatches()[0]
+			if err := wal.commit(batch); !errors.Is(err, tt.applyErr) {
+				t.Fatalf("commit returned %v, want %v", err, tt.applyErr)
+			}
+
+			if !reflect.DeepEqual(applied, []walBatch{batch}) {
+				t.Errorf("applied %v, want %v", applied, []walBatch{batch})
+			}
+			if sizeWhenApplied <= 0 {
+				t.Error("the batch should be in the log when it is applied")
+			}
+			if size := fileSize(t, path); size != 0 {
+				t.Errorf("the log has %d bytes after the commit, want it empty", size)
+			}
+		})
+	}
+}
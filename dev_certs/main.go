@@ -0,0 +1,33 @@
+// this creates a local CA and the certificates of the machines of the cluster, for development and tests
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"sdle.com/mod/protocol"
+)
+
+func main() {
+	argsWithoutProg := os.Args[1:]
+
+	if len(argsWithoutProg) < 2 {
+		fmt.Println("Usage: dev_certs <dir> <name> [host...]")
+		os.Exit(1)
+	}
+
+	dir := argsWithoutProg[0]
+	name := argsWithoutProg[1]
+
+	// The machines of a development cluster run on the same one
+	hosts := append([]string{"localhost", "127.0.0.1", "::1"}, argsWithoutProg[2:]...)
+
+	config, err := protocol.WriteDevCert(dir, name, hosts)
+	if err != nil {
+		fmt.Println("Failed to create the certificate:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("TLS_CERT_FILE=%s TLS_KEY_FILE=%s TLS_CA_FILE=%s\n", config.CertFile, config.KeyFile, config.CAFile)
+}
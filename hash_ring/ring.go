@@ -3,8 +3,12 @@ package hash_ring
 import (
 	"crypto/md5"
 	"fmt"
+	"log/slog"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"sdle.com/mod/utils"
 )
@@ -17,6 +21,21 @@ const (
 	NODE_UNKNOWN      NodeStatus = 2 // When a Node was recently added to the ring, and has never been communicated before
 )
 
+/**
+ * The name of the status, as it appears in the metrics
+ */
+func (status NodeStatus) String() string {
+	switch status {
+	case NODE_OK:
+		return "ok"
+	case NODE_UNRESPONSIVE:
+		return "unresponsive"
+	case NODE_UNKNOWN:
+		return "unknown"
+	}
+	return fmt.Sprintf("status(%d)", int64(status))
+}
+
 type NodeInfo struct {
 	Id          string
 	Address     string
@@ -25,6 +44,8 @@ type NodeInfo struct {
 	Status      NodeStatus
 	GossipLock  sync.Mutex
 	DeadCounter int64
+	// When the last gossip with the node succeeded, in Unix nanoseconds
+	lastGossip atomic.Int64
 }
 
 /**
@@ -41,6 +62,24 @@ func newNodeInfo(address string, port string, status NodeStatus) *NodeInfo {
 	}
 }
 
+/**
+ * Records that a gossip with the node succeeded at the given time
+ */
+func (nI *NodeInfo) SetLastGossip(at time.Time) {
+	nI.lastGossip.Store(at.UnixNano())
+}
+
+/**
+ * When the last gossip with the node succeeded, the zero time if none did
+ */
+func (nI *NodeInfo) GetLastGossip() time.Time {
+	at := nI.lastGossip.Load()
+	if at == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, at)
+}
+
 func (nI *NodeInfo) GetVirtualNodes() []string {
 	return nI.Vnodes
 }
@@ -181,7 +220,7 @@ func (ring *HashRing) updateRing() {
 
 		ring.partitions[vnodeHash] = make([]string, 0)
 
-		rawHealthynodes := ring.getHealthyNodesForID(vnodeHash)
+		rawHealthynodes := ring.getNodesForID(vnodeHash, true)
 		healthyNodes := rawHealthynodes[:min(ring.ReplicationFactor, len(rawHealthynodes))]
 
 		for j := 0; j < len(healthyNodes); j++ {
@@ -227,6 +266,86 @@ func (ring *HashRing) GetNodes() map[string]*NodeInfo {
 	return ring.nodes
 }
 
+// NodeDescription is a node of the ring, as the health checker is told about it
+type NodeDescription struct {
+	Id      string   `json:"id"`
+	Address string   `json:"address"`
+	Port    string   `json:"port"`
+	Status  string   `json:"status"`
+	Vnodes  []string `json:"vnodes"`
+	// The vnodes the node stores the lists of, as one of their replicas
+	Partitions []string `json:"partitions"`
+	// When the last gossip with the node succeeded, nil if none did
+	LastGossip *time.Time `json:"last_gossip,omitempty"`
+}
+
+// RingDescription is the ring, as the health checker is told about it
+type RingDescription struct {
+	ReplicationFactor int               `json:"replication_factor"`
+	Nodes             []NodeDescription `json:"nodes"`
+}
+
+/**
+ * Describes the nodes of the ring, sorted by id, with their vnodes and the partitions they store
+ */
+func (ring *HashRing) Describe() RingDescription {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	partitions := make(map[string][]string)
+	for vnode, replicas := range ring.partitions {
+		for _, replica := range replicas {
+			partitions[replica] = append(partitions[replica], vnode)
+		}
+	}
+
+	description := RingDescription{ReplicationFactor: ring.ReplicationFactor, Nodes: make([]NodeDescription, 0, len(ring.nodes))}
+	for id, node := range ring.nodes {
+		nodeDescription := NodeDescription{
+			Id:         id,
+			Address:    node.Address,
+			Port:       node.Port,
+			Status:     node.Status.String(),
+			Vnodes:     append([]string{}, node.Vnodes...),
+			Partitions: append([]string{}, partitions[id]...),
+		}
+		sort.Strings(nodeDescription.Partitions)
+		if lastGossip := node.GetLastGossip(); !lastGossip.IsZero() {
+			nodeDescription.LastGossip = &lastGossip
+		}
+		description.Nodes = append(description.Nodes, nodeDescription)
+	}
+
+	sort.Slice(description.Nodes, func(i, j int) bool { return description.Nodes[i].Id < description.Nodes[j].Id })
+	return description
+}
+
+/**
+ * Returns the nodes of the ring, except the one with the given id, in a slice the caller can change
+ */
+func (ring *HashRing) GetNodeList(exceptId string) []*NodeInfo {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	nodes := make([]*NodeInfo, 0, len(ring.nodes))
+	for id, node := range ring.nodes {
+		if id != exceptId {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+/**
+ * Indicates if a node is in the ring
+ */
+func (ring *HashRing) HasNode(id string) bool {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	return ring.nodes[id] != nil
+}
+
 func (ring *HashRing) NodesGossip() map[string][]map[string]string {
 	ring.lock.Lock()
 	nodesOnTheRing := ring.GetNodes()
@@ -258,7 +377,7 @@ func (ring *HashRing) CheckForNewNodes(nodes []map[string]string, ownHostname st
 		nodeId := fmt.Sprintf("%s:%s", node["address"], node["port"])
 
 		if ring.nodes[nodeId] == nil {
-			fmt.Printf("FOUND AN UNKNOWN NODE %s:%s \n", node["address"], node["port"])
+			slog.Info("Found an unknown node in the gossip", "node", nodeId)
 
 			ring.addNode(node["address"], node["port"], false)
 		}
@@ -279,8 +398,7 @@ func (ring *HashRing) GetNodeForIdFromRing(id string) *NodeInfo {
 		ring.lock.Unlock()
 		return nil
 	}
-	//I want to print this "avl_node: ", avl_node.Value
-	fmt.Println("avl_node/GetNodeForIdFromRing: ", avl_node.Value)
+	slog.Debug("Found the virtual node of an id", "vnode", avl_node.Value)
 
 
 
@@ -297,13 +415,30 @@ func (ring *HashRing) GetNodeForIdFromRing(id string) *NodeInfo {
 func (ring *HashRing) GetHealthyNodesForID(id string) []*NodeInfo {
 	ring.lock.Lock()
 
-	result := ring.getHealthyNodesForID(id)
+	result := ring.getNodesForID(id, true)
 
 	ring.lock.Unlock()
 
 	return result
 }
 
+/**
+* Gets the N nodes in the hash ring after a certain ID, healthy or not, these are the replicas of the ID
+ */
+func (ring *HashRing) GetReplicasForID(id string) []*NodeInfo {
+	ring.lock.Lock()
+
+	result := ring.getNodesForID(id, false)
+
+	ring.lock.Unlock()
+
+	if len(result) > ring.ReplicationFactor {
+		result = result[:ring.ReplicationFactor]
+	}
+
+	return result
+}
+
 func (ring *HashRing) GetNextHealthyVirtualNode(id string) string {
 	var hash_key string = HashId(id)
 
@@ -342,8 +477,8 @@ func (ring *HashRing) GetNextHealthyVirtualNode(id string) string {
 	return firstVNodeId
 }
 
-// Calculates the n nodes after an id and returns them
-func (ring *HashRing) getHealthyNodesForID(id string) []*NodeInfo {
+// Calculates the n nodes after an id and returns them, only the healthy ones if healthyOnly is set
+func (ring *HashRing) getNodesForID(id string, healthyOnly bool) []*NodeInfo {
 	nodes := make([]*NodeInfo, 0)
 
 	if ring.vnodes.Size() < 1 {
@@ -361,7 +496,7 @@ func (ring *HashRing) getHealthyNodesForID(id string) []*NodeInfo {
 	// parse virtual node name <node_name>_vnode<id>
 	parsedServerName := ring.ParseVirtualNodeID(avlNode.Value)[0]
 
-	if ring.nodes[parsedServerName].Status == NODE_OK {
+	if !healthyOnly || ring.nodes[parsedServerName].Status == NODE_OK {
 		nodes = append(nodes, ring.nodes[parsedServerName])
 		nodesChecked = append(nodesChecked, parsedServerName)
 	}
@@ -396,7 +531,7 @@ func (ring *HashRing) getHealthyNodesForID(id string) []*NodeInfo {
 			nodesChecked = append(nodesChecked, parsedServerName)
 		}
 
-		if ring.nodes[parsedServerName].Status == NODE_OK {
+		if !healthyOnly || ring.nodes[parsedServerName].Status == NODE_OK {
 			nodes = append(nodes, ring.nodes[parsedServerName])
 		}
 
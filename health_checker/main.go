@@ -1,139 +1,350 @@
-// this adds database-nodes and checks for their health
+// Discovers the nodes of the ring from the load balancer, checks their health on a schedule and serves their
+// history as JSON and as a live dashboard
 
 package main
 
 import (
+	_ "embed"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
+	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/logging"
+	"sdle.com/mod/metrics"
 	"sdle.com/mod/protocol"
+	"sdle.com/mod/scheduler"
 )
 
-type node struct {
-    address string
-    port string
-    status string
-}
+// How many checks are kept for each node
+const HISTORY_SIZE = 300
 
-func newNode(address string, port string) *node {
-    p := node{address: address, port: port, status: "NOT YET CONNECTED"}
-    return &p
-}
+// The outcomes of a check
+const (
+	CHECK_OK           string = "ok"
+	CHECK_UNRESPONSIVE string = "unresponsive"
+	CHECK_ERROR        string = "error"
+)
+
+// Every node is checked every round, which is overridden by the HEALTH_* environment variables
+var healthConfig = scheduler.Config{Interval: 2 * time.Second, Jitter: 0, Fanout: 0, Workers: 4}
+
+//go:embed index.html
+var dashboardHTML string
+
+// How long the nodes took to answer a ping, and how the pings ended
+var pingDuration = metrics.NewHistogramVec("node_ping_seconds", "How long a node took to answer a ping, in seconds.", metrics.DefaultBuckets, "node")
+var pings = metrics.NewCounterVec("node_pings_total", "Pings sent to a node, by outcome.", "node", "outcome")
 
 type PingResponse struct {
 	Message string
 }
 
-func pingNode(i int, index chan int, status chan string) {
-	var nodeAddress string = nodes[i].address
-	var nodePort string = nodes[i].port
+// Check is the outcome of one health check of a node
+type Check struct {
+	At     time.Time `json:"at"`
+	Status string    `json:"status"`
+	// How long the node took to answer the ping, 0 if it did not
+	LatencyMs float64 `json:"latency_ms"`
+}
 
-	start := time.Now().UnixNano() / int64(time.Millisecond)
-	response, err := protocol.SendGetRequest(nodeAddress, nodePort, "/ping")
-	end := time.Now().UnixNano() / int64(time.Millisecond)
-	diff := end - start
+// NodeState is a node of the ring with what the health checker learned about it
+type NodeState struct {
+	hash_ring.NodeDescription
+	// The status of the node in the ring is the one the load balancer sees, this is the last check's
+	Health string `json:"health"`
+	// What the node answered on /status in the last check that reached it
+	Details *protocol.NodeHealth `json:"details,omitempty"`
+	// The last checks, the oldest first
+	History []Check `json:"history"`
+}
 
-	if (err != nil) {
-		index <- i;
-		status <- "UNRESPONSIVE"
-		return
-	}
+// LoadBalancerState tells if the ring could be fetched from the load balancer
+type LoadBalancerState struct {
+	Address   string `json:"address"`
+	Port      string `json:"port"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	// When the ring was last fetched, nil if it never was
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+}
 
-	if (response.StatusCode == 200) {
-		target := PingResponse{} 
-		
-		json.NewDecoder(response.Body).Decode(&target)
+// ClusterState is what GET /api/nodes answers
+type ClusterState struct {
+	LoadBalancer      LoadBalancerState `json:"load_balancer"`
+	ReplicationFactor int               `json:"replication_factor"`
+	Nodes             []NodeState       `json:"nodes"`
+}
 
-		if (target.Message == "pong") {
-			index <- i;
-			status <- fmt.Sprintf("OK: %d ms", diff)
-			return
+var (
+	lock         sync.Mutex
+	loadBalancer LoadBalancerState
+	ring         hash_ring.RingDescription
+	// The nodes in the order of the ring, and their state by id
+	nodeOrder []string
+	nodes     = make(map[string]*NodeState)
+)
+
+/**
+ * Fetches the ring from the load balancer and returns the ids of its nodes. When the load balancer cannot be
+ * reached the last ring fetched is kept, so its nodes are still checked
+ */
+func refreshRing() []string {
+	description, err := fetchRing()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err != nil {
+		if loadBalancer.Reachable || loadBalancer.Error != err.Error() {
+			slog.Warn("Failed to fetch the ring from the load balancer", "err", err)
 		}
+		loadBalancer.Reachable = false
+		loadBalancer.Error = err.Error()
+		return append([]string{}, nodeOrder...)
 	}
 
-	index <- i;
-	status <- "ERROR"
+	now := time.Now()
+	loadBalancer.Reachable = true
+	loadBalancer.Error = ""
+	loadBalancer.LastRefresh = &now
+	ring = description
+
+	// The nodes that left the ring are forgotten, with their history
+	inRing := make(map[string]bool)
+	nodeOrder = nodeOrder[:0]
+	for _, node := range description.Nodes {
+		inRing[node.Id] = true
+		nodeOrder = append(nodeOrder, node.Id)
+
+		if state, ok := nodes[node.Id]; ok {
+			state.NodeDescription = node
+		} else {
+			slog.Info("Discovered a node", "node", node.Id)
+			nodes[node.Id] = &NodeState{NodeDescription: node, Health: hash_ring.NODE_UNKNOWN.String(), History: make([]Check, 0, HISTORY_SIZE)}
+		}
+	}
+	for id := range nodes {
+		if !inRing[id] {
+			slog.Info("A node left the ring", "node", id)
+			delete(nodes, id)
+		}
+	}
+
+	return append([]string{}, nodeOrder...)
 }
 
+func fetchRing() (hash_ring.RingDescription, error) {
+	var description hash_ring.RingDescription
 
-func healthCheck() {
-	indexChan := make(chan int)
-	statusChan := make(chan string)
+	response, err := protocol.SendGetRequest(loadBalancer.Address, loadBalancer.Port, "/ring")
+	if err != nil {
+		return description, err
+	}
+	defer response.Body.Close()
 
-	for i := 0; i < len(nodes); i++ {
-		go pingNode(i, indexChan, statusChan)
+	if response.StatusCode != http.StatusOK {
+		return description, fmt.Errorf("the load balancer answered %s", response.Status)
 	}
+	if err := json.NewDecoder(response.Body).Decode(&description); err != nil {
+		return description, fmt.Errorf("invalid ring: %w", err)
+	}
+	return description, nil
+}
 
-	for i := 0; i < len(nodes); i++ {
-		index, status := <- indexChan, statusChan
-		nodes[index].status = <-status
+/**
+ * Pings a node and asks for its status, and records the outcome in its history
+ */
+func checkNode(id string) {
+	lock.Lock()
+	state, ok := nodes[id]
+	if !ok {
+		lock.Unlock()
+		return
+	}
+	address, port := state.Address, state.Port
+	lock.Unlock()
+
+	check := Check{At: time.Now(), Status: pingNode(id, address, port)}
+	if check.Status == CHECK_OK {
+		check.LatencyMs = float64(time.Since(check.At).Microseconds()) / 1000
+	}
+
+	var details *protocol.NodeHealth
+	if check.Status == CHECK_OK {
+		details = fetchStatus(address, port)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The node may have left the ring while it was checked
+	if state, ok = nodes[id]; !ok {
+		return
+	}
+	if state.Health != check.Status {
+		slog.Info("The health of a node changed", "node", id, "from", state.Health, "to", check.Status)
 	}
+	state.Health = check.Status
+	if details != nil {
+		state.Details = details
+	}
+	if len(state.History) == HISTORY_SIZE {
+		state.History = append(state.History[:0], state.History[1:]...)
+	}
+	state.History = append(state.History, check)
 }
 
-var responseHTML string = ""
-var nodes []*node
+/**
+ * Pings a node, returning the outcome of the check
+ */
+func pingNode(id string, address string, port string) string {
+	start := time.Now()
+	response, err := protocol.SendGetRequest(address, port, "/ping")
+	pingDuration.Observe(time.Since(start).Seconds(), id)
+
+	if err != nil {
+		pings.Inc(id, CHECK_UNRESPONSIVE)
+		return CHECK_UNRESPONSIVE
+	}
+	defer response.Body.Close()
 
-func getRoot(w http.ResponseWriter, r *http.Request) {
-	io.WriteString(w, responseHTML)
+	if response.StatusCode == http.StatusOK {
+		target := PingResponse{}
+		json.NewDecoder(response.Body).Decode(&target)
+
+		if target.Message == "pong" {
+			pings.Inc(id, CHECK_OK)
+			return CHECK_OK
+		}
+	}
+
+	pings.Inc(id, CHECK_ERROR)
+	return CHECK_ERROR
 }
 
-func getNodes(w http.ResponseWriter, r *http.Request) {
-	healthCheck()
+/**
+ * Asks a node for its status, nil if it did not answer with one
+ */
+func fetchStatus(address string, port string) *protocol.NodeHealth {
+	response, err := protocol.SendGetRequest(address, port, "/status")
+	if err != nil {
+		slog.Debug("Failed to fetch the status of a node", "node", protocol.PeerId(address, port), "err", err)
+		return nil
+	}
+	defer response.Body.Close()
 
-	var nodesInfo string = "";
+	if response.StatusCode != http.StatusOK {
+		slog.Debug("Failed to fetch the status of a node", "node", protocol.PeerId(address, port), "status", response.Status)
+		return nil
+	}
 
-	for i := 0; i < len(nodes); i++ {
-		nodesInfo += "<h2>" + fmt.Sprintf("%s:%s - %s", nodes[i].address, nodes[i].port, nodes[i].status) + "</h2>\n";
+	details := protocol.NodeHealth{}
+	if err := json.NewDecoder(response.Body).Decode(&details); err != nil {
+		slog.Debug("Failed to decode the status of a node", "node", protocol.PeerId(address, port), "err", err)
+		return nil
 	}
+	return &details
+}
 
-	io.WriteString(w, nodesInfo)
+/**
+ * Copies the state of the cluster, so it can be encoded without holding the lock
+ */
+func clusterState() ClusterState {
+	lock.Lock()
+	defer lock.Unlock()
+
+	state := ClusterState{LoadBalancer: loadBalancer, ReplicationFactor: ring.ReplicationFactor, Nodes: make([]NodeState, 0, len(nodeOrder))}
+	for _, id := range nodeOrder {
+		node := *nodes[id]
+		node.History = append([]Check{}, node.History...)
+		state.Nodes = append(state.Nodes, node)
+	}
+	return state
 }
 
-func getAdd(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("got /add request\n")
+func getRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, dashboardHTML)
+}
 
-	if err := r.ParseForm(); err != nil {
-		fmt.Fprintf(w, "ParseForm() err: %v", err)
+/**
+ * Answers with the nodes of the ring, their last status and history
+ */
+func getNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		protocol.WrongRequestType(w)
 		return
 	}
-	fmt.Printf("Post from website! r.PostFrom = %v\n", r.PostForm)
-	address := r.FormValue("address")
-	port := r.FormValue("port")
 
-	nodes = append(nodes, newNode(address, port))
+	jsonData, err := json.Marshal(clusterState())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to marshal the nodes", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	w.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
 }
 
 func main() {
-	htmlContent, err1 := os.ReadFile("./health-checker/index.html")
-    checkErr(err1)
+	if err := logging.Setup("health_checker"); err != nil {
+		fmt.Println("Failed to set up logging:", err)
+		os.Exit(1)
+	}
 
-	responseHTML = string(htmlContent)
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: health_checker <own_port> <load_balancer_address> <load_balancer_port>")
+		os.Exit(1)
+	}
+	port := os.Args[1]
+	loadBalancer.Address, loadBalancer.Port = os.Args[2], os.Args[3]
 
-	http.HandleFunc("/", getRoot)
-	http.HandleFunc("/nodes", getNodes)
-	http.HandleFunc("/add", getAdd)
+	config, err := scheduler.ConfigFromEnv("HEALTH", healthConfig)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// The ring is fetched and the nodes are checked with the same certificates and secret as the rest of the cluster
+	protocol.LocalPeer = protocol.HEALTH_CHECKER_PEER
+	checkErr(protocol.SetupTLS())
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go scheduler.New("health", config, refreshRing, checkNode).Run(stop)
 
-	err := http.ListenAndServe(":3333", nil)
+	http.HandleFunc("/", metrics.InstrumentHandler("/", logging.HandleRequestID(false, getRoot)))
+	http.HandleFunc("/api/nodes", metrics.InstrumentHandler("/api/nodes", logging.HandleRequestID(false, getNodes)))
+	http.HandleFunc("/metrics", metrics.Handler)
+
+	// The dashboard is opened from a browser, so it is served over TLS without asking for a client certificate
+	err = protocol.ListenAndServe(port, false)
 
 	if errors.Is(err, http.ErrServerClosed) {
-		fmt.Printf("server closed\n")
+		slog.Info("Server closed")
 	} else if err != nil {
-		fmt.Printf("error starting server: %s\n", err)
+		slog.Error("Failed to start the server", "err", err)
 		os.Exit(1)
 	}
 }
 
 func checkErr(err error) {
-    if err != nil {
-        log.Fatal(err)
-    }
+	if err != nil {
+		slog.Error("Failed to start", "err", err)
+		os.Exit(1)
+	}
 }
-
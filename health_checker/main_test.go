@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/protocol"
+	"sdle.com/mod/scheduler"
+)
+
+// testBalancer is a load balancer that describes the ring it is given, or fails while failing is set
+type testBalancer struct {
+	lock    sync.Mutex
+	ring    hash_ring.RingDescription
+	failing bool
+}
+
+func (balancer *testBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	balancer.lock.Lock()
+	defer balancer.lock.Unlock()
+
+	if r.URL.Path != "/ring" || balancer.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(balancer.ring)
+}
+
+func (balancer *testBalancer) describe(nodes ...hash_ring.NodeDescription) {
+	balancer.lock.Lock()
+	defer balancer.lock.Unlock()
+
+	balancer.ring = hash_ring.RingDescription{ReplicationFactor: 2, Nodes: nodes}
+}
+
+func (balancer *testBalancer) fail(failing bool) {
+	balancer.lock.Lock()
+	defer balancer.lock.Unlock()
+
+	balancer.failing = failing
+}
+
+/**
+ * Starts a test server, returns its address and port
+ */
+func startServer(t *testing.T, handler http.Handler) (string, string) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address.Hostname(), address.Port()
+}
+
+/**
+ * Points the health checker at a test load balancer, forgetting the nodes of the previous test
+ */
+func setupTestBalancer(t *testing.T) *testBalancer {
+	balancer := &testBalancer{}
+	address, port := startServer(t, balancer)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	loadBalancer = LoadBalancerState{Address: address, Port: port}
+	ring = hash_ring.RingDescription{}
+	nodeOrder = nil
+	nodes = make(map[string]*NodeState)
+	return balancer
+}
+
+/**
+ * Starts a node that answers the pings, with an error to the first failures of them
+ */
+func startTestNode(t *testing.T, failures int) hash_ring.NodeDescription {
+	var pingLock sync.Mutex
+	pinged := 0
+
+	var id string
+	address, port := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			pingLock.Lock()
+			pinged++
+			failed := pinged <= failures
+			pingLock.Unlock()
+
+			if failed {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(PingResponse{Message: "pong"})
+		case "/status":
+			json.NewEncoder(w).Encode(protocol.NodeHealth{Id: id, StoredLists: 1})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	id = protocol.PeerId(address, port)
+	return hash_ring.NodeDescription{Id: id, Address: address, Port: port, Status: hash_ring.NODE_OK.String()}
+}
+
+/**
+ * The state of a node as GET /api/nodes answers it, false if the node is not in it
+ */
+func nodeState(id string) (NodeState, bool) {
+	for _, node := range clusterState().Nodes {
+		if node.Id == id {
+			return node, true
+		}
+	}
+	return NodeState{}, false
+}
+
+func TestRefreshKeepsTheLastRingWhenTheLoadBalancerFails(t *testing.T) {
+	balancer := setupTestBalancer(t)
+	first, second := startTestNode(t, 0), startTestNode(t, 0)
+	balancer.describe(first, second)
+
+	if ids := refreshRing(); strings.Join(ids, ",") != first.Id+","+second.Id {
+		t.Fatal("expected the nodes of the ring, got", ids)
+	}
+	refreshed := clusterState().LoadBalancer
+	if !refreshed.Reachable || refreshed.LastRefresh == nil {
+		t.Fatalf("expected the load balancer to be reachable, got %+v", refreshed)
+	}
+
+	balancer.fail(true)
+
+	if ids := refreshRing(); strings.Join(ids, ",") != first.Id+","+second.Id {
+		t.Fatal("expected the nodes of the last ring to still be checked, got", ids)
+	}
+	state := clusterState()
+	if state.LoadBalancer.Reachable || !strings.Contains(state.LoadBalancer.Error, "503") {
+		t.Errorf("expected the failure of the load balancer to be told, got %+v", state.LoadBalancer)
+	}
+	if state.LoadBalancer.LastRefresh == nil || !state.LoadBalancer.LastRefresh.Equal(*refreshed.LastRefresh) {
+		t.Error("expected the last refresh to be kept")
+	}
+	if state.ReplicationFactor != 2 || len(state.Nodes) != 2 {
+		t.Errorf("expected the last ring to be kept, got %+v", state)
+	}
+
+	// Its nodes are still checked
+	checkNode(first.Id)
+	if node, _ := nodeState(first.Id); node.Health != CHECK_OK || len(node.History) != 1 {
+		t.Errorf("expected the node to be checked, got %+v", node)
+	}
+
+	balancer.fail(false)
+	refreshRing()
+	if state := clusterState().LoadBalancer; !state.Reachable || state.Error != "" {
+		t.Errorf("expected the load balancer to be reachable again, got %+v", state)
+	}
+}
+
+func TestNodesThatLeftTheRingAreForgotten(t *testing.T) {
+	balancer := setupTestBalancer(t)
+	leaving, staying := startTestNode(t, 0), startTestNode(t, 0)
+	balancer.describe(leaving, staying)
+
+	refreshRing()
+	checkNode(leaving.Id)
+	checkNode(staying.Id)
+
+	balancer.describe(staying)
+	if ids := refreshRing(); len(ids) != 1 || ids[0] != staying.Id {
+		t.Fatal("expected only the node left in the ring, got", ids)
+	}
+
+	if _, ok := nodeState(leaving.Id); ok {
+		t.Error("expected the node that left the ring to be forgotten")
+	}
+	if node, ok := nodeState(staying.Id); !ok || len(node.History) != 1 {
+		t.Errorf("expected the node still in the ring to keep its history, got %+v", node)
+	}
+
+	// A check that was already scheduled for the node that left records nothing
+	checkNode(leaving.Id)
+	if _, ok := nodeState(leaving.Id); ok {
+		t.Error("a check of a node that left the ring should not bring it back")
+	}
+
+	// When it joins again it starts over
+	balancer.describe(leaving, staying)
+	refreshRing()
+	if node, ok := nodeState(leaving.Id); !ok || node.Health != hash_ring.NODE_UNKNOWN.String() || len(node.History) != 0 {
+		t.Errorf("expected the node to be back without its history, got %+v", node)
+	}
+}
+
+func TestHistoryKeepsTheLastChecks(t *testing.T) {
+	const FAILURES = 5
+
+	balancer := setupTestBalancer(t)
+	node := startTestNode(t, FAILURES)
+	balancer.describe(node)
+
+	clock := scheduler.NewFakeClock(time.Unix(0, 0))
+	checked := make(chan string, 1)
+	checks := scheduler.New("health", scheduler.Config{Interval: time.Second, Workers: 1}, refreshRing, func(id string) {
+		checkNode(id)
+		checked <- id
+	}).WithClock(clock)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go checks.Run(stop)
+
+	// The node fails its first checks, then answers every one of the others
+	for i := 0; i < HISTORY_SIZE+FAILURES; i++ {
+		if i > 0 {
+			clock.BlockUntilWaiting(1)
+			clock.Advance(time.Second)
+		}
+		select {
+		case <-checked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the node to be checked in round", i)
+		}
+
+		if i == FAILURES-1 {
+			if state, _ := nodeState(node.Id); state.Health != CHECK_ERROR || len(state.History) != FAILURES {
+				t.Fatalf("expected the failed checks in the history, got %s with %d checks", state.Health, len(state.History))
+			}
+		}
+	}
+
+	// Only the last checks are kept, so the failures are gone
+	state, _ := nodeState(node.Id)
+	if len(state.History) != HISTORY_SIZE {
+		t.Fatalf("expected the last %d checks, got %d", HISTORY_SIZE, len(state.History))
+	}
+	for _, check := range state.History {
+		if check.Status != CHECK_OK {
+			t.Fatal("expected the oldest checks to be dropped, got", check)
+		}
+	}
+	if state.Health != CHECK_OK || state.Details == nil || state.Details.Id != node.Id {
+		t.Errorf("expected the node to be healthy with its status, got %+v", state)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+
+	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/protocol"
+)
+
+/**
+* Returns every list of the cluster grouped by the partition of the ring it belongs to, asking every node
+* for the lists it stores. Used by the export, which then reads each list through its coordinators
+ */
+func routeListIds(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		protocol.WrongRequestType(writer)
+		return
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	listIds := make(map[string]bool)
+	response := protocol.ClusterLists{Partitions: make([]protocol.PartitionLists, 0)}
+
+	for _, node := range ring.GetNodes() {
+		wg.Add(1)
+		go func(node *hash_ring.NodeInfo) {
+			defer wg.Done()
+
+			nodeListIds, ok := fetchListIds(request.Context(), node)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if !ok {
+				response.Unreachable = append(response.Unreachable, node.Id)
+				return
+			}
+			for _, listId := range nodeListIds {
+				listIds[listId] = true
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	byPartition := make(map[string]*protocol.PartitionLists)
+	for listId := range listIds {
+		vnode := ring.GetNextHealthyVirtualNode(listId)
+
+		partition, ok := byPartition[vnode]
+		if !ok {
+			// The coordinators of a list are chosen among these nodes
+			partition = &protocol.PartitionLists{Partition: vnode, Nodes: make([]string, 0)}
+			for _, node := range ring.GetHealthyNodesForID(listId) {
+				partition.Nodes = append(partition.Nodes, node.Id)
+			}
+			byPartition[vnode] = partition
+		}
+		partition.ListIds = append(partition.ListIds, listId)
+	}
+
+	for _, partition := range byPartition {
+		sort.Strings(partition.ListIds)
+		response.Partitions = append(response.Partitions, *partition)
+	}
+	sort.Slice(response.Partitions, func(i, j int) bool {
+		return response.Partitions[i].Partition < response.Partitions[j].Partition
+	})
+	sort.Strings(response.Unreachable)
+
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		slog.ErrorContext(request.Context(), "Failed to marshal the lists of the cluster", "err", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(jsonData)
+}
+
+/**
+* Asks a node for the ids of the lists it stores
+ */
+func fetchListIds(ctx context.Context, node *hash_ring.NodeInfo) ([]string, bool) {
+	if node.Status == hash_ring.NODE_UNRESPONSIVE {
+		return nil, false
+	}
+
+	response, err := protocol.SendAdminRequest(ctx, http.MethodGet, node.Address, node.Port, "/admin/lists", nil, protocol.JSON_CONTENT_TYPE, protocol.JSON_CONTENT_TYPE)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to ask a node for its lists", "peer", node.Id, "err", err)
+		return nil, false
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		slog.WarnContext(ctx, "A node refused to list its lists", "peer", node.Id, "status", response.StatusCode)
+		return nil, false
+	}
+
+	var listIds []string
+	if err := json.NewDecoder(response.Body).Decode(&listIds); err != nil {
+		return nil, false
+	}
+
+	return listIds, true
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"os"
 	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/logging"
+	"sdle.com/mod/protocol"
+	"sdle.com/mod/scheduler"
+	"sdle.com/mod/tracing"
 	"sdle.com/mod/utils"
 	"sync"
 	"time"
@@ -16,6 +20,9 @@ var serverHostname string = ""
 var ring hash_ring.HashRing
 var roundRobinBalancer = NewRoundRobinBalancer()
 
+// The history retention limits of the whole cluster, handed to the nodes when they join
+var historyRetention protocol.HistoryRetention
+
 // Node represents information about a node.
 type Node struct {
 	add  string
@@ -38,8 +45,6 @@ func NewRoundRobinBalancer() *RoundRobinBalancer {
 // IncrementRequestCount increases the request count for a given node.
 func (b *RoundRobinBalancer) IncrementRequestCount(nodeId string) {
     b.lock.Lock()
-	// print inside the the IncrementRequestCount
-	fmt.Println("Inside IncrementRequestCount for node: ", nodeId)
 	// if the node is not in the map, add it
 	if _, ok := b.requestCount[nodeId]; !ok {
 		b.requestCount[nodeId] = 0
@@ -47,7 +52,6 @@ func (b *RoundRobinBalancer) IncrementRequestCount(nodeId string) {
 
 		b.requestCount[nodeId]++
 	}
-	fmt.Println("Inside IncrementRequestCount: requestCount of load balancer: ", b.requestCount,"for node: ", nodeId)
 	b.lock.Unlock()
 }
 // AddNode adds a new node to the balancer.
@@ -101,12 +105,9 @@ func (b *RoundRobinBalancer) SelectNodeFromList(list []*hash_ring.NodeInfo , thr
     minRequestCount := int(^uint(0) >> 1) // Max int value
     maxRequestCount := 0
 	
-	fmt.Println("len of list: ", len(list))
 	if(len(list) == 1){
 		return list[0]
 	}
-	// print  the map of requestCount for each node
-	fmt.Println("requestCount of load balancer: ", b.requestCount)
 
     for _, node := range list {
         if count, ok := b.requestCount[node.Id]; ok {
@@ -123,18 +124,12 @@ func (b *RoundRobinBalancer) SelectNodeFromList(list []*hash_ring.NodeInfo , thr
 
 	// Select a node based on the load balancing criteria and the threshold
 	// bounded consistent hashing to avoid node overload for a single list_ids !!
-	// print the consistentHashNode.Id 
-	fmt.Println("Getted consistentHashNode: ", list[0])	
-	fmt.Println("maxRequestCount: ", maxRequestCount, " minRequestCount: ", minRequestCount, " threshold: ", threshold)
-	fmt.Println("mostLoadedNode: ", mostLoadedNode, " leastLoadedNode: ", leastLoadedNode.Id)
-
-	
-	fmt.Println("consistentHashNodeCount: ", cons_hash_req_count_node)
+	slog.Debug("Bounded consistent hashing", "consistent_hash_node", list[0].Id, "consistent_hash_node_count", cons_hash_req_count_node, "min_request_count", minRequestCount, "max_request_count", maxRequestCount, "threshold", threshold)
 
     if mostLoadedNode != nil || leastLoadedNode != nil {
 		if cons_hash_req_count_node > 100 && float64(cons_hash_req_count_node) > float64(minRequestCount)*(1.0+float64(threshold)){ 
             // If the most loaded node is overloaded ( using threshold), select the least loaded node
-			fmt.Println("Selected the least loaded node")
+			slog.Debug("Selected the least loaded node", "node", leastLoadedNode.Id)
             b.IncrementRequestCount(leastLoadedNode.Id)
 		
             return leastLoadedNode
@@ -142,8 +137,6 @@ func (b *RoundRobinBalancer) SelectNodeFromList(list []*hash_ring.NodeInfo , thr
 
 	
 	}
-	fmt.Println("Selected the consistentHashNode")
-	fmt.Println("requestCount of load balancer: ", b.requestCount[list[0].Id],"for node: ", list[0].Id)
 	
 	return list[0]
 }
@@ -177,23 +170,47 @@ func main() {
 		fmt.Println("A server port must be specified")
 		os.Exit(1)
 	}
+	protocol.LocalPeer = protocol.LOAD_BALANCER_PEER
+	if err := logging.Setup("load_balancer"); err != nil {
+		fmt.Println("Failed to set up logging:", err)
+		os.Exit(1)
+	}
+	if err := tracing.Setup("load_balancer", protocol.PeerId(serverHostname, serverPort)); err != nil {
+		fmt.Println("Failed to set up tracing:", err)
+		os.Exit(1)
+	}
+	var err error
+	if gossipConfig, err = scheduler.ConfigFromEnv("GOSSIP", gossipConfig); err != nil {
+		fmt.Println("Failed to set up gossip:", err)
+		os.Exit(1)
+	}
+	if err := protocol.SetupTLS(); err != nil {
+		fmt.Println("Failed to set up TLS:", err)
+		os.Exit(1)
+	}
+	if err := protocol.CheckClusterSecret(); err != nil {
+		fmt.Println("Refusing to start:", err)
+		os.Exit(1)
+	}
+	if len(protocol.ClusterSecret) == 0 {
+		slog.Warn("CLUSTER_SECRET is not set and INSECURE_CLUSTER is, requests between the machines of the cluster are not signed")
+	}
+	if len(protocol.APITokens) == 0 {
+		slog.Warn("API_TOKENS is not set, clients are not authenticated")
+	}
+
 	ring.Initialize()
-	log.Printf("Load Balancer %s:%s", serverHostname, serverPort)
+	historyRetention = protocol.HistoryRetentionFromEnv()
+	slog.Info("Load balancer starting", "address", serverHostname, "port", serverPort)
 	
 
-	serverRunning := make(chan bool)
-	go func() {
-		ticker := time.Tick(1 * time.Second)
-		for {
-			select {
-			case <-ticker:
-				gossip()
-			case <-serverRunning:
-				fmt.Println("Server is no longer running. Exiting.")
-				return
-			}
-		}
-	}()
+	stop := make(chan struct{})
+	go gossip(stop)
+
+	serverRunning := make(chan bool, 1)
 	startServer(serverRunning)
 	<-serverRunning // waits for the server to close
+
+	slog.Info("Server is no longer running, exiting")
+	close(stop)
 }
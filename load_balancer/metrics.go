@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+
+	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/metrics"
+)
+
+// How long a gossip round with a node took, and how it ended
+var gossipRoundTrip = metrics.NewHistogramVec("gossip_round_trip_seconds", "How long a gossip round with a node took, in seconds.", metrics.DefaultBuckets, "node")
+var gossipRounds = metrics.NewCounterVec("gossip_rounds_total", "Gossip rounds with a node, by outcome.", "node", "outcome")
+
+// The nodes the load balancer saw change status
+var nodeStatusTransitions = metrics.NewCounterVec("node_status_transitions_total", "Status changes of the nodes of the ring, as seen by the load balancer.", "node", "from", "to")
+
+/**
+* Counts a gossip round with a node that started at start
+ */
+func observeGossip(node *hash_ring.NodeInfo, start time.Time, outcome string) {
+	gossipRoundTrip.Observe(time.Since(start).Seconds(), node.Id)
+	gossipRounds.Inc(node.Id, outcome)
+}
+
+/**
+* Changes the status of a node of the ring, counting the change
+ */
+func setNodeStatus(node *hash_ring.NodeInfo, status hash_ring.NodeStatus) {
+	nodeStatusTransitions.Inc(node.Id, node.Status.String(), status.String())
+	node.Status = status
+	// The partitions described on /ring are stored by the healthy nodes only
+	ring.NodeStatusChanged()
+}
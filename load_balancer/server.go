@@ -2,37 +2,70 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"time"
 
 	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/logging"
+	"sdle.com/mod/metrics"
 	"sdle.com/mod/protocol"
+	"sdle.com/mod/scheduler"
+	"sdle.com/mod/tracing"
 )
 
 var threshold = 0.4 // Threshold for bounded consistent hashing
 func registerRoutes() {
-	http.HandleFunc("/operation", routeOperation)
-	http.HandleFunc("/list", routeCoordenator)
-	http.HandleFunc("/node/add", addNode)
-	http.HandleFunc("/ping", Ping)
+	handle("/list", protocol.RequireAPIToken(routeCoordenator))
+	handle("/list/history", protocol.RequireAPIToken(routeByListQuery))
+	handle("/list/share", protocol.RequireAPIToken(routeByListQuery))
+	handle("/list/acl", protocol.RequireAPIToken(routeByListQuery))
+	// Joining nodes are not in the ring yet, they only need to be signed
+	handle("/node/add", protocol.RequireClientCert(protocol.RequireCluster(nil, addNode)))
+	handleUntraced("/ping", Ping)
+
+	// The health checker discovers the nodes from the ring
+	handleUntraced("/ring", protocol.RequireClientCert(protocol.RequireCluster(nil, routeRing)))
+
+	// The export tool needs both an API token and the admin token
+	handle("/admin/lists", protocol.RequireAPIToken(protocol.RequireAdmin(routeListIds)))
+
+	// Scraped by Prometheus, like the ping it tells nothing about the lists
+	http.HandleFunc("/metrics", metrics.Handler)
+}
+
+/**
+ * Registers the handler of a route, counting its requests and giving each a new id and trace, which are sent
+ * along with the request to the nodes
+ */
+func handle(route string, handler http.HandlerFunc) {
+	http.HandleFunc(route, metrics.InstrumentHandler(route, logging.HandleRequestID(false, tracing.HandleTrace(false, route, handler))))
+}
+
+/**
+ * Registers the handler of a route the health checker calls every few seconds, which would otherwise fill the
+ * traces with one span per call
+ */
+func handleUntraced(route string, handler http.HandlerFunc) {
+	http.HandleFunc(route, metrics.InstrumentHandler(route, logging.HandleRequestID(false, handler)))
 }
 
 func startServer(serverRunning chan bool) {
 	registerRoutes()
-	err := http.ListenAndServe(fmt.Sprintf(":%s", serverPort), nil)
+	// Clients talk to the load balancer too, so only the internal routes need a client certificate
+	err := protocol.ListenAndServe(serverPort, false)
 
 	if errors.Is(err, http.ErrServerClosed) {
-		log.Printf("server closed")
+		slog.Info("Server closed")
 	} else if err != nil {
-		log.Printf("error starting server: %s\n", err)
+		slog.Error("Failed to start the server", "err", err)
 	}
 
 	serverRunning <- true
@@ -42,8 +75,6 @@ func routeCoordenator(writer http.ResponseWriter, request *http.Request) {
 	switch request.Method {
 	case http.MethodPost:
 		{	
-			// Print on Function routeCoordenator MethodPost
-			fmt.Println("routeCoordenator MethodPost")
 			target := make(map[string]string)
 
 			buf, _ := io.ReadAll(request.Body)
@@ -68,59 +99,34 @@ func routeCoordenator(writer http.ResponseWriter, request *http.Request) {
 				writer.WriteHeader(http.StatusNotFound)
 				return
 			}
-			consistentHashNode := healthyNodes[0]
-
-			fmt.Println("list_id to get the node using consistent hashing: ", listId)
-			
-			fmt.Println("Getted consistentHashNode: ", consistentHashNode)
-			//print the healthyNodes
-			fmt.Println("Getted healthyNodes: ", healthyNodes)
-			fmt.Println("Getted consistentHashNode: ", healthyNodes[0])
-			//print the healthyNodes
 			// enter the bounded consistent hashing selection on SelectNodeFromList
 			
 			cons_hash_req_count_node := roundRobinBalancer.requestCount[healthyNodes[0].Id]
-			// print the request count of the node
-			fmt.Println("request count of node: ", cons_hash_req_count_node,"from node: ", healthyNodes[0].Id)
 
 			node := roundRobinBalancer.SelectNodeFromList(healthyNodes,threshold,cons_hash_req_count_node)
 			roundRobinBalancer.IncrementRequestCount(node.Id)
-			proxy := httputil.NewSingleHostReverseProxy(&url.URL{
-				Scheme: "http",
-				Host:   fmt.Sprintf("%s:%s", node.Address, node.Port),
-			})
-			
-			proxy.ServeHTTP(writer, request)
+			proxyToNode(writer, request, node)
 			return
 		}
 
 	case http.MethodPut:
 		{	
-			// Print on Function routeOperation MethodPut
-			fmt.Println("routeCoordenator MethodPut")
-			var target protocol.ShoppingListOperation
-
 			buf, _ := io.ReadAll(request.Body)
-			rdr1 := io.NopCloser(bytes.NewBuffer(buf))
-			rdr2 := io.NopCloser(bytes.NewBuffer(buf))
+			request.Body = io.NopCloser(bytes.NewBuffer(buf))
 
-			request.Body = rdr2
+			// The list can be sent either in JSON or in the binary encoding
+			target, err := protocol.DecodeOperation(buf, request.Header.Get("Content-Type"))
 
-			decoded, target := protocol.DecodeRequestBody(writer, rdr1, target)
-
-			if !decoded {
+			if err != nil {
+				protocol.FailedToDecodeJSON(writer)
 				return
 			}
 			
-			fmt.Println("list_id to get the node using consistent hashing: ", target.ListId)
 			// the healthyNodes are the nodes that are alive and they by order on the healthyNodes array from the ring
 			
 			healthyNodes := ring.GetHealthyNodesForID(target.ListId)
-			// print len of healthyNodes
-			fmt.Println("len of healthyNodes: ", len(healthyNodes))
 			if len(healthyNodes) == 0 {
-				// print if the len of healthyNodes is 0 then return 404
-				fmt.Println("len of healthyNodes is 0 then return 404")
+				slog.WarnContext(request.Context(), "No healthy node for the list", "list_id", target.ListId)
 				writer.WriteHeader(http.StatusNotFound)
 				return
 			}
@@ -128,151 +134,113 @@ func routeCoordenator(writer http.ResponseWriter, request *http.Request) {
 			
 			
 
-			// print the list_id to determine the node using consistent hashing
-			fmt.Println("list_id to get the node using consistent hashing: ", target.ListId)
-			
-			fmt.Println("Getted healthyNodes: ", healthyNodes)
-			fmt.Println("Getted consistentHashNode: ", healthyNodes[0])
-			//print the healthyNodes
 			// enter the bounded consistent hashing selection on SelectNodeFromList
 			
 			cons_hash_req_count_node := roundRobinBalancer.requestCount[healthyNodes[0].Id]
-			// print the request count of the node
-			fmt.Println("request count of node: ", cons_hash_req_count_node,"from node: ", healthyNodes[0].Id)
 
 			node := roundRobinBalancer.SelectNodeFromList(healthyNodes,threshold,cons_hash_req_count_node)
-			// print the node selected
-			fmt.Println("Chosen Getted inside Coordenator Method Put node: ", node)
-			//print node address and port
-			fmt.Println("Chosen Getted inside Coordenator Method Put node address: ", node.Address, " and port: ", node.Port)
 			roundRobinBalancer.IncrementRequestCount(node.Id)
-			proxy := httputil.NewSingleHostReverseProxy(&url.URL{
-				Scheme: "http",
-				Host:   fmt.Sprintf("%s:%s", node.Address, node.Port),
-			})
-			
-			proxy.ServeHTTP(writer, request)
+			proxyToNode(writer, request, node)
 			return
 		}
 
+	case http.MethodDelete:
+		{
+			// Deletes say their list in the query
+			routeByListQuery(writer, request)
+		}
 	}
 }
 
-func routeOperation(writer http.ResponseWriter, request *http.Request) {
-	rand.Seed(time.Now().UnixNano())//enforce random seed for each request to avoid same node selection "random pattern" for each request
-	
-	switch request.Method {
-	case http.MethodPost:
-		{	
+// Routes the requests that say their list in the list_id query parameter, like the history, the share tokens
+// and the ACL of a list. The nodes check the method
+func routeByListQuery(writer http.ResponseWriter, request *http.Request) {
+	listId := request.URL.Query().Get("list_id")
 
-			// Print on Function routeOperation MethodPost
-			fmt.Println("routeOperation MethodPost")
-			target := make(map[string]string)
-			decoded, target := protocol.DecodeRequestBody(writer, request.Body, target)
-
-			if !decoded {
-				return
-			}
-
-			var listId string = target["list_id"]
+	if listId == "" {
+		protocol.RequestWithWrongFormat(writer)
+		return
+	}
 
-			
-			// the healthyNodes are the nodes that are alive and they by order on the healthyNodes array from the ring
-			healthyNodes := ring.GetHealthyNodesForID(listId)
-			if len(healthyNodes) == 0 {
-				writer.WriteHeader(http.StatusNotFound)
-				return
-			}
-			// so we get the first node from the healthyNodes array ( the first on encounter on the ring for list_id)
-			
-			
-			
-			fmt.Println("list_id to get the node using consistent hashing: ", listId)
-			
-			fmt.Println("Getted consistentHashNode: ", healthyNodes[0])
-			//print the healthyNodes
-			// enter the bounded consistent hashing selection on SelectNodeFromList
-			
-			cons_hash_req_count_node := roundRobinBalancer.requestCount[healthyNodes[0].Id]
-			// print the request count of the node
-			fmt.Println("request count of node: ", cons_hash_req_count_node,"from node: ", healthyNodes[0].Id)
+	// The request is handled by a coordinator of the list, chosen like for the list itself
+	healthyNodes := ring.GetHealthyNodesForID(listId)
+	if len(healthyNodes) == 0 {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-			node := roundRobinBalancer.SelectNodeFromList(healthyNodes,threshold,cons_hash_req_count_node)
-			
-			roundRobinBalancer.IncrementRequestCount(node.Id)
-			proxy := httputil.NewSingleHostReverseProxy(&url.URL{
-				Scheme: "http",
-				Host:   fmt.Sprintf("%s:%s", node.Address, node.Port),
-			})
-			
-			proxy.ServeHTTP(writer, request)
-			// print the increment request count of the node
-			fmt.Println("request count of node: ", roundRobinBalancer.requestCount[node.Id],"from node: ", node.Id)
+	cons_hash_req_count_node := roundRobinBalancer.requestCount[healthyNodes[0].Id]
 
-			return
+	node := roundRobinBalancer.SelectNodeFromList(healthyNodes, threshold, cons_hash_req_count_node)
+	roundRobinBalancer.IncrementRequestCount(node.Id)
+	proxyToNode(writer, request, node)
+}
 
+/**
+ * Forwards a client request to a node, signed by the load balancer so the node trusts it
+ */
+func proxyToNode(writer http.ResponseWriter, request *http.Request, node *hash_ring.NodeInfo) {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		protocol.RequestWithWrongFormat(writer)
+		return
+	}
+	request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: protocol.Scheme(),
+		Host:   fmt.Sprintf("%s:%s", node.Address, node.Port),
+	})
+	proxy.Transport = protocol.Transport()
+
+	slog.InfoContext(request.Context(), "Proxying a request", "method", request.Method, "path", request.URL.Path, "node", node.Id)
+
+	ctx, span := tracing.Start(request.Context(), "proxy", tracing.SPAN_KIND_CLIENT, "node", node.Id)
+	defer span.End()
+
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		logging.PropagateRequestID(ctx, req)
+		tracing.Inject(ctx, req)
+
+		// The API token is only for the load balancer
+		req.Header.Del("Authorization")
+		// A client can not pass for another machine of the cluster
+		if len(protocol.ClusterSecret) > 0 {
+			protocol.SignRequest(protocol.ClusterSecret, protocol.LocalPeer, req, body, time.Now())
+		} else {
+			req.Header.Set(protocol.PEER_HEADER, protocol.LocalPeer)
 		}
-	case http.MethodPut:
-		{
-			// Print on Function routeOperation MethodPut
-			fmt.Println("routeOperation MethodPut")
-
-			var target protocol.ShoppingListOperation
-
-			decoded, target := protocol.DecodeRequestBody(writer, request.Body, target)
-
-			if !decoded {
-				return
-			}
-
-			
-
-			
-			// the healthyNodes are the nodes that are alive and they by order on the healthyNodes array from the ring
-			healthyNodes := ring.GetHealthyNodesForID(target.ListId)
-			if len(healthyNodes) == 0 {
-				writer.WriteHeader(http.StatusNotFound)
-				return
-			}
-			// so we get the first node from the healthyNodes array ( the first on encounter on the ring for list_id)
-			
-			consistentHashNode := healthyNodes[0]
-			
-			
-			fmt.Println("list_id to get the node using consistent hashing: ", target.ListId)
-			
-			fmt.Println("Getted consistentHashNode: ", consistentHashNode)
-			fmt.Println("Getted consistentHashNode: ", healthyNodes[0])
-			//print the healthyNodes
-			// enter the bounded consistent hashing selection on SelectNodeFromList
-			
-			cons_hash_req_count_node := roundRobinBalancer.requestCount[healthyNodes[0].Id]
-			// print the request count of the node
-			fmt.Println("request count of node: ", cons_hash_req_count_node,"from node: ", healthyNodes[0].Id)
+	}
 
-			node := roundRobinBalancer.SelectNodeFromList(healthyNodes,threshold,cons_hash_req_count_node)
-			roundRobinBalancer.IncrementRequestCount(node.Id)
-			proxy := httputil.NewSingleHostReverseProxy(&url.URL{
-				Scheme: "http",
-				Host:   fmt.Sprintf("%s:%s", node.Address, node.Port),
-			})
-			
-			proxy.ServeHTTP(writer, request)
-			// print the increment request count of the node
-			fmt.Println("request count of node: ", roundRobinBalancer.requestCount[node.Id],"from node: ", node.Id)
+	// The load balancer already answered with the id of the request, the node echoes the same one
+	proxy.ModifyResponse = func(response *http.Response) error {
+		response.Header.Del(logging.REQUEST_ID_HEADER)
+		span.SetAttributes("http.status_code", response.StatusCode)
+		return nil
+	}
 
-			return
-		}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		slog.WarnContext(ctx, "Failed to proxy a request", "node", node.Id, "err", err)
+		span.SetError(err)
+		w.WriteHeader(http.StatusBadGateway)
 	}
+
+	proxy.ServeHTTP(writer, request)
 }
 
-func gossip() {
-	for {
-		for _, value := range ring.GetNodes() {
-			go gossipWith(value)
-		}
-		time.Sleep(1 * time.Second) // FIXME: This should not be like this :p
-	}
+// How often the load balancer gossips, with how many nodes, and how many at once. The nodes learn the ring
+// from it, so by default it gossips with every node
+var gossipConfig = scheduler.Config{Interval: 1 * time.Second, Jitter: 200 * time.Millisecond, Fanout: 0, Workers: 8}
+
+/**
+* Gossips with the nodes of the ring every round, until stop is closed
+ */
+func gossip(stop <-chan struct{}) {
+	scheduler.New("gossip", gossipConfig, func() []*hash_ring.NodeInfo {
+		return ring.GetNodeList("")
+	}, gossipWith).Run(stop)
 }
 
 func gossipWith(node *hash_ring.NodeInfo) {
@@ -284,19 +252,22 @@ func gossipWith(node *hash_ring.NodeInfo) {
 
 	jsonData, err := json.Marshal(gossipMaterial)
 	if err != nil {
-		log.Printf("Error happened in JSON marshal. Err: %s", err)
+		slog.Error("Failed to marshal the gossip", "err", err)
 		node.GossipLock.Unlock()
 		return
 	}
 
+	start := time.Now()
 	response, err2 := protocol.SendRequestWithData(http.MethodPost, node.Address, node.Port, "/gossip", jsonData)
 	if err2 != nil {
+		observeGossip(node, start, "unreachable")
+
 		// If cannot gossip four consecutive times then assume the node is dead
 		if node.DeadCounter < 3 {
 			node.DeadCounter++
 		} else if node.DeadCounter == 3 {
-			node.Status = hash_ring.NODE_UNRESPONSIVE
-			log.Printf("%s set to UNRESPONSIVE\n", node.Id)
+			setNodeStatus(node, hash_ring.NODE_UNRESPONSIVE)
+			slog.Warn("Node set to unresponsive", "peer", node.Id, "err", err2)
 			node.DeadCounter++
 		}
 
@@ -306,11 +277,16 @@ func gossipWith(node *hash_ring.NodeInfo) {
 
 	// Await for response to get the node's status
 	if response.StatusCode == 200 {
+		observeGossip(node, start, "ok")
+		node.SetLastGossip(time.Now())
+
 		node.DeadCounter = 0
 		if node.Status != hash_ring.NODE_OK {
-			node.Status = hash_ring.NODE_OK
-			log.Printf("%s set to OK\n", node.Id)
+			setNodeStatus(node, hash_ring.NODE_OK)
+			slog.Info("Node set to OK", "peer", node.Id)
 		}
+	} else {
+		observeGossip(node, start, "refused")
 	}
 
 	node.GossipLock.Unlock()
@@ -333,10 +309,23 @@ func addNode(writer http.ResponseWriter, request *http.Request) {
 				return
 			}
 
+			// A node can only add itself
+			if len(protocol.ClusterSecret) > 0 && request.Header.Get(protocol.PEER_HEADER) != protocol.PeerId(target["address"], target["port"]) {
+				writer.WriteHeader(http.StatusForbidden)
+				writer.Write([]byte("A node can only add itself to the cluster."))
+				return
+			}
+
 			var isServer bool = target["address"] == serverHostname && target["port"] == serverPort
 
 			ring.AddNode(target["address"], target["port"], isServer)
-			jsonData := encodeRingState()
+			jsonData, err := encodeRingState()
+			if err != nil {
+				slog.ErrorContext(request.Context(), "Failed to encode the ring", "err", err)
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			slog.InfoContext(request.Context(), "Node added to the ring", "peer", protocol.PeerId(target["address"], target["port"]))
 
 			roundRobinBalancer.AddNode(target["address"], target["port"])
 			// adding node initially in map of request count
@@ -345,31 +334,49 @@ func addNode(writer http.ResponseWriter, request *http.Request) {
 			writer.Header().Set("Content-Type", "application/json")
 			writer.WriteHeader(http.StatusAccepted)
 			writer.Write(jsonData)
-			sendToRoundRobinBalancer(target["address"], target["port"])
+			sendToRoundRobinBalancer(context.WithoutCancel(request.Context()), target["address"], target["port"])
 			return
 		}
 	}
 }
 
-func encodeRingState() []byte {
+/**
+ * Answers with the nodes of the ring, their vnodes and partitions and when the last gossip with each succeeded
+ */
+func routeRing(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		protocol.WrongRequestType(writer)
+		return
+	}
+
+	jsonData, err := json.Marshal(ring.Describe())
+	if err != nil {
+		slog.ErrorContext(request.Context(), "Failed to marshal the ring", "err", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(jsonData)
+}
+
+func encodeRingState() ([]byte, error) {
 	nodesOnTheRing := ring.GetNodes()
 
-	nodesData := make(map[string][]map[string]string)
-	nodesData["nodes"] = make([]map[string]string, len(nodesOnTheRing))
+	nodesData := protocol.JoinResponse{
+		Nodes:   make([]map[string]string, len(nodesOnTheRing)),
+		History: &historyRetention,
+	}
 
 	for _, value := range ring.GetNodes() {
-		nodesData["nodes"] = append(nodesData["nodes"], map[string]string{"address": value.Address, "port": value.Port})
+		nodesData.Nodes = append(nodesData.Nodes, map[string]string{"address": value.Address, "port": value.Port})
 	}
 
-	jsonData, err := json.Marshal(nodesData)
-
-	if err != nil {
-		log.Fatalf("Error happened in JSON marshal. Err: %s", err)
-	}
-	return jsonData
+	return json.Marshal(nodesData)
 }
 
-func sendToRoundRobinBalancer(address string, port string) {
+func sendToRoundRobinBalancer(ctx context.Context, address string, port string) {
 	var1 := ring.GetNodes()
 	nodes := make([]*hash_ring.NodeInfo, 0)
 	for _, value := range var1 {
@@ -384,19 +391,20 @@ func sendToRoundRobinBalancer(address string, port string) {
 	target["port"] = port
 	j, _ := json.Marshal(target)
 
-	data, err := protocol.SendRequestWithData(http.MethodPut, node.Address, node.Port, "/node/add", j)
+	data, err := protocol.SendRequestWithContext(ctx, http.MethodPut, node.Address, node.Port, "/node/add", j, protocol.JSON_CONTENT_TYPE, protocol.JSON_CONTENT_TYPE)
 	if err != nil {
+		slog.WarnContext(ctx, "Failed to sync the rings", "peer", node.Id, "err", err)
 		return
 	}
 
 	if data.StatusCode == 202 {
-		log.Println("Rings are in sync")
+		slog.InfoContext(ctx, "Rings are in sync", "peer", node.Id)
 	} else {
 		body, err := io.ReadAll(data.Body)
 		if err != nil {
-			log.Println("Failed to sync the rings")
+			slog.WarnContext(ctx, "Failed to sync the rings", "peer", node.Id, "status", data.StatusCode)
 		} else {
-			log.Println("Failed to sync the rings:", string(body))
+			slog.WarnContext(ctx, "Failed to sync the rings", "peer", node.Id, "status", data.StatusCode, "reason", string(body))
 		}
 		return
 	}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sdle.com/mod/hash_ring"
+	"sdle.com/mod/protocol"
+)
+
+var testNodes = []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"}
+
+/**
+ * Starts the load balancer with a ring of three healthy nodes
+ */
+func setupTestRing(t *testing.T) {
+	protocol.LocalPeer = protocol.LOAD_BALANCER_PEER
+
+	ring = hash_ring.HashRing{}
+	ring.Initialize()
+	for _, port := range []string{"9001", "9002", "9003"} {
+		ring.AddNode("127.0.0.1", port, false)
+	}
+	for _, node := range ring.GetNodes() {
+		setNodeStatus(node, hash_ring.NODE_OK)
+	}
+}
+
+/**
+ * Asks the load balancer for the ring, as the given peer of the cluster, or unsigned if peer is empty
+ */
+func describeRing(t *testing.T, peer string) (*httptest.ResponseRecorder, hash_ring.RingDescription) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/ring", nil)
+	if peer != "" {
+		protocol.SignRequest(protocol.ClusterSecret, peer, req, nil, time.Now())
+	}
+	recorder := httptest.NewRecorder()
+	protocol.RequireClientCert(protocol.RequireCluster(nil, routeRing))(recorder, req)
+
+	var description hash_ring.RingDescription
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &description); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder, description
+}
+
+/**
+ * The partitions each node of the ring stores, by node id
+ */
+func partitionsByNode(description hash_ring.RingDescription) map[string][]string {
+	partitions := make(map[string][]string)
+	for _, node := range description.Nodes {
+		partitions[node.Id] = node.Partitions
+	}
+	return partitions
+}
+
+func TestRingIsDescribedToTheCluster(t *testing.T) {
+	setupTestRing(t)
+
+	defer func(secret []byte) { protocol.ClusterSecret = secret }(protocol.ClusterSecret)
+	protocol.ClusterSecret = []byte("secret")
+
+	if recorder, _ := describeRing(t, ""); recorder.Code != http.StatusUnauthorized {
+		t.Error("expected an unsigned request to be refused, got", recorder.Code)
+	}
+
+	recorder, description := describeRing(t, protocol.HEALTH_CHECKER_PEER)
+	if recorder.Code != http.StatusOK {
+		t.Fatal("expected the ring, got", recorder.Code, recorder.Body.String())
+	}
+	if description.ReplicationFactor != 2 || len(description.Nodes) != len(testNodes) {
+		t.Fatalf("expected %d nodes with a replication factor of 2, got %+v", len(testNodes), description)
+	}
+	for i, node := range description.Nodes {
+		if node.Id != testNodes[i] || node.Status != hash_ring.NODE_OK.String() {
+			t.Errorf("expected the healthy node %s, got %+v", testNodes[i], node)
+		}
+		if len(node.Vnodes) != description.ReplicationFactor || len(node.Partitions) == 0 {
+			t.Errorf("%s: expected %d vnodes and some partitions, got %+v", node.Id, description.ReplicationFactor, node)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/ring", nil)
+	protocol.SignRequest(protocol.ClusterSecret, protocol.HEALTH_CHECKER_PEER, req, nil, time.Now())
+	recorder = httptest.NewRecorder()
+	routeRing(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Error("expected only GET to be answered, got", recorder.Code)
+	}
+}
+
+func TestPartitionsFollowTheStatusOfTheNodes(t *testing.T) {
+	setupTestRing(t)
+
+	_, healthy := describeRing(t, protocol.HEALTH_CHECKER_PEER)
+	before := partitionsByNode(healthy)
+
+	// The partitions of an unresponsive node are stored by the others until it is back
+	down := ring.GetNodes()[testNodes[2]]
+	setNodeStatus(down, hash_ring.NODE_UNRESPONSIVE)
+
+	_, description := describeRing(t, protocol.HEALTH_CHECKER_PEER)
+	partitions := partitionsByNode(description)
+	if len(partitions[down.Id]) != 0 {
+		t.Error("an unresponsive node should store no partition, got", partitions[down.Id])
+	}
+
+	vnodes := 0
+	for _, node := range description.Nodes {
+		vnodes += len(node.Vnodes)
+	}
+	for _, id := range testNodes[:2] {
+		// With two healthy nodes left and a replication factor of 2, each of them stores every partition
+		if len(partitions[id]) != vnodes {
+			t.Errorf("%s: expected the %d partitions of the ring, got %d", id, vnodes, len(partitions[id]))
+		}
+	}
+
+	setNodeStatus(down, hash_ring.NODE_OK)
+
+	_, description = describeRing(t, protocol.HEALTH_CHECKER_PEER)
+	after := partitionsByNode(description)
+	for _, id := range testNodes {
+		if len(after[id]) != len(before[id]) {
+			t.Errorf("%s: expected the %d partitions it stored before, got %d", id, len(before[id]), len(after[id]))
+		}
+	}
+}
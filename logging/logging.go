@@ -0,0 +1,159 @@
+// Package logging sets up the structured logger of a machine of the cluster and keeps the id of the request
+// each log line belongs to, so a request can be followed from the load balancer across the nodes.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The header the id of a request is sent in, from the load balancer to the nodes and between the nodes
+const REQUEST_ID_HEADER string = "X-Request-Id"
+
+// The longest request id that is accepted from another machine
+const MAX_REQUEST_ID_LENGTH = 64
+
+type requestIDKey struct{}
+
+/**
+* Makes the logger of this machine the default one, writing to stderr at the level in LOG_LEVEL (debug, info,
+* warn or error, info by default) in the format in LOG_FORMAT (text or json, text by default). Every line
+* has the component and attrs, and the request id of the context it was logged with
+ */
+func Setup(component string, attrs ...any) error {
+	level, err := ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return err
+	}
+
+	handler, err := newHandler(os.Stderr, os.Getenv("LOG_FORMAT"), level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler).With("component", component).With(attrs...))
+	return nil
+}
+
+/**
+* Parses a level name, an empty one is info
+ */
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q, it must be debug, info, warn or error", name)
+}
+
+func newHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return contextHandler{slog.NewTextHandler(w, options)}, nil
+	case "json":
+		return contextHandler{slog.NewJSONHandler(w, options)}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, it must be text or json", format)
+}
+
+// contextHandler adds the request id of the context to every line
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+/**
+* Returns a copy of the context carrying the request id
+ */
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+/**
+* Returns the request id the context carries, or an empty string
+ */
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+/**
+* Generates a new request id
+ */
+func NewRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+/**
+* A request id sent by another machine is only kept if it can not break a log line
+ */
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+* Gives every request an id, the one in its REQUEST_ID_HEADER when trustHeader is set and the header is valid,
+* or a new one. The id is put in the context of the request, in its header so it is forwarded when the request
+* is proxied, and in the header of the response
+ */
+func HandleRequestID(trustHeader bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !trustHeader || !validRequestID(id) {
+			id = NewRequestID()
+		}
+
+		r.Header.Set(REQUEST_ID_HEADER, id)
+		w.Header().Set(REQUEST_ID_HEADER, id)
+
+		next(w, r.WithContext(WithRequestID(r.Context(), id)))
+	}
+}
+
+/**
+* Sets the request id of the context on a request to another machine
+ */
+func PropagateRequestID(ctx context.Context, req *http.Request) {
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set(REQUEST_ID_HEADER, id)
+	}
+}
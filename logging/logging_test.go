@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	levels := map[string]slog.Level{"": slog.LevelInfo, "DEBUG": slog.LevelDebug, "warn": slog.LevelWarn, "error": slog.LevelError}
+	for name, expected := range levels {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("Expected %q to be %s, got %s (%v)", name, expected, level, err)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be refused")
+	}
+}
+
+func TestRequestIDIsLogged(t *testing.T) {
+	var out bytes.Buffer
+	handler, err := newHandler(&out, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(handler)
+
+	logger.InfoContext(WithRequestID(context.Background(), "abc"), "wrote list", "list_id", "l1")
+	logger.DebugContext(context.Background(), "hidden")
+
+	if !strings.Contains(out.String(), `"request_id":"abc"`) || !strings.Contains(out.String(), `"list_id":"l1"`) {
+		t.Errorf("Expected the request id and the attributes in the line, got %s", out.String())
+	}
+	if strings.Contains(out.String(), "hidden") {
+		t.Errorf("Expected lines below the level to be dropped, got %s", out.String())
+	}
+}
+
+func TestHandleRequestID(t *testing.T) {
+	var seen string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "/list", nil)
+	request.Header.Set(REQUEST_ID_HEADER, "from-peer")
+	recorder := httptest.NewRecorder()
+	HandleRequestID(true, next)(recorder, request)
+	if seen != "from-peer" || recorder.Header().Get(REQUEST_ID_HEADER) != "from-peer" {
+		t.Errorf("Expected the trusted id to be kept, got %q", seen)
+	}
+
+	request = httptest.NewRequest(http.MethodPut, "/list", nil)
+	request.Header.Set(REQUEST_ID_HEADER, "from-client")
+	HandleRequestID(false, next)(httptest.NewRecorder(), request)
+	if seen == "from-client" || seen == "" || request.Header.Get(REQUEST_ID_HEADER) != seen {
+		t.Errorf("Expected a new id to replace the client's, got %q", seen)
+	}
+
+	request = httptest.NewRequest(http.MethodPut, "/list", nil)
+	request.Header.Set(REQUEST_ID_HEADER, "bad\nid")
+	HandleRequestID(true, next)(httptest.NewRecorder(), request)
+	if seen == "bad\nid" || seen == "" {
+		t.Errorf("Expected an invalid id to be replaced, got %q", seen)
+	}
+}
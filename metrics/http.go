@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The requests every machine served, by route, method and status code
+var httpRequests = NewCounterVec("http_requests_total", "Requests served, by route, method and status code.", "route", "method", "code")
+
+// How long the requests took, by route and method
+var httpRequestDuration = NewHistogramVec("http_request_duration_seconds", "How long the requests took to serve, in seconds.", DefaultBuckets, "route", "method")
+
+// statusRecorder keeps the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// Unwrap lets http.ResponseController reach the original writer, to flush proxied responses
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+/**
+* Counts the requests to a route and how long they take. The route is given rather than taken from the
+* request, so paths with ids in them do not create a series each
+ */
+func InstrumentHandler(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(recorder.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	}
+}
@@ -0,0 +1,324 @@
+// Package metrics keeps the metrics of a machine of the cluster and serves them in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The content type of the text exposition format
+const CONTENT_TYPE string = "text/plain; version=0.0.4; charset=utf-8"
+
+// The buckets of the latency histograms, in seconds
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry is a set of metrics served together
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// The metrics of this machine, the ones created with the New functions
+var DefaultRegistry = &Registry{}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// desc describes a metric and the names of its labels
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+// series is the value of a metric for some values of its labels
+type series struct {
+	labelValues []string
+	value       float64
+	// Only for histograms
+	buckets []uint64
+	count   uint64
+}
+
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name string, help string, kind string, labels []string) vec {
+	return vec{desc: desc{name, help, kind, labels}, series: make(map[string]*series)}
+}
+
+/**
+* Returns the series of the given label values, creating it if needed. The caller must hold the lock
+ */
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+/**
+* Returns the series sorted by their label values, the caller must hold the lock
+ */
+func (v *vec) sorted() []*series {
+	sorted := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Join(sorted[i].labelValues, "\xff") < strings.Join(sorted[j].labelValues, "\xff")
+	})
+	return sorted
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), formatValue(s.value))
+	}
+}
+
+// CounterVec is a value that only goes up, for each value of its labels
+type CounterVec struct {
+	vec
+}
+
+// GaugeVec is a value that goes up and down, for each value of its labels
+type GaugeVec struct {
+	vec
+}
+
+// GaugeFunc is a value read when the metrics are served
+type GaugeFunc struct {
+	desc
+	read func() float64
+}
+
+// HistogramVec counts observations in buckets, for each value of its labels
+type HistogramVec struct {
+	vec
+	bounds []float64
+}
+
+/**
+* Adds a metric to the registry
+ */
+func (r *Registry) Register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+/**
+* Writes every metric of the registry in the text exposition format
+ */
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+/**
+* Creates a counter with the given labels in the default registry
+ */
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{newVec(name, help, "counter", labels)}
+	DefaultRegistry.Register(counter)
+	return counter
+}
+
+/**
+* Adds one to the counter of the given label values
+ */
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+/**
+* Adds a value to the counter of the given label values, it can not be negative
+ */
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("counter %s can not go down", c.name))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.get(labelValues).value += value
+}
+
+/**
+* Creates a gauge with the given labels in the default registry
+ */
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	gauge := &GaugeVec{newVec(name, help, "gauge", labels)}
+	DefaultRegistry.Register(gauge)
+	return gauge
+}
+
+/**
+* Sets the gauge of the given label values
+ */
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(labelValues).value = value
+}
+
+/**
+* Adds a value, which can be negative, to the gauge of the given label values
+ */
+func (g *GaugeVec) Add(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(labelValues).value += value
+}
+
+/**
+* Creates a gauge without labels in the default registry, whose value is read with read when it is served
+ */
+func NewGaugeFunc(name string, help string, read func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{desc: desc{name: name, help: help, kind: "gauge"}, read: read}
+	DefaultRegistry.Register(gauge)
+	return gauge
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.read()))
+}
+
+/**
+* Creates a histogram with the given bucket upper bounds and labels in the default registry
+ */
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+
+	histogram := &HistogramVec{newVec(name, help, "histogram", labels), bounds}
+	DefaultRegistry.Register(histogram)
+	return histogram
+}
+
+/**
+* Counts an observation in the histogram of the given label values
+ */
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+
+	// Only the first bucket that fits is counted, they are added up when written
+	if i := sort.SearchFloat64s(h.bounds, value); i < len(h.bounds) {
+		s.buckets[i]++
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+/**
+* Serves the metrics of the default registry
+ */
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+	DefaultRegistry.Write(w)
+}
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	registry := &Registry{}
+	counter := &CounterVec{newVec("test_total", "A test counter.", "counter", []string{"outcome"})}
+	registry.Register(counter)
+
+	counter.Inc("success")
+	counter.Add(2, "success")
+	counter.Inc("fail\"ed")
+
+	var out bytes.Buffer
+	registry.Write(&out)
+
+	expected := "# HELP test_total A test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{outcome=\"fail\\\"ed\"} 1\n" +
+		"test_total{outcome=\"success\"} 3\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	registry := &Registry{}
+	histogram := &HistogramVec{newVec("test_seconds", "A test histogram.", "histogram", []string{"peer"}), []float64{0.1, 1}}
+	registry.Register(histogram)
+
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(5, "a")
+
+	var out bytes.Buffer
+	registry.Write(&out)
+
+	expected := "# HELP test_seconds A test histogram.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{peer=\"a\",le=\"0.1\"} 1\n" +
+		"test_seconds_bucket{peer=\"a\",le=\"1\"} 2\n" +
+		"test_seconds_bucket{peer=\"a\",le=\"+Inf\"} 3\n" +
+		"test_seconds_sum{peer=\"a\"} 5.55\n" +
+		"test_seconds_count{peer=\"a\"} 3\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	registry := &Registry{}
+	value := 1.0
+	registry.Register(&GaugeFunc{desc: desc{name: "test_gauge", help: "A test gauge.", kind: "gauge"}, read: func() float64 { return value }})
+
+	value = 7
+	var out bytes.Buffer
+	registry.Write(&out)
+
+	if !strings.HasSuffix(out.String(), "test_gauge 7\n") {
+		t.Errorf("Expected the gauge to be read when written, got:\n%s", out.String())
+	}
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	handler := InstrumentHandler("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test?id=1", nil))
+
+	recorder := httptest.NewRecorder()
+	Handler(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Header().Get("Content-Type") != CONTENT_TYPE {
+		t.Errorf("Expected content type %q, got %q", CONTENT_TYPE, recorder.Header().Get("Content-Type"))
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "http_requests_total{route=\"/test\",method=\"GET\",code=\"418\"} 1\n") {
+		t.Errorf("Expected the request to be counted, got:\n%s", body)
+	}
+	if !strings.Contains(body, "http_request_duration_seconds_count{route=\"/test\",method=\"GET\"} 1\n") {
+		t.Errorf("Expected the request duration to be observed, got:\n%s", body)
+	}
+}
@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// The header a share token is sent in, it gives its role on the list to whoever sends it
+	SHARE_TOKEN_HEADER string = "X-Share-Token"
+	// The header the admin token is sent in, it overrides the access control of every list
+	ADMIN_TOKEN_HEADER string = "X-Admin-Token"
+)
+
+// The token that overrides the access control of every list, from ADMIN_TOKEN. It is meant for repair
+// tooling and only sent by the requests made with SendAdminRequest: nodes never send it to each other, replicas
+// trust the operations of the coordinators because RequireCluster verified they were signed by a node of their
+// ring. Empty means no override.
+var AdminToken = os.Getenv("ADMIN_TOKEN")
+
+var ErrInvalidShareToken = errors.New("invalid share token")
+var ErrExpiredShareToken = errors.New("expired share token")
+
+// ShareToken gives whoever holds it a role on a list until it expires, unless it is revoked.
+// It is signed by the cluster, so any coordinator can check it.
+type ShareToken struct {
+	Id      string `json:"id"`
+	ListId  string `json:"list_id"`
+	Role    string `json:"role"`
+	Expires int64  `json:"expires"` // Unix milliseconds
+}
+
+// ShareTokenResponse is what a coordinator answers when a share token is created
+type ShareTokenResponse struct {
+	Token string `json:"token"`
+	ShareToken
+}
+
+/**
+* Creates a share token for a list, with a random id
+ */
+func NewShareToken(listId string, role string, ttl time.Duration, at time.Time) ShareToken {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return ShareToken{
+		Id:      hex.EncodeToString(id),
+		ListId:  listId,
+		Role:    role,
+		Expires: at.Add(ttl).UnixMilli(),
+	}
+}
+
+/**
+* Signs a share token, the result is the token in base64 and its HMAC-SHA256 separated by a dot
+ */
+func SignShareToken(secret []byte, token ShareToken) (string, error) {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(shareTokenSignature(secret, encoded)), nil
+}
+
+/**
+* Checks the signature of a share token and that it has not expired, revocation is checked against the list
+ */
+func ParseShareToken(secret []byte, signed string, at time.Time) (ShareToken, error) {
+	var token ShareToken
+
+	encoded, signature, ok := strings.Cut(signed, ".")
+	if !ok || len(secret) == 0 {
+		return token, ErrInvalidShareToken
+	}
+
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, shareTokenSignature(secret, encoded)) {
+		return token, ErrInvalidShareToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(payload, &token) != nil {
+		return token, ErrInvalidShareToken
+	}
+
+	if at.UnixMilli() >= token.Expires {
+		return token, ErrExpiredShareToken
+	}
+
+	return token, nil
+}
+
+func shareTokenSignature(secret []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+/**
+* Indicates if the request carries the admin token, never true when no admin token is set
+ */
+func IsAdmin(r *http.Request) bool {
+	if AdminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(ADMIN_TOKEN_HEADER)), []byte(AdminToken)) == 1
+}
+
+/**
+* Only lets through the requests that carry the admin token, none when no admin token is set
+ */
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if AdminToken == "" {
+			w.WriteHeader(http.StatusNotImplemented)
+			w.Write([]byte("Admin routes are disabled, ADMIN_TOKEN is not set."))
+			return
+		}
+
+		if !IsAdmin(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Missing or invalid admin token."))
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareToken(t *testing.T) {
+	secret := []byte("secret")
+	at := time.UnixMilli(1000)
+
+	token := NewShareToken("list1", "editor", time.Minute, at)
+	signed, err := SignShareToken(secret, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseShareToken(secret, signed, at.Add(time.Second))
+	if err != nil || parsed != token {
+		t.Fatal("a signed token should parse to itself", err)
+	}
+
+	// Should not be accepted after it expires
+	if _, err := ParseShareToken(secret, signed, at.Add(time.Minute)); !errors.Is(err, ErrExpiredShareToken) {
+		t.Fatal("an expired token should be rejected", err)
+	}
+
+	// Should not be accepted with another secret, or without one
+	if _, err := ParseShareToken([]byte("other"), signed, at); !errors.Is(err, ErrInvalidShareToken) {
+		t.Fatal("a token signed with another secret should be rejected", err)
+	}
+	if _, err := ParseShareToken(nil, signed, at); !errors.Is(err, ErrInvalidShareToken) {
+		t.Fatal("a token should be rejected without a secret", err)
+	}
+
+	// Should not be accepted if the payload is changed
+	token.Role = "owner"
+	forged, _ := SignShareToken([]byte("other"), token)
+	payload, _, _ := strings.Cut(forged, ".")
+	_, signature, _ := strings.Cut(signed, ".")
+	if _, err := ParseShareToken(secret, payload+"."+signature, at); !errors.Is(err, ErrInvalidShareToken) {
+		t.Fatal("a tampered token should be rejected", err)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	defer func(token string) { AdminToken = token }(AdminToken)
+
+	handler := RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	status := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		if token != "" {
+			req.Header.Set(ADMIN_TOKEN_HEADER, token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	AdminToken = ""
+	if status("") != http.StatusNotImplemented {
+		t.Fatal("admin routes should be disabled without an admin token")
+	}
+
+	AdminToken = "secret"
+	if status("") != http.StatusUnauthorized || status("wrong") != http.StatusUnauthorized {
+		t.Fatal("a request without the admin token should be rejected")
+	}
+	if status("secret") != http.StatusOK {
+		t.Fatal("a request with the admin token should be let through")
+	}
+}
+
+func TestOnlyAdminRequestsCarryTheAdminToken(t *testing.T) {
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "secret"
+
+	tokens := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get(ADMIN_TOKEN_HEADER)
+	}))
+	defer server.Close()
+	address, port, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+
+	response, err := SendRequestWithContext(context.Background(), http.MethodPut, address, port, "/operation", nil, JSON_CONTENT_TYPE, JSON_CONTENT_TYPE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if token := <-tokens; token != "" {
+		t.Error("a request between nodes should not carry the admin token")
+	}
+
+	response, err = SendAdminRequest(context.Background(), http.MethodGet, address, port, "/admin/lists", nil, JSON_CONTENT_TYPE, JSON_CONTENT_TYPE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if token := <-tokens; token != "secret" {
+		t.Error("a request to an admin route should carry the admin token, got", token)
+	}
+}
@@ -0,0 +1,318 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The machine of the cluster that signed a request, the id of a node or LOAD_BALANCER_PEER
+	PEER_HEADER string = "X-Cluster-Peer"
+	// When a request was signed, in Unix milliseconds
+	TIMESTAMP_HEADER string = "X-Cluster-Timestamp"
+	// The HMAC-SHA256 of the request with the cluster secret, in hexadecimal
+	SIGNATURE_HEADER string = "X-Cluster-Signature"
+	// A random value, in hexadecimal, that tells a signed request from a replay of it
+	NONCE_HEADER string = "X-Cluster-Nonce"
+	// The client the load balancer authenticated, the one the nodes give the roles of. It is covered by the
+	// signature, so only the machines of the cluster can vouch for a client
+	AUTHENTICATED_CLIENT_HEADER string = "X-Authenticated-Client"
+
+	// The peer id the load balancer signs its requests with
+	LOAD_BALANCER_PEER string = "load_balancer"
+	// The peer id the health checker signs its requests with
+	HEALTH_CHECKER_PEER string = "health_checker"
+
+	// How far apart the clocks of two machines can be, a signed request older than this is rejected
+	MAX_CLOCK_SKEW time.Duration = 30 * time.Second
+)
+
+// The secret the machines of the cluster sign their requests to each other with, from CLUSTER_SECRET.
+// Empty means requests are neither signed nor checked.
+var ClusterSecret = []byte(os.Getenv("CLUSTER_SECRET"))
+
+// Whether the machines may run without a cluster secret, from INSECURE_CLUSTER=true. Nothing then tells a node
+// of the ring from anyone claiming to be one, so it is only meant for development on a trusted network.
+var InsecureCluster = os.Getenv("INSECURE_CLUSTER") == "true"
+
+// The peer id this machine signs its requests with, set when it starts
+var LocalPeer string = ""
+
+// APIToken lets a client through the load balancer, as the client id it is bound to
+type APIToken struct {
+	// Empty if the token is not bound to a client, it then only lets through the requests that need no client
+	ClientId string
+	Token    string
+}
+
+// The tokens clients must send to the load balancer, from API_TOKENS: tokens separated by commas, each one
+// written <client_id>:<token> to bind it to a client. Empty means clients are not checked.
+var APITokens = ParseAPITokens(os.Getenv("API_TOKENS"))
+
+var ErrUnsignedRequest = errors.New("unsigned request")
+var ErrInvalidSignature = errors.New("invalid request signature")
+var ErrStaleRequest = errors.New("stale request")
+var ErrReplayedRequest = errors.New("replayed request")
+var ErrNoClusterSecret = errors.New("CLUSTER_SECRET must be set, or INSECURE_CLUSTER=true on a trusted network")
+var ErrUnsignedAPITokens = errors.New("API_TOKENS needs CLUSTER_SECRET to be set")
+
+// The nonces of the signed requests this machine accepted, until the requests go stale
+var seenNonces = nonceCache{seen: make(map[string]time.Time)}
+
+type nonceCache struct {
+	lock sync.Mutex
+	// When each nonce can be forgotten, since a request signed with it would be stale
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+/**
+* Remembers a nonce until it expires, returns false if it was already seen
+ */
+func (cache *nonceCache) claim(nonce string, expires time.Time, at time.Time) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	// The expired nonces are forgotten every so often, so the cache holds about the requests of a clock skew
+	if at.Sub(cache.lastPrune) > MAX_CLOCK_SKEW {
+		for seen, seenExpires := range cache.seen {
+			if at.After(seenExpires) {
+				delete(cache.seen, seen)
+			}
+		}
+		cache.lastPrune = at
+	}
+
+	if _, ok := cache.seen[nonce]; ok {
+		return false
+	}
+	cache.seen[nonce] = expires
+	return true
+}
+
+/**
+* The peer id of a node, the same as its id in the ring
+ */
+func PeerId(address string, port string) string {
+	return fmt.Sprintf("%s:%s", address, port)
+}
+
+/**
+* Parses API tokens separated by commas, each one either a token or <client_id>:<token>
+ */
+func ParseAPITokens(value string) []APIToken {
+	tokens := make([]APIToken, 0)
+	for _, token := range strings.Split(value, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		if clientId, secret, bound := strings.Cut(token, ":"); bound {
+			tokens = append(tokens, APIToken{ClientId: clientId, Token: secret})
+		} else {
+			tokens = append(tokens, APIToken{Token: token})
+		}
+	}
+	return tokens
+}
+
+func requestSignature(secret []byte, method string, uri string, peer string, timestamp string, nonce string, client string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s\n%x", method, uri, peer, timestamp, nonce, client, bodyHash)
+	return mac.Sum(nil)
+}
+
+/**
+* Signs a request as the given peer, the signature covers its method, path, query, body, the client it is
+* made for and a new nonce, so it is only accepted once
+ */
+func SignRequest(secret []byte, peer string, req *http.Request, body []byte, at time.Time) {
+	timestamp := strconv.FormatInt(at.UnixMilli(), 10)
+
+	random := make([]byte, 16)
+	rand.Read(random)
+	nonce := hex.EncodeToString(random)
+
+	req.Header.Set(PEER_HEADER, peer)
+	req.Header.Set(TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(NONCE_HEADER, nonce)
+	req.Header.Set(SIGNATURE_HEADER, hex.EncodeToString(requestSignature(secret, req.Method, req.URL.RequestURI(), peer, timestamp, nonce, req.Header.Get(AUTHENTICATED_CLIENT_HEADER), body)))
+}
+
+/**
+* Checks the signature of a request, returns the peer that signed it. A request is only accepted once, a
+* replay of it is rejected. The body is read and put back
+ */
+func VerifyRequest(secret []byte, r *http.Request, at time.Time) (string, error) {
+	peer := r.Header.Get(PEER_HEADER)
+	timestamp := r.Header.Get(TIMESTAMP_HEADER)
+	nonce := r.Header.Get(NONCE_HEADER)
+	signature := r.Header.Get(SIGNATURE_HEADER)
+
+	if len(secret) == 0 || peer == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", ErrUnsignedRequest
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	if skew := at.Sub(time.UnixMilli(signedAt)); skew > MAX_CLOCK_SKEW || skew < -MAX_CLOCK_SKEW {
+		return "", ErrStaleRequest
+	}
+
+	decoded, err := hex.DecodeString(signature)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+	}
+
+	if !hmac.Equal(decoded, requestSignature(secret, r.Method, r.URL.RequestURI(), peer, timestamp, nonce, r.Header.Get(AUTHENTICATED_CLIENT_HEADER), body)) {
+		return "", ErrInvalidSignature
+	}
+
+	// Only a request with a valid signature takes its nonce, so forged ones can not block the real one
+	if !seenNonces.claim(nonce, time.UnixMilli(signedAt).Add(MAX_CLOCK_SKEW), at) {
+		return "", ErrReplayedRequest
+	}
+
+	return peer, nil
+}
+
+/**
+* Checks that this machine can run with the cluster secret it has. Without one any request can claim to come
+* from a node of the ring, whose operations the replicas take without checking their access, so it is refused
+* unless INSECURE_CLUSTER says the network is trusted. API tokens always need it: without the signature, any
+* request can claim the client the load balancer vouches for, and binding tokens to clients would be for nothing
+ */
+func CheckClusterSecret() error {
+	if len(ClusterSecret) == 0 && len(APITokens) > 0 {
+		return ErrUnsignedAPITokens
+	}
+	if len(ClusterSecret) == 0 && !InsecureCluster {
+		return ErrNoClusterSecret
+	}
+	return nil
+}
+
+type clusterPeerKey struct{}
+
+/**
+* Returns the machine of the cluster RequireCluster let a request through from, empty if it did not. Without a
+* cluster secret it is the peer the request claims, which nothing checks
+ */
+func ClusterPeer(r *http.Request) string {
+	peer, _ := r.Context().Value(clusterPeerKey{}).(string)
+	return peer
+}
+
+/**
+* Only lets through the requests signed with the cluster secret, and by a known peer when known is given.
+* Every request is let through when there is no cluster secret
+ */
+func RequireCluster(known func(peer string) bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(ClusterSecret) == 0 {
+			next(w, r.WithContext(context.WithValue(r.Context(), clusterPeerKey{}, r.Header.Get(PEER_HEADER))))
+			return
+		}
+
+		peer, err := VerifyRequest(ClusterSecret, r, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Requests must be signed by the cluster."))
+			return
+		}
+
+		if known != nil && !known(peer) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Unknown peer " + peer + "."))
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), clusterPeerKey{}, peer)))
+	}
+}
+
+/**
+* Finds the API token a request carries, as "Authorization: Bearer <token>"
+ */
+func FindAPIToken(tokens []APIToken, r *http.Request) (APIToken, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return APIToken{}, false
+	}
+
+	for _, valid := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(valid.Token)) == 1 {
+			return valid, true
+		}
+	}
+	return APIToken{}, false
+}
+
+/**
+* Indicates if a request carries one of the API tokens, as "Authorization: Bearer <token>"
+ */
+func HasAPIToken(tokens []APIToken, r *http.Request) bool {
+	_, ok := FindAPIToken(tokens, r)
+	return ok
+}
+
+/**
+* Only lets through the requests of clients with an API token, and vouches for the client the token is bound
+* to in the AUTHENTICATED_CLIENT_HEADER. Every request is let through when there are no tokens, and the
+* client id it claims is vouched for as it is
+ */
+func RequireAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Only the load balancer says who the client is
+		r.Header.Del(AUTHENTICATED_CLIENT_HEADER)
+
+		if len(APITokens) == 0 {
+			if clientId := r.Header.Get(CLIENT_ID_HEADER); ValidClientId(clientId) {
+				r.Header.Set(AUTHENTICATED_CLIENT_HEADER, clientId)
+			}
+			next(w, r)
+			return
+		}
+
+		token, ok := FindAPIToken(APITokens, r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Missing or invalid API token."))
+			return
+		}
+
+		if token.ClientId != "" {
+			r.Header.Set(AUTHENTICATED_CLIENT_HEADER, token.ClientId)
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,237 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signedRequest(secret []byte, peer string, body []byte, at time.Time) *http.Request {
+	req := httptest.NewRequest(http.MethodPut, "/operation?list_id=1", bytes.NewBuffer(body))
+	SignRequest(secret, peer, req, body, at)
+	return req
+}
+
+func TestSignedRequest(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"list_id":"1"}`)
+	at := time.Now()
+
+	req := signedRequest(secret, "node1:8000", body, at)
+	peer, err := VerifyRequest(secret, req, at.Add(time.Second))
+	if err != nil || peer != "node1:8000" {
+		t.Fatal("a signed request should be accepted", err)
+	}
+
+	// Should leave the body for the handler
+	read, _ := io.ReadAll(req.Body)
+	if !bytes.Equal(read, body) {
+		t.Fatal("the body should be put back")
+	}
+
+	// Should not be accepted with another secret
+	req = signedRequest(secret, "node1:8000", body, at)
+	if _, err := VerifyRequest([]byte("other"), req, at); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a request signed with another secret should be rejected", err)
+	}
+
+	// Should not be accepted if the body, the query or the peer are changed
+	req = signedRequest(secret, "node1:8000", body, at)
+	req.Body = io.NopCloser(bytes.NewBufferString(`{"list_id":"2"}`))
+	if _, err := VerifyRequest(secret, req, at); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a request with another body should be rejected", err)
+	}
+
+	req = signedRequest(secret, "node1:8000", body, at)
+	req.URL.RawQuery = "list_id=2"
+	if _, err := VerifyRequest(secret, req, at); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a request with another query should be rejected", err)
+	}
+
+	req = signedRequest(secret, "node1:8000", body, at)
+	req.Header.Set(PEER_HEADER, LOAD_BALANCER_PEER)
+	if _, err := VerifyRequest(secret, req, at); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a request from another peer should be rejected", err)
+	}
+
+	req = signedRequest(secret, "node1:8000", body, at)
+	req.Header.Set(AUTHENTICATED_CLIENT_HEADER, "alice")
+	if _, err := VerifyRequest(secret, req, at); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a request for another client should be rejected", err)
+	}
+
+	req = signedRequest(secret, "node1:8000", body, at)
+	req.Header.Set(NONCE_HEADER, "00")
+	if _, err := VerifyRequest(secret, req, at); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a request with another nonce should be rejected", err)
+	}
+
+	// Should not be accepted long after it was signed, or unsigned
+	req = signedRequest(secret, "node1:8000", body, at)
+	if _, err := VerifyRequest(secret, req, at.Add(MAX_CLOCK_SKEW+time.Second)); !errors.Is(err, ErrStaleRequest) {
+		t.Fatal("a stale request should be rejected", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/operation", bytes.NewBuffer(body))
+	if _, err := VerifyRequest(secret, req, at); !errors.Is(err, ErrUnsignedRequest) {
+		t.Fatal("an unsigned request should be rejected", err)
+	}
+}
+
+func TestReplayedRequest(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"list_id":"1"}`)
+	at := time.Now()
+
+	req := signedRequest(secret, "node1:8000", body, at)
+	replay := req.Clone(req.Context())
+	replay.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	if _, err := VerifyRequest(secret, req, at); err != nil {
+		t.Fatal("a signed request should be accepted", err)
+	}
+	if _, err := VerifyRequest(secret, replay, at.Add(time.Second)); !errors.Is(err, ErrReplayedRequest) {
+		t.Fatal("a replayed request should be rejected", err)
+	}
+
+	// A forged request does not take the nonce of the real one
+	req = signedRequest(secret, "node1:8000", body, at)
+	forged := req.Clone(req.Context())
+	forged.Body = io.NopCloser(bytes.NewBufferString(`{"list_id":"2"}`))
+	if _, err := VerifyRequest(secret, forged, at); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("a request with another body should be rejected", err)
+	}
+	if _, err := VerifyRequest(secret, req, at); err != nil {
+		t.Fatal("the real request should still be accepted", err)
+	}
+
+	// The nonces are forgotten once the requests signed with them are stale
+	cache := nonceCache{seen: make(map[string]time.Time)}
+	cache.claim("nonce1", at.Add(MAX_CLOCK_SKEW), at)
+	cache.claim("nonce2", at.Add(3*MAX_CLOCK_SKEW), at.Add(2*MAX_CLOCK_SKEW))
+	if _, ok := cache.seen["nonce1"]; ok || len(cache.seen) != 1 {
+		t.Fatal("the expired nonces should be forgotten", cache.seen)
+	}
+}
+
+func TestRequireCluster(t *testing.T) {
+	previous := ClusterSecret
+	ClusterSecret = []byte("secret")
+	defer func() { ClusterSecret = previous }()
+
+	handler := RequireCluster(func(peer string) bool { return peer == "node1:8000" }, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		req  *http.Request
+		code int
+	}{
+		{signedRequest(ClusterSecret, "node1:8000", nil, time.Now()), http.StatusOK},
+		{signedRequest(ClusterSecret, "node2:8000", nil, time.Now()), http.StatusForbidden},
+		{signedRequest([]byte("other"), "node1:8000", nil, time.Now()), http.StatusUnauthorized},
+		{httptest.NewRequest(http.MethodGet, "/gossip", nil), http.StatusUnauthorized},
+	}
+
+	for i, c := range cases {
+		recorder := httptest.NewRecorder()
+		handler(recorder, c.req)
+		if recorder.Code != c.code {
+			t.Fatal("case", i, "expected", c.code, "got", recorder.Code)
+		}
+	}
+}
+
+func TestCheckClusterSecret(t *testing.T) {
+	defer func(secret []byte, insecure bool, tokens []APIToken) {
+		ClusterSecret, InsecureCluster, APITokens = secret, insecure, tokens
+	}(ClusterSecret, InsecureCluster, APITokens)
+
+	cases := []struct {
+		secret   string
+		insecure bool
+		tokens   string
+		err      error
+	}{
+		{"secret", false, "", nil},
+		{"secret", true, "", nil},
+		{"", false, "", ErrNoClusterSecret},
+		{"", true, "", nil},
+		{"secret", false, "alice:token1", nil},
+		{"", true, "alice:token1", ErrUnsignedAPITokens},
+	}
+
+	for i, c := range cases {
+		ClusterSecret, InsecureCluster, APITokens = []byte(c.secret), c.insecure, ParseAPITokens(c.tokens)
+		if err := CheckClusterSecret(); !errors.Is(err, c.err) {
+			t.Error("case", i, "expected", c.err, "got", err)
+		}
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	tokens := ParseAPITokens("token1, alice:token2,")
+	if len(tokens) != 2 || tokens[0] != (APIToken{Token: "token1"}) || tokens[1] != (APIToken{ClientId: "alice", Token: "token2"}) {
+		t.Fatal("unexpected tokens", tokens)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	if HasAPIToken(tokens, req) {
+		t.Fatal("a request without a token should be rejected")
+	}
+
+	req.Header.Set("Authorization", "Bearer token2")
+	if token, ok := FindAPIToken(tokens, req); !ok || token.ClientId != "alice" {
+		t.Fatal("a request with a token should be accepted as its client", token)
+	}
+
+	req.Header.Set("Authorization", "Bearer token3")
+	if HasAPIToken(tokens, req) {
+		t.Fatal("a request with an unknown token should be rejected")
+	}
+}
+
+func TestRequireAPIToken(t *testing.T) {
+	previous := APITokens
+	defer func() { APITokens = previous }()
+
+	var vouched string
+	handler := RequireAPIToken(func(w http.ResponseWriter, r *http.Request) {
+		vouched = r.Header.Get(AUTHENTICATED_CLIENT_HEADER)
+	})
+	request := func(token string, clientId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/list", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set(CLIENT_ID_HEADER, clientId)
+		// Only the load balancer can vouch for a client
+		req.Header.Set(AUTHENTICATED_CLIENT_HEADER, "mallory")
+
+		vouched = ""
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder
+	}
+
+	APITokens = ParseAPITokens("alice:token1,token2")
+	if recorder := request("", "alice"); recorder.Code != http.StatusUnauthorized {
+		t.Fatal("a request without a token should be rejected", recorder.Code)
+	}
+	if request("token1", "bob"); vouched != "alice" {
+		t.Fatal("the client the token is bound to should be vouched for, got", vouched)
+	}
+	if request("token2", "bob"); vouched != "" {
+		t.Fatal("a token bound to no client should vouch for none, got", vouched)
+	}
+
+	// Without tokens clients are not checked, and the id they claim is taken as it is
+	APITokens = nil
+	if request("", "bob"); vouched != "bob" {
+		t.Fatal("the claimed client should be vouched for without tokens, got", vouched)
+	}
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"net/http"
+
+	"sdle.com/mod/crdt_go"
+)
+
+// The header a client identifies itself with when writing a list, it is the actor it changes the list as
+const CLIENT_ID_HEADER string = "X-Client-Id"
+
+const MAX_CLIENT_ID_LENGTH int = 64
+
+/**
+* Checks if a client id can be used as an actor: letters, digits, '-', '_' and '.', not too long, and not the base actor
+ */
+func ValidClientId(clientId string) bool {
+	if clientId == "" || len(clientId) > MAX_CLIENT_ID_LENGTH || clientId == crdt_go.BASE_ACTOR {
+		return false
+	}
+
+	for _, c := range clientId {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+/**
+* Returns the client the request is made by: the one the load balancer authenticated, or the one an admin
+* claims. Empty if the request is made by no known client
+ */
+func AuthenticatedClient(r *http.Request) string {
+	if IsAdmin(r) {
+		if clientId := r.Header.Get(CLIENT_ID_HEADER); ValidClientId(clientId) {
+			return clientId
+		}
+	}
+
+	if clientId := r.Header.Get(AUTHENTICATED_CLIENT_HEADER); ValidClientId(clientId) {
+		return clientId
+	}
+	return ""
+}
+
+/**
+* Checks that the client id a request claims, if any, is the one it was authenticated as, answering with a
+* forbidden if it is not
+ */
+func ClaimsOwnClientId(w http.ResponseWriter, r *http.Request) bool {
+	claimed := r.Header.Get(CLIENT_ID_HEADER)
+	if claimed == "" || claimed == AuthenticatedClient(r) {
+		return true
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("The " + CLIENT_ID_HEADER + " header does not match the API token."))
+	return false
+}
+
+/**
+* Returns the id of the client that made the request, answering with an error if it is not authenticated or
+* claims to be another client
+ */
+func ClientId(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claimed := r.Header.Get(CLIENT_ID_HEADER)
+	clientId := AuthenticatedClient(r)
+
+	if (claimed == "" && clientId == "") || (claimed != "" && !ValidClientId(claimed)) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Missing or invalid " + CLIENT_ID_HEADER + " header."))
+		return "", false
+	}
+
+	if clientId == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("The client is not authenticated, its API token is not bound to a client id."))
+		return "", false
+	}
+
+	if !ClaimsOwnClientId(w, r) {
+		return "", false
+	}
+	return clientId, true
+}
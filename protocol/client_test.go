@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientId(t *testing.T) {
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "admin"
+
+	cases := []struct {
+		claimed       string
+		authenticated string
+		admin         bool
+		code          int
+		clientId      string
+	}{
+		{"alice", "alice", false, http.StatusOK, "alice"},
+		{"", "alice", false, http.StatusOK, "alice"},
+		{"", "", false, http.StatusBadRequest, ""},
+		{"not valid!", "alice", false, http.StatusBadRequest, ""},
+		{"alice", "", false, http.StatusUnauthorized, ""},
+		// A client can not claim to be another one
+		{"alice", "mallory", false, http.StatusForbidden, ""},
+		// But an admin can act as any client
+		{"alice", "", true, http.StatusOK, "alice"},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodPut, "/list", nil)
+		req.Header.Set(CLIENT_ID_HEADER, c.claimed)
+		req.Header.Set(AUTHENTICATED_CLIENT_HEADER, c.authenticated)
+		if c.admin {
+			req.Header.Set(ADMIN_TOKEN_HEADER, AdminToken)
+		}
+
+		recorder := httptest.NewRecorder()
+		recorder.Code = http.StatusOK
+		clientId, ok := ClientId(recorder, req)
+		if recorder.Code != c.code || clientId != c.clientId || ok != (c.code == http.StatusOK) {
+			t.Error("case", i, "expected", c.code, c.clientId, "got", recorder.Code, clientId)
+		}
+	}
+}
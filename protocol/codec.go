@@ -0,0 +1,248 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"sdle.com/mod/crdt_go"
+)
+
+const (
+	JSON_CONTENT_TYPE   string = "application/json"
+	BINARY_CONTENT_TYPE string = "application/x-quantum-list"
+)
+
+/**
+* Indicates if the body of a request or response with this Content-Type is in the binary encoding
+ */
+func IsBinaryContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == BINARY_CONTENT_TYPE
+}
+
+/**
+* Indicates if the client asked, through the Accept header, for the binary encoding
+ */
+func AcceptsBinary(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if IsBinaryContentType(strings.TrimSpace(accepted)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalBinary encodes the operation as the list id, the list in its canonical binary form, the transfers and the order.
+func (op ShoppingListOperation) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0)
+
+	appendString := func(s string) {
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+
+	appendString(op.ListId)
+
+	if op.Content == nil {
+		buf = binary.AppendUvarint(buf, 0)
+	} else {
+		content, err := op.Content.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(content)))
+		buf = append(buf, content...)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(op.Transfers)))
+	for _, transfer := range op.Transfers {
+		appendString(transfer.ItemName)
+		appendString(transfer.From)
+		appendString(transfer.To)
+		buf = binary.AppendUvarint(buf, uint64(transfer.Amount))
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(op.Order)))
+	for _, itemName := range op.Order {
+		appendString(itemName)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes an operation encoded by MarshalBinary.
+func (op *ShoppingListOperation) UnmarshalBinary(data []byte) error {
+	invalid := fmt.Errorf("%w: shopping list operation", crdt_go.ErrInvalidEncoding)
+
+	readUvarint := func() (uint64, bool) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, false
+		}
+		data = data[n:]
+		return v, true
+	}
+
+	readBytes := func() ([]byte, bool) {
+		n, ok := readUvarint()
+		if !ok || n > uint64(len(data)) {
+			return nil, false
+		}
+		value := data[:n]
+		data = data[n:]
+		return value, true
+	}
+
+	listId, ok := readBytes()
+	if !ok {
+		return invalid
+	}
+
+	content, ok := readBytes()
+	if !ok {
+		return invalid
+	}
+
+	decoded := ShoppingListOperation{ListId: string(listId)}
+
+	if len(content) > 0 {
+		decoded.Content = &crdt_go.ShoppingList{}
+		if err := decoded.Content.UnmarshalBinary(content); err != nil {
+			return err
+		}
+	}
+
+	numberOfTransfers, ok := readUvarint()
+	if !ok || numberOfTransfers > uint64(len(data)) {
+		return invalid
+	}
+
+	for i := uint64(0); i < numberOfTransfers; i++ {
+		itemName, ok1 := readBytes()
+		from, ok2 := readBytes()
+		to, ok3 := readBytes()
+		amount, ok4 := readUvarint()
+
+		if !(ok1 && ok2 && ok3 && ok4) || amount > uint64(^uint32(0)) {
+			return invalid
+		}
+
+		decoded.Transfers = append(decoded.Transfers, crdt_go.RightsTransfer{
+			ItemName: string(itemName),
+			From:     string(from),
+			To:       string(to),
+			Amount:   uint32(amount),
+		})
+	}
+
+	numberOfItems, ok := readUvarint()
+	if !ok || numberOfItems > uint64(len(data)) {
+		return invalid
+	}
+
+	for i := uint64(0); i < numberOfItems; i++ {
+		itemName, ok := readBytes()
+		if !ok {
+			return invalid
+		}
+		decoded.Order = append(decoded.Order, string(itemName))
+	}
+
+	if len(data) > 0 {
+		return invalid
+	}
+
+	*op = decoded
+
+	return nil
+}
+
+/**
+* Encodes an operation in the given content type, JSON if it is not the binary one
+ */
+func EncodeOperation(op ShoppingListOperation, contentType string) ([]byte, error) {
+	if IsBinaryContentType(contentType) {
+		return op.MarshalBinary()
+	}
+	return json.Marshal(op)
+}
+
+/**
+* Decodes an operation in the given content type, JSON if it is not the binary one
+ */
+func DecodeOperation(data []byte, contentType string) (ShoppingListOperation, error) {
+	var op ShoppingListOperation
+
+	if IsBinaryContentType(contentType) {
+		err := op.UnmarshalBinary(data)
+		return op, err
+	}
+
+	err := json.NewDecoder(bytes.NewReader(data)).Decode(&op)
+	return op, err
+}
+
+/**
+* Returns false if it fails to decode the body of the request into an operation, the encoding
+* being chosen by the request's Content-Type
+ */
+func DecodeOperationRequest(w http.ResponseWriter, r *http.Request) (bool, ShoppingListOperation) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		FailedToDecodeJSON(w)
+		return false, ShoppingListOperation{}
+	}
+
+	op, err := DecodeOperation(data, r.Header.Get("Content-Type"))
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to decode the operation", "err", err)
+
+		FailedToDecodeJSON(w)
+		return false, op
+	}
+
+	return true, op
+}
+
+/**
+* Decodes an operation from a response, the encoding being chosen by the response's Content-Type
+ */
+func DecodeOperationResponse(response *http.Response) (ShoppingListOperation, error) {
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return ShoppingListOperation{}, err
+	}
+
+	return DecodeOperation(data, response.Header.Get("Content-Type"))
+}
+
+/**
+* Writes an operation as the response, in binary if the client accepts it and JSON otherwise
+ */
+func WriteOperation(w http.ResponseWriter, r *http.Request, status int, op ShoppingListOperation) error {
+	contentType := JSON_CONTENT_TYPE
+	if AcceptsBinary(r) {
+		contentType = BINARY_CONTENT_TYPE
+	}
+
+	data, err := EncodeOperation(op, contentType)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err = w.Write(data)
+
+	return err
+}
@@ -0,0 +1,158 @@
+package protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How long the development certificates last
+const DEV_CERT_VALIDITY time.Duration = 365 * 24 * time.Hour
+
+// Names of the files of the development CA
+const (
+	DEV_CA_CERT_FILE string = "ca.pem"
+	DEV_CA_KEY_FILE  string = "ca-key.pem"
+)
+
+/**
+* Creates a self signed CA for development and tests, returns its certificate and key in PEM
+ */
+func GenerateCA(commonName string, at time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             at.Add(-time.Hour),
+		NotAfter:              at.Add(DEV_CERT_VALIDITY),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodeCert(der, key)
+}
+
+/**
+* Issues a certificate signed by a CA for the given hosts, it can be used both to serve and as a client
+ */
+func IssueCert(caCertPEM []byte, caKeyPEM []byte, commonName string, hosts []string, at time.Time) ([]byte, []byte, error) {
+	ca, err := tls.X509KeyPair(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    at.Add(-time.Hour),
+		NotAfter:     at.Add(DEV_CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodeCert(der, key)
+}
+
+/**
+* Writes a certificate for the given hosts into a directory, as <name>.pem and <name>-key.pem, signed by
+* the CA in the directory, which is created if there is none. Returns the configuration to use them
+ */
+func WriteDevCert(dir string, name string, hosts []string) (TLSConfig, error) {
+	config := TLSConfig{
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+		CAFile:   filepath.Join(dir, DEV_CA_CERT_FILE),
+	}
+	caKeyFile := filepath.Join(dir, DEV_CA_KEY_FILE)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return config, err
+	}
+
+	caCertPEM, err := os.ReadFile(config.CAFile)
+	var caKeyPEM []byte
+	if err == nil {
+		caKeyPEM, err = os.ReadFile(caKeyFile)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		caCertPEM, caKeyPEM, err = GenerateCA("Quantum List development CA", time.Now())
+		if err == nil {
+			err = writeCert(config.CAFile, caKeyFile, caCertPEM, caKeyPEM)
+		}
+	}
+	if err != nil {
+		return config, err
+	}
+
+	certPEM, keyPEM, err := IssueCert(caCertPEM, caKeyPEM, name, hosts, time.Now())
+	if err != nil {
+		return config, err
+	}
+
+	return config, writeCert(config.CertFile, config.KeyFile, certPEM, keyPEM)
+}
+
+func writeCert(certFile string, keyFile string, certPEM []byte, keyPEM []byte) error {
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, keyPEM, 0o600)
+}
+
+func encodeCert(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM, nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
@@ -0,0 +1,18 @@
+package protocol
+
+// The client id imports write the lists with, so the writes of an import can be told apart in their history
+const IMPORT_CLIENT_ID string = "import"
+
+// PartitionLists are the lists stored in a partition of the ring, and the nodes that store it
+type PartitionLists struct {
+	Partition string   `json:"partition"`
+	Nodes     []string `json:"nodes"`
+	ListIds   []string `json:"list_ids"`
+}
+
+// ClusterLists is what the load balancer answers when asked for every list of the cluster. The lists of
+// the unreachable nodes are missing unless another node stores them too.
+type ClusterLists struct {
+	Partitions  []PartitionLists `json:"partitions"`
+	Unreachable []string         `json:"unreachable,omitempty"`
+}
@@ -0,0 +1,15 @@
+package protocol
+
+import "time"
+
+// NodeHealth is what a node answers on GET /status, the state of the node the health checker shows next to
+// the ring the load balancer describes
+type NodeHealth struct {
+	Id string `json:"id"`
+	// Lists waiting to be handed off to the nodes they belong to
+	HintedHandoffBacklog int `json:"hinted_handoff_backlog"`
+	StoredLists          int `json:"stored_lists"`
+	StoredListsBytes     int `json:"stored_lists_bytes"`
+	// When the node was last gossiped to, nil if it never was
+	LastGossipReceived *time.Time `json:"last_gossip_received,omitempty"`
+}
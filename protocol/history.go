@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"sdle.com/mod/crdt_go"
+)
+
+const (
+	DEFAULT_HISTORY_MAX_ENTRIES int   = 1000
+	DEFAULT_HISTORY_MAX_AGE     int64 = 30 * 24 * 60 * 60 // 30 days, in seconds
+)
+
+// HistoryRetention limits how much of the history of each list is kept.
+// It is set on the load balancer and handed to every node that joins the cluster.
+type HistoryRetention struct {
+	MaxEntries    int   `json:"max_entries"`
+	MaxAgeSeconds int64 `json:"max_age_seconds"`
+}
+
+// JoinResponse is what the load balancer answers to a node joining the cluster
+type JoinResponse struct {
+	Nodes   []map[string]string `json:"nodes"`
+	History *HistoryRetention   `json:"history,omitempty"`
+}
+
+// HistoryPage is a page of the history of a list, from the newest to the oldest operation
+type HistoryPage struct {
+	ListId     string              `json:"list_id"`
+	Entries    []crdt_go.Operation `json:"entries"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+/**
+* Returns the retention limits set in the HISTORY_MAX_ENTRIES and HISTORY_MAX_AGE (in seconds)
+* environment variables, using the defaults for the ones not set. Zero means no limit.
+ */
+func HistoryRetentionFromEnv() HistoryRetention {
+	retention := HistoryRetention{
+		MaxEntries:    DEFAULT_HISTORY_MAX_ENTRIES,
+		MaxAgeSeconds: DEFAULT_HISTORY_MAX_AGE,
+	}
+
+	if value, err := strconv.Atoi(os.Getenv("HISTORY_MAX_ENTRIES")); err == nil {
+		retention.MaxEntries = value
+	}
+
+	if value, err := strconv.ParseInt(os.Getenv("HISTORY_MAX_AGE"), 10, 64); err == nil {
+		retention.MaxAgeSeconds = value
+	}
+
+	return retention
+}
+
+/**
+* Drops the operations of the list's history that are beyond the retention limits
+ */
+func (retention HistoryRetention) Apply(list *crdt_go.ShoppingList) {
+	if list == nil {
+		return
+	}
+
+	list.RetainHistory(retention.MaxEntries, time.Duration(retention.MaxAgeSeconds)*time.Second, time.Now())
+}
@@ -2,28 +2,40 @@ package protocol
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"sdle.com/mod/crdt_go"
+	"sdle.com/mod/logging"
+	"sdle.com/mod/tracing"
 )
 
 type ShoppingListOperation struct {
-	ListId  string                `json:"list_id"`
-	Content *crdt_go.ShoppingList `json:"content"`
+	ListId    string                   `json:"list_id"`
+	Content   *crdt_go.ShoppingList    `json:"content"`
+	Transfers []crdt_go.RightsTransfer `json:"transfers,omitempty"` // Rights transfers the coordinator applies before the write
+	Order     []string                 `json:"order,omitempty"`     // The items in the order of the list, sent on reads
 }
 //To use on anti-entropy first message
 
 
 func SendGetRequest(address string, port string, path string) (*http.Response, error) {
-	requestURL := fmt.Sprintf("http://%s:%s%s", address, port, path)
-	client := http.Client{
-		Timeout: 5 * time.Second,
+	requestURL := fmt.Sprintf("%s://%s:%s%s", Scheme(), address, port, path)
+	client := *httpClient
+	client.Timeout = 5 * time.Second
+
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
 	}
-	res, err := client.Get(requestURL)
+	signClusterRequest(req, nil)
+
+	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -32,17 +44,51 @@ func SendGetRequest(address string, port string, path string) (*http.Response, e
 }
 
 func SendRequestWithData(method string, address string, port string, path string, data []byte) (*http.Response, error) {
-	requestURL := fmt.Sprintf("http://%s:%s%s", address, port, path)
+	return SendRequestWithContentType(method, address, port, path, data, JSON_CONTENT_TYPE, JSON_CONTENT_TYPE)
+}
+
+/**
+* Sends data in the given content type, asking for the response in the accepted content type
+ */
+func SendRequestWithContentType(method string, address string, port string, path string, data []byte, contentType string, accept string) (*http.Response, error) {
+	return SendRequestWithContext(context.Background(), method, address, port, path, data, contentType, accept)
+}
+
+/**
+* Sends data in the given content type as part of the request the context belongs to, whose id and trace
+* context it carries
+ */
+func SendRequestWithContext(ctx context.Context, method string, address string, port string, path string, data []byte, contentType string, accept string) (*http.Response, error) {
+	return sendRequest(ctx, method, address, port, path, data, contentType, accept, false)
+}
 
-	req, err := http.NewRequest(method, requestURL, bytes.NewBuffer(data))
+/**
+* Sends a request to an admin route, with the admin token. Only these requests carry it, so the nodes never
+* take the requests they send each other for the admin's
+ */
+func SendAdminRequest(ctx context.Context, method string, address string, port string, path string, data []byte, contentType string, accept string) (*http.Response, error) {
+	return sendRequest(ctx, method, address, port, path, data, contentType, accept, true)
+}
+
+func sendRequest(ctx context.Context, method string, address string, port string, path string, data []byte, contentType string, accept string, admin bool) (*http.Response, error) {
+	requestURL := fmt.Sprintf("%s://%s:%s%s", Scheme(), address, port, path)
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", accept)
+	logging.PropagateRequestID(ctx, req)
+	tracing.Inject(ctx, req)
 
-	client := &http.Client{}
-	res, err := client.Do(req)
+	if admin {
+		req.Header.Set(ADMIN_TOKEN_HEADER, AdminToken)
+	}
+	signClusterRequest(req, data)
+
+	res, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -55,6 +101,18 @@ func SendRequestWithData(method string, address string, port string, path string
 	return res, nil
 }
 
+/**
+* Signs a request to another machine of the cluster, when there is a cluster secret. Without one the request
+* only says which machine sent it
+ */
+func signClusterRequest(req *http.Request, body []byte) {
+	if len(ClusterSecret) > 0 {
+		SignRequest(ClusterSecret, LocalPeer, req, body, time.Now())
+	} else if LocalPeer != "" {
+		req.Header.Set(PEER_HEADER, LocalPeer)
+	}
+}
+
 const (
 	JSON_DECODE_ERROR string = "Failed to decode the given JSON."
 )
@@ -82,7 +140,7 @@ func DecodeRequestBody[T any](w http.ResponseWriter, body io.ReadCloser, data T)
 	err := json.NewDecoder(body).Decode(&data)
 
 	if err != nil {
-		fmt.Println("the error is", err)
+		slog.Debug("Failed to decode the request body", "err", err)
 
 		FailedToDecodeJSON(w)
 		return false, data
@@ -98,14 +156,14 @@ func DecodeHTTPResponse[T any](w http.ResponseWriter, response *http.Response, d
     defer response.Body.Close() // Ensure to close the response body when done
 
     if response.StatusCode != http.StatusOK {
-        fmt.Println("Received non-OK status code:", response.StatusCode)
+        slog.Warn("Received a non-OK status code", "status", response.StatusCode)
         FailedToDecodeJSON(w)
         return false, data
     }
 
     err := json.NewDecoder(response.Body).Decode(&data)
     if err != nil {
-        fmt.Println("Error decoding HTTP response body:", err)
+        slog.Warn("Failed to decode the response body", "err", err)
         FailedToDecodeJSON(w)
         return false, data
     }
@@ -0,0 +1,193 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLSConfig has the paths of the certificates a machine uses, from TLS_CERT_FILE, TLS_KEY_FILE and TLS_CA_FILE.
+// Without a certificate and its key the machine uses plain HTTP. With a CA, the machines of the cluster
+// also authenticate each other with their certificates (mutual TLS).
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// The certificates of this machine, the same for its listener and for the requests it sends
+var TLS = TLSConfigFromEnv()
+
+var httpClient = &http.Client{}
+
+var ErrTLSNotConfigured = errors.New("TLS_CERT_FILE and TLS_KEY_FILE must both be set")
+
+func TLSConfigFromEnv() TLSConfig {
+	return TLSConfig{
+		CertFile: os.Getenv("TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("TLS_KEY_FILE"),
+		CAFile:   os.Getenv("TLS_CA_FILE"),
+	}
+}
+
+/**
+* Indicates if the machine uses TLS
+ */
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+/**
+* Indicates if the machines of the cluster authenticate each other with their certificates
+ */
+func (c TLSConfig) Mutual() bool {
+	return c.Enabled() && c.CAFile != ""
+}
+
+func (c TLSConfig) certPool() (*x509.CertPool, error) {
+	pem, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", c.CAFile)
+	}
+	return pool, nil
+}
+
+/**
+* The TLS configuration of a listener, requireClientCert is for the listeners only the cluster talks to
+ */
+func (c TLSConfig) ServerConfig(requireClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		config.ClientCAs, err = c.certPool()
+		if err != nil {
+			return nil, err
+		}
+		if requireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return config, nil
+}
+
+/**
+* The TLS configuration of the requests to the other machines, which present this machine's certificate
+ */
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CAFile != "" {
+		pool, err := c.certPool()
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if c.Enabled() {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+/**
+* Sets up the requests this machine sends for its TLS configuration, it must be called before sending any
+ */
+func SetupTLS() error {
+	if (TLS.CertFile == "") != (TLS.KeyFile == "") {
+		return ErrTLSNotConfigured
+	}
+	if !TLS.Enabled() {
+		return nil
+	}
+
+	config, err := TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	httpClient = &http.Client{Transport: transport}
+
+	return nil
+}
+
+/**
+* The scheme of the URLs of the cluster
+ */
+func Scheme() string {
+	if TLS.Enabled() {
+		return "https"
+	}
+	return "http"
+}
+
+/**
+* The transport of the requests to the other machines, for proxies
+ */
+func Transport() http.RoundTripper {
+	if httpClient.Transport == nil {
+		return http.DefaultTransport
+	}
+	return httpClient.Transport
+}
+
+/**
+* Serves on a port with TLS if it is enabled, requireClientCert is for the listeners only the cluster talks to
+ */
+func ListenAndServe(port string, requireClientCert bool) error {
+	addr := fmt.Sprintf(":%s", port)
+
+	if !TLS.Enabled() {
+		return http.ListenAndServe(addr, nil)
+	}
+
+	config, err := TLS.ServerConfig(requireClientCert)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: addr, TLSConfig: config, ReadHeaderTimeout: 10 * time.Second}
+	return server.ListenAndServeTLS("", "")
+}
+
+/**
+* Only lets through the requests with a client certificate signed by the cluster CA, when there is one
+ */
+func RequireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if TLS.Mutual() && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("A client certificate is required."))
+			return
+		}
+
+		next(w, r)
+	}
+}
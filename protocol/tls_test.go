@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	serverTLS, err := WriteDevCert(dir, "node", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTLS, err := WriteDevCert(dir, "load_balancer", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS, err = serverTLS.ServerConfig(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// Should accept a client with a certificate of the same CA
+	config, err := clientTLS.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	res, err := client.Get(server.URL)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatal("a client with a certificate should be accepted", err)
+	}
+	res.Body.Close()
+
+	// Should reject a client without a certificate
+	config, err = TLSConfig{CAFile: clientTLS.CAFile}.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	if res, err := client.Get(server.URL); err == nil {
+		res.Body.Close()
+		t.Fatal("a client without a certificate should be rejected")
+	}
+
+	// Should not trust a server of another CA
+	other, err := WriteDevCert(t.TempDir(), "other", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = other.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	if res, err := client.Get(server.URL); err == nil {
+		res.Body.Close()
+		t.Fatal("a server of another CA should not be trusted")
+	}
+}
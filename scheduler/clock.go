@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits, so the schedulers can be run on a fake clock in the tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// The clock of the machine
+var RealClock Clock = realClock{}
+
+type fakeTimer struct {
+	deadline time.Time
+	fire     chan time.Time
+}
+
+// FakeClock only moves when it is advanced
+type FakeClock struct {
+	mu      sync.Mutex
+	waiting *sync.Cond
+	now     time.Time
+	timers  []fakeTimer
+}
+
+/**
+* Creates a fake clock set to now
+ */
+func NewFakeClock(now time.Time) *FakeClock {
+	clock := &FakeClock{now: now}
+	clock.waiting = sync.NewCond(&clock.mu)
+	return clock
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fire := make(chan time.Time, 1)
+	if d <= 0 {
+		fire <- c.now
+		return fire
+	}
+
+	c.timers = append(c.timers, fakeTimer{c.now.Add(d), fire})
+	c.waiting.Broadcast()
+	return fire
+}
+
+/**
+* Moves the clock forward, firing every timer whose deadline it reaches
+ */
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.fire <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+/**
+* Waits until n timers are waiting for the clock to be advanced
+ */
+func (c *FakeClock) BlockUntilWaiting(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.waiting.Wait()
+	}
+}
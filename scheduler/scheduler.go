@@ -0,0 +1,206 @@
+// Package scheduler runs the periodic rounds of the cluster, like gossip and anti-entropy: every interval,
+// plus some jitter so the machines do not all send at once, a few targets are picked at random and a task is
+// run for each of them on a fixed pool of workers. A target whose last task has not finished is skipped, so
+// a slow target never makes the tasks pile up.
+package scheduler
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"sdle.com/mod/metrics"
+)
+
+// How many tasks wait for a worker at most, the ones after them are skipped
+const MAX_QUEUED_TASKS = 1024
+
+// Why a task was not run: the last task of its target had not finished, or too many were waiting
+const (
+	SKIPPED_BUSY string = "busy"
+	SKIPPED_FULL string = "full"
+)
+
+// The tasks a scheduler skipped, by scheduler and reason
+var skippedTasks = metrics.NewCounterVec("scheduler_skipped_tasks_total", "Tasks a scheduler skipped, by reason.", "scheduler", "reason")
+
+// Config is how often a scheduler runs its rounds and how much work each round does
+type Config struct {
+	// The time between the start of two rounds
+	Interval time.Duration
+	// Up to this much time is added at random to every interval
+	Jitter time.Duration
+	// How many targets are picked each round, 0 picks every target
+	Fanout int
+	// How many tasks run at once
+	Workers int
+}
+
+/**
+* Overrides the fields of config with the environment variables starting with prefix: <prefix>_INTERVAL and
+* <prefix>_JITTER, durations like 1s or 250ms, and <prefix>_FANOUT and <prefix>_WORKERS
+ */
+func ConfigFromEnv(prefix string, config Config) (Config, error) {
+	durations := map[string]*time.Duration{"_INTERVAL": &config.Interval, "_JITTER": &config.Jitter}
+	for suffix, field := range durations {
+		if value := os.Getenv(prefix + suffix); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < 0 {
+				return config, fmt.Errorf("invalid %s %q, it must be a duration like 1s or 250ms", prefix+suffix, value)
+			}
+			*field = parsed
+		}
+	}
+
+	counts := map[string]*int{"_FANOUT": &config.Fanout, "_WORKERS": &config.Workers}
+	for suffix, field := range counts {
+		if value := os.Getenv(prefix + suffix); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return config, fmt.Errorf("invalid %s %q, it must be a number", prefix+suffix, value)
+			}
+			*field = parsed
+		}
+	}
+
+	if config.Interval <= 0 {
+		return config, fmt.Errorf("%s_INTERVAL must be more than 0", prefix)
+	}
+	if config.Workers < 1 {
+		return config, fmt.Errorf("%s_WORKERS must be at least 1", prefix)
+	}
+	return config, nil
+}
+
+// Scheduler runs a task for some of its targets every round
+type Scheduler[T comparable] struct {
+	name       string
+	config     Config
+	clock      Clock
+	random     *rand.Rand
+	targets    func() []T
+	task       func(target T)
+	afterRound func()
+	queue      chan T
+
+	mu sync.Mutex
+	// The targets whose task is waiting for a worker or running
+	pending map[T]bool
+}
+
+/**
+* Creates a scheduler that runs task for Fanout of the targets returned by targets each round. name tells the
+* schedulers apart in the logs and the metrics
+ */
+func New[T comparable](name string, config Config, targets func() []T, task func(target T)) *Scheduler[T] {
+	return &Scheduler[T]{
+		name:    name,
+		config:  config,
+		clock:   RealClock,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		targets: targets,
+		task:    task,
+		queue:   make(chan T, MAX_QUEUED_TASKS),
+		pending: make(map[T]bool),
+	}
+}
+
+/**
+* Makes the scheduler wait on clock instead of the clock of the machine
+ */
+func (s *Scheduler[T]) WithClock(clock Clock) *Scheduler[T] {
+	s.clock = clock
+	return s
+}
+
+/**
+* Calls afterRound once the tasks of every round are handed to the workers
+ */
+func (s *Scheduler[T]) AfterRound(afterRound func()) *Scheduler[T] {
+	s.afterRound = afterRound
+	return s
+}
+
+/**
+* Runs a round right away and then one every interval, until stop is closed. The tasks already running are
+* left to finish
+ */
+func (s *Scheduler[T]) Run(stop <-chan struct{}) {
+	for i := 0; i < max(s.config.Workers, 1); i++ {
+		go s.work()
+	}
+	defer close(s.queue)
+
+	for {
+		s.round()
+
+		select {
+		case <-stop:
+			return
+		case <-s.clock.After(s.nextWait()):
+		}
+	}
+}
+
+func (s *Scheduler[T]) work() {
+	for target := range s.queue {
+		s.task(target)
+
+		s.mu.Lock()
+		delete(s.pending, target)
+		s.mu.Unlock()
+	}
+}
+
+/**
+* The time until the next round: the interval and up to Jitter more
+ */
+func (s *Scheduler[T]) nextWait() time.Duration {
+	if s.config.Jitter <= 0 {
+		return s.config.Interval
+	}
+	return s.config.Interval + time.Duration(s.random.Int63n(int64(s.config.Jitter)+1))
+}
+
+/**
+* Hands the task of Fanout targets, picked at random, to the workers. Returns how many were skipped
+ */
+func (s *Scheduler[T]) round() int {
+	targets := s.targets()
+	s.random.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if s.config.Fanout > 0 && s.config.Fanout < len(targets) {
+		targets = targets[:s.config.Fanout]
+	}
+
+	busy, full := 0, 0
+	s.mu.Lock()
+	for _, target := range targets {
+		if s.pending[target] {
+			busy++
+			continue
+		}
+
+		select {
+		case s.queue <- target:
+			s.pending[target] = true
+		default:
+			full++
+		}
+	}
+	s.mu.Unlock()
+
+	if busy+full > 0 {
+		skippedTasks.Add(float64(busy), s.name, SKIPPED_BUSY)
+		skippedTasks.Add(float64(full), s.name, SKIPPED_FULL)
+		slog.Debug("Skipped some tasks of a round", "scheduler", s.name, "busy", busy, "full", full, "targets", len(targets))
+	}
+
+	if s.afterRound != nil {
+		s.afterRound()
+	}
+	return busy + full
+}
@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// How long a test waits for something that should not happen
+const QUIET_PERIOD = 50 * time.Millisecond
+
+func expectRound(t *testing.T, rounds chan int) {
+	t.Helper()
+	select {
+	case <-rounds:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a round to run")
+	}
+}
+
+func expectNoRound(t *testing.T, rounds chan int) {
+	t.Helper()
+	select {
+	case <-rounds:
+		t.Fatal("Expected no round to run yet")
+	case <-time.After(QUIET_PERIOD):
+	}
+}
+
+func TestRunsARoundEveryInterval(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	tasks := make(chan string, 10)
+	rounds := make(chan int, 10)
+
+	scheduler := New("test", Config{Interval: 10 * time.Second, Workers: 2},
+		func() []string { return []string{"a", "b", "c"} },
+		func(target string) { tasks <- target },
+	).WithClock(clock).AfterRound(func() { rounds <- 1 })
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(stop)
+		close(done)
+	}()
+
+	// The first round runs right away
+	expectRound(t, rounds)
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		seen[<-tasks] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected every target once, got %v", seen)
+	}
+
+	clock.BlockUntilWaiting(1)
+	clock.Advance(9 * time.Second)
+	expectNoRound(t, rounds)
+
+	clock.Advance(time.Second)
+	expectRound(t, rounds)
+
+	close(stop)
+	clock.BlockUntilWaiting(1)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the scheduler to stop")
+	}
+}
+
+func TestFanout(t *testing.T) {
+	tasks := make(chan string, 10)
+	scheduler := New("test", Config{Interval: time.Second, Fanout: 2, Workers: 5},
+		func() []string { return []string{"a", "b", "c", "d", "e"} },
+		func(target string) { tasks <- target },
+	)
+
+	if skipped := scheduler.round(); skipped != 0 {
+		t.Errorf("Expected nothing to be skipped, got %d", skipped)
+	}
+	close(scheduler.queue)
+
+	picked := map[string]bool{}
+	for target := range scheduler.queue {
+		picked[target] = true
+	}
+	if len(picked) != 2 {
+		t.Errorf("Expected 2 different targets, got %v", picked)
+	}
+}
+
+func TestSkipsTargetsStillBusy(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan string, 10)
+	scheduler := New("test", Config{Interval: time.Second, Workers: 1},
+		func() []string { return []string{"a", "b"} },
+		func(target string) {
+			started <- target
+			<-release
+		},
+	)
+	go scheduler.work()
+
+	if skipped := scheduler.round(); skipped != 0 {
+		t.Errorf("Expected nothing to be skipped in the first round, got %d", skipped)
+	}
+	<-started
+
+	// One target is running and the other waits for the only worker
+	if skipped := scheduler.round(); skipped != 2 {
+		t.Errorf("Expected both targets to be skipped, got %d", skipped)
+	}
+
+	close(release)
+	<-started
+	close(scheduler.queue)
+}
+
+func TestJitter(t *testing.T) {
+	scheduler := New("test", Config{Interval: 10 * time.Second, Jitter: 5 * time.Second, Workers: 1}, func() []int { return nil }, func(int) {})
+
+	waits := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		wait := scheduler.nextWait()
+		if wait < 10*time.Second || wait > 15*time.Second {
+			t.Fatalf("Expected the wait to be between 10s and 15s, got %s", wait)
+		}
+		waits[wait] = true
+	}
+	if len(waits) < 2 {
+		t.Error("Expected the waits to vary")
+	}
+
+	scheduler = New("test", Config{Interval: 10 * time.Second, Workers: 1}, func() []int { return nil }, func(int) {})
+	if wait := scheduler.nextWait(); wait != 10*time.Second {
+		t.Errorf("Expected the interval without jitter, got %s", wait)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	defaults := Config{Interval: time.Second, Jitter: 0, Fanout: 3, Workers: 4}
+
+	t.Setenv("TEST_INTERVAL", "250ms")
+	t.Setenv("TEST_FANOUT", "0")
+	config, err := ConfigFromEnv("TEST", defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{Interval: 250 * time.Millisecond, Jitter: 0, Fanout: 0, Workers: 4}
+	if config != expected {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+
+	t.Setenv("TEST_WORKERS", "0")
+	if _, err := ConfigFromEnv("TEST", defaults); err == nil {
+		t.Error("Expected a scheduler without workers to be refused")
+	}
+
+	t.Setenv("TEST_WORKERS", "")
+	t.Setenv("TEST_JITTER", "soon")
+	if _, err := ConfigFromEnv("TEST", defaults); err == nil {
+		t.Error("Expected an invalid duration to be refused")
+	}
+}
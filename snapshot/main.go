@@ -0,0 +1,115 @@
+// this takes a snapshot of a database node into an archive, and restores an archive into a node
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"sdle.com/mod/protocol"
+)
+
+// The content type of a snapshot archive, as the nodes send it
+const SNAPSHOT_CONTENT_TYPE string = "application/gzip"
+
+func main() {
+	argsWithoutProg := os.Args[1:]
+
+	if len(argsWithoutProg) != 4 || (argsWithoutProg[0] != "save" && argsWithoutProg[0] != "restore") {
+		fmt.Println("Usage: snapshot save|restore <node_address> <node_port> <archive>")
+		os.Exit(1)
+	}
+
+	if protocol.AdminToken == "" {
+		fmt.Println("ADMIN_TOKEN must be set to the admin token of the cluster")
+		os.Exit(1)
+	}
+
+	if err := protocol.SetupTLS(); err != nil {
+		fmt.Println("Failed to set up TLS:", err)
+		os.Exit(1)
+	}
+
+	address, port, archive := argsWithoutProg[1], argsWithoutProg[2], argsWithoutProg[3]
+
+	var err error
+	if argsWithoutProg[0] == "save" {
+		err = save(address, port, archive)
+	} else {
+		err = restore(address, port, archive)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+/**
+ * Writes a snapshot of the node into the archive
+ */
+func save(address string, port string, archive string) error {
+	response, err := protocol.SendAdminRequest(context.Background(), http.MethodGet, address, port, "/admin/snapshot", nil, protocol.JSON_CONTENT_TYPE, SNAPSHOT_CONTENT_TYPE)
+	if err != nil {
+		return fmt.Errorf("failed to reach the node: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("the node refused the snapshot (%s): %s", response.Status, body)
+	}
+
+	// Written aside first, so a snapshot that fails half way never replaces a good archive
+	file, err := os.Create(archive + ".part")
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(file, response.Body)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archive + ".part")
+		return fmt.Errorf("failed to write the archive: %w", err)
+	}
+
+	if err := os.Rename(archive+".part", archive); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved a snapshot of %s:%s into %s (%d bytes)\n", address, port, archive, written)
+	return nil
+}
+
+/**
+ * Loads the archive into the node, merging it into the lists the node has
+ */
+func restore(address string, port string, archive string) error {
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		return err
+	}
+
+	response, err := protocol.SendAdminRequest(context.Background(), http.MethodPut, address, port, "/admin/snapshot", data, SNAPSHOT_CONTENT_TYPE, protocol.JSON_CONTENT_TYPE)
+	if err != nil {
+		return fmt.Errorf("failed to reach the node: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("the node refused the snapshot (%s): %s", response.Status, body)
+	}
+
+	fmt.Printf("Restored %s into %s:%s: %s\n", archive, address, port, bytes.TrimSpace(body))
+	return nil
+}
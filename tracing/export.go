@@ -0,0 +1,352 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often the ended spans are exported
+const EXPORT_INTERVAL = 2 * time.Second
+
+// How many ended spans wait to be exported at most, the ones after them are dropped
+const MAX_QUEUED_SPANS = 4096
+
+// How many spans are exported together at most
+const MAX_EXPORT_BATCH = 512
+
+// How long an OTLP collector has to accept a batch
+const OTLP_TIMEOUT = 5 * time.Second
+
+// SpanData is an ended span, as it is written to a trace file
+type SpanData struct {
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Name         string         `json:"name"`
+	Kind         string         `json:"kind"`
+	Service      string         `json:"service"`
+	Instance     string         `json:"instance,omitempty"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	DurationMs   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+
+	kind SpanKind
+}
+
+func (s *Span) data(service string, instance string) SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := SpanData{
+		TraceID:    s.context.TraceID.String(),
+		SpanID:     s.context.SpanID.String(),
+		Name:       s.name,
+		Kind:       s.kind.String(),
+		Service:    service,
+		Instance:   instance,
+		Start:      s.start,
+		End:        s.end,
+		DurationMs: float64(s.end.Sub(s.start).Microseconds()) / 1000,
+		Error:      s.err,
+		kind:       s.kind,
+	}
+	if s.parent != (SpanID{}) {
+		data.ParentSpanID = s.parent.String()
+	}
+	if len(s.attributes) > 0 {
+		data.Attributes = make(map[string]any, len(s.attributes))
+		for _, attr := range s.attributes {
+			data.Attributes[attr.key] = attr.value
+		}
+	}
+	return data
+}
+
+// Exporter sends ended spans somewhere they can be looked at
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+// Tracer starts the spans of a machine and exports them in batches
+type Tracer struct {
+	service   string
+	instance  string
+	ratio     float64
+	exporters []Exporter
+	queue     chan *Span
+	flushes   chan chan struct{}
+}
+
+/**
+* Creates a tracer for an instance of the given service, which records ratio of the traces it starts and exports the spans
+* to every exporter. The spans are exported once Run is started
+ */
+func NewTracer(service string, instance string, ratio float64, exporters ...Exporter) *Tracer {
+	return &Tracer{
+		service:   service,
+		instance:  instance,
+		ratio:     ratio,
+		exporters: exporters,
+		queue:     make(chan *Span, MAX_QUEUED_SPANS),
+		flushes:   make(chan chan struct{}),
+	}
+}
+
+/**
+* Queues an ended span. A span is never waited for: if the exporters are behind it is dropped
+ */
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		slog.Warn("Dropped a span, the trace exporters are behind", "span", span.name)
+	}
+}
+
+/**
+* Exports the queued spans every interval, and when Flush is called
+ */
+func (t *Tracer) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, MAX_EXPORT_BATCH)
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span.data(t.service, t.instance))
+			if len(batch) >= MAX_EXPORT_BATCH {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case done := <-t.flushes:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span.data(t.service, t.instance))
+				default:
+					drained = true
+				}
+			}
+			batch = t.export(batch)
+			close(done)
+		}
+	}
+}
+
+/**
+* Exports the spans queued so far and waits until they are, Run must be running
+ */
+func (t *Tracer) Flush() {
+	done := make(chan struct{})
+	t.flushes <- done
+	<-done
+}
+
+func (t *Tracer) export(batch []SpanData) []SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	for _, exporter := range t.exporters {
+		if err := exporter.Export(batch); err != nil {
+			slog.Warn("Failed to export spans", "spans", len(batch), "err", err)
+		}
+	}
+	return batch[:0]
+}
+
+// FileExporter appends the spans to a file, one JSON object per line
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+/**
+* Opens the file the spans are appended to, creating it if it does not exist
+ */
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: file}, nil
+}
+
+func (e *FileExporter) Export(spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	writer := bufio.NewWriter(e.file)
+	encoder := json.NewEncoder(writer)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// OTLPExporter posts the spans to an OpenTelemetry collector in the OTLP/HTTP JSON encoding
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+/**
+* Creates an exporter posting to the given URL, the traces endpoint of the collector
+ */
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{endpoint: endpoint, client: &http.Client{Timeout: OTLP_TIMEOUT}}
+}
+
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	response, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("the collector answered %s", response.Status)
+	}
+	return nil
+}
+
+// The parts of an OTLP ExportTraceServiceRequest the spans need, in its JSON encoding
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// The status code OTLP gives a failed span
+const OTLP_STATUS_ERROR = 2
+
+/**
+* Groups the spans by the instance of the service that recorded them, in an OTLP export request
+ */
+func otlpRequest(spans []SpanData) otlpExportRequest {
+	request := otlpExportRequest{ResourceSpans: make([]otlpResourceSpans, 0)}
+	byResource := make(map[[2]string]int)
+
+	for _, span := range spans {
+		key := [2]string{span.Service, span.Instance}
+		index, ok := byResource[key]
+		if !ok {
+			var resource otlpResourceSpans
+			resource.Resource.Attributes = []otlpAttribute{otlpAttributeOf("service.name", span.Service)}
+			if span.Instance != "" {
+				resource.Resource.Attributes = append(resource.Resource.Attributes, otlpAttributeOf("service.instance.id", span.Instance))
+			}
+			resource.ScopeSpans = make([]otlpScopeSpans, 1)
+			resource.ScopeSpans[0].Scope.Name = "sdle.com/mod/tracing"
+
+			index = len(request.ResourceSpans)
+			byResource[key] = index
+			request.ResourceSpans = append(request.ResourceSpans, resource)
+		}
+
+		converted := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              int(span.kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		for key, value := range span.Attributes {
+			converted.Attributes = append(converted.Attributes, otlpAttributeOf(key, value))
+		}
+		if span.Error != "" {
+			converted.Status = otlpStatus{Code: OTLP_STATUS_ERROR, Message: span.Error}
+		}
+
+		scope := &request.ResourceSpans[index].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, converted)
+	}
+
+	return request
+}
+
+func otlpAttributeOf(key string, value any) otlpAttribute {
+	var converted otlpValue
+
+	switch v := value.(type) {
+	case bool:
+		converted.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		converted.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		converted.IntValue = &s
+	case float64:
+		converted.DoubleValue = &v
+	case string:
+		converted.StringValue = &v
+	default:
+		s := fmt.Sprint(v)
+		converted.StringValue = &s
+	}
+
+	return otlpAttribute{Key: key, Value: converted}
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"sdle.com/mod/logging"
+)
+
+// statusRecorder keeps the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// Unwrap lets http.ResponseController reach the original writer, to flush proxied responses
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+/**
+* Serves every request in a span named after its method and route, the child of the span in its traceparent
+* header when trustHeader is set. The trace context is kept even while tracing is off on this machine, so the
+* requests this one sends continue the trace
+ */
+func HandleTrace(trustHeader bool, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if trustHeader {
+			if parent, ok := ParseTraceparent(r.Header.Get(TRACEPARENT_HEADER)); ok {
+				ctx = ContextWithSpanContext(ctx, parent)
+			}
+		} else {
+			// It would otherwise be forwarded as it is when the request is proxied
+			r.Header.Del(TRACEPARENT_HEADER)
+		}
+
+		ctx, span := Start(ctx, r.Method+" "+route, SPAN_KIND_SERVER, "http.method", r.Method, "http.route", route)
+		if span == nil {
+			next(w, r.WithContext(ctx))
+			return
+		}
+		defer span.End()
+
+		if id := logging.RequestID(ctx); id != "" {
+			span.SetAttributes("request_id", id)
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes("http.status_code", recorder.status)
+	}
+}
+
+/**
+* Sets the trace context of ctx on a request to another machine
+ */
+func Inject(ctx context.Context, req *http.Request) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		req.Header.Set(TRACEPARENT_HEADER, sc.Traceparent())
+	}
+}
@@ -0,0 +1,302 @@
+// Package tracing records the spans of the requests served by the machines of the cluster and propagates
+// their trace context between them in the W3C traceparent header, so the time of a request can be broken
+// down across the load balancer, the coordinator and the replicas.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The header the trace context is sent in
+const TRACEPARENT_HEADER string = "traceparent"
+
+// The version of the traceparent header that is written
+const TRACEPARENT_VERSION string = "00"
+
+// The flag of the traceparent header telling the span is recorded
+const FLAG_SAMPLED byte = 0x01
+
+// SpanKind tells what a span measures, with the values OTLP gives them
+type SpanKind int
+
+const (
+	// Work done inside a machine
+	SPAN_KIND_INTERNAL SpanKind = 1
+	// A request served by a machine
+	SPAN_KIND_SERVER SpanKind = 2
+	// A request sent to another machine
+	SPAN_KIND_CLIENT SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SPAN_KIND_INTERNAL:
+		return "internal"
+	case SPAN_KIND_SERVER:
+		return "server"
+	case SPAN_KIND_CLIENT:
+		return "client"
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span, it is what is sent to the other machines
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+/**
+* A span context is valid if neither of its ids is all zeros
+ */
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+/**
+* Formats the span context as a traceparent header
+ */
+func (sc SpanContext) Traceparent() string {
+	var flags byte
+	if sc.Sampled {
+		flags = FLAG_SAMPLED
+	}
+	return fmt.Sprintf("%s-%s-%s-%02x", TRACEPARENT_VERSION, sc.TraceID, sc.SpanID, flags)
+}
+
+/**
+* Parses a traceparent header. Versions after 00 are read as 00, as the W3C recommendation asks
+ */
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+
+	// version-traceid-spanid-flags, later versions may add fields after the flags
+	if len(value) < 55 || (len(value) > 55 && (value[:2] == TRACEPARENT_VERSION || value[55] != '-')) {
+		return sc, false
+	}
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' || value[:2] == "ff" {
+		return sc, false
+	}
+
+	version, err := hex.DecodeString(value[:2])
+	if err != nil || len(version) != 1 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(value[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(value[36:52])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(value[53:55])
+	if err != nil {
+		return sc, false
+	}
+	// Ids are lowercase in the header
+	if value[3:35] != sc.TraceID.String() || value[36:52] != sc.SpanID.String() {
+		return sc, false
+	}
+
+	sc.Sampled = flags[0]&FLAG_SAMPLED != 0
+	return sc, sc.IsValid()
+}
+
+type spanContextKey struct{}
+
+/**
+* Returns a copy of the context carrying the span context, the parent of the spans started with it
+ */
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+/**
+* Returns the span context the context carries, an invalid one if it carries none
+ */
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// attribute is a key and a value describing a span
+type attribute struct {
+	key   string
+	value any
+}
+
+// Span is a timed piece of work of a request. Its methods can be called on a nil span, which records nothing
+type Span struct {
+	tracer  *Tracer
+	name    string
+	kind    SpanKind
+	context SpanContext
+	parent  SpanID
+	start   time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []attribute
+	err        string
+	ended      bool
+}
+
+/**
+* Adds attributes to the span, given as key and value pairs like the ones of log/slog
+ */
+func (s *Span) SetAttributes(attrs ...any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attributes = append(s.attributes, attribute{fmt.Sprint(attrs[i]), attrs[i+1]})
+	}
+}
+
+/**
+* Marks the span as failed with err, if err is not nil
+ */
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+/**
+* Ends the span and hands it to the exporter if it is sampled. Ending it again does nothing
+ */
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+/**
+* Returns the span context of the span, the invalid one for a nil span
+ */
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// The tracer the spans are started with, nil while tracing is off
+var defaultTracer atomic.Pointer[Tracer]
+
+/**
+* Sets up the tracer of this machine from the environment: the spans are written as JSON lines to the file
+* in TRACE_FILE and posted in the OTLP/HTTP JSON encoding to the URL in TRACE_OTLP_ENDPOINT (for example
+* http://localhost:4318/v1/traces). TRACE_SAMPLE_RATIO is the share of the traces started on this machine
+* that are recorded, 1 by default; a trace started elsewhere is recorded if it was there. Without an
+* exporter tracing is off, but the trace context of the requests is still forwarded. instance tells the
+* machines of the same service apart
+ */
+func Setup(service string, instance string) error {
+	exporters := make([]Exporter, 0)
+
+	if path := os.Getenv("TRACE_FILE"); path != "" {
+		exporter, err := NewFileExporter(path)
+		if err != nil {
+			return err
+		}
+		exporters = append(exporters, exporter)
+	}
+
+	if endpoint := os.Getenv("TRACE_OTLP_ENDPOINT"); endpoint != "" {
+		exporters = append(exporters, NewOTLPExporter(endpoint))
+	}
+
+	ratio := 1.0
+	if value := os.Getenv("TRACE_SAMPLE_RATIO"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return fmt.Errorf("invalid TRACE_SAMPLE_RATIO %q, it must be between 0 and 1", value)
+		}
+		ratio = parsed
+	}
+
+	if len(exporters) == 0 {
+		return nil
+	}
+
+	tracer := NewTracer(service, instance, ratio, exporters...)
+	go tracer.Run(EXPORT_INTERVAL)
+	SetDefault(tracer)
+	return nil
+}
+
+/**
+* Makes tracer the one the spans are started with, nil turns tracing off
+ */
+func SetDefault(tracer *Tracer) {
+	defaultTracer.Store(tracer)
+}
+
+/**
+* Starts a span as a child of the span context ctx carries, or as the root of a new trace. Returns the context
+* carrying the new span, to start its children with, and the span, which is nil while tracing is off
+ */
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...any) (context.Context, *Span) {
+	tracer := defaultTracer.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	span := &Span{tracer: tracer, name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = mathrand.Float64() < tracer.ratio
+	}
+	rand.Read(span.context.SpanID[:])
+	span.SetAttributes(attrs...)
+
+	return ContextWithSpanContext(ctx, span.context), span
+}
@@ -0,0 +1,207 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingExporter keeps the spans it is given
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) byName(name string) (SpanData, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, span := range e.spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return SpanData{}, false
+}
+
+func setupTracer(t *testing.T, ratio float64) (*Tracer, *recordingExporter) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer("test", "test-1", ratio, exporter)
+	go tracer.Run(time.Hour)
+	SetDefault(tracer)
+	t.Cleanup(func() { SetDefault(nil) })
+	return tracer, exporter
+}
+
+func TestTraceparent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(header)
+	if !ok || !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("Failed to parse %q, got %+v", header, sc)
+	}
+	if sc.Traceparent() != header {
+		t.Errorf("Expected %q to be formatted back, got %q", header, sc.Traceparent())
+	}
+
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); !ok {
+		t.Error("Expected a later version with more fields to be read")
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-more",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	}
+	for _, value := range invalid {
+		if _, ok := ParseTraceparent(value); ok {
+			t.Errorf("Expected %q to be refused", value)
+		}
+	}
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	parent := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}
+	ctx := ContextWithSpanContext(context.Background(), parent)
+
+	childCtx, span := Start(ctx, "work", SPAN_KIND_INTERNAL)
+	span.SetAttributes("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+
+	if span != nil || SpanContextFromContext(childCtx) != parent {
+		t.Error("Expected no span and the trace context to be kept while tracing is off")
+	}
+}
+
+func TestSpansAreChildrenOfTheirParent(t *testing.T) {
+	tracer, exporter := setupTracer(t, 1)
+
+	ctx, root := Start(context.Background(), "root", SPAN_KIND_SERVER)
+	_, child := Start(ctx, "child", SPAN_KIND_CLIENT, "replica", "a:1")
+	child.SetError(errors.New("unreachable"))
+	child.End()
+	child.End()
+	root.End()
+	tracer.Flush()
+
+	rootData, ok := exporter.byName("root")
+	childData, ok2 := exporter.byName("child")
+	if !ok || !ok2 || len(exporter.spans) != 2 {
+		t.Fatalf("Expected both spans once, got %+v", exporter.spans)
+	}
+	if rootData.ParentSpanID != "" || childData.ParentSpanID != rootData.SpanID || childData.TraceID != rootData.TraceID {
+		t.Errorf("Expected the child to be in the trace of the root, got %+v and %+v", rootData, childData)
+	}
+	if childData.Kind != "client" || childData.Attributes["replica"] != "a:1" || childData.Error != "unreachable" {
+		t.Errorf("Expected the child to keep its kind, attributes and error, got %+v", childData)
+	}
+}
+
+func TestUnsampledSpansAreNotExported(t *testing.T) {
+	tracer, exporter := setupTracer(t, 0)
+
+	ctx, span := Start(context.Background(), "root", SPAN_KIND_SERVER)
+	if SpanContextFromContext(ctx).Sampled {
+		t.Error("Expected a root span not to be sampled with a ratio of 0")
+	}
+	span.End()
+
+	// A trace sampled upstream is recorded whatever the ratio
+	sampled := ContextWithSpanContext(context.Background(), SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true})
+	_, span = Start(sampled, "child", SPAN_KIND_SERVER)
+	span.End()
+	tracer.Flush()
+
+	if len(exporter.spans) != 1 || exporter.spans[0].Name != "child" {
+		t.Errorf("Expected only the span of the sampled trace, got %+v", exporter.spans)
+	}
+}
+
+func TestHandleTrace(t *testing.T) {
+	tracer, exporter := setupTracer(t, 1)
+
+	var sent *http.Request
+	handler := HandleTrace(true, "/operation", func(w http.ResponseWriter, r *http.Request) {
+		sent = httptest.NewRequest(http.MethodGet, "/next", nil)
+		Inject(r.Context(), sent)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	request := httptest.NewRequest(http.MethodPut, "/operation", nil)
+	request.Header.Set(TRACEPARENT_HEADER, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler(httptest.NewRecorder(), request)
+	tracer.Flush()
+
+	span, ok := exporter.byName("PUT /operation")
+	if !ok || span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("Expected a server span continuing the trace, got %+v", exporter.spans)
+	}
+	if span.Attributes["http.status_code"] != http.StatusAccepted {
+		t.Errorf("Expected the status code to be recorded, got %+v", span.Attributes)
+	}
+	if sent.Header.Get(TRACEPARENT_HEADER) != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanID+"-01" {
+		t.Errorf("Expected the request sent to be a child of the server span, got %q", sent.Header.Get(TRACEPARENT_HEADER))
+	}
+
+	// An untrusted header starts a new trace
+	request = httptest.NewRequest(http.MethodPut, "/operation", nil)
+	request.Header.Set(TRACEPARENT_HEADER, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	HandleTrace(false, "/list", func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), request)
+	tracer.Flush()
+
+	span, _ = exporter.byName("PUT /list")
+	if span.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" || request.Header.Get(TRACEPARENT_HEADER) != "" {
+		t.Errorf("Expected the client's trace context to be dropped, got %+v", span)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+	}))
+	defer collector.Close()
+
+	start := time.Unix(0, 1000)
+	err := NewOTLPExporter(collector.URL).Export([]SpanData{{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Name: "unqlite commit", Service: "node", Instance: "10.0.0.1:9001",
+		Start: start, End: start.Add(time.Microsecond), Attributes: map[string]any{"writes": 3}, Error: "failed", kind: SPAN_KIND_INTERNAL,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _ := json.Marshal(body)
+	for _, expected := range []string{
+		`"key":"service.name","value":{"stringValue":"node"}`,
+		`"key":"service.instance.id","value":{"stringValue":"10.0.0.1:9001"}`,
+		`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`,
+		`"startTimeUnixNano":"1000"`,
+		`"endTimeUnixNano":"2000"`,
+		`"key":"writes","value":{"intValue":"3"}`,
+		`"status":{"code":2,"message":"failed"}`,
+		`"kind":1`,
+	} {
+		if !strings.Contains(string(encoded), expected) {
+			t.Errorf("Expected %s in the request, got %s", expected, encoded)
+		}
+	}
+}
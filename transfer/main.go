@@ -0,0 +1,389 @@
+// this exports every list of a cluster into a newline-delimited JSON file, and imports such a file into a cluster
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sdle.com/mod/protocol"
+)
+
+// How many lists are imported per second when the command does not say
+const DEFAULT_IMPORT_RATE = 20
+
+// How many times a list is tried before the export or the import stops
+const MAX_ATTEMPTS = 5
+
+// The longest line an import reads, a list in JSON
+const MAX_LINE_SIZE = 64 * 1024 * 1024
+
+// The list was deleted or is gone, it is skipped
+var errListGone = errors.New("the list is gone")
+
+// The cluster refused the list, trying again would not change its answer
+var errRefused = errors.New("the coordinator refused the list")
+
+// The progress was saved for another file, or another version of it, resuming would skip the wrong lines
+var errProgressMismatch = errors.New("the progress of the import was saved for another file")
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// importFingerprint tells the file an import was started with from any other one
+type importFingerprint struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// importProgress is what <file>.progress keeps: how many lines of which file were imported
+type importProgress struct {
+	File  importFingerprint `json:"file"`
+	Lines int               `json:"lines"`
+}
+
+// The load balancer the lists go through
+var balancerAddress, balancerPort string
+
+func main() {
+	argsWithoutProg := os.Args[1:]
+
+	if len(argsWithoutProg) < 4 || (argsWithoutProg[0] != "export" && argsWithoutProg[0] != "import") {
+		fmt.Println("Usage: transfer export <balancer_address> <balancer_port> <file>")
+		fmt.Println("       transfer import <balancer_address> <balancer_port> <file> [lists_per_second]")
+		os.Exit(1)
+	}
+
+	if protocol.AdminToken == "" {
+		fmt.Println("ADMIN_TOKEN must be set to the admin token of the cluster")
+		os.Exit(1)
+	}
+
+	if err := protocol.SetupTLS(); err != nil {
+		fmt.Println("Failed to set up TLS:", err)
+		os.Exit(1)
+	}
+	client.Transport = protocol.Transport()
+
+	balancerAddress, balancerPort = argsWithoutProg[1], argsWithoutProg[2]
+	file := argsWithoutProg[3]
+
+	var err error
+	if argsWithoutProg[0] == "export" {
+		err = exportLists(file)
+	} else {
+		rate := DEFAULT_IMPORT_RATE
+		if len(argsWithoutProg) > 4 {
+			rate, err = strconv.Atoi(argsWithoutProg[4])
+			if err != nil || rate < 1 {
+				fmt.Println("The rate must be a positive number of lists per second")
+				os.Exit(1)
+			}
+		}
+		err = importLists(file, rate)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+/**
+ * Sends a request to the load balancer as an admin, with the API token from API_TOKEN if there is one
+ */
+func sendToBalancer(method string, path string, body []byte) (*http.Response, error) {
+	requestURL := fmt.Sprintf("%s://%s:%s%s", protocol.Scheme(), balancerAddress, balancerPort, path)
+
+	req, err := http.NewRequest(method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", protocol.JSON_CONTENT_TYPE)
+	req.Header.Set("Accept", protocol.JSON_CONTENT_TYPE)
+	req.Header.Set(protocol.ADMIN_TOKEN_HEADER, protocol.AdminToken)
+	req.Header.Set(protocol.CLIENT_ID_HEADER, protocol.IMPORT_CLIENT_ID)
+	if token := os.Getenv("API_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return client.Do(req)
+}
+
+/**
+ * Calls try until it succeeds, the list is gone or refused, or it failed MAX_ATTEMPTS times, waiting longer each time
+ */
+func withRetries(try func() error) error {
+	var err error
+	for attempt := 0; attempt < MAX_ATTEMPTS; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * 100 * time.Millisecond)
+		}
+
+		err = try()
+		if err == nil || errors.Is(err, errListGone) || errors.Is(err, errRefused) {
+			return err
+		}
+	}
+	return err
+}
+
+/**
+ * Writes every list of the cluster into the file, one protocol.ShoppingListOperation in JSON per line,
+ * reading each list through its coordinators so it is merged from a read quorum
+ */
+func exportLists(file string) error {
+	response, err := sendToBalancer(http.MethodGet, "/admin/lists", nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach the load balancer: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("the load balancer refused to list the lists (%s): %s", response.Status, body)
+	}
+
+	var lists protocol.ClusterLists
+	if err := json.NewDecoder(response.Body).Decode(&lists); err != nil {
+		return fmt.Errorf("invalid answer from the load balancer: %w", err)
+	}
+	if len(lists.Unreachable) > 0 {
+		fmt.Println("Warning: the lists only stored on", strings.Join(lists.Unreachable, ", "), "are missing")
+	}
+
+	// Written aside first, so an export that fails half way never replaces a good one
+	output, err := os.Create(file + ".part")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(output)
+
+	exported, skipped := 0, 0
+	for _, partition := range lists.Partitions {
+		for _, listId := range partition.ListIds {
+			var line []byte
+			err := withRetries(func() error {
+				var readErr error
+				line, readErr = readList(listId)
+				return readErr
+			})
+
+			if errors.Is(err, errListGone) {
+				skipped++
+				continue
+			}
+			if err != nil {
+				output.Close()
+				os.Remove(file + ".part")
+				return fmt.Errorf("failed to read the list %s: %w", listId, err)
+			}
+
+			writer.Write(line)
+			writer.WriteByte('\n')
+			exported++
+		}
+		fmt.Printf("Exported the partition %s\n", partition.Partition)
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = output.Sync()
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file + ".part")
+		return fmt.Errorf("failed to write the export: %w", err)
+	}
+	if err := os.Rename(file+".part", file); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d lists into %s, %d were gone\n", exported, file, skipped)
+	return nil
+}
+
+/**
+ * Reads a list through its coordinators, returns it as a line of the export
+ */
+func readList(listId string) ([]byte, error) {
+	body, _ := json.Marshal(map[string]string{"list_id": listId})
+
+	response, err := sendToBalancer(http.MethodPost, "/list", body)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, errListGone
+	default:
+		return nil, fmt.Errorf("the coordinator answered %s", response.Status)
+	}
+
+	var operation protocol.ShoppingListOperation
+	if err := json.NewDecoder(response.Body).Decode(&operation); err != nil {
+		return nil, err
+	}
+
+	// The order is derived from the list, it is only sent on reads
+	return json.Marshal(protocol.ShoppingListOperation{ListId: operation.ListId, Content: operation.Content})
+}
+
+/**
+ * Writes every list in the file to the cluster through its coordinators, which write it to a write quorum,
+ * at most rate lists per second. The lines already imported are kept in <file>.progress, with a fingerprint of
+ * the file, so an import that stopped resumes where it was, and only in the file it was started with
+ */
+func importLists(file string, rate int) error {
+	input, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	fingerprint, err := fingerprintFile(input)
+	if err != nil {
+		return err
+	}
+
+	progressFile := file + ".progress"
+	done, err := readProgress(progressFile, fingerprint)
+	if err != nil {
+		return err
+	}
+	if done > 0 {
+		fmt.Printf("Resuming after %d lines\n", done)
+	}
+
+	throttle := time.NewTicker(time.Second / time.Duration(rate))
+	defer throttle.Stop()
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_LINE_SIZE)
+
+	lineNumber, imported, skipped := 0, 0, 0
+	for scanner.Scan() {
+		lineNumber++
+		if lineNumber <= done {
+			continue
+		}
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 {
+			<-throttle.C
+
+			err := withRetries(func() error { return writeList(line) })
+			if errors.Is(err, errListGone) {
+				skipped++
+			} else if err != nil {
+				return fmt.Errorf("failed to import line %d, run the import again to resume: %w", lineNumber, err)
+			} else {
+				imported++
+			}
+		}
+
+		if err := writeProgress(progressFile, importProgress{File: fingerprint, Lines: lineNumber}); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	os.Remove(progressFile)
+
+	fmt.Printf("Imported %d lists from %s, %d were deleted in the cluster\n", imported, file, skipped)
+	return nil
+}
+
+/**
+ * Reads the size and the SHA-256 of a file, leaving it at its start
+ */
+func fingerprintFile(input *os.File) (importFingerprint, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, input)
+	if err != nil {
+		return importFingerprint{}, err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return importFingerprint{}, err
+	}
+
+	return importFingerprint{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+/**
+ * Returns how many lines of the file with the given fingerprint were already imported, 0 if there is no
+ * progress. Progress saved for another file, or without a fingerprint, is an error: it must be removed to
+ * import the file from its start
+ */
+func readProgress(progressFile string, fingerprint importFingerprint) (int, error) {
+	data, err := os.ReadFile(progressFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var progress importProgress
+	if err := json.Unmarshal(data, &progress); err != nil || progress.File != fingerprint {
+		return 0, fmt.Errorf("%w, remove %s to import it from its start", errProgressMismatch, progressFile)
+	}
+
+	return progress.Lines, nil
+}
+
+/**
+ * Saves the progress of an import, replacing the file at once so a crash never leaves half of it
+ */
+func writeProgress(progressFile string, progress importProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(progressFile+".part", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(progressFile+".part", progressFile)
+}
+
+/**
+ * Writes a line of an export through the coordinators of its list
+ */
+func writeList(line []byte) error {
+	response, err := sendToBalancer(http.MethodPut, "/list", line)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusGone:
+		return errListGone
+	case http.StatusServiceUnavailable:
+		return fmt.Errorf("the coordinator answered %s", response.Status)
+	default:
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("%w (%s): %s", errRefused, response.Status, bytes.TrimSpace(body))
+	}
+}
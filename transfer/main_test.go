@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"sdle.com/mod/protocol"
+)
+
+// testBalancer is a load balancer that takes the writes of an import, refusing the lists in refuse
+type testBalancer struct {
+	lock    sync.Mutex
+	written []string
+	refuse  map[string]bool
+}
+
+func (balancer *testBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var operation protocol.ShoppingListOperation
+	if r.Method != http.MethodPut || r.URL.Path != "/list" || json.NewDecoder(r.Body).Decode(&operation) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	balancer.lock.Lock()
+	defer balancer.lock.Unlock()
+
+	if balancer.refuse[operation.ListId] {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	balancer.written = append(balancer.written, operation.ListId)
+}
+
+func (balancer *testBalancer) writes() []string {
+	balancer.lock.Lock()
+	defer balancer.lock.Unlock()
+
+	return append([]string{}, balancer.written...)
+}
+
+/**
+ * Points the import at a test load balancer
+ */
+func setupTestBalancer(t *testing.T) *testBalancer {
+	balancer := &testBalancer{refuse: make(map[string]bool)}
+	server := httptest.NewServer(balancer)
+	t.Cleanup(server.Close)
+
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancerAddress, balancerPort = address.Hostname(), address.Port()
+	return balancer
+}
+
+/**
+ * Writes an export of the given lists, returns its path
+ */
+func writeExport(t *testing.T, dir string, listIds ...string) string {
+	t.Helper()
+
+	lines := make([]string, 0, len(listIds))
+	for _, listId := range listIds {
+		lines = append(lines, fmt.Sprintf(`{"list_id":%q,"content":null}`, listId))
+	}
+
+	file := filepath.Join(dir, "export.ndjson")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestImportResumesWhereItStopped(t *testing.T) {
+	balancer := setupTestBalancer(t)
+	file := writeExport(t, t.TempDir(), "list1", "list2", "list3", "list4")
+
+	// The cluster refuses list3, so the import stops after the first two lists
+	balancer.refuse["list3"] = true
+	if err := importLists(file, 1000); !errors.Is(err, errRefused) {
+		t.Fatal("expected the import to stop at the refused list, got", err)
+	}
+	if _, err := os.Stat(file + ".progress"); err != nil {
+		t.Fatal("the progress of the import should have been saved:", err)
+	}
+
+	delete(balancer.refuse, "list3")
+	if err := importLists(file, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"list1", "list2", "list3", "list4"}
+	if written := balancer.writes(); strings.Join(written, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected every list to be written once, in order, got %v", written)
+	}
+	if _, err := os.Stat(file + ".progress"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("the progress should have been removed once the import was done")
+	}
+}
+
+func TestImportRefusesToResumeAnotherFile(t *testing.T) {
+	balancer := setupTestBalancer(t)
+	dir := t.TempDir()
+	file := writeExport(t, dir, "list1", "list2", "list3")
+
+	balancer.refuse["list2"] = true
+	if err := importLists(file, 1000); !errors.Is(err, errRefused) {
+		t.Fatal("expected the import to stop at the refused list, got", err)
+	}
+	delete(balancer.refuse, "list2")
+
+	// Another export was written in the place of the one the import was started with
+	writeExport(t, dir, "other1", "other2", "other3")
+	if err := importLists(file, 1000); !errors.Is(err, errProgressMismatch) {
+		t.Fatal("expected the import not to resume in another file, got", err)
+	}
+
+	// As does a progress saved before it had a fingerprint
+	if err := os.WriteFile(file+".progress", []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := importLists(file, 1000); !errors.Is(err, errProgressMismatch) {
+		t.Fatal("expected the import not to resume without a fingerprint, got", err)
+	}
+
+	if written := balancer.writes(); len(written) != 1 || written[0] != "list1" {
+		t.Errorf("expected nothing to be written since the import stopped, got %v", written)
+	}
+
+	// Once the progress is removed the file is imported from its start
+	if err := os.Remove(file + ".progress"); err != nil {
+		t.Fatal(err)
+	}
+	if err := importLists(file, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if written := balancer.writes(); strings.Join(written, ",") != "list1,other1,other2,other3" {
+		t.Errorf("expected the new file to be imported whole, got %v", written)
+	}
+}
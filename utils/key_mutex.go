@@ -0,0 +1,51 @@
+package utils
+
+import "sync"
+
+// KeyMutex is a set of mutexes, one for each key, so work on different keys does not wait on each other.
+// A key only takes memory while it is locked or waited on. The zero value is ready to use.
+type KeyMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	holders int // How many goroutines hold or wait for the lock
+}
+
+// Lock locks the given key, waiting until no one else holds it, and returns the function that unlocks it
+func (k *KeyMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		k.locks[key] = lock
+	}
+	lock.holders++
+	k.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		k.mu.Lock()
+		lock.holders--
+		if lock.holders == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// Size returns how many keys are locked or waited on
+func (k *KeyMutex) Size() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.locks)
+}
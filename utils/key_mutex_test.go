@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestKeyMutex(t *testing.T) {
+	var locks KeyMutex
+	// The map is only read concurrently, each counter is only changed under the lock of its key
+	counters := map[string]*int{"a": new(int), "b": new(int)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		for key := range counters {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+
+				unlock := locks.Lock(key)
+				defer unlock()
+
+				// Without the lock concurrent increments of the same key would be lost
+				value := *counters[key]
+				*counters[key] = value + 1
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	// Every increment is seen
+	for key, value := range counters {
+		if *value != 100 {
+			t.Log("key", key, "was incremented", *value, "times, not 100")
+			t.Fail()
+		}
+	}
+
+	// Keys are forgotten once they are unlocked
+	if locks.Size() != 0 {
+		t.Log("there should be no locked keys, not", locks.Size())
+		t.Fail()
+	}
+}
+
+func TestKeyMutexDifferentKeys(t *testing.T) {
+	var locks KeyMutex
+
+	unlockA := locks.Lock("a")
+
+	// Another key can be locked while "a" is held
+	done := make(chan bool)
+	go func() {
+		unlockB := locks.Lock("b")
+		unlockB()
+		done <- true
+	}()
+	<-done
+
+	unlockA()
+}
@@ -3,6 +3,9 @@ package utils
 import (
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"time"
 )
 
 func CheckErr(err error) {
@@ -11,6 +14,15 @@ func CheckErr(err error) {
 	}
 }
 
+// SecondsFromEnv reads a duration, in seconds, from an environment variable, or returns fallback if it is not set
+func SecondsFromEnv(name string, fallback time.Duration) time.Duration {
+	seconds, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func Int64ToString(number int64) string {
 	return fmt.Sprintf("%d", number)
 }